@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/AlessioPani/go-booking/internal/config"
@@ -14,7 +21,10 @@ import (
 	"github.com/AlessioPani/go-booking/internal/handlers"
 	"github.com/AlessioPani/go-booking/internal/helpers"
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/payments"
 	"github.com/AlessioPani/go-booking/internal/renders"
+	"github.com/AlessioPani/go-booking/internal/repository"
+	"github.com/AlessioPani/go-booking/internal/repository/dbrepo"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -22,6 +32,9 @@ import (
 // Port number of our website
 const portNumber = ":8080"
 
+// holdSweepInterval is how often expired room holds are removed
+const holdSweepInterval = time.Minute
+
 // app is the config struct of our webapp
 var app config.AppConfig
 
@@ -35,27 +48,57 @@ var errorLog *log.Logger
 func main() {
 
 	db, err := run()
+	if errors.Is(err, errMigrateOnly) {
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
+	if db != nil {
+		defer db.SQL.Close()
+	}
 
 	defer close(app.MailChan)
 	listenForMail()
 
+	// the base context of every request is cancelled on shutdown, so running queries are cancelled too
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go handlers.Repo.SweepExpiredHolds(ctx, holdSweepInterval)
+
 	serve := &http.Server{
 		Addr:    portNumber,
 		Handler: routes(),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
 	}
 
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := serve.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
 	fmt.Println("Starting an application on port", portNumber[1:])
 	err = serve.ListenAndServe()
-	log.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
 
 func run() (*driver.DB, error) {
 	// data models I'm going to put to the session
 	gob.Register(models.Reservation{})
+	gob.Register([]models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
@@ -64,11 +107,25 @@ func run() (*driver.DB, error) {
 	// parse flags provided by cli
 	production := flag.Bool("production", true, "True for production, false for development")
 	useCache := flag.Bool("cache", true, "Use template cache")
+	demo := flag.Bool("demo", false, "Run with an in memory database seeded with sample data, nothing is saved")
+	dbDriver := flag.String("dbdriver", driver.Postgres, "Database driver: postgres or sqlite")
+	dbFile := flag.String("dbfile", "bookings.db", "SQLite database file, used with -dbdriver=sqlite")
 	dbHost := flag.String("dbhost", "localhost", "Database hostname")
 	dbPort := flag.String("dbport", "5432", "Database port")
 	dbName := flag.String("dbname", "bookings", "Database name")
 	dbUser := flag.String("dbuser", "postgres", "Database username")
 	dbPassword := flag.String("dbpassword", "", "Database password")
+	overlap := flag.String("overlap", repository.HalfOpen.String(), "When stays overlap: half-open lets a room be booked again on its check-out day, closed does not")
+	dbTimeout := flag.Duration("dbtimeout", 3*time.Second, "Maximum duration of a database query")
+	migrateDirection := flag.String("migrate", "", "Migrate the database before starting: up applies pending migrations, down rolls back and exits")
+	migrateSteps := flag.Int("migrate-steps", 1, "Number of migrations to roll back with -migrate=down")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "Print the migrations that would run, then exit")
+	paymentGateway := flag.String("payment-gateway", payments.FakeName, "Payment gateway used to take deposits")
+	paymentSecret := flag.String("payment-webhook-secret", "", "Secret the payment gateway signs its webhooks with, webhooks are refused without it")
+	currency := flag.String("currency", "EUR", "Currency of room rates and payments")
+	holdDuration := flag.Duration("hold", 15*time.Minute, "How long a room is held for a guest filling in the reservation form")
+	waitlistOffer := flag.Duration("waitlist-offer", 24*time.Hour, "How long the booking link sent to a waitlisted guest is valid")
+	baseURL := flag.String("base-url", "http://localhost"+portNumber, "Public address of the site, the links sent by email point to it")
 	flag.Parse()
 
 	// create a channel
@@ -81,6 +138,27 @@ func run() (*driver.DB, error) {
 
 	// change this to true when in production
 	app.InProduction = *production
+	app.DBTimeout = *dbTimeout
+
+	overlapPolicy, err := repository.ParseOverlapPolicy(*overlap)
+	if err != nil {
+		return nil, err
+	}
+	app.Overlap = overlapPolicy
+
+	gateway, err := payments.New(*paymentGateway, *paymentSecret)
+	if err != nil {
+		return nil, err
+	}
+	app.Payments = gateway
+	app.Currency = *currency
+	app.HoldDuration = *holdDuration
+	app.WaitlistOfferDuration = *waitlistOffer
+
+	app.BaseURL, err = parseBaseURL(*baseURL)
+	if err != nil {
+		return nil, err
+	}
 
 	// Set up the infoLog
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -106,14 +184,49 @@ func run() (*driver.DB, error) {
 	app.UseCache = *useCache
 	app.Session = session
 
+	if *demo {
+		if *migrateDirection != "" {
+			return nil, errors.New("-migrate cannot be used with -demo")
+		}
+
+		repo, err := handlers.NewDemoRepo(&app)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Demo mode: data is kept in memory, log in as %s / %s", dbrepo.DemoEmail, dbrepo.DemoPassword)
+
+		handlers.NewHandlers(repo)
+		renders.NewRenderer(&app)
+		helpers.NewHelpers(&app)
+
+		return nil, nil
+	}
+
 	// connect to database
 	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL(fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPassword))
+	db, err := connectDatabase(*dbDriver, *dbFile, fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPassword))
 	if err != nil {
-		log.Fatal("cannot connect to database")
+		return nil, err
 	}
 	log.Println("Connected to database")
 
+	if *migrateDirection != "" {
+		if db.Driver != driver.Postgres {
+			db.SQL.Close()
+			return nil, fmt.Errorf("-migrate is only supported with -dbdriver=%s", driver.Postgres)
+		}
+
+		exit, err := migrateDatabase(db.SQL, *migrateDirection, *migrateSteps, *migrateDryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		if exit {
+			db.SQL.Close()
+			return nil, errMigrateOnly
+		}
+	}
+
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 	renders.NewRenderer(&app)
@@ -121,3 +234,41 @@ func run() (*driver.DB, error) {
 
 	return db, nil
 }
+
+// connectDatabase opens the database selected by the -dbdriver flag,
+// a SQLite database gets its schema created on the fly
+func connectDatabase(dbDriver, dbFile, dsn string) (*driver.DB, error) {
+	switch dbDriver {
+	case driver.Postgres:
+		db, err := driver.ConnectSQL(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to database: %w", err)
+		}
+		return db, nil
+	case driver.SQLite:
+		db, err := driver.ConnectSQLite(dbFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open sqlite database: %w", err)
+		}
+
+		err = dbrepo.InitSqliteSchema(context.Background(), db.SQL)
+		if err != nil {
+			db.SQL.Close()
+			return nil, fmt.Errorf("cannot create sqlite schema: %w", err)
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", dbDriver)
+	}
+}
+
+// parseBaseURL checks that the public address of the site is an absolute http or https URL,
+// and returns it without a trailing slash
+func parseBaseURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid -base-url %q, expected an absolute http or https URL", s)
+	}
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
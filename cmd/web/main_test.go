@@ -8,3 +8,29 @@ func TestRun(t *testing.T) {
 		t.Error("Failed Run")
 	}
 }
+
+var baseURLTests = []struct {
+	name     string
+	value    string
+	expected string
+	valid    bool
+}{
+	{"http", "http://localhost:8080", "http://localhost:8080", true},
+	{"https with slash", "https://booking.example.com/", "https://booking.example.com", true},
+	{"with path", "https://example.com/booking/", "https://example.com/booking", true},
+	{"no scheme", "booking.example.com", "", false},
+	{"other scheme", "ftp://booking.example.com", "", false},
+	{"no host", "https://", "", false},
+}
+
+func TestParseBaseURL(t *testing.T) {
+	for _, e := range baseURLTests {
+		got, err := parseBaseURL(e.value)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid %t, got error %v", e.name, e.valid, err)
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
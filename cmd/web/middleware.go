@@ -4,12 +4,15 @@ import (
 	"net/http"
 
 	"github.com/AlessioPani/go-booking/internal/helpers"
+	"github.com/AlessioPani/go-booking/internal/i18n"
 	"github.com/justinas/nosurf"
 )
 
 // NoSurf adds CSRF protection to all POST request
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// the payment gateway signs its webhooks instead
+	csrfHandler.ExemptPath("/payments/webhook")
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -38,3 +41,30 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Language stores the language of a request in its context. It is taken from the lang url parameter,
+// which is remembered in a cookie, then from that cookie, then from the Accept-Language header
+func Language(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var lang string
+
+		if l := r.URL.Query().Get("lang"); i18n.Supported(l) {
+			lang = l
+			http.SetCookie(w, &http.Cookie{
+				Name:     i18n.CookieName,
+				Value:    l,
+				Path:     "/",
+				MaxAge:   365 * 24 * 60 * 60,
+				HttpOnly: true,
+				Secure:   app.InProduction,
+				SameSite: http.SameSiteLaxMode,
+			})
+		} else if c, err := r.Cookie(i18n.CookieName); err == nil && i18n.Supported(c.Value) {
+			lang = c.Value
+		} else {
+			lang = i18n.Match(r.Header.Get("Accept-Language"))
+		}
+
+		next.ServeHTTP(w, r.WithContext(i18n.WithLang(r.Context(), lang)))
+	})
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/AlessioPani/go-booking/internal/i18n"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -17,6 +21,19 @@ func TestNoSurf(t *testing.T) {
 	}
 }
 
+func TestNoSurf_PaymentWebhook(t *testing.T) {
+	h := NoSurf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for target, expected := range map[string]int{"/payments/webhook": http.StatusOK, "/make-reservation": http.StatusBadRequest} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", target, strings.NewReader("{}")))
+
+		if rr.Code != expected {
+			t.Errorf("POST %s without a CSRF token: expected %d, got %d", target, expected, rr.Code)
+		}
+	}
+}
+
 func TestSessionLoad(t *testing.T) {
 	var mH myHandler
 	h := SessionLoad(&mH)
@@ -29,3 +46,48 @@ func TestSessionLoad(t *testing.T) {
 	}
 
 }
+
+var languageTests = []struct {
+	name           string
+	url            string
+	cookie         string
+	acceptLanguage string
+	expectedLang   string
+	expectedCookie bool
+}{
+	{"default", "/", "", "", "en", false},
+	{"header", "/", "", "it-IT,it;q=0.9", "it", false},
+	{"cookie over header", "/", "en", "it-IT,it;q=0.9", "en", false},
+	{"url over cookie", "/?lang=it", "en", "", "it", true},
+	{"unsupported url", "/?lang=xx", "it", "", "it", false},
+	{"unsupported cookie", "/", "xx", "it", "it", false},
+}
+
+func TestLanguage(t *testing.T) {
+	for _, e := range languageTests {
+		var lang string
+		h := Language(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lang = i18n.FromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", e.url, nil)
+		if e.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: i18n.CookieName, Value: e.cookie})
+		}
+		if e.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", e.acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		if lang != e.expectedLang {
+			t.Errorf("%s: expected language %s, got %s", e.name, e.expectedLang, lang)
+		}
+
+		cookieSet := strings.Contains(rr.Header().Get("Set-Cookie"), i18n.CookieName+"="+e.expectedLang)
+		if cookieSet != e.expectedCookie {
+			t.Errorf("%s: expected cookie set %t, got %t", e.name, e.expectedCookie, cookieSet)
+		}
+	}
+}
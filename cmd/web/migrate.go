@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlessioPani/go-booking/internal/migrate"
+	"github.com/AlessioPani/go-booking/migrations"
+)
+
+// errMigrateOnly is returned by run when the application must exit after migrating the database
+var errMigrateOnly = errors.New("migrate only")
+
+// migrateDatabase applies the embedded migrations in the given direction ("up" or "down").
+// It returns true if the application should exit afterwards instead of serving requests.
+func migrateDatabase(db *sql.DB, direction string, steps int, dryRun bool) (bool, error) {
+	m, err := migrate.New(db, migrations.FS, os.Stdout)
+	if err != nil {
+		return false, err
+	}
+	m.DryRun = dryRun
+
+	ctx := context.Background()
+
+	switch direction {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return false, err
+		}
+		infoLog.Printf("%d migration(s) applied", n)
+	case "down":
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return false, err
+		}
+		infoLog.Printf("%d migration(s) rolled back", n)
+	default:
+		return false, fmt.Errorf("unknown migration direction %q, use up or down", direction)
+	}
+
+	if dryRun {
+		infoLog.Println("Dry run, no changes were made")
+	}
+
+	// after a rollback or a dry run the schema is not the one the application expects
+	return direction == "down" || dryRun, nil
+}
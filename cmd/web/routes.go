@@ -16,6 +16,7 @@ func routes() http.Handler {
 	mux.Use(middleware.Recoverer)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(Language)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
@@ -25,15 +26,26 @@ func routes() http.Handler {
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.Get("/waitlist", handlers.Repo.Waitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", handlers.Repo.WaitlistOffer)
+	mux.Get("/api/availability/calendar", handlers.Repo.AvailabilityCalendar)
+	mux.Get("/api/availability/flexible", handlers.Repo.FlexibleAvailabilityJSON)
 
 	mux.Get("/contact", handlers.Repo.Contact)
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Post("/cancel-reservation", handlers.Repo.PostCancelReservation)
 
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Post("/choose-rooms", handlers.Repo.ChooseRooms)
+	mux.Get("/make-group-reservation", handlers.Repo.GroupReservation)
+	mux.Post("/make-group-reservation", handlers.Repo.PostGroupReservation)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
@@ -46,12 +58,32 @@ func routes() http.Handler {
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/reservations-{src}.csv", handlers.Repo.AdminExportReservations)
+		mux.Get("/reservations/new", handlers.Repo.AdminCreateReservation)
+		mux.Post("/reservations/new", handlers.Repo.AdminPostCreateReservation)
 		mux.Get("/reservations-cal", handlers.Repo.AdminCalendarReservations)
 		mux.Post("/reservations-cal", handlers.Repo.AdminPostCalendarReservations)
-		mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
+		mux.Post("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/reservation-status/{src}/{id}/{status}", handlers.Repo.AdminReservationStatus)
 		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Get("/reservations/{src}/{id}/invoice", handlers.Repo.AdminReservationInvoice)
+		mux.Post("/issue-invoice/{src}/{id}", handlers.Repo.AdminIssueInvoice)
+		mux.Post("/email-invoice/{src}/{id}", handlers.Repo.AdminEmailInvoice)
+		mux.Get("/promotions", handlers.Repo.AdminPromotions)
+		mux.Get("/promotions/new", handlers.Repo.AdminNewPromotion)
+		mux.Post("/promotions/new", handlers.Repo.AdminPostPromotion)
+		mux.Get("/promotions/{id}", handlers.Repo.AdminShowPromotion)
+		mux.Post("/promotions/{id}", handlers.Repo.AdminPostPromotion)
+		mux.Post("/promotions/{id}/delete", handlers.Repo.AdminDeletePromotion)
+		mux.Get("/tax-rules", handlers.Repo.AdminTaxRules)
+		mux.Get("/tax-rules/new", handlers.Repo.AdminNewTaxRule)
+		mux.Post("/tax-rules/new", handlers.Repo.AdminPostTaxRule)
+		mux.Get("/tax-rules/{id}", handlers.Repo.AdminShowTaxRule)
+		mux.Post("/tax-rules/{id}", handlers.Repo.AdminPostTaxRule)
+		mux.Post("/tax-rules/{id}/delete", handlers.Repo.AdminDeleteTaxRule)
+		mux.Get("/tax-report", handlers.Repo.AdminTaxReport)
 
 	})
 
@@ -2,8 +2,11 @@ package config
 
 import (
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/payments"
+	"github.com/AlessioPani/go-booking/internal/repository"
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -17,4 +20,14 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	MailChan      chan models.MailData
+	DBTimeout     time.Duration
+	Overlap       repository.OverlapPolicy
+	Payments      payments.Gateway
+	Currency      string
+	// HoldDuration is how long a room stays held for a guest filling in the reservation form
+	HoldDuration time.Duration
+	// WaitlistOfferDuration is how long the booking link sent to a waitlisted guest is valid
+	WaitlistOfferDuration time.Duration
+	// BaseURL is the public address of the site, the links sent by email point to it
+	BaseURL string
 }
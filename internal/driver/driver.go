@@ -2,15 +2,24 @@ package driver
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	_ "github.com/jackc/pgx/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Supported database drivers
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
 )
 
 // DB holds the database connection pool
 type DB struct {
-	SQL *sql.DB
+	SQL    *sql.DB
+	Driver string
 }
 
 var dbCon = &DB{}
@@ -31,6 +40,7 @@ func ConnectSQL(dsn string) (*DB, error) {
 	d.SetConnMaxLifetime(maxDbLifetime)
 
 	dbCon.SQL = d
+	dbCon.Driver = Postgres
 
 	err = testDB(d)
 	if err != nil {
@@ -40,6 +50,26 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbCon, nil
 }
 
+// ConnectSQLite creates database pool for SQLite, path is a file name or ":memory:"
+func ConnectSQLite(path string) (*DB, error) {
+	d, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path))
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and an in memory database only lives as long as its connection
+	d.SetMaxOpenConns(1)
+	d.SetMaxIdleConns(1)
+	d.SetConnMaxLifetime(0)
+
+	err = testDB(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{SQL: d, Driver: SQLite}, nil
+}
+
 // testDB tries to ping the database
 func testDB(d *sql.DB) error {
 	err := d.Ping()
@@ -0,0 +1,258 @@
+package forms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewFromJSON reads a JSON object into a form, so that API bodies are bound and validated like HTML forms.
+// Strings, numbers and booleans are kept as their text, null values are left out.
+func NewFromJSON(r io.Reader) (*Form, error) {
+	var body map[string]any
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	err := dec.Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	for name, value := range body {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			data.Set(name, v)
+		case json.Number:
+			data.Set(name, v.String())
+		case bool:
+			data.Set(name, strconv.FormatBool(v))
+		default:
+			return nil, fmt.Errorf("field %q must be a string, a number or a boolean", name)
+		}
+	}
+
+	return New(data), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Bind decodes the form into dst, a pointer to a struct, and validates it. Fields are bound by their
+// `form` tag and checked by the comma separated rules of their `validate` tag:
+//
+//	required     the field cannot be blank
+//	min=N, max=N the text is at least, at most N characters long, the number is at least, at most N
+//	email        the text is an email address
+//	phone        the text is a phone number
+//	future       the date is not in the past
+//	after=F      the date comes after the date in field F, declared before it, as a departure after the arrival
+//	nights=N     the date is at most N nights after the date in the field of the after rule
+//
+// N is a number, or the name of one of f.Limits. Strings, ints, bools (as strconv.ParseBool reads them,
+// or "on" as posted by a checkbox without a value) and dates (in DateLayout) are supported, embedded
+// structs are bound too.
+// A value that is missing or can't be decoded gets the message of the `message` tag, if any.
+// Errors are collected in f.Errors, Bind returns true if the form is valid.
+func (f *Form) Bind(dst any) bool {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("forms: Bind needs a pointer to a struct, got %T", dst))
+	}
+
+	f.bindStruct(v.Elem())
+
+	return f.Valid()
+}
+
+// bindStruct binds every tagged field of a struct value
+func (f *Form) bindStruct(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			f.bindStruct(v.Field(i))
+			continue
+		}
+
+		name := sf.Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		f.bindField(v.Field(i), sf, name)
+	}
+}
+
+// bindField decodes a single field and applies its rules
+func (f *Form) bindField(field reflect.Value, sf reflect.StructField, name string) {
+	rs := parseRules(sf.Tag.Get("validate"))
+
+	invalid := func(message string) {
+		if custom := sf.Tag.Get("message"); custom != "" {
+			message = custom
+		}
+		f.Errors.Add(name, f.message(message))
+	}
+
+	raw := f.Get(name)
+	if strings.TrimSpace(raw) == "" {
+		if _, ok := rs.get("required"); ok {
+			invalid("This field cannot be blank")
+		}
+		return
+	}
+
+	switch {
+	case sf.Type == timeType:
+		d, ok := f.date(name)
+		if !ok {
+			invalid("Invalid date")
+			return
+		}
+		field.Set(reflect.ValueOf(d))
+	case sf.Type.Kind() == reflect.String:
+		field.SetString(raw)
+	case sf.Type.Kind() == reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			invalid("Invalid number")
+			return
+		}
+		field.SetInt(int64(n))
+	case sf.Type.Kind() == reflect.Bool:
+		b, ok := parseBool(raw)
+		if !ok {
+			invalid("Invalid value")
+			return
+		}
+		field.SetBool(b)
+	default:
+		panic(fmt.Sprintf("forms: field %s has unsupported type %s", sf.Name, sf.Type))
+	}
+
+	f.applyRules(name, rs, field)
+}
+
+// applyRules runs the validate rules of a decoded field
+func (f *Form) applyRules(name string, rs rules, field reflect.Value) {
+	for _, r := range rs {
+		rule, arg := r.name, r.arg
+		switch rule {
+		case "required":
+		case "min":
+			n := f.ruleInt(name, rule, arg)
+			if field.Kind() != reflect.Int {
+				f.MinLength(name, n)
+			} else if field.Int() < int64(n) {
+				f.Errors.Add(name, f.message("This field must be at least %d", n))
+			}
+		case "max":
+			n := f.ruleInt(name, rule, arg)
+			if field.Kind() != reflect.Int {
+				f.MaxLength(name, n)
+			} else if field.Int() > int64(n) {
+				f.Errors.Add(name, f.message("This field cannot be more than %d", n))
+			}
+		case "email":
+			f.IsEmail(name)
+		case "phone":
+			f.IsPhone(name)
+		case "future":
+			f.FutureDate(name, time.Now())
+		case "after":
+			if f.Errors.Get(arg) != "" {
+				// no point comparing with an invalid date
+				continue
+			}
+			start, startOk := f.date(arg)
+			end, endOk := f.date(name)
+			if startOk && endOk && !end.After(start) {
+				f.Errors.Add(name, f.message("Departure must be after arrival"))
+			}
+		case "nights":
+			after, ok := rs.get("after")
+			if !ok {
+				panic(fmt.Sprintf("forms: rule nights of field %s needs an after rule", name))
+			}
+			if f.Errors.Get(after) != "" {
+				continue
+			}
+			f.MaxNights(after, name, f.ruleInt(name, rule, arg))
+		default:
+			panic(fmt.Sprintf("forms: unknown rule %q for field %s", rule, name))
+		}
+	}
+}
+
+// rule is a validate rule with its argument, if any
+type rule struct {
+	name string
+	arg  string
+}
+
+// rules are the validate rules of a field, in the order of the tag
+type rules []rule
+
+// get returns the argument of a rule, and false if the field does not have it
+func (rs rules) get(name string) (string, bool) {
+	for _, r := range rs {
+		if r.name == name {
+			return r.arg, true
+		}
+	}
+
+	return "", false
+}
+
+// parseRules splits a validate tag into rules and their arguments
+func parseRules(tag string) rules {
+	var rs rules
+	for _, r := range strings.Split(tag, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(r, "=")
+		rs = append(rs, rule{name: name, arg: arg})
+	}
+
+	return rs
+}
+
+// ruleInt returns the numeric argument of a rule, a number or a named limit
+func (f *Form) ruleInt(field, rule, arg string) int {
+	if n, ok := f.Limits[arg]; ok {
+		return n
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("forms: rule %s of field %s needs a number or a named limit, got %q", rule, field, arg))
+	}
+
+	return n
+}
+
+// parseBool decodes a boolean field, false if the value is not one
+func parseBool(raw string) (bool, bool) {
+	raw = strings.TrimSpace(raw)
+	if strings.EqualFold(raw, "on") {
+		return true, true
+	}
+
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, false
+	}
+
+	return b, true
+}
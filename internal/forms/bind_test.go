@@ -0,0 +1,228 @@
+package forms
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type guest struct {
+	FirstName string `form:"first_name" validate:"required,min=3,max=10"`
+	Email     string `form:"email" validate:"required,email"`
+	Phone     string `form:"phone" validate:"phone"`
+}
+
+type booking struct {
+	guest
+	StartDate time.Time `form:"start_date" validate:"required,future" message:"Invalid arrival date"`
+	EndDate   time.Time `form:"end_date" validate:"required,after=start_date,nights=30" message:"Invalid departure date"`
+	RoomID    int       `form:"room_id" validate:"required" message:"Invalid room"`
+	Adults    int       `form:"adults" validate:"min=1,max=4"`
+	SendEmail bool      `form:"send_email"`
+	Notes     string
+}
+
+func bookingValues() url.Values {
+	data := url.Values{}
+	data.Add("first_name", "John")
+	data.Add("email", "john@smith.com")
+	data.Add("phone", "123456789")
+	data.Add("start_date", "2040-01-01")
+	data.Add("end_date", "2040-01-05")
+	data.Add("room_id", "2")
+	data.Add("adults", "2")
+	data.Add("send_email", "on")
+	data.Add("Notes", "not bound")
+	return data
+}
+
+func TestForm_Bind(t *testing.T) {
+	var b booking
+	f := New(bookingValues())
+
+	if !f.Bind(&b) {
+		t.Fatalf("valid form bound with errors: %v", f.Errors)
+	}
+
+	if b.FirstName != "John" || b.Email != "john@smith.com" || b.Phone != "123456789" {
+		t.Errorf("embedded struct not bound: %+v", b.guest)
+	}
+
+	if b.StartDate.Format(DateLayout) != "2040-01-01" || b.EndDate.Format(DateLayout) != "2040-01-05" {
+		t.Errorf("dates not bound: %s - %s", b.StartDate, b.EndDate)
+	}
+
+	if b.RoomID != 2 || b.Adults != 2 || !b.SendEmail {
+		t.Errorf("numbers or checkbox not bound: %d, %d, %t", b.RoomID, b.Adults, b.SendEmail)
+	}
+
+	if b.Notes != "" {
+		t.Errorf("field without form tag was bound: %q", b.Notes)
+	}
+}
+
+var bindTests = []struct {
+	name          string
+	field         string
+	value         string
+	expectedError string
+}{
+	{"blank required", "first_name", "", "This field cannot be blank"},
+	{"too short", "first_name", "Jo", "This field must be at least 3 characters long"},
+	{"too long", "first_name", "Johnjohnjohn", "This field cannot be longer than 10 characters"},
+	{"invalid email", "email", "john", "Invalid email address"},
+	{"invalid phone", "phone", "call me", "Invalid phone number"},
+	{"invalid date", "start_date", "tomorrow", "Invalid arrival date"},
+	{"past date", "start_date", "2020-01-01", "Date cannot be in the past"},
+	{"departure before arrival", "end_date", "2039-12-31", "Departure must be after arrival"},
+	{"too many nights", "end_date", "2040-03-01", "Stays cannot be longer than 30 nights"},
+	{"invalid number", "room_id", "two", "Invalid room"},
+	{"blank number", "room_id", "", "Invalid room"},
+	{"number too small", "adults", "0", "This field must be at least 1"},
+	{"number too big", "adults", "5", "This field cannot be more than 4"},
+	{"invalid checkbox", "send_email", "yes", "Invalid value"},
+	{"checkbox turned off", "send_email", "off", "Invalid value"},
+}
+
+func TestForm_BindErrors(t *testing.T) {
+	for _, e := range bindTests {
+		data := bookingValues()
+		data.Set(e.field, e.value)
+
+		var b booking
+		f := New(data)
+
+		if f.Bind(&b) {
+			t.Errorf("%s: form bound without errors", e.name)
+		}
+
+		if got := f.Errors.Get(e.field); got != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, got)
+		}
+
+		if len(f.Errors) != 1 {
+			t.Errorf("%s: expected errors on %s only, got %v", e.name, e.field, f.Errors)
+		}
+	}
+}
+
+func TestForm_BindOptional(t *testing.T) {
+	data := bookingValues()
+	data.Del("phone")
+	data.Del("send_email")
+
+	var b booking
+	f := New(data)
+
+	if !f.Bind(&b) {
+		t.Errorf("optional fields reported as errors: %v", f.Errors)
+	}
+
+	if b.Phone != "" || b.SendEmail {
+		t.Errorf("missing optional fields were set: %q, %t", b.Phone, b.SendEmail)
+	}
+}
+
+var boolTests = []struct {
+	value    string
+	expected bool
+}{
+	{"on", true},
+	{"ON", true},
+	{"true", true},
+	{"1", true},
+	{"false", false},
+	{"0", false},
+}
+
+func TestForm_BindBool(t *testing.T) {
+	for _, e := range boolTests {
+		data := bookingValues()
+		data.Set("send_email", e.value)
+
+		var b booking
+		f := New(data)
+
+		if !f.Bind(&b) {
+			t.Errorf("%s: bound with errors: %v", e.value, f.Errors)
+		}
+
+		if b.SendEmail != e.expected {
+			t.Errorf("%s: expected %t, got %t", e.value, e.expected, b.SendEmail)
+		}
+	}
+}
+
+func TestForm_BindLimits(t *testing.T) {
+	var b struct {
+		Adults int `form:"adults" validate:"min=1,max=maxAdults"`
+	}
+
+	f := New(url.Values{"adults": {"3"}})
+	f.Limits = map[string]int{"maxAdults": 2}
+
+	if f.Bind(&b) {
+		t.Error("form bound a number above its named limit")
+	}
+
+	if got := f.Errors.Get("adults"); got != "This field cannot be more than 2" {
+		t.Errorf("expected the named limit in the error, got %q", got)
+	}
+}
+
+func TestForm_BindPanics(t *testing.T) {
+	var panicTests = []struct {
+		name string
+		dst  any
+	}{
+		{"not a pointer", booking{}},
+		{"unknown rule", &struct {
+			Name string `form:"first_name" validate:"shiny"`
+		}{}},
+		{"unsupported type", &struct {
+			Price float64 `form:"room_id"`
+		}{}},
+		{"unknown limit", &struct {
+			Adults int `form:"adults" validate:"max=maxAdults"`
+		}{}},
+	}
+
+	for _, e := range panicTests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected Bind to panic", e.name)
+				}
+			}()
+
+			New(bookingValues()).Bind(e.dst)
+		}()
+	}
+}
+
+func TestNewFromJSON(t *testing.T) {
+	body := `{"first_name": "John", "email": "john@smith.com", "phone": null,
+		"start_date": "2040-01-01", "end_date": "2040-01-05", "room_id": 2, "send_email": true}`
+
+	f, err := NewFromJSON(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b booking
+	if !f.Bind(&b) {
+		t.Fatalf("valid JSON body bound with errors: %v", f.Errors)
+	}
+
+	if b.RoomID != 2 || !b.SendEmail || b.Phone != "" {
+		t.Errorf("JSON body not bound: %+v", b)
+	}
+
+	for _, body := range []string{`not json`, `[1, 2]`, `{"room_id": [1, 2]}`, `{"guest": {"name": "John"}}`} {
+		_, err := NewFromJSON(strings.NewReader(body))
+		if err == nil {
+			t.Errorf("%s: expected an error", body)
+		}
+	}
+}
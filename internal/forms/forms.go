@@ -1,16 +1,29 @@
 package forms
 
 import (
-	"fmt"
+	"github.com/AlessioPani/go-booking/internal/i18n"
 	"github.com/asaskevich/govalidator"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
+// DateLayout is the layout of the dates posted by the forms
+const DateLayout = "2006-01-02"
+
+// phoneRegexp matches phone numbers made of digits, spaces, dots, dashes, brackets and a leading +,
+// IsPhone also asks for at least 6 digits
+var phoneRegexp = regexp.MustCompile(`^\+?[0-9 .()-]{6,20}$`)
+
 // Form creates a custom form struct and it embeddeds a url.Values object
 type Form struct {
 	url.Values
 	Errors errors
+	// Lang is the language of the error messages, English if empty
+	Lang string
+	// Limits are the named limits the validate tags of Bind can refer to, as in max=maxFieldLength
+	Limits map[string]int
 }
 
 // Valid returns true if there are no errors, otherwise false
@@ -21,17 +34,22 @@ func (f *Form) Valid() bool {
 // New initializes a form struct
 func New(data url.Values) *Form {
 	return &Form{
-		data,
-		errors(map[string][]string{}),
+		Values: data,
+		Errors: errors(map[string][]string{}),
 	}
 }
 
+// message translates an error message into the language of the form
+func (f *Form) message(format string, args ...any) string {
+	return i18n.T(f.Lang, format, args...)
+}
+
 // Required checks for required fields
 func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
 		value := f.Get(field)
 		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
+			f.Errors.Add(field, f.message("This field cannot be blank"))
 		}
 	}
 }
@@ -40,7 +58,7 @@ func (f *Form) Required(fields ...string) {
 func (f *Form) MinLength(field string, length int) bool {
 	x := f.Get(field)
 	if len(x) < length {
-		f.Errors.Add(field, fmt.Sprintf("This field must be at least %d characters long", length))
+		f.Errors.Add(field, f.message("This field must be at least %d characters long", length))
 		return false
 	}
 	return true
@@ -58,6 +76,103 @@ func (f *Form) Has(field string) bool {
 // IsEmail checks for valid email address
 func (f *Form) IsEmail(field string) {
 	if !govalidator.IsEmail(f.Get(field)) {
-		f.Errors.Add(field, "Invalid email address")
+		f.Errors.Add(field, f.message("Invalid email address"))
+	}
+}
+
+// MaxLength checks for string maximum length
+func (f *Form) MaxLength(field string, length int) bool {
+	x := f.Get(field)
+	if len(x) > length {
+		f.Errors.Add(field, f.message("This field cannot be longer than %d characters", length))
+		return false
+	}
+	return true
+}
+
+// IsPhone checks for a valid phone number, an empty field is left to Required
+func (f *Form) IsPhone(field string) bool {
+	x := strings.TrimSpace(f.Get(field))
+	if x == "" {
+		return true
+	}
+
+	digits := 0
+	for _, c := range x {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+
+	if !phoneRegexp.MatchString(x) || digits < 6 {
+		f.Errors.Add(field, f.message("Invalid phone number"))
+		return false
+	}
+	return true
+}
+
+// date parses the date in a field, it returns false if the field does not hold a date
+func (f *Form) date(field string) (time.Time, bool) {
+	d, err := time.Parse(DateLayout, f.Get(field))
+	if err != nil {
+		return time.Time{}, false
 	}
+	return d, true
+}
+
+// DateRange checks that the arrival and departure fields hold dates, with the departure after the arrival,
+// and returns them
+func (f *Form) DateRange(startField, endField string) (time.Time, time.Time, bool) {
+	startDate, startOk := f.date(startField)
+	if !startOk {
+		f.Errors.Add(startField, f.message("Invalid arrival date"))
+	}
+
+	endDate, endOk := f.date(endField)
+	if !endOk {
+		f.Errors.Add(endField, f.message("Invalid departure date"))
+	}
+
+	if !startOk || !endOk {
+		return startDate, endDate, false
+	}
+
+	if !endDate.After(startDate) {
+		f.Errors.Add(endField, f.message("Departure must be after arrival"))
+		return startDate, endDate, false
+	}
+
+	return startDate, endDate, true
+}
+
+// FutureDate checks that the date in a field is not before the day of now,
+// a field that does not hold a date is left to DateRange
+func (f *Form) FutureDate(field string, now time.Time) bool {
+	d, ok := f.date(field)
+	if !ok {
+		return true
+	}
+
+	y, m, day := now.Date()
+	if d.Before(time.Date(y, m, day, 0, 0, 0, 0, time.UTC)) {
+		f.Errors.Add(field, f.message("Date cannot be in the past"))
+		return false
+	}
+	return true
+}
+
+// MaxNights checks that a stay is not longer than the given number of nights,
+// fields that do not hold dates are left to DateRange
+func (f *Form) MaxNights(startField, endField string, nights int) bool {
+	startDate, startOk := f.date(startField)
+	endDate, endOk := f.date(endField)
+	if !startOk || !endOk {
+		return true
+	}
+
+	if endDate.Sub(startDate) > time.Duration(nights)*24*time.Hour {
+		f.Errors.Add(endField, f.message("Stays cannot be longer than %d nights", nights))
+		return false
+	}
+	return true
 }
@@ -3,6 +3,7 @@ package forms
 import (
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestForm_Valid(t *testing.T) {
@@ -124,3 +125,156 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("form defined valid with a wrong email value")
 	}
 }
+
+func TestForm_MaxLength(t *testing.T) {
+	postData := url.Values{}
+	postData.Add("short_field", "abc")
+	postData.Add("long_field", "abcdefghijk")
+	f := New(postData)
+
+	if !f.MaxLength("short_field", 10) {
+		t.Error("form shows max length error for a short field")
+	}
+
+	if f.MaxLength("long_field", 10) {
+		t.Error("form allows a field longer than max length")
+	}
+
+	if f.Errors.Get("long_field") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+var phoneTests = []struct {
+	phone string
+	valid bool
+}{
+	{"", true},
+	{"123456789", true},
+	{"+39 333 123 4567", true},
+	{"(555) 010-0100", true},
+	{"555.010.0100", true},
+	{"12345", false},
+	{"phone", false},
+	{"+39 333 123 4567 ext 2", false},
+	{"-- -- --", false},
+}
+
+func TestForm_IsPhone(t *testing.T) {
+	for _, e := range phoneTests {
+		postData := url.Values{}
+		postData.Add("phone", e.phone)
+		f := New(postData)
+
+		if f.IsPhone("phone") != e.valid {
+			t.Errorf("%q: expected valid %t", e.phone, e.valid)
+		}
+
+		if f.Valid() != e.valid {
+			t.Errorf("%q: expected form valid %t", e.phone, e.valid)
+		}
+	}
+}
+
+var dateRangeTests = []struct {
+	name          string
+	start         string
+	end           string
+	valid         bool
+	errorField    string
+	expectedError string
+}{
+	{"valid", "2040-01-01", "2040-01-05", true, "", ""},
+	{"one night", "2040-01-01", "2040-01-02", true, "", ""},
+	{"invalid start", "invalid", "2040-01-05", false, "start_date", "Invalid arrival date"},
+	{"missing end", "2040-01-01", "", false, "end_date", "Invalid departure date"},
+	{"same day", "2040-01-01", "2040-01-01", false, "end_date", "Departure must be after arrival"},
+	{"end before start", "2040-01-05", "2040-01-01", false, "end_date", "Departure must be after arrival"},
+}
+
+func TestForm_DateRange(t *testing.T) {
+	for _, e := range dateRangeTests {
+		postData := url.Values{}
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", e.end)
+		f := New(postData)
+
+		startDate, endDate, ok := f.DateRange("start_date", "end_date")
+		if ok != e.valid {
+			t.Errorf("%s: expected valid %t", e.name, e.valid)
+		}
+
+		if e.valid && (startDate.Format(DateLayout) != e.start || endDate.Format(DateLayout) != e.end) {
+			t.Errorf("%s: expected dates %s - %s, got %s - %s", e.name, e.start, e.end, startDate, endDate)
+		}
+
+		if e.errorField != "" && f.Errors.Get(e.errorField) != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, f.Errors.Get(e.errorField))
+		}
+	}
+}
+
+func TestForm_FutureDate(t *testing.T) {
+	now := time.Date(2040, 1, 10, 15, 30, 0, 0, time.UTC)
+
+	var futureDateTests = []struct {
+		date  string
+		valid bool
+	}{
+		{"2040-01-10", true},
+		{"2040-01-11", true},
+		{"2040-01-09", false},
+		{"2039-12-31", false},
+		{"invalid", true},
+	}
+
+	for _, e := range futureDateTests {
+		postData := url.Values{}
+		postData.Add("start_date", e.date)
+		f := New(postData)
+
+		if f.FutureDate("start_date", now) != e.valid {
+			t.Errorf("%s: expected valid %t", e.date, e.valid)
+		}
+	}
+}
+
+func TestForm_MaxNights(t *testing.T) {
+	var maxNightsTests = []struct {
+		start string
+		end   string
+		valid bool
+	}{
+		{"2040-01-01", "2040-01-31", true},
+		{"2040-01-01", "2040-02-01", false},
+		{"2040-03-20", "2040-04-19", true},
+		{"invalid", "2040-02-01", true},
+	}
+
+	for _, e := range maxNightsTests {
+		postData := url.Values{}
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", e.end)
+		f := New(postData)
+
+		if f.MaxNights("start_date", "end_date", 30) != e.valid {
+			t.Errorf("%s - %s: expected valid %t", e.start, e.end, e.valid)
+		}
+	}
+}
+
+func TestForm_Lang(t *testing.T) {
+	form := New(url.Values{})
+	form.Lang = "it"
+
+	form.Required("a")
+	if got := form.Errors.Get("a"); got != "Questo campo è obbligatorio" {
+		t.Errorf("expected the error in Italian, got %q", got)
+	}
+
+	form.Add("b", "x")
+	form.MinLength("b", 3)
+	if got := form.Errors.Get("b"); got != "Questo campo deve essere lungo almeno 3 caratteri" {
+		t.Errorf("expected the formatted error in Italian, got %q", got)
+	}
+}
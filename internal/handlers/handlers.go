@@ -1,10 +1,21 @@
 package handlers
 
 import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +24,10 @@ import (
 	"github.com/AlessioPani/go-booking/internal/driver"
 	"github.com/AlessioPani/go-booking/internal/forms"
 	"github.com/AlessioPani/go-booking/internal/helpers"
+	"github.com/AlessioPani/go-booking/internal/i18n"
+	"github.com/AlessioPani/go-booking/internal/invoices"
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/payments"
 	"github.com/AlessioPani/go-booking/internal/renders"
 	"github.com/AlessioPani/go-booking/internal/repository"
 	"github.com/AlessioPani/go-booking/internal/repository/dbrepo"
@@ -29,14 +43,45 @@ type Repository struct {
 // Repo is the repository used by handlers
 var Repo *Repository
 
+// siteName is the name of the property, as printed on invoices
+const siteName = "Fort Smythe B&B"
+
+// entities recorded in the audit log
+const (
+	auditEntityReservation = "reservation"
+	auditEntityRoom        = "room"
+	auditEntityPromotion   = "promotion"
+	auditEntityTaxRule     = "tax rule"
+)
+
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
+	if db.Driver == driver.SQLite {
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewSqliteRepo(db.SQL, a),
+		}
+	}
+
 	return &Repository{
 		App: a,
 		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
 	}
 }
 
+// NewDemoRepo creates a new repository backed by an in memory store with sample data
+func NewDemoRepo(a *config.AppConfig) (*Repository, error) {
+	db, err := dbrepo.NewDemoRepo(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		App: a,
+		DB:  db,
+	}, nil
+}
+
 // NewTestRepo creates a new repository for testing purpose
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
@@ -50,6 +95,695 @@ func NewHandlers(r *Repository) {
 	Repo = r
 }
 
+// audit records a change in the audit log on behalf of the logged in user, if any.
+// A failure to write the audit log is logged but does not fail the request.
+func (pr *Repository) audit(r *http.Request, entity string, entityId int, action, details string) {
+	entry := models.AuditLog{
+		UserId:   pr.App.Session.GetInt(r.Context(), "user_id"),
+		Entity:   entity,
+		EntityId: entityId,
+		Action:   action,
+		Details:  details,
+	}
+
+	err := pr.DB.InsertAuditLog(r.Context(), entry)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+	}
+}
+
+// cancelReservation cancels a reservation that could not be completed, which frees its room.
+// A failure is logged but does not fail the request.
+func (pr *Repository) cancelReservation(r *http.Request, id int, reason string) {
+	err := pr.DB.UpdateReservationStatus(r.Context(), id, models.ReservationStatusCancelled)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		return
+	}
+
+	pr.audit(r, auditEntityReservation, id, "status changed",
+		fmt.Sprintf("%s -> %s, %s", models.ReservationStatusPending, models.ReservationStatusCancelled, reason))
+}
+
+// authorizeDeposit authorizes the deposit of a reservation booked online and records the payment,
+// a declined payment is recorded as failed. The returned payment has no ID when no deposit is due.
+func (pr *Repository) authorizeDeposit(ctx context.Context, res models.Reservation, token string) (models.Payment, error) {
+	_, deposit := stayPrice(res)
+	if deposit == 0 {
+		return models.Payment{}, nil
+	}
+
+	gateway := pr.App.Payments
+	payment := models.Payment{
+		ReservationId: res.ID,
+		Gateway:       gateway.Name(),
+		Amount:        deposit,
+		Currency:      pr.App.Currency,
+		Status:        models.PaymentStatusAuthorized,
+	}
+
+	reference, err := gateway.Authorize(ctx, payments.Request{
+		Amount:      deposit,
+		Currency:    pr.App.Currency,
+		Description: fmt.Sprintf("Deposit for reservation %d", res.ID),
+		Token:       token,
+	})
+	if err != nil {
+		payment.Status = models.PaymentStatusFailed
+		if _, insertErr := pr.DB.InsertPayment(ctx, payment); insertErr != nil {
+			pr.App.ErrorLog.Println(insertErr)
+		}
+		return models.Payment{}, err
+	}
+
+	payment.Reference = reference
+	payment.ID, err = pr.DB.InsertPayment(ctx, payment)
+	if err != nil {
+		// an authorization that can't be recorded would never be captured, so it is released
+		if refundErr := gateway.Refund(ctx, reference, deposit); refundErr != nil {
+			pr.App.ErrorLog.Println(refundErr)
+		}
+		return models.Payment{}, err
+	}
+
+	return payment, nil
+}
+
+// settlePayments captures or gives back the payments of a reservation moving to a new status:
+// the deposit is taken at check-in and kept for a no-show. On cancellation, the cancellation policy of the room
+// decides what is kept and the rest is given back. It returns the amount given back.
+func (pr *Repository) settlePayments(ctx context.Context, res models.Reservation, status string) (int, error) {
+	list, err := pr.DB.GetPaymentsForReservation(ctx, res.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	total, _ := stayPrice(res)
+	penalty := cancellationPolicy(res.Room).Penalty(total, res.StartDate, time.Now())
+
+	gateway := pr.App.Payments
+	refunded := 0
+	for _, p := range list {
+		capture := p.Status == models.PaymentStatusAuthorized &&
+			(status == models.ReservationStatusCheckedIn || status == models.ReservationStatusNoShow)
+		cancel := (p.Status == models.PaymentStatusAuthorized || p.Status == models.PaymentStatusCaptured) &&
+			status == models.ReservationStatusCancelled
+		if !capture && !cancel {
+			continue
+		}
+
+		if p.Gateway != gateway.Name() {
+			return refunded, fmt.Errorf("payment %d was taken through the %s gateway", p.ID, p.Gateway)
+		}
+
+		if capture {
+			err = gateway.Capture(ctx, p.Reference, p.Amount)
+			if err != nil {
+				return refunded, fmt.Errorf("payment %d: %w", p.ID, err)
+			}
+
+			err = pr.DB.UpdatePaymentStatus(ctx, p.ID, models.PaymentStatusCaptured)
+			if err != nil {
+				return refunded, err
+			}
+			continue
+		}
+
+		// the penalty is kept from the oldest payments first
+		kept := min(penalty, p.Amount)
+		penalty -= kept
+
+		err = pr.cancelPayment(ctx, p, kept)
+		if err != nil {
+			return refunded, err
+		}
+		refunded += p.Amount - kept
+	}
+
+	return refunded, nil
+}
+
+// cancelPayment keeps part of a payment of a cancelled reservation and gives back the rest
+func (pr *Repository) cancelPayment(ctx context.Context, p models.Payment, kept int) error {
+	gateway := pr.App.Payments
+	refund := p.Amount - kept
+
+	var err error
+	switch {
+	case p.Status == models.PaymentStatusAuthorized && kept > 0:
+		// capturing part of an authorization releases the rest
+		err = gateway.Capture(ctx, p.Reference, kept)
+	case refund > 0:
+		err = gateway.Refund(ctx, p.Reference, refund)
+	}
+	if err != nil {
+		return fmt.Errorf("payment %d: %w", p.ID, err)
+	}
+
+	if refund == 0 {
+		return pr.DB.UpdatePaymentStatus(ctx, p.ID, models.PaymentStatusCaptured)
+	}
+
+	return pr.DB.RefundPayment(ctx, p.ID, refund)
+}
+
+// defaultHoldDuration is how long a room is held when no hold duration has been configured
+const defaultHoldDuration = 15 * time.Minute
+
+// holdDuration returns how long a room is held for the guest filling in the reservation form
+func (pr *Repository) holdDuration() time.Duration {
+	if pr.App.HoldDuration <= 0 {
+		return defaultHoldDuration
+	}
+
+	return pr.App.HoldDuration
+}
+
+// deleteHolds releases holds, a failure is logged but does not fail the request
+func (pr *Repository) deleteHolds(r *http.Request, ids ...int) {
+	for _, id := range ids {
+		err := pr.DB.DeleteHold(r.Context(), id)
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+		}
+	}
+}
+
+// holdRoom holds the room of a reservation for the guest filling in the reservation form, in place of the
+// hold the session had before. It returns repository.ErrRoomNotAvailable if the room was taken meanwhile.
+func (pr *Repository) holdRoom(r *http.Request, res models.Reservation) error {
+	if holdId, ok := pr.App.Session.Pop(r.Context(), "hold_id").(int); ok {
+		pr.deleteHolds(r, holdId)
+	}
+
+	holdId, err := pr.DB.InsertHold(r.Context(), models.RoomRestriction{
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		RoomId:    res.RoomId,
+		ExpiresAt: time.Now().Add(pr.holdDuration()),
+	})
+	if err != nil {
+		return err
+	}
+
+	pr.App.Session.Put(r.Context(), "hold_id", holdId)
+
+	return nil
+}
+
+// holdRoomOrRedirect holds the room of a reservation, or flashes why it can't and redirects the guest
+func (pr *Repository) holdRoomOrRedirect(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	err := pr.holdRoom(r, res)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		pr.App.Session.Put(r.Context(), "error", "Sorry, the room is no longer available for the selected dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return false
+	} else if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't hold the room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return false
+	}
+
+	return true
+}
+
+// SweepExpiredHolds removes the expired room holds every interval, until ctx is done.
+// Expired holds already leave their room free, they are removed to keep the table small.
+func (pr *Repository) SweepExpiredHolds(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := pr.DB.DeleteExpiredHolds(ctx, now)
+			if err != nil {
+				if ctx.Err() == nil {
+					pr.App.ErrorLog.Println(err)
+				}
+				continue
+			}
+
+			if deleted > 0 {
+				pr.App.InfoLog.Printf("Removed %d expired room holds", deleted)
+			}
+		}
+	}
+}
+
+// defaultWaitlistOfferDuration is how long a waitlist booking link is valid when no duration has been configured
+const defaultWaitlistOfferDuration = 24 * time.Hour
+
+// offerFreedNights sends a booking link to the guests waiting for a stay in the nights of a room that have just
+// been freed, if their whole stay can now be booked. Guests are offered a stay once, in the order they joined
+// the waitlist; the first to book gets the room. A failure is logged but does not fail the request.
+func (pr *Repository) offerFreedNights(r *http.Request, roomId int, start, end time.Time) {
+	entries, err := pr.DB.WaitingForDates(r.Context(), start, end, roomId)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		return
+	}
+
+	duration := pr.App.WaitlistOfferDuration
+	if duration <= 0 {
+		duration = defaultWaitlistOfferDuration
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, e := range entries {
+		if e.StartDate.Before(today) {
+			continue
+		}
+
+		var available bool
+		if e.RoomId > 0 {
+			available, err = pr.DB.SearchAvailabilityByDatesByRoomId(r.Context(), e.StartDate, e.EndDate, e.RoomId)
+		} else {
+			var rooms []models.Room
+			rooms, err = pr.DB.SearchAvailabilityForAllRooms(r.Context(), e.StartDate, e.EndDate, e.Adults+e.Children)
+			available = len(rooms) > 0
+		}
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			continue
+		}
+		if !available {
+			continue
+		}
+
+		token := rand.Text()
+		expiresAt := time.Now().Add(duration)
+		err = pr.DB.OfferWaitlistEntry(r.Context(), e.ID, token, expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			// offered meanwhile by another request
+			continue
+		} else if err != nil {
+			pr.App.ErrorLog.Println(err)
+			continue
+		}
+
+		pr.sendWaitlistOffer(pr.absoluteURL("/waitlist/"+token), e, expiresAt)
+	}
+}
+
+// sendWaitlistOffer emails a waitlisted guest the link to book the stay they waited for
+func (pr *Repository) sendWaitlistOffer(link string, e models.WaitlistEntry, expiresAt time.Time) {
+	lang := e.Language
+
+	htmlMessage := fmt.Sprintf(
+		`<strong>%s</strong><br><br>
+		%s <br>
+		%s<br>
+		<a href="%s">%s</a><br>
+		%s<br><br>
+
+		%s<br>
+		Admin`,
+		i18n.T(lang, "A room is available"),
+		i18n.T(lang, "Dear %s,", e.FirstName),
+		i18n.T(lang, "a room is now available for your stay from %s to %s.",
+			i18n.FormatDate(lang, e.StartDate), i18n.FormatDate(lang, e.EndDate)),
+		html.EscapeString(link),
+		i18n.T(lang, "Book now"),
+		i18n.T(lang, "The link is valid until %s, the room goes to the first guest who books it.",
+			expiresAt.UTC().Format("2006-01-02 15:04")+" UTC"),
+		i18n.T(lang, "Best regards,"))
+
+	msg := models.MailData{
+		To:       e.Email,
+		From:     "reservation@me.com",
+		Subject:  i18n.T(lang, "A room is available"),
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	pr.App.MailChan <- msg
+}
+
+// absoluteURL returns the URL of path on the configured address of the site. The headers of the request are
+// not trusted: a forged Host would send the link, and its token, to another site.
+func (pr *Repository) absoluteURL(path string) string {
+	return strings.TrimSuffix(pr.App.BaseURL, "/") + path
+}
+
+// maxStayNights is the longest stay that can be booked
+const maxStayNights = 30
+
+// maxFieldLength is the size of the text columns of the reservations table
+const maxFieldLength = 255
+
+// formLimits are the named limits the validate tags of the forms below refer to
+var formLimits = map[string]int{
+	"maxStayNights":  maxStayNights,
+	"maxFieldLength": maxFieldLength,
+}
+
+// The forms below are bound with forms.Bind, with the named limits of formLimits.
+
+// guestForm holds the guest fields of a reservation form
+type guestForm struct {
+	FirstName string `form:"first_name" validate:"required,min=3,max=maxFieldLength"`
+	LastName  string `form:"last_name" validate:"required,max=maxFieldLength"`
+	Email     string `form:"email" validate:"required,email,max=maxFieldLength"`
+	Phone     string `form:"phone" validate:"phone,max=maxFieldLength"`
+}
+
+// guestsForm holds the number of guests of a stay
+type guestsForm struct {
+	Adults   int `form:"adults" validate:"min=1,max=20" message:"Invalid number of adults"`
+	Children int `form:"children" validate:"min=0,max=20" message:"Invalid number of children"`
+}
+
+// count returns the adults and children of the stay, a blank adults field is one adult
+func (g guestsForm) count() (int, int) {
+	return max(g.Adults, 1), g.Children
+}
+
+// searchForm holds the dates and the guests of an availability search
+type searchForm struct {
+	Start time.Time `form:"start" validate:"required,future" message:"Invalid arrival date"`
+	End   time.Time `form:"end" validate:"required,after=start,nights=maxStayNights" message:"Invalid departure date"`
+	guestsForm
+}
+
+// flexibleForm holds a search for stays of a number of nights, arriving up to Flex days around Start
+// or, in weekend mode, on a friday of Month (as yyyy-mm)
+type flexibleForm struct {
+	Mode   string    `form:"mode"`
+	Start  time.Time `form:"start" message:"Invalid arrival date"`
+	Flex   int       `form:"flex" validate:"min=0,max=7" message:"Invalid number of days"`
+	Month  string    `form:"month"`
+	Nights int       `form:"nights" validate:"required,min=1,max=maxStayNights" message:"Invalid number of nights"`
+	Sort   string    `form:"sort"`
+	guestsForm
+}
+
+// availabilityForm holds an availability search for a single room
+type availabilityForm struct {
+	searchForm
+	RoomID int `form:"room_id" validate:"required" message:"Invalid room"`
+}
+
+// bookRoomForm holds the dates of the book room link
+type bookRoomForm struct {
+	Start time.Time `form:"s" validate:"required,future" message:"Invalid arrival date"`
+	End   time.Time `form:"e" validate:"required,after=s,nights=maxStayNights" message:"Invalid departure date"`
+}
+
+// waitlistForm holds a guest joining the waitlist for a stay, in a room or in any room
+type waitlistForm struct {
+	FirstName string `form:"first_name" validate:"required,max=maxFieldLength"`
+	LastName  string `form:"last_name" validate:"required,max=maxFieldLength"`
+	Email     string `form:"email" validate:"required,email,max=maxFieldLength"`
+	searchForm
+	RoomID int `form:"room_id" validate:"min=0" message:"Invalid room"`
+}
+
+// reservationForm holds a new reservation
+type reservationForm struct {
+	guestForm
+	StartDate time.Time `form:"start_date" validate:"required,future" message:"Invalid arrival date"`
+	EndDate   time.Time `form:"end_date" validate:"required,after=start_date,nights=maxStayNights" message:"Invalid departure date"`
+	RoomID    int       `form:"room_id" validate:"required" message:"Invalid room"`
+	guestsForm
+	SpecialRequests string `form:"special_requests" validate:"max=1000"`
+	// PaymentToken is the card of the guest, as returned by the payment gateway to the page
+	PaymentToken string `form:"payment_token"`
+	PromoCode    string `form:"promo_code" validate:"max=maxFieldLength"`
+}
+
+// groupReservationForm holds the guest booking several rooms together, the guests of each room are read
+// from the adults_<room id> and children_<room id> fields
+type groupReservationForm struct {
+	guestForm
+	SpecialRequests string `form:"special_requests" validate:"max=1000"`
+	PaymentToken    string `form:"payment_token"`
+}
+
+// adminReservationForm holds a reservation created by staff. A walk-in is often entered after the guest
+// has arrived, so past dates are allowed, and staff take neither promotion codes nor card payments.
+type adminReservationForm struct {
+	guestForm
+	StartDate time.Time `form:"start_date" validate:"required" message:"Invalid arrival date"`
+	EndDate   time.Time `form:"end_date" validate:"required,after=start_date,nights=maxStayNights" message:"Invalid departure date"`
+	RoomID    int       `form:"room_id" validate:"required" message:"Invalid room"`
+	guestsForm
+	SpecialRequests string `form:"special_requests" validate:"max=1000"`
+}
+
+// editReservationForm holds the changes to a reservation. Names that were accepted before
+// the minimum length was introduced stay valid, and a reservation can be corrected after
+// the guest has left, so past dates are allowed.
+type editReservationForm struct {
+	FirstName string    `form:"first_name" validate:"required,max=maxFieldLength"`
+	LastName  string    `form:"last_name" validate:"required,max=maxFieldLength"`
+	Email     string    `form:"email" validate:"required,email,max=maxFieldLength"`
+	Phone     string    `form:"phone" validate:"phone,max=maxFieldLength"`
+	StartDate time.Time `form:"start_date" validate:"required" message:"Invalid arrival date"`
+	EndDate   time.Time `form:"end_date" validate:"required,after=start_date,nights=maxStayNights" message:"Invalid departure date"`
+	RoomID    int       `form:"room_id" validate:"required" message:"Invalid room"`
+	guestsForm
+	SpecialRequests string `form:"special_requests" validate:"max=1000"`
+}
+
+// promotionForm holds a promotion code edited by staff, the fixed discount is in cents
+type promotionForm struct {
+	Code            string    `form:"code" validate:"required,max=50"`
+	Description     string    `form:"description" validate:"max=maxFieldLength"`
+	DiscountPercent int       `form:"discount_percent" validate:"min=0,max=100"`
+	DiscountFixed   int       `form:"discount_fixed" validate:"min=0"`
+	ValidFrom       time.Time `form:"valid_from" validate:"required" message:"Invalid date"`
+	ValidTo         time.Time `form:"valid_to" validate:"required" message:"Invalid date"`
+	RoomID          int       `form:"room_id" validate:"min=0" message:"Invalid room"`
+	MinNights       int       `form:"min_nights" validate:"min=0,max=maxStayNights"`
+	MaxUses         int       `form:"max_uses" validate:"min=0"`
+}
+
+// validPromotionCode reports whether a code is made of letters, digits, dashes and underscores only
+func validPromotionCode(code string) bool {
+	for _, c := range code {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return code != ""
+}
+
+// promotionTerms describes the discount of a promotion, as recorded in the audit log
+func promotionTerms(promo models.Promotion, currency string) string {
+	if promo.DiscountFixed > 0 {
+		return payments.FormatAmount(promo.DiscountFixed, currency) + " off"
+	}
+
+	return fmt.Sprintf("%d%% off", promo.DiscountPercent)
+}
+
+// taxRuleForm holds a tax rule edited by staff, the amount and the cap are in cents
+type taxRuleForm struct {
+	Name           string `form:"name" validate:"required,max=maxFieldLength"`
+	Kind           string `form:"kind" validate:"required" message:"Invalid kind"`
+	Percent        int    `form:"percent" validate:"min=0,max=100"`
+	Amount         int    `form:"amount" validate:"min=0"`
+	ExemptChildren bool   `form:"exempt_children"`
+	MaxNights      int    `form:"max_nights" validate:"min=0,max=365"`
+	Cap            int    `form:"cap" validate:"min=0"`
+}
+
+// taxRule returns the rule the taxes of a stay are computed with
+func taxRule(rule models.TaxRule) payments.TaxRule {
+	return payments.TaxRule{
+		Name:           rule.Name,
+		Kind:           rule.Kind,
+		Percent:        rule.Percent,
+		Amount:         rule.Amount,
+		ExemptChildren: rule.ExemptChildren,
+		MaxNights:      rule.MaxNights,
+		Cap:            rule.Cap,
+	}
+}
+
+// taxRuleTerms describes what a tax rule charges, as listed to staff and recorded in the audit log
+func taxRuleTerms(rule models.TaxRule, currency string) string {
+	var terms string
+	switch rule.Kind {
+	case payments.TaxPercent:
+		terms = fmt.Sprintf("%d%% of the stay", rule.Percent)
+	case payments.TaxPerNight:
+		terms = payments.FormatAmount(rule.Amount, currency) + " per night"
+	default:
+		terms = payments.FormatAmount(rule.Amount, currency) + " per guest and night"
+		if rule.ExemptChildren {
+			terms += ", children exempt"
+		}
+	}
+
+	if rule.MaxNights > 0 {
+		terms += fmt.Sprintf(", up to %d nights", rule.MaxNights)
+	}
+	if rule.Cap > 0 {
+		terms += ", capped at " + payments.FormatAmount(rule.Cap, currency)
+	}
+
+	return terms
+}
+
+// taxesFor returns the taxes charged on a reservation by the current tax rules, on the price of the stay
+// after its discount. Rules charging nothing for the stay are left out.
+func (pr *Repository) taxesFor(ctx context.Context, res models.Reservation) ([]models.ReservationTax, error) {
+	rules, err := pr.DB.AllTaxRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	total, _ := stayPrice(res)
+	stay := payments.Stay{Price: total, Nights: res.Nights(), Adults: res.Adults, Children: res.Children}
+
+	var taxes []models.ReservationTax
+	for _, rule := range rules {
+		line := taxRule(rule).Apply(stay)
+		if line.Amount <= 0 {
+			continue
+		}
+
+		taxes = append(taxes, models.ReservationTax{
+			TaxRuleId: rule.ID,
+			Name:      line.Name,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			Amount:    line.Amount,
+		})
+	}
+
+	return taxes, nil
+}
+
+// checkOccupancy adds an error to the adults field if the room can't host the guests
+func checkOccupancy(form *forms.Form, room models.Room, adults, children int) {
+	if adults+children > room.MaxOccupancy {
+		form.Errors.Add("adults", i18n.T(form.Lang, "The room can host up to %d guests", room.MaxOccupancy))
+	}
+}
+
+// stayPrice returns the price of a stay, less its discount, and the deposit due when it is booked online
+func stayPrice(res models.Reservation) (int, int) {
+	total := max(res.Nights()*res.Room.NightlyRate-res.Discount, 0)
+	policy := payments.DepositPolicy{Percent: res.Room.DepositPercent, Fixed: res.Room.DepositFixed}
+
+	return total, policy.Amount(total)
+}
+
+// cancellationPolicy returns the cancellation policy of a room
+func cancellationPolicy(room models.Room) payments.CancellationPolicy {
+	return payments.CancellationPolicy{FreeDays: room.FreeCancellationDays, PenaltyPercent: room.CancellationPercent}
+}
+
+// cancellationTerms describes the cancellation policy of a reservation in the given language
+func cancellationTerms(lang string, res models.Reservation) string {
+	policy := cancellationPolicy(res.Room)
+	if policy.PenaltyPercent <= 0 {
+		return i18n.T(lang, "Free cancellation")
+	}
+
+	return i18n.T(lang, "Free cancellation until %s, then %d%% of the stay is charged",
+		i18n.FormatDate(lang, policy.FreeUntil(res.StartDate)), min(policy.PenaltyPercent, 100))
+}
+
+// addPrice adds the price of a stay, its deposit and its cancellation terms, formatted, to the string map of a page
+func (pr *Repository) addPrice(stringMap map[string]string, lang string, res models.Reservation) {
+	total, deposit := stayPrice(res)
+	if total == 0 {
+		return
+	}
+
+	stringMap["cancellation"] = cancellationTerms(lang, res)
+
+	stringMap["total"] = payments.FormatAmount(total, pr.App.Currency)
+	if res.Discount > 0 {
+		stringMap["discount"] = payments.FormatAmount(res.Discount, pr.App.Currency)
+	}
+	if deposit > 0 {
+		stringMap["deposit"] = payments.FormatAmount(deposit, pr.App.Currency)
+		stringMap["payment_gateway"] = pr.App.Payments.Name()
+	}
+	if taxes := res.TaxTotal(); taxes > 0 {
+		stringMap["currency"] = pr.App.Currency
+		stringMap["total_with_taxes"] = payments.FormatAmount(total+taxes, pr.App.Currency)
+	}
+}
+
+// applyPromotion checks the promotion code entered for a reservation and sets the discount it gives,
+// a code that can't be used for the stay adds an error to the promo_code field. It returns the promotion
+// applied, with no ID when there is none; its use is counted by the caller once the reservation is made.
+func (pr *Repository) applyPromotion(ctx context.Context, form *forms.Form, code string, res *models.Reservation) (models.Promotion, error) {
+	if strings.TrimSpace(code) == "" {
+		return models.Promotion{}, nil
+	}
+
+	promo, err := pr.DB.GetPromotionByCode(ctx, code)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("promo_code", i18n.T(form.Lang, "Invalid promotion code"))
+		return models.Promotion{}, nil
+	} else if err != nil {
+		return models.Promotion{}, err
+	}
+
+	switch {
+	case res.StartDate.Before(promo.ValidFrom) || res.StartDate.After(promo.ValidTo):
+		form.Errors.Add("promo_code", i18n.T(form.Lang, "This code is valid for arrivals from %s to %s",
+			i18n.FormatDate(form.Lang, promo.ValidFrom), i18n.FormatDate(form.Lang, promo.ValidTo)))
+	case promo.RoomId > 0 && promo.RoomId != res.RoomId:
+		form.Errors.Add("promo_code", i18n.T(form.Lang, "This code is not valid for this room"))
+	case res.Nights() < promo.MinNights:
+		form.Errors.Add("promo_code", i18n.T(form.Lang, "This code requires a stay of at least %d nights", promo.MinNights))
+	case promo.UsedUp():
+		form.Errors.Add("promo_code", i18n.T(form.Lang, "This code has been used up"))
+	default:
+		total, _ := stayPrice(*res)
+		res.PromoCode = promo.Code
+		res.Discount = payments.Discount{Percent: promo.DiscountPercent, Fixed: promo.DiscountFixed}.Amount(total)
+		return promo, nil
+	}
+
+	return models.Promotion{}, nil
+}
+
+// releasePromotion gives back the use of a promotion taken by a reservation that could not be completed.
+// A failure is logged but does not fail the request.
+func (pr *Repository) releasePromotion(r *http.Request, promo models.Promotion) {
+	if promo.ID == 0 {
+		return
+	}
+
+	err := pr.DB.ReleasePromotion(r.Context(), promo.ID)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+	}
+}
+
+// newForm returns a form holding data, with its error messages in the language of the request
+func newForm(r *http.Request, data url.Values) *forms.Form {
+	form := forms.New(data)
+	form.Lang = i18n.FromContext(r.Context())
+	form.Limits = formLimits
+
+	return form
+}
+
+// firstError returns the first error message of the given fields
+func firstError(form *forms.Form, fields ...string) string {
+	for _, field := range fields {
+		if msg := form.Errors.Get(field); msg != "" {
+			return msg
+		}
+	}
+
+	return ""
+}
+
 // Home is the homepage handler.
 func (pr *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	renders.Template(w, r, "home.page.tmpl", &models.TemplateData{})
@@ -69,7 +803,7 @@ func (pr *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := pr.DB.GetRoomById(res.RoomId)
+	room, err := pr.DB.GetRoomById(r.Context(), res.RoomId)
 	if err != nil {
 		pr.App.Session.Put(r.Context(), "error", "Can't find room")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -77,6 +811,24 @@ func (pr *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res.Room.RoomName = room.RoomName
+	res.Room.MaxOccupancy = room.MaxOccupancy
+	res.Room.NightlyRate = room.NightlyRate
+	res.Room.DepositPercent = room.DepositPercent
+	res.Room.DepositFixed = room.DepositFixed
+	res.Room.FreeCancellationDays = room.FreeCancellationDays
+	res.Room.CancellationPercent = room.CancellationPercent
+	if res.Adults < 1 {
+		// rooms booked from their own page come without a guest count
+		res.Adults = 1
+	}
+
+	res.Taxes, err = pr.taxesFor(r.Context(), res)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't compute the taxes")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 	pr.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -84,6 +836,7 @@ func (pr *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
+	pr.addPrice(stringMap, i18n.FromContext(r.Context()), res)
 
 	data := make(map[string]any)
 	data["reservation"] = res
@@ -104,679 +857,3020 @@ func (pr *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sd := r.Form.Get("start_date")
-	ed := r.Form.Get("end_date")
-
-	// 2020-01-01 -- 01/02 03:04:05PM '06 -0700
-	layout := "2006-01-02"
-
-	startDate, err := time.Parse(layout, sd)
-	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't parse start date")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	res, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// the dates are shown back as they were typed, even when they can't be read
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = r.PostForm.Get("start_date")
+	stringMap["end_date"] = r.PostForm.Get("end_date")
+
+	var input reservationForm
+	form := newForm(r, r.PostForm)
+	valid := form.Bind(&input)
+
+	// the room is a hidden field, the guest can't correct it
+	if form.Errors.Get("room_id") != "" {
+		pr.App.Session.Put(r.Context(), "error", "invalid data!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Phone:     input.Phone,
+		Email:     input.Email,
+		StartDate: input.StartDate,
+		EndDate:   input.EndDate,
+		RoomId:    input.RoomID,
+		Room:      res.Room,
+		Language:  i18n.FromContext(r.Context()),
+	}
+
+	if valid {
+		room, err := pr.DB.GetRoomById(r.Context(), input.RoomID)
+		if err != nil {
+			pr.App.Session.Put(r.Context(), "error", "Can't find room")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		reservation.Room.MaxOccupancy = room.MaxOccupancy
+		reservation.Room.NightlyRate = room.NightlyRate
+		reservation.Room.DepositPercent = room.DepositPercent
+		reservation.Room.DepositFixed = room.DepositFixed
+		reservation.Room.FreeCancellationDays = room.FreeCancellationDays
+		reservation.Room.CancellationPercent = room.CancellationPercent
+
+		adults, children := input.count()
+		checkOccupancy(form, room, adults, children)
+	}
+
+	reservation.Adults, reservation.Children = input.count()
+	reservation.SpecialRequests = input.SpecialRequests
+	stringMap["promo_code"] = input.PromoCode
+
+	promo, err := pr.applyPromotion(r.Context(), form, input.PromoCode, &reservation)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't check the promotion code")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// the use of the code is counted last, a form with errors doesn't take one
+	if form.Valid() && promo.ID > 0 {
+		err = pr.DB.UsePromotion(r.Context(), promo.ID)
+		if errors.Is(err, repository.ErrPromotionUsedUp) {
+			form.Errors.Add("promo_code", i18n.T(form.Lang, "This code has been used up"))
+			reservation.PromoCode, reservation.Discount = "", 0
+		} else if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "Can't check the promotion code")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// taxes are computed on the price after the discount of the code, if it could be used
+	reservation.Taxes, err = pr.taxesFor(r.Context(), reservation)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		if form.Valid() {
+			pr.releasePromotion(r, promo)
+		}
+		pr.App.Session.Put(r.Context(), "error", "Can't compute the taxes")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !form.Valid() {
+		pr.addPrice(stringMap, i18n.FromContext(r.Context()), reservation)
+
+		data := make(map[string]any)
+		data["reservation"] = reservation
+		renders.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	// the hold of the guest is released in the same transaction that books the room
+	holdId, _ := pr.App.Session.Pop(r.Context(), "hold_id").(int)
+
+	newReservationID, err := pr.DB.InsertBooking(r.Context(), reservation, holdId)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		pr.releasePromotion(r, promo)
+		pr.App.Session.Put(r.Context(), "error", "Sorry, the room is no longer available for the selected dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		pr.releasePromotion(r, promo)
+		pr.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	details := "booked online"
+	if reservation.PromoCode != "" {
+		details = fmt.Sprintf("%s with the promotion code %s, %s off", details, reservation.PromoCode,
+			payments.FormatAmount(reservation.Discount, pr.App.Currency))
+	}
+	pr.audit(r, auditEntityReservation, newReservationID, "created", details)
+
+	reservation.ID = newReservationID
+	payment, err := pr.authorizeDeposit(r.Context(), reservation, input.PaymentToken)
+	if err != nil {
+		if !errors.Is(err, payments.ErrDeclined) {
+			pr.App.ErrorLog.Println(err)
+		}
+
+		// the room and the promotion code are released, the guest can try again with another card
+		pr.cancelReservation(r, newReservationID, "payment not authorized")
+		pr.releasePromotion(r, promo)
+		pr.App.Session.Put(r.Context(), "error", "Your payment was not authorized, please try again")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+
+	// a reservation with a deposit is confirmed once the deposit is authorized
+	if payment.ID > 0 {
+		err = pr.DB.UpdateReservationStatus(r.Context(), newReservationID, models.ReservationStatusConfirmed)
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+		} else {
+			reservation.Status = models.ReservationStatusConfirmed
+			pr.audit(r, auditEntityReservation, newReservationID, "status changed",
+				fmt.Sprintf("%s -> %s, deposit authorized", models.ReservationStatusPending, models.ReservationStatusConfirmed))
+		}
+	}
+
+	// send mail notification - first to guest
+	pr.sendGuestConfirmation(reservation, payment)
+
+	// send mail notification - second to owner
+	htmlMessage := fmt.Sprintf(
+		`<strong>Reservation Notification</strong><br><br>
+		Dear Admin, <br>
+		there is a new reservation from Mr./Mrs. %s %s, from %s to %s. <br>
+		Guests: %d adults, %d children <br>
+		Special requests: %s <br><br><br>
+
+		Kind regards,<br>
+		Admin`,
+		reservation.FirstName, reservation.LastName, reservation.StartDate.Format("2006-01-02"),
+		reservation.EndDate.Format("2006-01-02"), reservation.Adults, reservation.Children,
+		html.EscapeString(reservation.SpecialRequests))
+
+	msgToAdmin := models.MailData{
+		To:      "me@me.com",
+		From:    "me@me.com",
+		Subject: "Reservation Notice",
+		Content: htmlMessage,
+	}
+
+	pr.App.MailChan <- msgToAdmin
+
+	pr.App.Session.Put(r.Context(), "reservation", reservation)
+	pr.App.Session.Remove(r.Context(), "booked_lines")
+	// the guest can cancel the reservation from this session
+	pr.App.Session.Put(r.Context(), "booked_id", newReservationID)
+
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// PostCancelReservation cancels the reservation made from the session of the guest, with the other rooms
+// of its group if any, giving back what the cancellation policy of each room allows
+func (pr *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pr.App.Session.Get(r.Context(), "booked_id").(int)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res, err := pr.DB.GetReservationById(r.Context(), id)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !res.CanTransitionTo(models.ReservationStatusCancelled) {
+		pr.App.Session.Remove(r.Context(), "booked_id")
+		pr.App.Session.Put(r.Context(), "error", "This reservation can't be cancelled")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	lines := []models.Reservation{res}
+	if res.GroupId > 0 {
+		lines, err = pr.DB.GetReservationsByGroup(r.Context(), res.GroupId)
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "Can't cancel the reservation, please contact us")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	refunded := 0
+	for _, line := range lines {
+		// a room of the group already cancelled, or checked in, is left as it is
+		if !line.CanTransitionTo(models.ReservationStatusCancelled) {
+			continue
+		}
+
+		lineRefunded, err := pr.settlePayments(r.Context(), line, models.ReservationStatusCancelled)
+		refunded += lineRefunded
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "Can't cancel the reservation, please contact us")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		err = pr.DB.UpdateReservationStatus(r.Context(), line.ID, models.ReservationStatusCancelled)
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "Can't cancel the reservation, please contact us")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		details := fmt.Sprintf("%s -> %s, cancelled by the guest", line.Status, models.ReservationStatusCancelled)
+		if lineRefunded > 0 {
+			details = fmt.Sprintf("%s, %s refunded", details, payments.FormatAmount(lineRefunded, pr.App.Currency))
+		}
+		pr.audit(r, auditEntityReservation, line.ID, "status changed", details)
+		pr.offerFreedNights(r, line.RoomId, line.StartDate, line.EndDate)
+	}
+
+	pr.App.Session.Remove(r.Context(), "booked_id")
+
+	lang := i18n.FromContext(r.Context())
+	if refunded > 0 {
+		pr.App.Session.Put(r.Context(), "flash", i18n.T(lang, "Your reservation has been cancelled, %s will be refunded",
+			payments.FormatAmount(refunded, pr.App.Currency)))
+	} else {
+		pr.App.Session.Put(r.Context(), "flash", i18n.T(lang, "Your reservation has been cancelled"))
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sendGuestConfirmation sends the reservation confirmation email to the guest, with the deposit authorized if any
+func (pr *Repository) sendGuestConfirmation(reservation models.Reservation, deposit models.Payment) {
+	lang := reservation.Language
+
+	var discountLine string
+	if reservation.Discount > 0 {
+		discountLine = i18n.T(lang, "Promotion code %s: %s off the stay.", reservation.PromoCode,
+			payments.FormatAmount(reservation.Discount, pr.App.Currency)) + "<br>"
+	}
+
+	var depositLine string
+	if deposit.ID > 0 {
+		depositLine = i18n.T(lang, "A deposit of %s has been authorized on your card.", payments.FormatAmount(deposit.Amount, deposit.Currency)) + "<br>"
+	}
+
+	htmlMessage := fmt.Sprintf(
+		`<strong>%s</strong><br><br>
+		%s <br>
+		%s<br>
+		%s<br>
+		%s%s<br>
+		%s<br>
+
+		%s<br><br>
+		%s<br>
+		Admin`,
+		i18n.T(lang, "Reservation Confirmation"),
+		i18n.T(lang, "Dear %s,", reservation.FirstName),
+		i18n.T(lang, "this is a confirmation of your reservation from %s to %s.",
+			i18n.FormatDate(lang, reservation.StartDate), i18n.FormatDate(lang, reservation.EndDate)),
+		i18n.T(lang, "Guests: %d adults, %d children", reservation.Adults, reservation.Children),
+		discountLine,
+		depositLine,
+		i18n.T(lang, "Cancellation policy: %s.", cancellationTerms(lang, reservation)),
+		i18n.T(lang, "Looking forward to see you soon"),
+		i18n.T(lang, "Best regards,"))
+
+	msg := models.MailData{
+		To:       reservation.Email,
+		From:     "reservation@me.com",
+		Subject:  i18n.T(lang, "Reservation Confirmation"),
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	pr.App.MailChan <- msg
+}
+
+// Generals renders the room page
+func (pr *Repository) Generals(w http.ResponseWriter, r *http.Request) {
+	renders.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+}
+
+// Majors renders the room page
+func (pr *Repository) Majors(w http.ResponseWriter, r *http.Request) {
+	renders.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+}
+
+// Availability renders the search availability page. A flexible search, sent as url parameters,
+// is run and its stays are listed on the page.
+func (pr *Repository) Availability(w http.ResponseWriter, r *http.Request) {
+	form := newForm(r, r.URL.Query())
+	data := make(map[string]any)
+
+	var input flexibleForm
+	if form.Has("mode") && form.Bind(&input) {
+		from, to, near := flexibleWindow(form, input, today())
+		if form.Valid() {
+			rooms, err := pr.searchFlexible(r.Context(), input, from, to, near)
+			if err != nil {
+				pr.App.ErrorLog.Println(err)
+				pr.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+
+			data["rooms"] = rooms
+			data["currency"] = pr.App.Currency
+			data["searched"] = true
+		}
+	}
+
+	renders.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostAvailability renders the search availability page
+func (pr *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var input searchForm
+	form := newForm(r, r.PostForm)
+	if !form.Bind(&input) {
+		pr.App.Session.Put(r.Context(), "error", firstError(form, "start", "end", "adults", "children"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	adults, children := input.count()
+	rooms, err := pr.DB.SearchAvailabilityForAllRooms(r.Context(), input.Start, input.End, adults+children)
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// a party that no room can host on its own may still fit in several rooms booked together
+	groupOnly := false
+	if len(rooms) == 0 && adults+children > 1 {
+		free, err := pr.DB.SearchAvailabilityForAllRooms(r.Context(), input.Start, input.End, 1)
+		if err != nil {
+			pr.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		capacity := 0
+		for _, room := range free {
+			capacity += room.MaxOccupancy
+		}
+		if len(free) > 1 && capacity >= adults+children {
+			rooms, groupOnly = free, true
+		}
+	}
+
+	if len(rooms) == 0 {
+		// no availability, the guest can wait for a room to be freed
+		search := url.Values{}
+		search.Set("start", input.Start.Format(forms.DateLayout))
+		search.Set("end", input.End.Format(forms.DateLayout))
+		search.Set("adults", strconv.Itoa(adults))
+		search.Set("children", strconv.Itoa(children))
+
+		pr.App.Session.Put(r.Context(), "error", "No availability")
+		http.Redirect(w, r, "/waitlist?"+search.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]any)
+	data["rooms"] = rooms
+	data["group_only"] = groupOnly
+
+	res := models.Reservation{
+		StartDate: input.Start,
+		EndDate:   input.End,
+		Adults:    adults,
+		Children:  children,
+	}
+
+	pr.App.Session.Put(r.Context(), "reservation", res)
+
+	renders.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+
+}
+
+// Flexible search modes and orders
+const (
+	flexibleAround  = "around"
+	flexibleWeekend = "weekend"
+	sortByDate      = "date"
+	sortByPrice     = "price"
+)
+
+// flexibleStaysPerRoom is the number of stays a flexible search offers in each room
+const flexibleStaysPerRoom = 3
+
+// flexibleStay is a stay offered by a flexible search, its price is in cents
+type flexibleStay struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Price     int
+}
+
+// roomStays are the best stays a flexible search found in a room, the best first
+type roomStays struct {
+	Room  models.Room
+	Stays []flexibleStay
+}
+
+// flexibleWindow checks a flexible search and returns the first and the last arrival it allows, with the
+// date stays are ranked by their distance from: the arrival asked for, or the first arrival of the month
+func flexibleWindow(form *forms.Form, input flexibleForm, today time.Time) (time.Time, time.Time, time.Time) {
+	switch input.Mode {
+	case flexibleAround:
+		if form.Get("start") == "" {
+			form.Errors.Add("start", i18n.T(form.Lang, "Invalid arrival date"))
+		} else {
+			form.FutureDate("start", today)
+		}
+		if !form.Valid() {
+			return time.Time{}, time.Time{}, time.Time{}
+		}
+
+		from := input.Start.AddDate(0, 0, -input.Flex)
+		if from.Before(today) {
+			from = today
+		}
+		return from, input.Start.AddDate(0, 0, input.Flex), input.Start
+	case flexibleWeekend:
+		month, err := time.Parse("2006-01", input.Month)
+		end := month.AddDate(0, 1, -1)
+		if err != nil || end.Before(today) {
+			form.Errors.Add("month", i18n.T(form.Lang, "Invalid month"))
+			return time.Time{}, time.Time{}, time.Time{}
+		}
+		if month.Before(today) {
+			month = today
+		}
+		return month, end, month
+	}
+
+	form.Errors.Add("mode", i18n.T(form.Lang, "Invalid search"))
+	return time.Time{}, time.Time{}, time.Time{}
+}
+
+// searchFlexible returns the best stays that can be booked in each room for a flexible search, rooms with
+// the best stay first. Stays are ranked by price, then by distance from near, or the other way round.
+func (pr *Repository) searchFlexible(ctx context.Context, input flexibleForm, from, to, near time.Time) ([]roomStays, error) {
+	adults, children := input.count()
+	options, err := pr.DB.SearchFlexibleAvailability(ctx, from, to, input.Nights, adults+children)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Mode == flexibleWeekend {
+		options = slices.DeleteFunc(options, func(o models.StayOption) bool {
+			return o.StartDate.Weekday() != time.Friday
+		})
+	}
+
+	distance := func(o models.StayOption) time.Duration {
+		d := o.StartDate.Sub(near)
+		return max(d, -d)
+	}
+	price := func(o models.StayOption) int {
+		total, _ := stayPrice(models.Reservation{StartDate: o.StartDate, EndDate: o.EndDate, Room: o.Room})
+		return total
+	}
+	slices.SortStableFunc(options, func(a, b models.StayOption) int {
+		byDate := cmp.Compare(distance(a), distance(b))
+		byPrice := cmp.Compare(price(a), price(b))
+		if input.Sort == sortByPrice {
+			return cmp.Or(byPrice, byDate, a.StartDate.Compare(b.StartDate))
+		}
+		return cmp.Or(byDate, byPrice, a.StartDate.Compare(b.StartDate))
+	})
+
+	var rooms []roomStays
+	index := make(map[int]int)
+	for _, o := range options {
+		i, ok := index[o.Room.ID]
+		if !ok {
+			i = len(rooms)
+			index[o.Room.ID] = i
+			rooms = append(rooms, roomStays{Room: o.Room})
+		}
+		if len(rooms[i].Stays) < flexibleStaysPerRoom {
+			rooms[i].Stays = append(rooms[i].Stays, flexibleStay{StartDate: o.StartDate, EndDate: o.EndDate, Price: price(o)})
+		}
+	}
+
+	return rooms, nil
+}
+
+// today returns midnight UTC of the current day, as the dates of the forms are parsed
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Waitlist renders the form to join the waitlist, filled in with the search that found no room
+func (pr *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	pr.renderWaitlist(w, r, newForm(r, r.URL.Query()))
+}
+
+// renderWaitlist renders the waitlist form
+func (pr *Repository) renderWaitlist(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := pr.DB.AllRooms(r.Context())
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't get the rooms")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]any)
+	data["rooms"] = rooms
+
+	renders.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostWaitlist adds the guest to the waitlist of a stay
+func (pr *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var input waitlistForm
+	form := newForm(r, r.PostForm)
+	if form.Bind(&input) && input.RoomID > 0 {
+		room, err := pr.DB.GetRoomById(r.Context(), input.RoomID)
+		if err != nil {
+			form.Errors.Add("room_id", i18n.T(form.Lang, "Invalid room"))
+		} else {
+			adults, children := input.count()
+			checkOccupancy(form, room, adults, children)
+		}
+	}
+
+	if !form.Valid() {
+		pr.renderWaitlist(w, r, form)
+		return
+	}
+
+	adults, children := input.count()
+	_, err = pr.DB.InsertWaitlistEntry(r.Context(), models.WaitlistEntry{
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Email:     input.Email,
+		StartDate: input.Start,
+		EndDate:   input.End,
+		RoomId:    input.RoomID,
+		Adults:    adults,
+		Children:  children,
+		Language:  i18n.FromContext(r.Context()),
+	})
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't add you to the waitlist")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	pr.App.Session.Put(r.Context(), "flash", "You are on the waitlist, we will email you if a room becomes available")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// WaitlistOffer follows the booking link sent to a waitlisted guest: the room waited for is held and the guest
+// fills in the reservation form, a guest waiting for any room chooses one of the rooms available
+func (pr *Repository) WaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	entry, err := pr.DB.GetWaitlistEntryByToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		pr.App.Session.Put(r.Context(), "error", "This link is not valid")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		pr.App.ErrorLog.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Can't get the waitlist offer")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if time.Now().After(entry.ExpiresAt) {
+		pr.App.Session.Put(r.Context(), "error", "This link has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: entry.FirstName,
+		LastName:  entry.LastName,
+		Email:     entry.Email,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		Adults:    entry.Adults,
+		Children:  entry.Children,
+	}
+
+	if entry.RoomId == 0 {
+		rooms, err := pr.DB.SearchAvailabilityForAllRooms(r.Context(), entry.StartDate, entry.EndDate, entry.Adults+entry.Children)
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		if len(rooms) == 0 {
+			pr.App.Session.Put(r.Context(), "error", "Sorry, the room is no longer available for the selected dates")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+
+		pr.App.Session.Put(r.Context(), "reservation", res)
+
+		data := make(map[string]any)
+		data["rooms"] = rooms
+		renders.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+			Data: data,
+		})
+		return
+	}
+
+	room, err := pr.DB.GetRoomById(r.Context(), entry.RoomId)
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "Can't find room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.RoomId = entry.RoomId
+	res.Room.RoomName = room.RoomName
+
+	if !pr.holdRoomOrRedirect(w, r, res) {
+		return
+	}
+
+	pr.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+type jsonResponse struct {
+	OK        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomID    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// AvailabilityJSON handlers requests for availability and send JSON response.
+// The search is read from a JSON body when the request has one, from the form otherwise.
+func (pr *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	form, err := availabilityRequestForm(r)
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Internal server error",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	var input availabilityForm
+	if form.Bind(&input) {
+		room, err := pr.DB.GetRoomById(r.Context(), input.RoomID)
+		if err != nil {
+			form.Errors.Add("room_id", i18n.T(form.Lang, "Invalid room"))
+		} else {
+			adults, children := input.count()
+			checkOccupancy(form, room, adults, children)
+		}
+	}
+
+	if !form.Valid() {
+		resp := jsonResponse{
+			OK:      false,
+			Message: firstError(form, "start", "end", "room_id", "adults", "children"),
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	available, err := pr.DB.SearchAvailabilityByDatesByRoomId(r.Context(), input.Start, input.End, input.RoomID)
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Error connecting to the database",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:        available,
+		Message:   "",
+		StartDate: form.Get("start"),
+		EndDate:   form.Get("end"),
+		RoomID:    strconv.Itoa(input.RoomID),
+	}
+
+	// removed error checking, resp is created manually
+	out, _ := json.MarshalIndent(resp, "", " ")
+
+	w.Header().Set("Content-type", "application/json")
+	w.Write(out)
+}
+
+// maxCalendarDays is the longest period the availability calendar returns at once
+const maxCalendarDays = 93
+
+// calendarForm holds the period of the availability calendar of a room, to is excluded
+type calendarForm struct {
+	RoomID int       `form:"room_id" validate:"required" message:"Invalid room"`
+	From   time.Time `form:"from" validate:"required" message:"Invalid date"`
+	To     time.Time `form:"to" validate:"required" message:"Invalid date"`
+}
+
+// calendarDay is a day of the availability calendar, the price of its night is in cents
+type calendarDay struct {
+	Date      string `json:"date"`
+	Available bool   `json:"available"`
+	Price     int    `json:"price,omitempty"`
+}
+
+// calendarResponse is the availability calendar of a room
+type calendarResponse struct {
+	OK       bool          `json:"ok"`
+	Message  string        `json:"message,omitempty"`
+	RoomID   int           `json:"room_id,omitempty"`
+	From     string        `json:"from,omitempty"`
+	To       string        `json:"to,omitempty"`
+	Currency string        `json:"currency,omitempty"`
+	Days     []calendarDay `json:"days,omitempty"`
+}
+
+// writeJSON sends v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	out, _ := json.MarshalIndent(v, "", "  ")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// AvailabilityCalendar returns the days of a room from the from date until the day before the to date,
+// telling for each whether its night can be booked and its price. Days in the past are never available.
+func (pr *Repository) AvailabilityCalendar(w http.ResponseWriter, r *http.Request) {
+	form := newForm(r, r.URL.Query())
+
+	var input calendarForm
+	if form.Bind(&input) && !input.To.After(input.From) {
+		form.Errors.Add("to", i18n.T(form.Lang, "Invalid date"))
+	} else if form.Valid() && input.To.Sub(input.From) > maxCalendarDays*24*time.Hour {
+		form.Errors.Add("to", i18n.T(form.Lang, "The calendar can show up to %d days", maxCalendarDays))
+	}
+
+	if !form.Valid() {
+		writeJSON(w, http.StatusBadRequest, calendarResponse{Message: firstError(form, "room_id", "from", "to")})
+		return
+	}
+
+	days, err := pr.DB.AvailabilityCalendar(r.Context(), input.RoomID, input.From, input.To)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, calendarResponse{Message: i18n.T(form.Lang, "Invalid room")})
+		return
+	}
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		writeJSON(w, http.StatusInternalServerError, calendarResponse{Message: "Error connecting to the database"})
+		return
+	}
+
+	resp := calendarResponse{
+		OK:     true,
+		RoomID: input.RoomID,
+		From:   input.From.Format(forms.DateLayout),
+		To:     input.To.Format(forms.DateLayout),
+		Days:   make([]calendarDay, 0, len(days)),
+	}
+
+	today := time.Now().Format(forms.DateLayout)
+	for _, d := range days {
+		day := calendarDay{Date: d.Date.Format(forms.DateLayout), Available: d.Available, Price: d.Price}
+		if day.Date < today {
+			day.Available = false
+		}
+		if day.Price > 0 {
+			resp.Currency = pr.App.Currency
+		}
+		resp.Days = append(resp.Days, day)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// flexibleStayJSON is a stay offered by a flexible search, its price is in cents
+type flexibleStayJSON struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Price     int    `json:"price,omitempty"`
+}
+
+// roomStaysJSON are the best stays found in a room
+type roomStaysJSON struct {
+	RoomID   int                `json:"room_id"`
+	RoomName string             `json:"room_name"`
+	Stays    []flexibleStayJSON `json:"stays"`
+}
+
+// flexibleResponse is the result of a flexible search
+type flexibleResponse struct {
+	OK       bool            `json:"ok"`
+	Message  string          `json:"message,omitempty"`
+	Nights   int             `json:"nights,omitempty"`
+	Currency string          `json:"currency,omitempty"`
+	Rooms    []roomStaysJSON `json:"rooms,omitempty"`
+}
+
+// FlexibleAvailabilityJSON runs a flexible search, sent as url parameters, and returns the best stays of each room
+func (pr *Repository) FlexibleAvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	form := newForm(r, r.URL.Query())
+
+	var input flexibleForm
+	var from, to, near time.Time
+	if form.Bind(&input) {
+		from, to, near = flexibleWindow(form, input, today())
+	}
+
+	if !form.Valid() {
+		msg := firstError(form, "mode", "start", "flex", "month", "nights", "adults", "children")
+		writeJSON(w, http.StatusBadRequest, flexibleResponse{Message: msg})
+		return
+	}
+
+	rooms, err := pr.searchFlexible(r.Context(), input, from, to, near)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+		writeJSON(w, http.StatusInternalServerError, flexibleResponse{Message: "Error connecting to the database"})
+		return
+	}
+
+	resp := flexibleResponse{
+		OK:     true,
+		Nights: input.Nights,
+		Rooms:  make([]roomStaysJSON, 0, len(rooms)),
+	}
+	for _, room := range rooms {
+		rs := roomStaysJSON{RoomID: room.Room.ID, RoomName: room.Room.RoomName}
+		for _, stay := range room.Stays {
+			rs.Stays = append(rs.Stays, flexibleStayJSON{
+				StartDate: stay.StartDate.Format(forms.DateLayout),
+				EndDate:   stay.EndDate.Format(forms.DateLayout),
+				Price:     stay.Price,
+			})
+			if stay.Price > 0 {
+				resp.Currency = pr.App.Currency
+			}
+		}
+		resp.Rooms = append(resp.Rooms, rs)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Contact renders the contact page
+func (pr *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	renders.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+}
+
+// ReservationSummary displays the reservation summary page
+func (pr *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	pr.App.Session.Remove(r.Context(), "reservation")
+
+	data := make(map[string]any)
+	data["reservation"] = reservation
+
+	sd := reservation.StartDate.Format("2006-01-02")
+	ed := reservation.EndDate.Format("2006-01-02")
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+
+	// a group booking is summed up room by room
+	if lines, ok := pr.App.Session.Pop(r.Context(), "booked_lines").([]models.Reservation); ok {
+		data["lines"] = pr.groupLines(stringMap, i18n.FromContext(r.Context()), lines)
+	} else {
+		pr.addPrice(stringMap, i18n.FromContext(r.Context()), reservation)
+	}
+
+	renders.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// ChooseRoom displays a list of available rooms
+func (pr *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := helpers.IntURLParam(r, "id")
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.RoomId = roomID
+
+	if !pr.holdRoomOrRedirect(w, r, res) {
+		return
+	}
+
+	pr.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// groupLine is a room of a group booking as shown to the guest, with its price
+type groupLine struct {
+	models.Reservation
+	Total        int
+	Deposit      int
+	Cancellation string
+}
+
+// groupLines returns the rooms of a group booking with their price, and adds the totals of the group,
+// formatted, to the string map of a page
+func (pr *Repository) groupLines(stringMap map[string]string, lang string, lines []models.Reservation) []groupLine {
+	var views []groupLine
+	var total, deposit, taxes int
+	for _, res := range lines {
+		price, due := stayPrice(res)
+		total += price
+		deposit += due
+		taxes += res.TaxTotal()
+		views = append(views, groupLine{Reservation: res, Total: price, Deposit: due, Cancellation: cancellationTerms(lang, res)})
+	}
+
+	stringMap["currency"] = pr.App.Currency
+	if total > 0 {
+		stringMap["total"] = payments.FormatAmount(total, pr.App.Currency)
+	}
+	if deposit > 0 {
+		stringMap["deposit"] = payments.FormatAmount(deposit, pr.App.Currency)
+		stringMap["payment_gateway"] = pr.App.Payments.Name()
+	}
+	if taxes > 0 {
+		stringMap["total_with_taxes"] = payments.FormatAmount(total+taxes, pr.App.Currency)
+	}
+
+	return views
+}
+
+// splitGuests spreads the guests of a search over the rooms of a group, filling each room in turn.
+// Every room gets at least one adult.
+func splitGuests(lines []models.Reservation, adults, children int) {
+	for i := range lines {
+		capacity := lines[i].Room.MaxOccupancy
+		lines[i].Adults = max(min(adults, capacity), 1)
+		lines[i].Children = max(min(children, capacity-lines[i].Adults), 0)
+		adults = max(adults-lines[i].Adults, 0)
+		children -= lines[i].Children
+	}
+}
+
+// ChooseRooms holds the rooms chosen for a group booking, for the dates of the search of the session,
+// and takes the guest to the group reservation page
+func (pr *Repository) ChooseRooms(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var roomIds []int
+	for _, v := range r.PostForm["room_id"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			pr.App.Session.Put(r.Context(), "error", "invalid data!")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if !slices.Contains(roomIds, id) {
+			roomIds = append(roomIds, id)
+		}
+	}
+
+	if len(roomIds) < 2 {
+		pr.App.Session.Put(r.Context(), "error", "Please choose at least two rooms")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	// the holds of an earlier choice are released first
+	if holdId, ok := pr.App.Session.Pop(r.Context(), "hold_id").(int); ok {
+		pr.deleteHolds(r, holdId)
+	}
+	if holds, ok := pr.App.Session.Pop(r.Context(), "group_holds").([]int); ok {
+		pr.deleteHolds(r, holds...)
+	}
+
+	var lines []models.Reservation
+	var holds []int
+	for _, roomId := range roomIds {
+		room, err := pr.DB.GetRoomById(r.Context(), roomId)
+		if err != nil {
+			pr.deleteHolds(r, holds...)
+			pr.App.Session.Put(r.Context(), "error", "Can't find room")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		holdId, err := pr.DB.InsertHold(r.Context(), models.RoomRestriction{
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+			RoomId:    roomId,
+			ExpiresAt: time.Now().Add(pr.holdDuration()),
+		})
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			pr.deleteHolds(r, holds...)
+			pr.App.Session.Put(r.Context(), "error", "Sorry, the room is no longer available for the selected dates")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.deleteHolds(r, holds...)
+			pr.App.Session.Put(r.Context(), "error", "Can't hold the room")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		holds = append(holds, holdId)
+		lines = append(lines, models.Reservation{
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+			RoomId:    roomId,
+			Room:      room,
+		})
+	}
+
+	splitGuests(lines, res.Adults, res.Children)
+
+	pr.App.Session.Put(r.Context(), "group", lines)
+	pr.App.Session.Put(r.Context(), "group_holds", holds)
+
+	http.Redirect(w, r, "/make-group-reservation", http.StatusSeeOther)
+}
+
+// GroupReservation renders the reservation page of a group booking, with a line for each room
+func (pr *Repository) GroupReservation(w http.ResponseWriter, r *http.Request) {
+	lines, ok := pr.App.Session.Get(r.Context(), "group").([]models.Reservation)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	for i := range lines {
+		var err error
+		lines[i].Taxes, err = pr.taxesFor(r.Context(), lines[i])
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "Can't compute the taxes")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	pr.renderGroupReservation(w, r, forms.New(nil), models.Reservation{}, lines)
+}
+
+// renderGroupReservation renders the group reservation form, guest holds the fields shared by the rooms
+func (pr *Repository) renderGroupReservation(w http.ResponseWriter, r *http.Request, form *forms.Form, guest models.Reservation, lines []models.Reservation) {
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = lines[0].StartDate.Format("2006-01-02")
+	stringMap["end_date"] = lines[0].EndDate.Format("2006-01-02")
+
+	data := make(map[string]any)
+	data["guest"] = guest
+	data["lines"] = pr.groupLines(stringMap, i18n.FromContext(r.Context()), lines)
+
+	renders.Template(w, r, "make-group-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostGroupReservation books the rooms of a group together, as one reservation per room.
+// Either every room is booked or none is, and the guest gets a single confirmation.
+func (pr *Repository) PostGroupReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	lines, ok := pr.App.Session.Get(r.Context(), "group").([]models.Reservation)
+	if !ok {
+		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var input groupReservationForm
+	form := newForm(r, r.PostForm)
+	form.Bind(&input)
+
+	guest := models.Reservation{
+		FirstName:       input.FirstName,
+		LastName:        input.LastName,
+		Email:           input.Email,
+		Phone:           input.Phone,
+		SpecialRequests: input.SpecialRequests,
+	}
+
+	for i := range lines {
+		// the guests of each room are checked on their own, their errors are shown next to the room
+		adultsField := fmt.Sprintf("adults_%d", lines[i].RoomId)
+		lineForm := newForm(r, url.Values{
+			"adults":   {r.PostForm.Get(adultsField)},
+			"children": {r.PostForm.Get(fmt.Sprintf("children_%d", lines[i].RoomId))},
+		})
+
+		var guests guestsForm
+		if lineForm.Bind(&guests) {
+			adults, children := guests.count()
+			checkOccupancy(lineForm, lines[i].Room, adults, children)
+		}
+		if msg := firstError(lineForm, "adults", "children"); msg != "" {
+			form.Errors.Add(adultsField, msg)
+		}
+
+		lines[i].FirstName = guest.FirstName
+		lines[i].LastName = guest.LastName
+		lines[i].Email = guest.Email
+		lines[i].Phone = guest.Phone
+		lines[i].SpecialRequests = guest.SpecialRequests
+		lines[i].Language = i18n.FromContext(r.Context())
+		lines[i].Adults, lines[i].Children = guests.count()
+
+		lines[i].Taxes, err = pr.taxesFor(r.Context(), lines[i])
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			pr.App.Session.Put(r.Context(), "error", "Can't compute the taxes")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		pr.renderGroupReservation(w, r, form, guest, lines)
+		return
+	}
+
+	holds, _ := pr.App.Session.Pop(r.Context(), "group_holds").([]int)
+	groupId, ids, err := pr.DB.InsertReservationGroup(r.Context(), lines, holds)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		pr.deleteHolds(r, holds...)
+		pr.App.Session.Remove(r.Context(), "group")
+		pr.App.Session.Put(r.Context(), "error", "Sorry, the rooms are no longer available for the selected dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		pr.App.ErrorLog.Println(err)
+		// nothing was booked, the rooms stay held for another try
+		pr.App.Session.Put(r.Context(), "group_holds", holds)
+		pr.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	for i := range lines {
+		lines[i].ID = ids[i]
+		lines[i].GroupId = groupId
+		lines[i].Status = models.ReservationStatusPending
+		pr.audit(r, auditEntityReservation, ids[i], "created", fmt.Sprintf("booked online in group %d", groupId))
+	}
+
+	var deposits []models.Payment
+	for _, res := range lines {
+		payment, err := pr.authorizeDeposit(r.Context(), res, input.PaymentToken)
+		if err != nil {
+			if !errors.Is(err, payments.ErrDeclined) {
+				pr.App.ErrorLog.Println(err)
+			}
+
+			// the whole group is released, with the deposits already authorized, the guest can try again
+			for _, p := range deposits {
+				if p.ID == 0 {
+					continue
+				}
+				if err := pr.cancelPayment(r.Context(), p, 0); err != nil {
+					pr.App.ErrorLog.Println(err)
+				}
+			}
+			for _, line := range lines {
+				pr.cancelReservation(r, line.ID, "payment not authorized")
+			}
+
+			pr.App.Session.Put(r.Context(), "error", "Your payment was not authorized, please try again")
+			http.Redirect(w, r, "/make-group-reservation", http.StatusSeeOther)
+			return
+		}
+		deposits = append(deposits, payment)
+	}
+
+	// a room with a deposit is confirmed once its deposit is authorized
+	for i, payment := range deposits {
+		if payment.ID == 0 {
+			continue
+		}
+
+		err = pr.DB.UpdateReservationStatus(r.Context(), lines[i].ID, models.ReservationStatusConfirmed)
+		if err != nil {
+			pr.App.ErrorLog.Println(err)
+			continue
+		}
+		lines[i].Status = models.ReservationStatusConfirmed
+		pr.audit(r, auditEntityReservation, lines[i].ID, "status changed",
+			fmt.Sprintf("%s -> %s, deposit authorized", models.ReservationStatusPending, models.ReservationStatusConfirmed))
+	}
+
+	// one mail for the whole group to the guest, then one to the owner
+	pr.sendGroupConfirmation(lines, deposits)
+
+	var rooms strings.Builder
+	for _, res := range lines {
+		fmt.Fprintf(&rooms, "%s: %d adults, %d children <br>", res.Room.RoomName, res.Adults, res.Children)
+	}
+
+	htmlMessage := fmt.Sprintf(
+		`<strong>Reservation Notification</strong><br><br>
+		Dear Admin, <br>
+		there is a new group reservation of %d rooms from Mr./Mrs. %s %s, from %s to %s. <br>
+		%s
+		Special requests: %s <br><br><br>
+
+		Kind regards,<br>
+		Admin`,
+		len(lines), guest.FirstName, guest.LastName, lines[0].StartDate.Format("2006-01-02"),
+		lines[0].EndDate.Format("2006-01-02"), rooms.String(), html.EscapeString(guest.SpecialRequests))
+
+	pr.App.MailChan <- models.MailData{
+		To:      "me@me.com",
+		From:    "me@me.com",
+		Subject: "Reservation Notice",
+		Content: htmlMessage,
+	}
+
+	pr.App.Session.Remove(r.Context(), "group")
+	pr.App.Session.Put(r.Context(), "reservation", lines[0])
+	pr.App.Session.Put(r.Context(), "booked_lines", lines)
+	// the guest can cancel the group from this session
+	pr.App.Session.Put(r.Context(), "booked_id", lines[0].ID)
+
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// sendGroupConfirmation sends a single confirmation email for the rooms of a group booking,
+// with the deposits authorized if any
+func (pr *Repository) sendGroupConfirmation(lines []models.Reservation, deposits []models.Payment) {
+	lead := lines[0]
+	lang := lead.Language
+
+	var rooms strings.Builder
+	for _, res := range lines {
+		fmt.Fprintf(&rooms, "%s: %s %s<br>", html.EscapeString(res.Room.RoomName),
+			i18n.T(lang, "Guests: %d adults, %d children", res.Adults, res.Children),
+			i18n.T(lang, "Cancellation policy: %s.", cancellationTerms(lang, res)))
+	}
+
+	var depositLine string
+	deposit := 0
+	for _, p := range deposits {
+		deposit += p.Amount
+	}
+	if deposit > 0 {
+		depositLine = i18n.T(lang, "A deposit of %s has been authorized on your card.", payments.FormatAmount(deposit, pr.App.Currency)) + "<br>"
+	}
+
+	htmlMessage := fmt.Sprintf(
+		`<strong>%s</strong><br><br>
+		%s <br>
+		%s<br>
+		%s<br>
+		%s%s<br>
+
+		%s<br><br>
+		%s<br>
+		Admin`,
+		i18n.T(lang, "Reservation Confirmation"),
+		i18n.T(lang, "Dear %s,", lead.FirstName),
+		i18n.T(lang, "this is a confirmation of your reservation of %d rooms from %s to %s.", len(lines),
+			i18n.FormatDate(lang, lead.StartDate), i18n.FormatDate(lang, lead.EndDate)),
+		i18n.T(lang, "Rooms booked:"),
+		rooms.String(),
+		depositLine,
+		i18n.T(lang, "Looking forward to see you soon"),
+		i18n.T(lang, "Best regards,"))
+
+	pr.App.MailChan <- models.MailData{
+		To:       lead.Email,
+		From:     "reservation@me.com",
+		Subject:  i18n.T(lang, "Reservation Confirmation"),
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+// availabilityRequestForm reads the availability search of a request, from its JSON body or its form
+func availabilityRequestForm(r *http.Request) (*forms.Form, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		form, err := forms.NewFromJSON(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		form.Lang = i18n.FromContext(r.Context())
+		form.Limits = formLimits
+		return form, nil
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	return newForm(r, r.Form), nil
+}
+
+// BookRoom takes url parameters, builds a session variable and takes user to the make reservation page
+func (pr *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	roomId, err := helpers.IntQueryParam(r, "id")
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var input bookRoomForm
+	form := newForm(r, r.URL.Query())
+	if !form.Bind(&input) {
+		pr.App.Session.Put(r.Context(), "error", firstError(form, "s", "e"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// create a reservation
+	var res models.Reservation
+
+	room, err := pr.DB.GetRoomById(r.Context(), roomId)
+	if err != nil {
+		pr.App.Session.Put(r.Context(), "error", "can't get room by id")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.StartDate = input.Start
+	res.EndDate = input.End
+	res.RoomId = roomId
+	res.Room.RoomName = room.RoomName
+
+	if !pr.holdRoomOrRedirect(w, r, res) {
+		return
+	}
+
+	pr.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+
+}
+
+// ShowLogin shows login page
+func (pr *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	renders.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logins of users
+func (pr *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = pr.App.Session.RenewToken(r.Context())
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+	}
+
+	form := newForm(r, r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		renders.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+	id, _, err := pr.DB.Authenticate(r.Context(), email, password)
+	if err != nil {
+		log.Println(err)
+		pr.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	pr.App.Session.Put(r.Context(), "user_id", id)
+	pr.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs a user out
+func (pr *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = pr.App.Session.Destroy(r.Context())
+	pr.App.Session.RenewToken(r.Context())
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminDashboard shows an admin page - required authentication
+func (pr *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	renders.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+// AdminNewReservations shows an admin page with all new reservations
+func (pr *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := pr.DB.AllNewReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]any)
+	data["reservations"] = reservations
+	renders.Template(w, r, "admin-reservations-new.page.tmpl", &models.TemplateData{Data: data})
+}
+
+// AdminAllReservations shows an admin page with all reservations
+func (pr *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := pr.DB.AllReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]any)
+	data["reservations"] = reservations
+	renders.Template(w, r, "admin-reservations-all.page.tmpl", &models.TemplateData{Data: data})
+}
+
+// AdminExportReservations downloads the new or all reservations as a CSV file, with a column for each tax charged
+func (pr *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	src, err := helpers.SrcURLParam(r)
+	if err != nil || src == "cal" {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var reservations []models.Reservation
+	if src == "new" {
+		reservations, err = pr.DB.AllNewReservations(r.Context())
+	} else {
+		reservations, err = pr.DB.AllReservations(r.Context())
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	allTaxes, err := pr.DB.AllReservationTaxes(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the taxes of each reservation by name, the columns are the names in the order they first appear
+	taxes := make(map[int]map[string]int)
+	var taxNames []string
+	for _, t := range allTaxes {
+		if taxes[t.ReservationId] == nil {
+			taxes[t.ReservationId] = make(map[string]int)
+		}
+		if !slices.Contains(taxNames, t.Name) {
+			taxNames = append(taxNames, t.Name)
+		}
+		taxes[t.ReservationId][t.Name] += t.Amount
+	}
+
+	amount := func(a int) string {
+		return strconv.FormatFloat(float64(a)/100, 'f', 2, 64)
+	}
+
+	header := []string{"id", "first_name", "last_name", "email", "room", "arrival", "departure", "nights",
+		"adults", "children", "status", "promo_code", "stay"}
+	header = append(header, taxNames...)
+	header = append(header, "taxes", "total")
+
+	records := [][]string{header}
+	for _, res := range reservations {
+		total, _ := stayPrice(res)
+		record := []string{strconv.Itoa(res.ID), res.FirstName, res.LastName, res.Email, res.Room.RoomName,
+			res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), strconv.Itoa(res.Nights()),
+			strconv.Itoa(res.Adults), strconv.Itoa(res.Children), res.Status, res.PromoCode, amount(total)}
+
+		taxTotal := 0
+		for _, name := range taxNames {
+			record = append(record, amount(taxes[res.ID][name]))
+			taxTotal += taxes[res.ID][name]
+		}
+		record = append(record, amount(taxTotal), amount(total+taxTotal))
+
+		records = append(records, record)
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("reservations-%s.csv", src),
+	}))
+
+	err = csv.NewWriter(w).WriteAll(records)
+	if err != nil {
+		pr.App.ErrorLog.Println(err)
+	}
+}
+
+// AdminCreateReservation shows the form used by staff to create a reservation (phone and walk-in bookings)
+func (pr *Repository) AdminCreateReservation(w http.ResponseWriter, r *http.Request) {
+	rooms, err := pr.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]any)
+	data["reservation"] = models.Reservation{Adults: 1}
+	data["rooms"] = rooms
+
+	stringMap := make(map[string]string)
+	stringMap["send_email"] = "1"
+
+	renders.Template(w, r, "admin-reservation-create.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminPostCreateReservation handles the posting of a reservation created by staff
+func (pr *Repository) AdminPostCreateReservation(w http.ResponseWriter, r *http.Request) {
+	// the reservation records which staff member created it
+	userId := pr.App.Session.GetInt(r.Context(), "user_id")
+	if userId == 0 {
+		pr.App.Session.Put(r.Context(), "error", "Log in first")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Phone:     r.Form.Get("phone"),
+		Email:     r.Form.Get("email"),
+		Processed: 1,
+		Status:    models.ReservationStatusConfirmed,
+		Language:  r.Form.Get("language"),
+	}
+
+	// the guest confirmation is written in the language staff picked for the guest
+	if !i18n.Supported(reservation.Language) {
+		reservation.Language = i18n.Default
+	}
+
+	var input adminReservationForm
+	form := newForm(r, r.PostForm)
+	if form.Bind(&input) {
+		room, err := pr.DB.GetRoomById(r.Context(), input.RoomID)
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		} else {
+			reservation.RoomId = input.RoomID
+			reservation.Room = room
+
+			adults, children := input.count()
+			checkOccupancy(form, room, adults, children)
+		}
+	}
+
+	reservation.Adults, reservation.Children = input.count()
+	reservation.SpecialRequests = input.SpecialRequests
+
+	if form.Valid() {
+		available, err := pr.DB.SearchAvailabilityByDatesByRoomId(r.Context(), input.StartDate, input.EndDate, input.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if !available {
+			form.Errors.Add("room_id", "Room is not available for the selected dates")
+		}
+	}
+
+	if !form.Valid() {
+		rooms, err := pr.DB.AllRooms(r.Context())
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]any)
+		data["reservation"] = reservation
+		data["rooms"] = rooms
+
+		stringMap := make(map[string]string)
+		stringMap["start_date"] = r.Form.Get("start_date")
+		stringMap["end_date"] = r.Form.Get("end_date")
+		stringMap["send_email"] = r.Form.Get("send_email")
+
+		renders.Template(w, r, "admin-reservation-create.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	reservation.StartDate = input.StartDate
+	reservation.EndDate = input.EndDate
+
+	reservation.Taxes, err = pr.taxesFor(r.Context(), reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation.CreatedBy = userId
+
+	newReservationID, err := pr.DB.InsertBooking(r.Context(), reservation, 0)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		// the room was booked after the availability check above
+		pr.App.Session.Put(r.Context(), "error", "Room is not available for the selected dates")
+		http.Redirect(w, r, "/admin/reservations/new", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	pr.audit(r, auditEntityReservation, newReservationID, "created", "created by staff")
+
+	if form.Has("send_email") {
+		pr.sendGuestConfirmation(reservation, models.Payment{})
+	}
+
+	pr.App.Session.Put(r.Context(), "flash", "Reservation created")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d", newReservationID), http.StatusSeeOther)
+}
+
+// AdminCalendarReservations displays a reservations calendar
+func (pr *Repository) AdminCalendarReservations(w http.ResponseWriter, r *http.Request) {
+	// assume that there is no month or year specified
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, err := helpers.IntQueryParam(r, "y")
+		if err != nil || year > 9999 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+
+		month, err := helpers.IntQueryParam(r, "m")
+		if err != nil || month > 12 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]any)
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = nextMonth
+	stringMap["next_month_year"] = nextMonthYear
+	stringMap["last_month"] = lastMonth
+	stringMap["last_month_year"] = lastMonthYear
+
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	// get first and last date of a month
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastofMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastofMonth.Day()
+
+	rooms, err := pr.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+
+	// the group of each reservation booked together with other rooms
+	groupMap := make(map[int]int)
+	data["group_map"] = groupMap
+
+	for _, x := range rooms {
+		// create maps
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+
+		for d := firstOfMonth; !d.After(lastofMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+		}
+
+		// get all restriction for the current room
+		restrictions, err := pr.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastofMonth.AddDate(0, 0, 1))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			if y.ReservationId > 0 {
+				if y.Reservation.GroupId > 0 {
+					groupMap[y.ReservationId] = y.Reservation.GroupId
+				}
+
+				// it's a reservation, shown on the days it holds the room
+				for _, d := range pr.App.Overlap.RestrictionDays(y) {
+					if _, ok := reservationMap[d.Format("2006-01-2")]; ok {
+						reservationMap[d.Format("2006-01-2")] = y.ReservationId
+					}
+				}
+			} else {
+				// it's a block, shown on the days it blocks
+				for _, d := range pr.App.Overlap.RestrictionDays(y) {
+					if _, ok := blockMap[d.Format("2006-01-2")]; ok {
+						blockMap[d.Format("2006-01-2")] = y.ID
+					}
+				}
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+
+		pr.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	}
+
+	renders.Template(w, r, "admin-reservation-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminPostCalendarReservations handles post of reservation calendar
+func (pr *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, err := helpers.IntFormValue(r, "y")
+	if err != nil || year > 9999 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	month, err := helpers.IntFormValue(r, "m")
+	if err != nil || month > 12 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// every block field is checked before anything is changed
+	type block struct {
+		roomId int
+		day    time.Time
+	}
+	var added []block
+	for name := range r.PostForm {
+		prefix := ""
+		switch {
+		case strings.HasPrefix(name, "add_block_"):
+			prefix = "add_block"
+		case strings.HasPrefix(name, "remove_block_"):
+			prefix = "remove_block"
+		default:
+			continue
+		}
+
+		roomId, day, err := helpers.BlockField(name, prefix)
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		if prefix == "add_block" {
+			added = append(added, block{roomId, day})
+		}
+	}
+
+	// process blocks
+	rooms, err := pr.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	blockMaps := make(map[int]map[string]int)
+	for _, x := range rooms {
+		curMap, ok := pr.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+		if !ok {
+			// the calendar was not shown in this session
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		blockMaps[x.ID] = curMap
+	}
+
+	form := newForm(r, r.PostForm)
+	for _, x := range rooms {
+		// Loop through the block map of the room shown, if we have an entry in the map that does not exist
+		// in our posted data, and if restriction id > 0, then it is a block we need to remove.
+		for name, value := range blockMaps[x.ID] {
+			// only pay attention to values > 0, and that are not in the form post
+			// the rest are just placeholders for days without blocks
+			if value > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
+				//delete restriction by id
+				err := pr.DB.DeleteBlockById(r.Context(), value)
+				if err != nil {
+					log.Println(err)
+				} else {
+					pr.audit(r, auditEntityRoom, x.ID, "block removed", name)
+					if day, err := time.Parse("2006-01-2", name); err == nil {
+						pr.offerFreedNights(r, x.ID, day, day.AddDate(0, 0, 1))
+					}
+				}
+			}
+		}
+	}
+
+	// Handle new blocks
+	for _, b := range added {
+		err = pr.DB.AddBlockForRoom(r.Context(), b.roomId, b.day)
+		if err != nil {
+			log.Println(err)
+		} else {
+			pr.audit(r, auditEntityRoom, b.roomId, "block added", b.day.Format("2006-01-2"))
+		}
+	}
+
+	pr.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-cal?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// adminReservationParams returns the validated {id} and {src} path parameters of the admin reservation routes,
+// answering with a 400 if any of them is malformed
+func adminReservationParams(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	id, err := helpers.IntURLParam(r, "id")
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return 0, "", false
+	}
+
+	src, err := helpers.SrcURLParam(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return 0, "", false
+	}
+
+	return id, src, true
+}
+
+// getReservation gets a reservation from the database, answering with a 404 if it does not exist
+func (pr *Repository) getReservation(w http.ResponseWriter, r *http.Request, id int) (models.Reservation, bool) {
+	res, err := pr.DB.GetReservationById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// AdminShowReservation displays in a detailed view a single reservation
+func (pr *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	// get reservation from database
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
+		return
+	}
+
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	pr.addPrice(stringMap, i18n.FromContext(r.Context()), res)
+
+	pr.renderReservation(w, r, res, forms.New(nil), stringMap)
+}
+
+// renderReservation displays a reservation with the rooms it can move to, its history, payments, invoice
+// and the other rooms booked with it
+func (pr *Repository) renderReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form, stringMap map[string]string) {
+	rooms, err := pr.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	auditLog, err := pr.DB.GetAuditLogsForEntity(r.Context(), auditEntityReservation, res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	resPayments, err := pr.DB.GetPaymentsForReservation(r.Context(), res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	invoice, err := pr.DB.GetInvoiceForReservation(r.Context(), res.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the other rooms booked together with this one
+	var group []models.Reservation
+	if res.GroupId > 0 {
+		lines, err := pr.DB.GetReservationsByGroup(r.Context(), res.GroupId)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		for _, line := range lines {
+			if line.ID != res.ID {
+				group = append(group, line)
+			}
+		}
+	}
+
+	data := make(map[string]any)
+	data["reservation"] = res
+	data["rooms"] = rooms
+	data["audit_log"] = auditLog
+	data["payments"] = resPayments
+	data["invoice"] = invoice
+	data["group"] = group
+
+	renders.Template(w, r, "admin-reservation-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostShowReservation updates a single reservation, including its dates and room
+func (pr *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
+		return
+	}
+
+	// get reservation from database
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
+		return
+	}
+	if res.Status == models.ReservationStatusCancelled {
+		pr.refuseCancelledEdit(w, r, src, id)
+		return
+	}
+	original := res
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	var input editReservationForm
+	form := newForm(r, r.PostForm)
+	if form.Bind(&input) {
+		room, err := pr.DB.GetRoomById(r.Context(), input.RoomID)
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		} else {
+			res.RoomId = input.RoomID
+			res.Room = room
+
+			adults, children := input.count()
+			checkOccupancy(form, room, adults, children)
+		}
+	}
+
+	res.Adults, res.Children = input.count()
+	res.SpecialRequests = input.SpecialRequests
+
+	// the dates and the price shown back are the ones that were posted
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["start_date"] = r.Form.Get("start_date")
+	stringMap["end_date"] = r.Form.Get("end_date")
+	pr.addPrice(stringMap, i18n.FromContext(r.Context()), original)
+
+	if !form.Valid() {
+		pr.renderReservation(w, r, res, form, stringMap)
+		return
+	}
+
+	res.StartDate = input.StartDate
+	res.EndDate = input.EndDate
+
+	// the taxes charged are kept unless the stay changes, a new tax rule doesn't apply to past bookings
+	if !res.StartDate.Equal(original.StartDate) || !res.EndDate.Equal(original.EndDate) || res.RoomId != original.RoomId ||
+		res.Adults != original.Adults || res.Children != original.Children {
+		res.Taxes, err = pr.taxesFor(r.Context(), res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	// the room is checked again when the change is saved, a booking made meanwhile wins
+	err = pr.DB.UpdateReservation(r.Context(), res)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Errors.Add("room_id", "Room is not available for the selected dates")
+		pr.renderReservation(w, r, res, form, stringMap)
+		return
+	} else if errors.Is(err, repository.ErrReservationCancelled) {
+		pr.refuseCancelledEdit(w, r, src, id)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if changes := reservationChanges(original, res); len(changes) > 0 {
+		pr.audit(r, auditEntityReservation, res.ID, "updated", strings.Join(changes, ", "))
+	}
+
+	pr.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// refuseCancelledEdit sends the staff back to a cancelled reservation, which can't be changed anymore
+func (pr *Repository) refuseCancelledEdit(w http.ResponseWriter, r *http.Request, src string, id int) {
+	pr.App.Session.Put(r.Context(), "error", "A cancelled reservation can't be changed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+}
+
+// reservationChanges returns a description of the fields that differ between two versions of a reservation
+func reservationChanges(before, after models.Reservation) []string {
+	var changes []string
+
+	layout := "2006-01-02"
+	if before.FirstName != after.FirstName {
+		changes = append(changes, fmt.Sprintf("first name: %s -> %s", before.FirstName, after.FirstName))
+	}
+	if before.LastName != after.LastName {
+		changes = append(changes, fmt.Sprintf("last name: %s -> %s", before.LastName, after.LastName))
+	}
+	if before.Email != after.Email {
+		changes = append(changes, fmt.Sprintf("email: %s -> %s", before.Email, after.Email))
+	}
+	if before.Phone != after.Phone {
+		changes = append(changes, fmt.Sprintf("phone: %s -> %s", before.Phone, after.Phone))
+	}
+	if !before.StartDate.Equal(after.StartDate) {
+		changes = append(changes, fmt.Sprintf("arrival: %s -> %s", before.StartDate.Format(layout), after.StartDate.Format(layout)))
+	}
+	if !before.EndDate.Equal(after.EndDate) {
+		changes = append(changes, fmt.Sprintf("departure: %s -> %s", before.EndDate.Format(layout), after.EndDate.Format(layout)))
+	}
+	if before.RoomId != after.RoomId {
+		changes = append(changes, fmt.Sprintf("room: %d -> %d", before.RoomId, after.RoomId))
+	}
+	if before.Adults != after.Adults || before.Children != after.Children {
+		changes = append(changes, fmt.Sprintf("guests: %d+%d -> %d+%d", before.Adults, before.Children, after.Adults, after.Children))
+	}
+	if before.SpecialRequests != after.SpecialRequests {
+		changes = append(changes, "special requests")
+	}
+
+	return changes
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (pr *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := pr.getReservation(w, r, id); !ok {
+		return
+	}
+
+	err := pr.DB.UpdatedProcessedForReservation(r.Context(), id, 1)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	pr.audit(r, auditEntityReservation, id, "processed", "")
+
+	pr.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+
+}
+
+// AdminReservationStatus moves a reservation to a new status
+func (pr *Repository) AdminReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
+		return
+	}
+	status := chi.URLParam(r, "status")
+
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
+		return
+	}
+
+	if !res.CanTransitionTo(status) {
+		pr.App.Session.Put(r.Context(), "error", fmt.Sprintf("Can't change status from %s to %s", res.Status, status))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
 		return
 	}
 
-	endDate, err := time.Parse(layout, ed)
+	refunded, err := pr.settlePayments(r.Context(), res, status)
 	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't get parse end date")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	err = pr.DB.UpdateReservationStatus(r.Context(), id, status)
 	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "invalid data!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
-
-	res, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	details := fmt.Sprintf("%s -> %s", res.Status, status)
+	flash := fmt.Sprintf("Reservation marked as %s", status)
+	if refunded > 0 {
+		details = fmt.Sprintf("%s, %s refunded", details, payments.FormatAmount(refunded, pr.App.Currency))
+		flash = fmt.Sprintf("%s, %s refunded", flash, payments.FormatAmount(refunded, pr.App.Currency))
 	}
+	pr.audit(r, auditEntityReservation, id, "status changed", details)
 
-	reservation := models.Reservation{
-		FirstName: r.Form.Get("first_name"),
-		LastName:  r.Form.Get("last_name"),
-		Phone:     r.Form.Get("phone"),
-		Email:     r.Form.Get("email"),
-		StartDate: startDate,
-		EndDate:   endDate,
-		RoomId:    roomID,
-		Room:      res.Room,
+	// a cancelled reservation and a no-show free their room
+	if status == models.ReservationStatusCancelled || status == models.ReservationStatusNoShow {
+		pr.offerFreedNights(r, res.RoomId, res.StartDate, res.EndDate)
 	}
 
-	form := forms.New(r.PostForm)
+	pr.App.Session.Put(r.Context(), "flash", flash)
 
-	form.Required("first_name", "last_name", "email")
-	form.MinLength("first_name", 3)
-	form.IsEmail("email")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
 
-	if !form.Valid() {
-		data := make(map[string]any)
-		data["reservation"] = reservation
-		renders.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form:      form,
-			Data:      data,
-			StringMap: stringMap,
-		})
+// AdminDeleteReservation soft deletes a reservation, the row is kept for the audit trail
+func (pr *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
 		return
 	}
 
-	newReservationID, err := pr.DB.InsertReservation(reservation)
-	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomId:        roomID,
-		ReservationId: newReservationID,
-		RestrictionId: 1,
+	// the payments are settled like for a cancellation, no deposit stays held for a reservation that is gone
+	refunded := 0
+	if res.CanTransitionTo(models.ReservationStatusCancelled) {
+		var err error
+		refunded, err = pr.settlePayments(r.Context(), res, models.ReservationStatusCancelled)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 	}
 
-	err = pr.DB.InsertRoomRestriction(restriction)
+	err := pr.DB.DeleteReservation(r.Context(), id)
 	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't insert room restriction!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	// send mail notification - first to guest
-	htmlMessage := fmt.Sprintf(
-		`<strong>Reservation Confirmation</strong><br><br>
-		Dear %s, <br>
-		this is a confirmation of your reservation from %s to %s .<br><br>
+	details := ""
+	flash := "Reservation deleted"
+	if refunded > 0 {
+		details = fmt.Sprintf("%s refunded", payments.FormatAmount(refunded, pr.App.Currency))
+		flash = fmt.Sprintf("%s, %s", flash, details)
+	}
+	pr.audit(r, auditEntityReservation, id, "deleted", details)
+	pr.offerFreedNights(r, res.RoomId, res.StartDate, res.EndDate)
 
-		Looking forward to see you soon<br><br>
-		Best regards,<br>
-		Admin`,
-		reservation.FirstName, reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"))
+	pr.App.Session.Put(r.Context(), "flash", flash)
 
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "reservation@me.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// invoiceFor builds the invoice of a reservation from its stay, its discount, its taxes and what the guest paid.
+// It is written in the language of the guest.
+func (pr *Repository) invoiceFor(ctx context.Context, res models.Reservation, issued models.Invoice) (invoices.Invoice, error) {
+	lang := res.Language
+	inv := invoices.Invoice{
+		Number:   issued.Number,
+		IssuedAt: issued.IssuedAt,
+		Lang:     lang,
+		Currency: pr.App.Currency,
+		Seller:   []string{siteName},
+		Customer: []string{fmt.Sprintf("%s %s", res.FirstName, res.LastName), res.Email},
+		Reference: i18n.T(lang, "Reservation %d, from %s to %s", res.ID,
+			i18n.FormatDate(lang, res.StartDate), i18n.FormatDate(lang, res.EndDate)),
+	}
+	if res.Phone != "" {
+		inv.Customer = append(inv.Customer, res.Phone)
 	}
 
-	pr.App.MailChan <- msg
+	if nights := res.Nights(); nights > 0 {
+		inv.Lines = append(inv.Lines, invoices.Line{
+			Description: i18n.T(lang, "Nights in %s", res.Room.RoomName),
+			Quantity:    nights,
+			UnitPrice:   res.Room.NightlyRate,
+			Amount:      nights * res.Room.NightlyRate,
+		})
+	}
+	if res.Discount > 0 {
+		inv.Lines = append(inv.Lines, invoices.Line{
+			Description: i18n.T(lang, "Discount (%s)", res.PromoCode),
+			Amount:      -res.Discount,
+		})
+	}
+	for _, t := range res.Taxes {
+		inv.Taxes = append(inv.Taxes, invoices.Line{
+			Description: t.Name,
+			Quantity:    t.Quantity,
+			UnitPrice:   t.UnitPrice,
+			Amount:      t.Amount,
+		})
+	}
 
-	// send mail notification - second to owner
-	htmlMessage = fmt.Sprintf(
-		`<strong>Reservation Notification</strong><br><br>
-		Dear Admin, <br>
-		there is a new reservation from Mr./Mrs. %s %s, from %s to %s. <br><br><br>
+	resPayments, err := pr.DB.GetPaymentsForReservation(ctx, res.ID)
+	if err != nil {
+		return inv, err
+	}
 
-		Kind regards,<br>
-		Admin`,
-		reservation.FirstName, reservation.LastName, reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"))
+	// only the money actually taken is paid, authorizations are still on the card of the guest
+	for _, p := range resPayments {
+		paid := p.Amount - p.Refunded
+		if (p.Status != models.PaymentStatusCaptured && p.Status != models.PaymentStatusRefunded) || paid <= 0 {
+			continue
+		}
+		inv.Payments = append(inv.Payments, invoices.Payment{
+			Date:        p.UpdatedAt,
+			Description: i18n.T(lang, "Card payment"),
+			Amount:      paid,
+		})
+	}
 
-	msgToAdmin := models.MailData{
-		To:      "me@me.com",
-		From:    "me@me.com",
-		Subject: "Reservation Notice",
-		Content: htmlMessage,
+	return inv, nil
+}
+
+// AdminIssueInvoice issues the invoice of a reservation, a reservation invoiced before keeps its invoice
+func (pr *Repository) AdminIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
+		return
 	}
 
-	pr.App.MailChan <- msgToAdmin
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
+		return
+	}
 
-	pr.App.Session.Put(r.Context(), "reservation", reservation)
+	inv, err := pr.DB.IssueInvoice(r.Context(), res.ID, time.Now())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
-}
+	pr.audit(r, auditEntityReservation, id, "invoice issued", fmt.Sprintf("invoice %d", inv.Number))
 
-// Generals renders the room page
-func (pr *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	renders.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
-}
+	pr.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invoice %d issued", inv.Number))
 
-// Majors renders the room page
-func (pr *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	renders.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
 }
 
-// Availability renders the search availability page
-func (pr *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	renders.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
-}
+// AdminReservationInvoice downloads the invoice of a reservation as a PDF, answering with a 404 if none was issued
+func (pr *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := adminReservationParams(w, r)
+	if !ok {
+		return
+	}
 
-// PostAvailability renders the search availability page
-func (pr *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't parse form!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
 		return
 	}
 
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+	issued, err := pr.DB.GetInvoiceForReservation(r.Context(), res.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	// 2020-01-01 --- Format using this 01/02 03:04:05PM '06 -0700 as a reference
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+	inv, err := pr.invoiceFor(r.Context(), res, issued)
 	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't parse start date")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	endDate, err := time.Parse(layout, end)
-	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't parse end date")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": invoiceFilename(issued)}))
+	w.Write(inv.PDF())
+}
+
+// AdminEmailInvoice sends the invoice of a reservation to the guest, issuing it first if needed
+func (pr *Repository) AdminEmailInvoice(w http.ResponseWriter, r *http.Request) {
+	id, src, ok := adminReservationParams(w, r)
+	if !ok {
+		return
+	}
+
+	res, ok := pr.getReservation(w, r, id)
+	if !ok {
 		return
 	}
 
-	rooms, err := pr.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	issued, err := pr.DB.IssueInvoice(r.Context(), res.ID, time.Now())
 	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	if len(rooms) == 0 {
-		// no availability
-		pr.App.Session.Put(r.Context(), "error", "No availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+	inv, err := pr.invoiceFor(r.Context(), res, issued)
+	if err != nil {
+		helpers.ServerError(w, err)
 		return
 	}
 
-	data := make(map[string]any)
-	data["rooms"] = rooms
+	lang := res.Language
+	htmlMessage := fmt.Sprintf(
+		`%s <br>
+		%s<br><br>
+		%s<br>
+		Admin`,
+		i18n.T(lang, "Dear %s,", res.FirstName),
+		i18n.T(lang, "please find attached the invoice of your stay from %s to %s.",
+			i18n.FormatDate(lang, res.StartDate), i18n.FormatDate(lang, res.EndDate)),
+		i18n.T(lang, "Best regards,"))
 
-	res := models.Reservation{
-		StartDate: startDate,
-		EndDate:   endDate,
+	pr.App.MailChan <- models.MailData{
+		To:       res.Email,
+		From:     "reservation@me.com",
+		Subject:  i18n.T(lang, "Invoice %d", issued.Number),
+		Content:  htmlMessage,
+		Template: "basic.html",
+		Attachments: []models.Attachment{
+			{Name: invoiceFilename(issued), ContentType: "application/pdf", Data: inv.PDF()},
+		},
 	}
 
-	pr.App.Session.Put(r.Context(), "reservation", res)
+	pr.audit(r, auditEntityReservation, id, "invoice emailed", fmt.Sprintf("invoice %d to %s", issued.Number, res.Email))
 
-	renders.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	pr.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invoice %d sent to %s", issued.Number, res.Email))
 
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
 }
 
-type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+// invoiceFilename returns the name the PDF of an invoice is downloaded and attached with
+func invoiceFilename(inv models.Invoice) string {
+	return fmt.Sprintf("invoice-%d.pdf", inv.Number)
 }
 
-// AvailabilityJSON handlers requests for availability and send JSON response
-func (pr *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// AdminPromotions lists the promotion codes
+func (pr *Repository) AdminPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := pr.DB.AllPromotions(r.Context())
 	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Internal server error",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "  ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
-
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
-	roomId, _ := strconv.Atoi(r.Form.Get("room_id"))
-
-	available, err := pr.DB.SearchAvailabilityByDatesByRoomId(startDate, endDate, roomId)
+	rooms, err := pr.DB.AllRooms(r.Context())
 	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Error connecting to the database",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "  ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	resp := jsonResponse{
-		OK:        available,
-		Message:   "",
-		StartDate: sd,
-		EndDate:   ed,
-		RoomID:    strconv.Itoa(roomId),
+	// the room names of the codes restricted to a room
+	roomNames := make(map[int]string)
+	for _, room := range rooms {
+		roomNames[room.ID] = room.RoomName
 	}
 
-	// removed error checking, resp is created manually
-	out, _ := json.MarshalIndent(resp, "", " ")
+	data := make(map[string]any)
+	data["promotions"] = promotions
+	data["room_names"] = roomNames
 
-	w.Header().Set("Content-type", "application/json")
-	w.Write(out)
-}
+	stringMap := make(map[string]string)
+	stringMap["currency"] = pr.App.Currency
 
-// Contact renders the contact page
-func (pr *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	renders.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+	renders.Template(w, r, "admin-promotions.page.tmpl", &models.TemplateData{Data: data, StringMap: stringMap})
 }
 
-// ReservationSummary displays the reservation summary page
-func (pr *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+// renderPromotion renders the form used by staff to create or edit a promotion
+func (pr *Repository) renderPromotion(w http.ResponseWriter, r *http.Request, promo models.Promotion, form *forms.Form, stringMap map[string]string) {
+	rooms, err := pr.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
 		return
 	}
 
-	pr.App.Session.Remove(r.Context(), "reservation")
-
 	data := make(map[string]any)
-	data["reservation"] = reservation
-
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	data["promotion"] = promo
+	data["rooms"] = rooms
 
-	renders.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+	renders.Template(w, r, "admin-promotion.page.tmpl", &models.TemplateData{
+		Form:      form,
 		Data:      data,
 		StringMap: stringMap,
 	})
 }
 
-// ChooseRoom displays a list of available rooms
-func (pr *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[2])
+// AdminNewPromotion shows the form used by staff to create a promotion code
+func (pr *Repository) AdminNewPromotion(w http.ResponseWriter, r *http.Request) {
+	pr.renderPromotion(w, r, models.Promotion{}, forms.New(nil), map[string]string{})
+}
+
+// AdminShowPromotion shows a promotion code in a form to edit it
+func (pr *Repository) AdminShowPromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := helpers.IntURLParam(r, "id")
 	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "missing url parameter")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
 
-	res, ok := pr.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	promo, ok := pr.getPromotion(w, r, id)
 	if !ok {
-		pr.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res.RoomId = roomID
+	stringMap := make(map[string]string)
+	stringMap["valid_from"] = promo.ValidFrom.Format("2006-01-02")
+	stringMap["valid_to"] = promo.ValidTo.Format("2006-01-02")
 
-	pr.App.Session.Put(r.Context(), "reservation", res)
+	pr.renderPromotion(w, r, promo, forms.New(nil), stringMap)
+}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+// getPromotion returns the promotion with the given id, or writes a not found or a server error
+func (pr *Repository) getPromotion(w http.ResponseWriter, r *http.Request, id int) (models.Promotion, bool) {
+	promo, err := pr.DB.GetPromotionById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return promo, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return promo, false
+	}
+
+	return promo, true
 }
 
-// BookRoom takes url parameters, builds a session variable and takes user to the make reservation page
-func (pr *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	roomId, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	sd := r.URL.Query().Get("s")
-	ed := r.URL.Query().Get("e")
+// AdminPostPromotion handles the posting of a promotion code, a new one when there is no id in the path
+func (pr *Repository) AdminPostPromotion(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var promo models.Promotion
+	if chi.URLParam(r, "id") != "" {
+		id, err := helpers.IntURLParam(r, "id")
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+
+		var ok bool
+		promo, ok = pr.getPromotion(w, r, id)
+		if !ok {
+			return
+		}
+	}
+
+	var input promotionForm
+	form := newForm(r, r.PostForm)
+	form.Bind(&input)
+
+	promo.Code = strings.ToUpper(strings.TrimSpace(input.Code))
+	promo.Description = input.Description
+	promo.DiscountPercent = input.DiscountPercent
+	promo.DiscountFixed = input.DiscountFixed
+	promo.RoomId = input.RoomID
+	promo.MinNights = input.MinNights
+	promo.MaxUses = input.MaxUses
+
+	if form.Errors.Get("code") == "" && !validPromotionCode(promo.Code) {
+		form.Errors.Add("code", "Use only letters, digits, dashes and underscores")
+	}
+
+	if (promo.DiscountPercent > 0) == (promo.DiscountFixed > 0) && form.Errors.Get("discount_percent") == "" && form.Errors.Get("discount_fixed") == "" {
+		form.Errors.Add("discount_percent", "Set either a percentage or a fixed discount")
+	}
+
+	if !input.ValidFrom.IsZero() && !input.ValidTo.IsZero() && input.ValidTo.Before(input.ValidFrom) {
+		form.Errors.Add("valid_to", "The last arrival date can't be before the first")
+	}
+
+	if promo.RoomId > 0 && form.Errors.Get("room_id") == "" {
+		if _, err := pr.DB.GetRoomById(r.Context(), promo.RoomId); err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	if form.Errors.Get("code") == "" {
+		existing, err := pr.DB.GetPromotionByCode(r.Context(), promo.Code)
+		if err == nil && existing.ID != promo.ID {
+			form.Errors.Add("code", "This code already exists")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
 
-	// create a reservation
-	var res models.Reservation
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["valid_from"] = r.Form.Get("valid_from")
+		stringMap["valid_to"] = r.Form.Get("valid_to")
 
-	room, err := pr.DB.GetRoomById(roomId)
-	if err != nil {
-		pr.App.Session.Put(r.Context(), "error", "can't get room by id")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		pr.renderPromotion(w, r, promo, form, stringMap)
 		return
 	}
 
-	res.StartDate = startDate
-	res.EndDate = endDate
-	res.RoomId = roomId
-	res.Room.RoomName = room.RoomName
+	promo.ValidFrom = input.ValidFrom
+	promo.ValidTo = input.ValidTo
 
-	pr.App.Session.Put(r.Context(), "reservation", res)
+	details := fmt.Sprintf("%s, %s", promo.Code, promotionTerms(promo, pr.App.Currency))
+	if promo.ID == 0 {
+		promo.ID, err = pr.DB.InsertPromotion(r.Context(), promo)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		pr.audit(r, auditEntityPromotion, promo.ID, "created", details)
+		pr.App.Session.Put(r.Context(), "flash", "Promotion created")
+	} else {
+		err = pr.DB.UpdatePromotion(r.Context(), promo)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-}
+		pr.audit(r, auditEntityPromotion, promo.ID, "updated", details)
+		pr.App.Session.Put(r.Context(), "flash", "Changes saved")
+	}
 
-// ShowLogin shows login page
-func (pr *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	renders.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-	})
+	http.Redirect(w, r, "/admin/promotions", http.StatusSeeOther)
 }
 
-// PostShowLogin handles logins of users
-func (pr *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = pr.App.Session.RenewToken(r.Context())
-	err := r.ParseForm()
+// AdminDeletePromotion deletes a promotion code, the reservations booked with it keep their discount
+func (pr *Repository) AdminDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := helpers.IntURLParam(r, "id")
 	if err != nil {
-		log.Println(err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
 	}
 
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
-	if !form.Valid() {
-		renders.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form: form,
-		})
+	if _, ok := pr.getPromotion(w, r, id); !ok {
 		return
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
-	id, _, err := pr.DB.Authenticate(email, password)
+	err = pr.DB.DeletePromotion(r.Context(), id)
 	if err != nil {
-		log.Println(err)
-		pr.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	pr.App.Session.Put(r.Context(), "user_id", id)
-	pr.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
+	pr.audit(r, auditEntityPromotion, id, "deleted", "")
 
-// Logout logs a user out
-func (pr *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = pr.App.Session.Destroy(r.Context())
-	pr.App.Session.RenewToken(r.Context())
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-}
+	pr.App.Session.Put(r.Context(), "flash", "Promotion deleted")
 
-// AdminDashboard shows an admin page - required authentication
-func (pr *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	renders.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	http.Redirect(w, r, "/admin/promotions", http.StatusSeeOther)
 }
 
-// AdminNewReservations shows an admin page with all new reservations
-func (pr *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := pr.DB.AllNewReservations()
+// AdminTaxRules lists the tax rules charged on top of the price of a stay
+func (pr *Repository) AdminTaxRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := pr.DB.AllTaxRules(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
-	data := make(map[string]any)
-	data["reservations"] = reservations
-	renders.Template(w, r, "admin-reservations-new.page.tmpl", &models.TemplateData{Data: data})
-}
 
-// AdminAllReservations shows an admin page with all reservations
-func (pr *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := pr.DB.AllReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	terms := make(map[int]string)
+	for _, rule := range rules {
+		terms[rule.ID] = taxRuleTerms(rule, pr.App.Currency)
 	}
-	data := make(map[string]any)
-	data["reservations"] = reservations
-	renders.Template(w, r, "admin-reservations-all.page.tmpl", &models.TemplateData{Data: data})
-}
 
-// AdminCalendarReservations displays a reservations calendar
-func (pr *Repository) AdminCalendarReservations(w http.ResponseWriter, r *http.Request) {
-	// assume that there is no month or year specified
-	now := time.Now()
+	data := make(map[string]any)
+	data["tax_rules"] = rules
+	data["terms"] = terms
 
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	}
+	renders.Template(w, r, "admin-tax-rules.page.tmpl", &models.TemplateData{Data: data})
+}
 
+// renderTaxRule renders the form used by staff to create or edit a tax rule
+func (pr *Repository) renderTaxRule(w http.ResponseWriter, r *http.Request, rule models.TaxRule, form *forms.Form) {
 	data := make(map[string]any)
-	data["now"] = now
+	data["tax_rule"] = rule
+	data["kinds"] = payments.TaxKinds
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
+	stringMap := make(map[string]string)
+	stringMap["currency"] = pr.App.Currency
 
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
+	renders.Template(w, r, "admin-tax-rule.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
 
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+// AdminNewTaxRule shows the form used by staff to create a tax rule
+func (pr *Repository) AdminNewTaxRule(w http.ResponseWriter, r *http.Request) {
+	pr.renderTaxRule(w, r, models.TaxRule{Kind: payments.TaxPercent}, forms.New(nil))
+}
 
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
+// AdminShowTaxRule shows a tax rule in a form to edit it
+func (pr *Repository) AdminShowTaxRule(w http.ResponseWriter, r *http.Request) {
+	id, err := helpers.IntURLParam(r, "id")
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+	rule, ok := pr.getTaxRule(w, r, id)
+	if !ok {
+		return
+	}
 
-	// get first and last date of a month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastofMonth := firstOfMonth.AddDate(0, 1, -1)
+	pr.renderTaxRule(w, r, rule, forms.New(nil))
+}
 
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastofMonth.Day()
+// getTaxRule returns the tax rule with the given id, or writes a not found or a server error
+func (pr *Repository) getTaxRule(w http.ResponseWriter, r *http.Request, id int) (models.TaxRule, bool) {
+	rule, err := pr.DB.GetTaxRuleById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return rule, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return rule, false
+	}
+
+	return rule, true
+}
 
-	rooms, err := pr.DB.AllRooms()
+// AdminPostTaxRule handles the posting of a tax rule, a new one when there is no id in the path.
+// The reservations already booked keep the taxes they were quoted.
+func (pr *Repository) AdminPostTaxRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	data["rooms"] = rooms
+	var rule models.TaxRule
+	if chi.URLParam(r, "id") != "" {
+		id, err := helpers.IntURLParam(r, "id")
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
 
-	for _, x := range rooms {
-		// create maps
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
+		var ok bool
+		rule, ok = pr.getTaxRule(w, r, id)
+		if !ok {
+			return
+		}
+	}
 
-		for d := firstOfMonth; !d.After(lastofMonth); d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
+	var input taxRuleForm
+	form := newForm(r, r.PostForm)
+	form.Bind(&input)
+
+	rule.Name = strings.TrimSpace(input.Name)
+	rule.Kind = input.Kind
+	rule.Percent = input.Percent
+	rule.Amount = input.Amount
+	rule.ExemptChildren = input.ExemptChildren
+	rule.MaxNights = input.MaxNights
+	rule.Cap = input.Cap
+
+	if form.Errors.Get("kind") == "" && !slices.Contains(payments.TaxKinds, rule.Kind) {
+		form.Errors.Add("kind", "Invalid kind")
+	}
+
+	if rule.Kind == payments.TaxPercent {
+		if rule.Percent == 0 && form.Errors.Get("percent") == "" {
+			form.Errors.Add("percent", "Set the percentage of the stay charged")
 		}
+	} else if rule.Amount == 0 && form.Errors.Get("amount") == "" {
+		form.Errors.Add("amount", "Set the amount charged")
+	}
 
-		// get all restriction for the current room
-		restrictions, err := pr.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastofMonth)
+	if !form.Valid() {
+		pr.renderTaxRule(w, r, rule, form)
+		return
+	}
+
+	details := fmt.Sprintf("%s, %s", rule.Name, taxRuleTerms(rule, pr.App.Currency))
+	if rule.ID == 0 {
+		rule.ID, err = pr.DB.InsertTaxRule(r.Context(), rule)
 		if err != nil {
 			helpers.ServerError(w, err)
 			return
 		}
 
-		for _, y := range restrictions {
-			if y.ReservationId > 0 {
-				// it's a reservation
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationId
-				}
-			} else {
-				// it's a block
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					blockMap[d.Format("2006-01-2")] = y.ID
-				}
-			}
+		pr.audit(r, auditEntityTaxRule, rule.ID, "created", details)
+		pr.App.Session.Put(r.Context(), "flash", "Tax rule created")
+	} else {
+		err = pr.DB.UpdateTaxRule(r.Context(), rule)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
 		}
 
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
-
-		pr.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+		pr.audit(r, auditEntityTaxRule, rule.ID, "updated", details)
+		pr.App.Session.Put(r.Context(), "flash", "Changes saved")
 	}
 
-	renders.Template(w, r, "admin-reservation-calendar.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		IntMap:    intMap,
-	})
+	http.Redirect(w, r, "/admin/tax-rules", http.StatusSeeOther)
 }
 
-// AdminPostCalendarReservations handles post of reservation calendar
-func (pr *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// AdminDeleteTaxRule deletes a tax rule, the reservations it was charged on keep their taxes
+func (pr *Repository) AdminDeleteTaxRule(w http.ResponseWriter, r *http.Request) {
+	id, err := helpers.IntURLParam(r, "id")
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	if _, ok := pr.getTaxRule(w, r, id); !ok {
+		return
+	}
 
-	// process blocks
-	rooms, err := pr.DB.AllRooms()
+	err = pr.DB.DeleteTaxRule(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
-	for _, x := range rooms {
-		// Get the block map from session. Loop through the entire map, if we have an entry in the map
-		// that does not exist in our posted data, and if restriction id > 0, then it is a block we need
-		// to remove.
-		curMap := pr.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						//delete restriction by id
-						err := pr.DB.DeleteBlockById(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
-	}
+	pr.audit(r, auditEntityTaxRule, id, "deleted", "")
 
-	// Handle new blocks
-	for name := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomId, _ := strconv.Atoi(exploded[2])
-			date, _ := time.Parse("2006-01-2", exploded[3])
+	pr.App.Session.Put(r.Context(), "flash", "Tax rule deleted")
 
-			// insert a new block
-			err = pr.DB.AddBlockForRoom(roomId, date)
-			if err != nil {
-				log.Println(err)
-			}
+	http.Redirect(w, r, "/admin/tax-rules", http.StatusSeeOther)
+}
+
+// AdminTaxReport shows the taxes collected on the stays starting in a month, the current one by default.
+// Cancelled reservations and no-shows are left out.
+func (pr *Repository) AdminTaxReport(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	if r.URL.Query().Get("y") != "" {
+		y, err := helpers.IntQueryParam(r, "y")
+		if err != nil || y > 9999 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
 		}
-	}
 
-	pr.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-cal?y=%d&m=%d", year, month), http.StatusSeeOther)
-}
+		m, err := helpers.IntQueryParam(r, "m")
+		if err != nil || m > 12 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
 
-// AdminShowReservation displays in a detailed view a single reservation
-func (pr *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[4])
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		month = time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.UTC)
 	}
 
-	src := exploded[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
-
-	// get reservation from database
-	res, err := pr.DB.GetReservationById(roomID)
+	lines, err := pr.DB.TaxReport(r.Context(), month, month.AddDate(0, 1, 0))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	total := 0
+	for _, l := range lines {
+		total += l.Amount
+	}
+
 	data := make(map[string]any)
-	data["reservation"] = res
+	data["now"] = month
+	data["lines"] = lines
+	data["total"] = total
 
-	renders.Template(w, r, "admin-reservation-show.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
-	})
+	next := month.AddDate(0, 1, 0)
+	last := month.AddDate(0, -1, 0)
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = pr.App.Currency
+	stringMap["next_month"] = next.Format("01")
+	stringMap["next_month_year"] = next.Format("2006")
+	stringMap["last_month"] = last.Format("01")
+	stringMap["last_month_year"] = last.Format("2006")
+
+	renders.Template(w, r, "admin-tax-report.page.tmpl", &models.TemplateData{Data: data, StringMap: stringMap})
 }
 
-// AdminPostShowReservation displays in a detailed view a single reservation
-func (pr *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// paymentEventStatuses are the payment statuses set by the webhook events of a gateway
+var paymentEventStatuses = map[string]string{
+	payments.EventCaptured: models.PaymentStatusCaptured,
+	payments.EventRefunded: models.PaymentStatusRefunded,
+	payments.EventFailed:   models.PaymentStatusFailed,
+}
+
+// PaymentWebhook receives the events the payment gateway sends about a payment. A payment that fails
+// after it was authorized releases the room of its reservation, if the reservation is not under way.
+func (pr *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
 
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[4])
+	gateway := pr.App.Payments
+	event, err := gateway.VerifyWebhook(payload, r.Header.Get(payments.SignatureHeader))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
 
-	src := exploded[3]
+	status, ok := paymentEventStatuses[event.Type]
+	if !ok {
+		// events the site does not need are acknowledged, so that the gateway does not send them again
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-	// get reservation from database
-	res, err := pr.DB.GetReservationById(roomID)
-	if err != nil {
+	payment, err := pr.DB.GetPaymentByReference(r.Context(), gateway.Name(), event.Reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	res.FirstName = r.Form.Get("first_name")
-	res.LastName = r.Form.Get("last_name")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
-
-	err = pr.DB.UpdateReservation(res)
+	err = pr.DB.UpdatePaymentStatus(r.Context(), payment.ID, status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	pr.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-}
-
-// AdminProcessReservation marks a reservation as processed
-func (pr *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-
-	_ = pr.DB.UpdatedProcessedForReservation(id, 1)
-
-	pr.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
-
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-
-}
+	pr.audit(r, auditEntityReservation, payment.ReservationId, "payment "+status,
+		fmt.Sprintf("%s %s", event.Reference, payments.FormatAmount(payment.Amount, payment.Currency)))
 
-// AdminDeleteReservation deletes a reservation
-func (pr *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
+	if status == models.PaymentStatusFailed {
+		// a deleted reservation has no room left to free
+		res, err := pr.DB.GetReservationById(r.Context(), payment.ReservationId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
 
-	_ = pr.DB.DeleteReservation(id)
+		if res.Status == models.ReservationStatusPending || res.Status == models.ReservationStatusConfirmed {
+			err = pr.DB.UpdateReservationStatus(r.Context(), res.ID, models.ReservationStatusCancelled)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
 
-	pr.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+			pr.audit(r, auditEntityReservation, res.ID, "status changed",
+				fmt.Sprintf("%s -> %s, payment failed", res.Status, models.ReservationStatusCancelled))
+			pr.offerFreedNights(r, res.RoomId, res.StartDate, res.EndDate)
+		}
+	}
 
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	w.WriteHeader(http.StatusOK)
 }
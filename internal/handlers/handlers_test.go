@@ -1,16 +1,30 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/AlessioPani/go-booking/internal/driver"
+	"github.com/AlessioPani/go-booking/internal/i18n"
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/payments"
+	"github.com/AlessioPani/go-booking/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
 )
 
 var theTests = []struct {
@@ -33,8 +47,130 @@ var theTests = []struct {
 	{"new res", "/admin/reservations-new", "GET", http.StatusOK},
 	{"all res", "/admin/reservations-all", "GET", http.StatusOK},
 	{"show res", "/admin/reservations/new/1", "GET", http.StatusOK},
+	{"show deleted res", "/admin/reservations/new/4", "GET", http.StatusNotFound},
+	{"create res", "/admin/reservations/new", "GET", http.StatusOK},
+
+	// state changing admin actions must not be reachable with GET
+	{"process res with get", "/admin/process-reservation/new/1", "GET", http.StatusMethodNotAllowed},
+	{"delete res with get", "/admin/delete-reservation/all/1", "GET", http.StatusMethodNotAllowed},
+	{"status res with get", "/admin/reservation-status/new/1/confirmed", "GET", http.StatusMethodNotAllowed},
+
+	// POST
+	{"process res", "/admin/process-reservation/new/1", "POST", http.StatusOK},
+	{"process res fails", "/admin/process-reservation/new/1000", "POST", http.StatusInternalServerError},
+	{"delete res", "/admin/delete-reservation/all/1", "POST", http.StatusOK},
+	{"delete res fails", "/admin/delete-reservation/all/1000", "POST", http.StatusInternalServerError},
+	{"confirm res", "/admin/reservation-status/new/1/confirmed", "POST", http.StatusOK},
+	{"cancel res", "/admin/reservation-status/all/1/cancelled", "POST", http.StatusOK},
+	{"invalid status transition", "/admin/reservation-status/all/1/checked-out", "POST", http.StatusOK},
+	{"unknown status", "/admin/reservation-status/all/1/invalid", "POST", http.StatusOK},
+	{"status change fails", "/admin/reservation-status/all/1000/confirmed", "POST", http.StatusInternalServerError},
+	{"process deleted res", "/admin/process-reservation/new/4", "POST", http.StatusNotFound},
+	{"delete deleted res", "/admin/delete-reservation/all/4", "POST", http.StatusNotFound},
+	{"status deleted res", "/admin/reservation-status/all/4/confirmed", "POST", http.StatusNotFound},
+
+	// malformed paths and query strings
+	{"show res unknown src", "/admin/reservations/bogus/1", "GET", http.StatusBadRequest},
+	{"show res non numeric id", "/admin/reservations/new/abc", "GET", http.StatusBadRequest},
+	{"show res negative id", "/admin/reservations/new/-1", "GET", http.StatusBadRequest},
+	{"show res not found", "/admin/reservations/new/2000", "GET", http.StatusNotFound},
+	{"post res unknown src", "/admin/reservations/evil.com/1", "POST", http.StatusBadRequest},
+	{"post res not found", "/admin/reservations/all/2000", "POST", http.StatusNotFound},
+	{"process res unknown src", "/admin/process-reservation/evil.com/1", "POST", http.StatusBadRequest},
+	{"process res zero id", "/admin/process-reservation/new/0", "POST", http.StatusBadRequest},
+	{"delete res unknown src", "/admin/delete-reservation/%2F%2Fevil.com/1", "POST", http.StatusBadRequest},
+	{"delete res non numeric id", "/admin/delete-reservation/all/1abc", "POST", http.StatusBadRequest},
+	{"status res unknown src", "/admin/reservation-status/x/1/confirmed", "POST", http.StatusBadRequest},
+	{"status res not found", "/admin/reservation-status/all/2000/confirmed", "POST", http.StatusNotFound},
+	{"res cal invalid year", "/admin/reservations-cal?y=abc&m=4", "GET", http.StatusBadRequest},
+	{"res cal invalid month", "/admin/reservations-cal?y=2023&m=13", "GET", http.StatusBadRequest},
+	{"res cal missing month", "/admin/reservations-cal?y=2023", "GET", http.StatusBadRequest},
+	{"choose room invalid id", "/choose-room/abc", "GET", http.StatusOK},
+	{"book room invalid id", "/book-room?id=abc&s=2040-01-01&e=2040-01-02", "GET", http.StatusOK},
 	{"show res cal", "/admin/reservations-cal", "GET", http.StatusOK},
 	{"show res cal with params", "/admin/reservations-cal?y=2023&m=4", "GET", http.StatusOK},
+
+	// promotions
+	{"promotions", "/admin/promotions", "GET", http.StatusOK},
+	{"new promotion", "/admin/promotions/new", "GET", http.StatusOK},
+	{"show promotion", "/admin/promotions/1", "GET", http.StatusOK},
+	{"show promotion not found", "/admin/promotions/2", "GET", http.StatusNotFound},
+	{"show promotion fails", "/admin/promotions/1000", "GET", http.StatusInternalServerError},
+	{"show promotion non numeric id", "/admin/promotions/abc", "GET", http.StatusBadRequest},
+	{"post new promotion invalid", "/admin/promotions/new", "POST", http.StatusOK},
+	{"post promotion not found", "/admin/promotions/2", "POST", http.StatusNotFound},
+	{"delete promotion with get", "/admin/promotions/1/delete", "GET", http.StatusMethodNotAllowed},
+	{"delete promotion", "/admin/promotions/1/delete", "POST", http.StatusOK},
+	{"delete promotion fails", "/admin/promotions/1000/delete", "POST", http.StatusInternalServerError},
+	{"delete promotion not found", "/admin/promotions/2/delete", "POST", http.StatusNotFound},
+
+	// invoices
+	{"download invoice", "/admin/reservations/new/1/invoice", "GET", http.StatusOK},
+	{"download invoice not issued", "/admin/reservations/new/2/invoice", "GET", http.StatusNotFound},
+	{"download invoice res not found", "/admin/reservations/new/2000/invoice", "GET", http.StatusNotFound},
+	{"download invoice unknown src", "/admin/reservations/x/1/invoice", "GET", http.StatusBadRequest},
+	{"issue invoice with get", "/admin/issue-invoice/new/1", "GET", http.StatusMethodNotAllowed},
+	{"issue invoice", "/admin/issue-invoice/new/1", "POST", http.StatusOK},
+	{"issue invoice fails", "/admin/issue-invoice/new/1000", "POST", http.StatusInternalServerError},
+	{"issue invoice res not found", "/admin/issue-invoice/new/2000", "POST", http.StatusNotFound},
+	{"email invoice with get", "/admin/email-invoice/new/1", "GET", http.StatusMethodNotAllowed},
+	{"email invoice", "/admin/email-invoice/new/1", "POST", http.StatusOK},
+	{"email invoice fails", "/admin/email-invoice/new/1000", "POST", http.StatusInternalServerError},
+
+	// waitlist
+	{"waitlist", "/waitlist?start=2050-01-01&end=2050-01-02", "GET", http.StatusOK},
+	{"post waitlist invalid", "/waitlist", "POST", http.StatusOK},
+	{"waitlist offer", "/waitlist/valid", "GET", http.StatusOK},
+	{"waitlist offer expired", "/waitlist/expired", "GET", http.StatusOK},
+	{"waitlist offer unknown", "/waitlist/unknown", "GET", http.StatusOK},
+	{"waitlist offer fails", "/waitlist/fail", "GET", http.StatusOK},
+
+	// flexible search
+	{"flexible search", "/search-availability?mode=around&start=2040-01-10&flex=3&nights=2", "GET", http.StatusOK},
+	{"flexible search weekend", "/search-availability?mode=weekend&month=2040-01&nights=2&sort=price", "GET", http.StatusOK},
+	{"flexible search no stays", "/search-availability?mode=around&start=2050-01-10&nights=2", "GET", http.StatusOK},
+	{"flexible search invalid", "/search-availability?mode=around&nights=2", "GET", http.StatusOK},
+	{"flexible search fails", "/search-availability?mode=around&start=2060-01-10&nights=2", "GET", http.StatusOK},
+	{"flexible search json", "/api/availability/flexible?mode=around&start=2040-01-10&nights=2", "GET", http.StatusOK},
+	{"flexible search json past month", "/api/availability/flexible?mode=weekend&month=2000-01&nights=2", "GET", http.StatusBadRequest},
+	{"flexible search json invalid mode", "/api/availability/flexible?mode=any&nights=2", "GET", http.StatusBadRequest},
+	{"flexible search json no nights", "/api/availability/flexible?mode=around&start=2040-01-10", "GET", http.StatusBadRequest},
+	{"flexible search json fails", "/api/availability/flexible?mode=around&start=2060-01-10&nights=2", "GET", http.StatusInternalServerError},
+
+	// availability calendar
+	{"availability calendar", "/api/availability/calendar?room_id=1&from=2040-01-01&to=2040-02-01", "GET", http.StatusOK},
+	{"availability calendar invalid dates", "/api/availability/calendar?room_id=1&from=2040-02-01&to=2040-01-01", "GET", http.StatusBadRequest},
+	{"availability calendar too long", "/api/availability/calendar?room_id=1&from=2040-01-01&to=2041-01-01", "GET", http.StatusBadRequest},
+	{"availability calendar no room", "/api/availability/calendar?from=2040-01-01&to=2040-02-01", "GET", http.StatusBadRequest},
+	{"availability calendar unknown room", "/api/availability/calendar?room_id=3&from=2040-01-01&to=2040-02-01", "GET", http.StatusNotFound},
+	{"availability calendar fails", "/api/availability/calendar?room_id=1000&from=2040-01-01&to=2040-02-01", "GET", http.StatusInternalServerError},
+
+	// taxes
+	{"tax rules", "/admin/tax-rules", "GET", http.StatusOK},
+	{"new tax rule", "/admin/tax-rules/new", "GET", http.StatusOK},
+	{"show tax rule", "/admin/tax-rules/1", "GET", http.StatusOK},
+	{"show tax rule not found", "/admin/tax-rules/2", "GET", http.StatusNotFound},
+	{"show tax rule fails", "/admin/tax-rules/1000", "GET", http.StatusInternalServerError},
+	{"show tax rule non numeric id", "/admin/tax-rules/abc", "GET", http.StatusBadRequest},
+	{"post new tax rule invalid", "/admin/tax-rules/new", "POST", http.StatusOK},
+	{"post tax rule not found", "/admin/tax-rules/2", "POST", http.StatusNotFound},
+	{"delete tax rule with get", "/admin/tax-rules/1/delete", "GET", http.StatusMethodNotAllowed},
+	{"delete tax rule", "/admin/tax-rules/1/delete", "POST", http.StatusOK},
+	{"delete tax rule fails", "/admin/tax-rules/1000/delete", "POST", http.StatusInternalServerError},
+	{"delete tax rule not found", "/admin/tax-rules/2/delete", "POST", http.StatusNotFound},
+	{"tax report", "/admin/tax-report", "GET", http.StatusOK},
+	{"tax report with params", "/admin/tax-report?y=2040&m=1", "GET", http.StatusOK},
+	{"tax report invalid month", "/admin/tax-report?y=2040&m=13", "GET", http.StatusBadRequest},
+	{"tax report fails", "/admin/tax-report?y=1000&m=1", "GET", http.StatusInternalServerError},
+	{"export new res", "/admin/reservations-new.csv", "GET", http.StatusOK},
+	{"export all res", "/admin/reservations-all.csv", "GET", http.StatusOK},
+	{"export res cal", "/admin/reservations-cal.csv", "GET", http.StatusBadRequest},
+	{"export res unknown src", "/admin/reservations-x.csv", "GET", http.StatusBadRequest},
+
+	// group bookings
+	{"choose rooms without session", "/choose-rooms", "POST", http.StatusOK},
+	{"group res without session", "/make-group-reservation", "GET", http.StatusOK},
+	{"post group res without session", "/make-group-reservation", "POST", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -55,6 +191,73 @@ func TestHandlers(t *testing.T) {
 			if resp.StatusCode != e.expectedStatusCode {
 				t.Errorf("For %s expected %d, but we got %d", e.name, e.expectedStatusCode, resp.StatusCode)
 			}
+		} else {
+			resp, err := ts.Client().PostForm(ts.URL+e.url, url.Values{})
+			if err != nil {
+				t.Log(err)
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != e.expectedStatusCode {
+				t.Errorf("For %s expected %d, but we got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestRepository_CancelledContext(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"new res": Repo.AdminNewReservations,
+		"all res": Repo.AdminAllReservations,
+	}
+
+	for name, handler := range handlers {
+		req, _ := http.NewRequest("GET", "/admin/reservations-all", nil)
+		ctx := getCtx(req)
+
+		// the client went away before the query could run
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("%s with cancelled context gave wrong status code: got %d, wanted %d", name, rr.Code, http.StatusInternalServerError)
+		}
+	}
+}
+
+var csrfTests = []struct {
+	name string
+	url  string
+}{
+	{"process res", "/admin/process-reservation/new/1"},
+	{"delete res", "/admin/delete-reservation/all/1"},
+	{"status res", "/admin/reservation-status/new/1/cancelled"},
+	{"issue invoice", "/admin/issue-invoice/new/1"},
+	{"email invoice", "/admin/email-invoice/new/1"},
+	{"delete tax rule", "/admin/tax-rules/1/delete"},
+	{"choose rooms", "/choose-rooms"},
+	{"group res", "/make-group-reservation"},
+}
+
+func TestHandlers_CSRF(t *testing.T) {
+	routes := NoSurf(getRoutes())
+
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	for _, e := range csrfTests {
+		// a POST without a CSRF token must be rejected before reaching the handler
+		resp, err := ts.Client().PostForm(ts.URL+e.url, url.Values{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("For %s without csrf token expected %d, but we got %d", e.name, http.StatusBadRequest, resp.StatusCode)
 		}
 	}
 }
@@ -160,7 +363,7 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation handler returned wrong response code for missing post body: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	// test for invalid start date
+	// test for invalid start date, shown back with the error
 	postedData = url.Values{}
 	postedData.Add("start_date", "invalid")
 	postedData.Add("end_date", "2050-01-02")
@@ -172,6 +375,7 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
 	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
@@ -180,11 +384,11 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code for invalid start date: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Invalid arrival date") {
+		t.Errorf("PostReservation handler should show the form again for invalid start date: got %d", rr.Code)
 	}
 
-	// test for invalid end date
+	// test for invalid end date, shown back with the error
 	postedData = url.Values{}
 	postedData.Add("start_date", "2050-01-01")
 	postedData.Add("end_date", "invalid")
@@ -196,6 +400,7 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
 	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
@@ -204,8 +409,8 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code for invalid end date: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Invalid departure date") {
+		t.Errorf("PostReservation handler should show the form again for invalid end date: got %d", rr.Code)
 	}
 
 	// test for invalid room id
@@ -220,6 +425,7 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
 	ctx = getCtx(req)
+	session.Put(ctx, "reservation", reservation)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
@@ -351,6 +557,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("Post availability when no rooms available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/waitlist?adults=1&children=0&end=2050-01-02&start=2050-01-01" {
+		t.Errorf("Post availability when no rooms available should offer the waitlist for the search, got %v", loc)
+	}
 
 	// second case -- rooms are available
 	postedData = url.Values{}
@@ -487,8 +696,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	// first test with no room id parameter in the url
 	req, _ := http.NewRequest("GET", "/choose-room/invalid", nil)
 	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/invalid"
+	req = withURLParams(req.WithContext(ctx), map[string]string{"id": "invalid"})
 
 	rr := httptest.NewRecorder()
 
@@ -510,8 +718,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 
 	req, _ = http.NewRequest("GET", "/choose-room/1", nil)
 	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/1"
+	req = withURLParams(req.WithContext(ctx), map[string]string{"id": "1"})
 
 	rr = httptest.NewRecorder()
 
@@ -527,8 +734,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	// third test with valid url but no session
 	req, _ = http.NewRequest("GET", "/choose-room/1", nil)
 	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/1"
+	req = withURLParams(req.WithContext(ctx), map[string]string{"id": "1"})
 
 	rr = httptest.NewRecorder()
 
@@ -542,7 +748,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 
 func TestRepository_BookRoom(t *testing.T) {
 	// first test with valid data
-	req, _ := http.NewRequest("GET", "/book-room/?s=2039-01-01&e=2030-01-02&id=1", nil)
+	req, _ := http.NewRequest("GET", "/book-room/?s=2039-01-01&e=2039-01-02&id=1", nil)
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
 	rr := httptest.NewRecorder()
@@ -550,12 +756,30 @@ func TestRepository_BookRoom(t *testing.T) {
 	handler := http.HandlerFunc(Repo.BookRoom)
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Book room with valid data gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/make-reservation" {
+		t.Errorf("Book room with valid data gave wrong response: got %d to %v, wanted %d to /make-reservation", rr.Code, loc, http.StatusSeeOther)
+	}
+
+	// invalid dates are not put in the session
+	for _, query := range []string{"s=invalid&e=2039-01-02", "s=2039-01-05&e=2039-01-02", "s=2020-01-01&e=2020-01-02", "s=2039-01-01&e=2039-03-01"} {
+		req, _ = http.NewRequest("GET", "/book-room/?id=1&"+query, nil)
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+
+		handler = http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/" {
+			t.Errorf("Book room with %s gave wrong response: got %d to %v, wanted %d to /", query, rr.Code, loc, http.StatusSeeOther)
+		}
+		if session.GetString(ctx, "error") == "" {
+			t.Errorf("Book room with %s did not flash an error", query)
+		}
 	}
 
 	// second test with invalid data
-	req, _ = http.NewRequest("GET", "/book-room/?s=2039-01-01&e=2030-01-02&id=10", nil)
+	req, _ = http.NewRequest("GET", "/book-room/?s=2039-01-01&e=2039-01-02&id=10", nil)
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	rr = httptest.NewRecorder()
@@ -642,6 +866,154 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	}
 }
 
+var availabilityJSONValidationTests = []struct {
+	name            string
+	start           string
+	end             string
+	roomID          string
+	expectedMessage string
+}{
+	{"invalid-start", "invalid", "2040-01-02", "1", "Invalid arrival date"},
+	{"end-before-start", "2040-01-05", "2040-01-02", "1", "Departure must be after arrival"},
+	{"past", "2020-01-01", "2020-01-02", "1", "Date cannot be in the past"},
+	{"too-long", "2040-01-01", "2040-03-01", "1", "Stays cannot be longer than 30 nights"},
+	{"invalid-room", "2040-01-01", "2040-01-02", "abc", "Invalid room"},
+}
+
+func TestRepository_AvailabilityJSONValidation(t *testing.T) {
+	for _, e := range availabilityJSONValidationTests {
+		postedData := url.Values{}
+		postedData.Add("start", e.start)
+		postedData.Add("end", e.end)
+		postedData.Add("room_id", e.roomID)
+
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var jr jsonResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &jr)
+		if err != nil {
+			t.Errorf("failed %s: can't parse json", e.name)
+			continue
+		}
+
+		if jr.OK || jr.Message != e.expectedMessage {
+			t.Errorf("failed %s: expected not ok with message %q, got ok %t with %q", e.name, e.expectedMessage, jr.OK, jr.Message)
+		}
+	}
+}
+
+var availabilityJSONBodyTests = []struct {
+	name            string
+	body            string
+	expectedOK      bool
+	expectedMessage string
+}{
+	{"available", `{"start": "2040-01-01", "end": "2040-01-02", "room_id": 1}`, true, ""},
+	{"not-available", `{"start": "2050-01-01", "end": "2050-01-02", "room_id": "1"}`, false, ""},
+	{"invalid-room", `{"start": "2040-01-01", "end": "2040-01-02", "room_id": "abc"}`, false, "Invalid room"},
+	{"missing-end", `{"start": "2040-01-01", "room_id": 1}`, false, "Invalid departure date"},
+	{"malformed", `{"start": ["2040-01-01"]}`, false, "Internal server error"},
+}
+
+func TestRepository_AvailabilityJSONBody(t *testing.T) {
+	for _, e := range availabilityJSONBodyTests {
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var jr jsonResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &jr)
+		if err != nil {
+			t.Errorf("failed %s: can't parse json", e.name)
+			continue
+		}
+
+		if jr.OK != e.expectedOK || jr.Message != e.expectedMessage {
+			t.Errorf("failed %s: expected ok %t with message %q, got ok %t with %q", e.name, e.expectedOK, e.expectedMessage, jr.OK, jr.Message)
+		}
+	}
+}
+
+var stayValidationTests = []struct {
+	name          string
+	startDate     string
+	endDate       string
+	phone         string
+	expectedError string
+}{
+	{"end-before-start", "2040-01-05", "2040-01-02", "123456789", "Departure must be after arrival"},
+	{"past", "2020-01-01", "2020-01-02", "123456789", "Date cannot be in the past"},
+	{"too-long", "2040-01-01", "2040-03-01", "123456789", "Stays cannot be longer than 30 nights"},
+	{"invalid-phone", "2040-01-01", "2040-01-02", "call me", "Invalid phone number"},
+}
+
+func TestRepository_PostReservationValidation(t *testing.T) {
+	for _, e := range stayValidationTests {
+		postedData := url.Values{}
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("phone", e.phone)
+		postedData.Add("room_id", "1")
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", models.Reservation{RoomId: 1})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("failed %s: expected to find %q but did not", e.name, e.expectedError)
+		}
+	}
+
+	// the search form sends the guest back to it with the first error
+	for _, e := range stayValidationTests[:3] {
+		postedData := url.Values{}
+		postedData.Add("start", e.startDate)
+		postedData.Add("end", e.endDate)
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/search-availability" {
+			t.Errorf("failed search %s: got %d to %v, wanted %d to /search-availability", e.name, rr.Code, loc, http.StatusSeeOther)
+		}
+
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed search %s: expected error %q, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
 var loginTests = []struct {
 	name               string
 	email              string
@@ -714,10 +1086,2129 @@ func TestLogin(t *testing.T) {
 	}
 }
 
-func getCtx(r *http.Request) context.Context {
-	ctx, err := session.Load(r.Context(), r.Header.Get("X-Session"))
-	if err != nil {
-		log.Println(err)
+var adminPostCalendarTests = []struct {
+	name               string
+	form               url.Values
+	expectedStatusCode int
+}{
+	{"valid", url.Values{"y": {"2040"}, "m": {"1"}, "add_block_1_2040-01-5": {"1"}}, http.StatusSeeOther},
+	{"missing year", url.Values{"m": {"1"}}, http.StatusBadRequest},
+	{"invalid year", url.Values{"y": {"abc"}, "m": {"1"}}, http.StatusBadRequest},
+	{"invalid month", url.Values{"y": {"2040"}, "m": {"13"}}, http.StatusBadRequest},
+	{"block without day", url.Values{"y": {"2040"}, "m": {"1"}, "add_block_1": {"1"}}, http.StatusBadRequest},
+	{"block with invalid room", url.Values{"y": {"2040"}, "m": {"1"}, "add_block_x_2040-01-5": {"1"}}, http.StatusBadRequest},
+	{"removed block with invalid day", url.Values{"y": {"2040"}, "m": {"1"}, "remove_block_1_soon": {"3"}}, http.StatusBadRequest},
+}
+
+func TestRepository_AdminPostCalendarReservations(t *testing.T) {
+	for _, e := range adminPostCalendarTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-cal", strings.NewReader(e.form.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostCalendarReservations).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// the rooms shown need their block map, kept in the session when the calendar is shown
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+	form := url.Values{"y": {"2040"}, "m": {"1"}}
+	req, _ := http.NewRequest("POST", "/admin/reservations-cal", strings.NewReader(form.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.AdminPostCalendarReservations).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("without a calendar shown: expected %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+var adminPostShowReservationTests = []struct {
+	name               string
+	url                string
+	startDate          string
+	endDate            string
+	roomID             string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		"valid-data",
+		"/admin/reservations/new/1",
+		"2040-01-01",
+		"2040-01-05",
+		"1",
+		http.StatusSeeOther,
+		"/admin/reservations-new",
+		"",
+	},
+	{
+		"valid-data-from-all",
+		"/admin/reservations/all/1",
+		"2040-01-01",
+		"2040-01-05",
+		"2",
+		http.StatusSeeOther,
+		"/admin/reservations-all",
+		"",
+	},
+	{
+		"invalid-start-date",
+		"/admin/reservations/new/1",
+		"invalid",
+		"2040-01-05",
+		"1",
+		http.StatusOK,
+		"",
+		"Invalid arrival date",
+	},
+	{
+		"end-before-start",
+		"/admin/reservations/new/1",
+		"2040-01-05",
+		"2040-01-01",
+		"1",
+		http.StatusOK,
+		"",
+		"Departure must be after arrival",
+	},
+	{
+		"same-start-and-end",
+		"/admin/reservations/new/1",
+		"2040-01-05",
+		"2040-01-05",
+		"1",
+		http.StatusOK,
+		"",
+		"Departure must be after arrival",
+	},
+	{
+		"invalid-room",
+		"/admin/reservations/new/1",
+		"2040-01-01",
+		"2040-01-05",
+		"10",
+		http.StatusOK,
+		"",
+		"Invalid room",
+	},
+	{
+		"room-not-available",
+		"/admin/reservations/new/1",
+		"2050-01-01",
+		"2050-01-05",
+		"1",
+		http.StatusOK,
+		"",
+		"Room is not available for the selected dates",
+	},
+	{
+		"room-not-available-keeps-invoice",
+		"/admin/reservations/new/1",
+		"2050-01-01",
+		"2050-01-05",
+		"1",
+		http.StatusOK,
+		"",
+		"Invoice 1 issued on 2040-01-05",
+	},
+	{
+		"availability-query-fails",
+		"/admin/reservations/new/1",
+		"2060-01-01",
+		"2060-01-05",
+		"1",
+		http.StatusInternalServerError,
+		"",
+		"",
+	},
+	{
+		"cancelled-reservation",
+		"/admin/reservations/new/3",
+		"2040-01-01",
+		"2040-01-05",
+		"1",
+		http.StatusSeeOther,
+		"/admin/reservations/new/3",
+		"",
+	},
+	{
+		"update-fails",
+		"/admin/reservations/new/1000",
+		"2040-01-01",
+		"2040-01-05",
+		"1",
+		http.StatusInternalServerError,
+		"",
+		"",
+	},
+}
+
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	for _, e := range adminPostShowReservationTests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("phone", "123456789")
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+		postedData.Add("room_id", e.roomID)
+
+		exploded := strings.Split(e.url, "/")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = withURLParams(req.WithContext(ctx), map[string]string{"src": exploded[3], "id": exploded[4]})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostCreateReservationTests = []struct {
+	name               string
+	firstName          string
+	startDate          string
+	endDate            string
+	roomID             string
+	sendEmail          bool
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{"valid-data", "John", "2040-01-01", "2040-01-05", "1", true, http.StatusSeeOther, "/admin/reservations/all/1", ""},
+	{"valid-data-no-email", "John", "2040-01-01", "2040-01-05", "1", false, http.StatusSeeOther, "/admin/reservations/all/1", ""},
+	{"invalid-first-name", "J", "2040-01-01", "2040-01-05", "1", true, http.StatusOK, "", "This field must be at least 3 characters long"},
+	{"invalid-end-date", "John", "2040-01-01", "invalid", "1", true, http.StatusOK, "", "Invalid departure date"},
+	{"end-before-start", "John", "2040-01-05", "2040-01-01", "1", true, http.StatusOK, "", "Departure must be after arrival"},
+	{"past-dates", "John", "2020-01-01", "2020-01-05", "1", true, http.StatusSeeOther, "/admin/reservations/all/1", ""},
+	{"too-long", "John", "2040-01-01", "2040-03-01", "1", true, http.StatusOK, "", "Stays cannot be longer than 30 nights"},
+	{"invalid-room", "John", "2040-01-01", "2040-01-05", "invalid", true, http.StatusOK, "", "Invalid room"},
+	{"room-not-available", "John", "2050-01-01", "2050-01-05", "1", true, http.StatusOK, "", "Room is not available for the selected dates"},
+	{"availability-query-fails", "John", "2060-01-01", "2060-01-05", "1", true, http.StatusInternalServerError, "", ""},
+	{"insert-fails", "John", "2040-01-01", "2040-01-05", "2", true, http.StatusInternalServerError, "", ""},
+}
+
+func TestRepository_AdminPostCreateReservation(t *testing.T) {
+	for _, e := range adminPostCreateReservationTests {
+		postedData := url.Values{}
+		postedData.Add("first_name", e.firstName)
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("phone", "123456789")
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+		postedData.Add("room_id", e.roomID)
+		if e.sendEmail {
+			postedData.Add("send_email", "1")
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/new", strings.NewReader(postedData.Encode()))
+		ctx := getStaffCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCreateReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostCreateReservationLoggedOut(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("start_date", "2040-01-01")
+	postedData.Add("end_date", "2040-01-05")
+	postedData.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/admin/reservations/new", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminPostCreateReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if loc, _ := rr.Result().Location(); loc.String() != "/user/login" {
+		t.Errorf("expected a redirect to the login page, got %s", loc)
+	}
+	if msg := session.GetString(ctx, "error"); msg != "Log in first" {
+		t.Errorf("expected to be asked to log in, got %q", msg)
+	}
+}
+
+// TestRepository_SQLite runs the admin booking flow against a real in memory database
+func TestRepository_SQLite(t *testing.T) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	err = dbrepo.InitSqliteSchema(context.Background(), db.SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the staff member creating the reservations
+	_, err = db.SQL.Exec(`insert into users (email, password, access_level, created_at, updated_at)
+	                      values ('admin@admin.com', '', 3, datetime('now'), datetime('now'))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRepo(&app, db)
+
+	postReservation := func(startDate, endDate string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("phone", "123456789")
+		postedData.Add("start_date", startDate)
+		postedData.Add("end_date", endDate)
+		postedData.Add("room_id", "1")
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/new", strings.NewReader(postedData.Encode()))
+		ctx := getStaffCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostCreateReservation)
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := postReservation("2040-01-01", "2040-01-05")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	reservations, err := repo.DB.AllReservations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 1 {
+		t.Fatalf("expected 1 reservation, got %d", len(reservations))
+	}
+	if reservations[0].Status != models.ReservationStatusConfirmed {
+		t.Errorf("expected status %q, got %q", models.ReservationStatusConfirmed, reservations[0].Status)
+	}
+
+	// the room is now taken for overlapping dates
+	rr = postReservation("2040-01-03", "2040-01-07")
+	if !strings.Contains(rr.Body.String(), "Room is not available for the selected dates") {
+		t.Error("expected an overlapping reservation to be refused")
+	}
+
+	// but free from the departure day on
+	rr = postReservation("2040-01-05", "2040-01-07")
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected a back to back reservation to succeed, got code %d", rr.Code)
+	}
+}
+
+// TestRepository_BookingFlow books a room through the public pages and cancels it from the admin pages,
+// against an in memory store that keeps its state between requests
+func TestRepository_BookingFlow(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	serve := func(h http.HandlerFunc, method, target string, form url.Values, params map[string]string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+
+		req, _ := http.NewRequest(method, target, body)
+		req = req.WithContext(ctx)
+		if params != nil {
+			req = withURLParams(req, params)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	dates := url.Values{}
+	dates.Add("start", "2040-01-01")
+	dates.Add("end", "2040-01-05")
+
+	rr := serve(repo.PostAvailability, "POST", "/search-availability", dates, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `href="/choose-room/1"`) {
+		t.Fatalf("expected room 1 to be offered, got code %d", rr.Code)
+	}
+
+	rr = serve(repo.ChooseRoom, "GET", "/choose-room/1", nil, map[string]string{"id": "1"})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("ChooseRoom returned code %d", rr.Code)
+	}
+
+	rr = serve(repo.Reservation, "GET", "/make-reservation", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Reservation returned code %d", rr.Code)
+	}
+
+	postedData := url.Values{}
+	postedData.Add("start_date", "2040-01-01")
+	postedData.Add("end_date", "2040-01-05")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "123456789")
+	postedData.Add("room_id", "1")
+
+	rr = serve(repo.PostReservation, "POST", "/make-reservation", postedData, nil)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/reservation-summary" {
+		t.Fatalf("PostReservation returned code %d, location %v", rr.Code, loc)
+	}
+
+	rr = serve(repo.PostAvailability, "POST", "/search-availability", dates, nil)
+	if strings.Contains(rr.Body.String(), `href="/choose-room/1"`) {
+		t.Error("expected a booked room not to be offered again")
+	}
+
+	reservations, err := repo.DB.AllNewReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 1 {
+		t.Fatalf("expected 1 new reservation, got %d", len(reservations))
+	}
+	id := strconv.Itoa(reservations[0].ID)
+
+	// 4 nights at 90.00 with a 30% deposit
+	if reservations[0].Status != models.ReservationStatusConfirmed {
+		t.Errorf("expected the reservation to be confirmed once its deposit is authorized, got %s", reservations[0].Status)
+	}
+	resPayments, err := repo.DB.GetPaymentsForReservation(ctx, reservations[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resPayments) != 1 || resPayments[0].Amount != 10800 || resPayments[0].Status != models.PaymentStatusAuthorized {
+		t.Fatalf("expected an authorized deposit of 10800, got %+v", resPayments)
+	}
+
+	rr = serve(repo.AdminReservationStatus, "POST", "/admin/reservation-status/new/"+id+"/cancelled", nil,
+		map[string]string{"src": "new", "id": id, "status": models.ReservationStatusCancelled})
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/admin/reservations-new" {
+		t.Fatalf("AdminReservationStatus returned code %d, location %v", rr.Code, loc)
+	}
+
+	rr = serve(repo.PostAvailability, "POST", "/search-availability", dates, nil)
+	if !strings.Contains(rr.Body.String(), `href="/choose-room/1"`) {
+		t.Error("expected a cancelled booking to free its room")
+	}
+
+	logs, err := repo.DB.GetAuditLogsForEntity(ctx, auditEntityReservation, reservations[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 || logs[0].Action != "status changed" || logs[1].Action != "status changed" || logs[2].Action != "created" {
+		t.Errorf("expected the booking, its confirmation and the cancellation to be audited, got %+v", logs)
+	}
+
+	resPayments, err = repo.DB.GetPaymentsForReservation(ctx, reservations[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resPayments) != 1 || resPayments[0].Status != models.PaymentStatusRefunded {
+		t.Errorf("expected the deposit to be given back on cancellation, got %+v", resPayments)
+	}
+}
+
+// TestRepository_DeclinedPayment checks that a declined deposit releases the room and sends the guest back to the form
+func TestRepository_DeclinedPayment(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{RoomId: 1})
+
+	postedData := url.Values{}
+	postedData.Add("start_date", "2040-01-01")
+	postedData.Add("end_date", "2040-01-05")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("room_id", "1")
+	postedData.Add("payment_token", payments.FakeDeclineToken)
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/make-reservation" {
+		t.Fatalf("expected a redirect to the form, got %d to %v", rr.Code, loc)
+	}
+	if msg := session.GetString(ctx, "error"); msg != "Your payment was not authorized, please try again" {
+		t.Errorf("unexpected error %q", msg)
+	}
+
+	res, err := repo.DB.GetReservationById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.ReservationStatusCancelled {
+		t.Errorf("expected the reservation to be cancelled, got %s", res.Status)
+	}
+
+	available, err := repo.DB.SearchAvailabilityByDatesByRoomId(ctx, res.StartDate, res.EndDate, 1)
+	if err != nil || !available {
+		t.Errorf("expected the room to be released, got %t, %v", available, err)
+	}
+
+	resPayments, err := repo.DB.GetPaymentsForReservation(ctx, res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resPayments) != 1 || resPayments[0].Status != models.PaymentStatusFailed {
+		t.Errorf("expected the declined payment to be recorded, got %+v", resPayments)
+	}
+}
+
+// TestRepository_Holds checks that a room chosen by a guest is held until the reservation is made
+func TestRepository_Holds(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+	start := time.Date(2040, time.February, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 4)
+
+	serve := func(ctx context.Context, h http.HandlerFunc, target string, params map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", target, nil)
+		req = req.WithContext(ctx)
+		if params != nil {
+			req = withURLParams(req, params)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	first := getCtx(req)
+	second := getCtx(req)
+
+	session.Put(first, "reservation", models.Reservation{StartDate: start, EndDate: end})
+	rr := serve(first, repo.ChooseRoom, "/choose-room/1", map[string]string{"id": "1"})
+	if loc, _ := rr.Result().Location(); loc.String() != "/make-reservation" {
+		t.Fatalf("expected the first guest to get the room, got %d to %v", rr.Code, loc)
+	}
+
+	available, err := repo.DB.SearchAvailabilityByDatesByRoomId(first, start, end, 1)
+	if err != nil || available {
+		t.Errorf("expected the chosen room to be held, got %t, %v", available, err)
+	}
+
+	rr = serve(second, repo.BookRoom, "/book-room?id=1&s=2040-02-02&e=2040-02-03", nil)
+	if loc, _ := rr.Result().Location(); loc.String() != "/search-availability" {
+		t.Errorf("expected the second guest to be sent back to the search, got %d to %v", rr.Code, loc)
+	}
+	if msg := session.GetString(second, "error"); msg != "Sorry, the room is no longer available for the selected dates" {
+		t.Errorf("unexpected error %q", msg)
+	}
+
+	// choosing another room releases the first one
+	rr = serve(first, repo.ChooseRoom, "/choose-room/2", map[string]string{"id": "2"})
+	if loc, _ := rr.Result().Location(); loc.String() != "/make-reservation" {
+		t.Fatalf("expected the first guest to get the other room, got %d to %v", rr.Code, loc)
+	}
+
+	available, err = repo.DB.SearchAvailabilityByDatesByRoomId(first, start, end, 1)
+	if err != nil || !available {
+		t.Errorf("expected the first room to be released, got %t, %v", available, err)
+	}
+
+	postedData := url.Values{}
+	postedData.Add("start_date", "2040-02-01")
+	postedData.Add("end_date", "2040-02-05")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("room_id", "2")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(first)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); loc.String() != "/reservation-summary" {
+		t.Fatalf("expected the reservation to be made, got %d to %v", rr.Code, loc)
+	}
+	if session.Exists(first, "hold_id") {
+		t.Error("expected the hold to be removed from the session")
+	}
+
+	// the hold became the restriction of the reservation, nothing is left to expire
+	deleted, err := repo.DB.DeleteExpiredHolds(first, end)
+	if err != nil || deleted != 0 {
+		t.Errorf("expected no holds left, got %d, %v", deleted, err)
+	}
+
+	restrictions, err := repo.DB.GetRestrictionsForRoomByDate(first, 2, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 || restrictions[0].ReservationId == 0 || restrictions[0].RestrictionId != models.RestrictionReservation {
+		t.Errorf("expected the reservation to hold the room, got %+v", restrictions)
+	}
+}
+
+// TestRepository_ExpiredHold checks that a guest whose hold expired can still book a room that is free
+func TestRepository_ExpiredHold(t *testing.T) {
+	expiring := app
+	expiring.HoldDuration = time.Nanosecond
+	repo := &Repository{App: &expiring, DB: dbrepo.NewMemoryRepo(&expiring)}
+
+	req, _ := http.NewRequest("GET", "/choose-room/1", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{
+		StartDate: time.Date(2040, time.February, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, time.February, 5, 0, 0, 0, 0, time.UTC),
+	})
+	req = withURLParams(req.WithContext(ctx), map[string]string{"id": "1"})
+	http.HandlerFunc(repo.ChooseRoom).ServeHTTP(httptest.NewRecorder(), req)
+
+	postedData := url.Values{}
+	postedData.Add("start_date", "2040-02-01")
+	postedData.Add("end_date", "2040-02-05")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("room_id", "1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); loc.String() != "/reservation-summary" {
+		t.Fatalf("expected the free room to be booked, got %d to %v", rr.Code, loc)
+	}
+
+	// the expired hold is released by the booking, nothing is left for the sweeper
+	deleted, err := repo.DB.DeleteExpiredHolds(ctx, time.Now())
+	if err != nil || deleted != 0 {
+		t.Errorf("expected the expired hold to be released by the booking, got %d, %v", deleted, err)
+	}
+}
+
+// TestRepository_Cancellation checks that cancelling keeps what the cancellation policy of the room charges
+func TestRepository_Cancellation(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	// book makes a reservation from a new guest session, the dates are days from today
+	book := func(roomId, from, nights int) (context.Context, models.Reservation) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", models.Reservation{RoomId: roomId})
+
+		start := time.Now().AddDate(0, 0, from)
+		postedData := url.Values{}
+		postedData.Add("start_date", start.Format("2006-01-02"))
+		postedData.Add("end_date", start.AddDate(0, 0, nights).Format("2006-01-02"))
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("room_id", strconv.Itoa(roomId))
+
+		req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+		if loc, _ := rr.Result().Location(); loc.String() != "/reservation-summary" {
+			t.Fatalf("expected the reservation to be made, got %d to %v", rr.Code, loc)
+		}
+
+		return ctx, session.Get(ctx, "reservation").(models.Reservation)
+	}
+
+	refunded := func(ctx context.Context, id int) models.Payment {
+		resPayments, err := repo.DB.GetPaymentsForReservation(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resPayments) != 1 {
+			t.Fatalf("expected a deposit, got %+v", resPayments)
+		}
+		return resPayments[0]
+	}
+
+	// room 1: 4 nights at 90.00 cost 360.00 with a deposit of 108.00, a late cancellation keeps 20% of the stay
+	ctx, res := book(1, 3, 4)
+
+	req, _ := http.NewRequest("POST", "/cancel-reservation", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.PostCancelReservation).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); loc.String() != "/" {
+		t.Fatalf("expected the guest to be sent home, got %d to %v", rr.Code, loc)
+	}
+	if msg := session.GetString(ctx, "flash"); msg != "Your reservation has been cancelled, 36.00 EUR will be refunded" {
+		t.Errorf("unexpected flash %q", msg)
+	}
+	if p := refunded(ctx, res.ID); p.Status != models.PaymentStatusRefunded || p.Refunded != 3600 {
+		t.Errorf("expected 36.00 of the deposit to be refunded, got %+v", p)
+	}
+
+	cancelled, err := repo.DB.GetReservationById(ctx, res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != models.ReservationStatusCancelled {
+		t.Errorf("expected the reservation to be cancelled, got %s", cancelled.Status)
+	}
+
+	// the reservation can't be cancelled twice
+	req, _ = http.NewRequest("POST", "/cancel-reservation", nil)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(repo.PostCancelReservation).ServeHTTP(rr, req)
+	if msg := session.GetString(ctx, "error"); msg != "Can't get reservation from session" {
+		t.Errorf("unexpected error %q", msg)
+	}
+
+	// room 2: a guest cancelling late keeps no refund, and is told so in their language
+	ctx, res = book(2, 3, 2)
+	req, _ = http.NewRequest("POST", "/cancel-reservation", nil)
+	req = req.WithContext(i18n.WithLang(ctx, "it"))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(repo.PostCancelReservation).ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "flash"); msg != "La prenotazione è stata annullata" {
+		t.Errorf("unexpected flash %q", msg)
+	}
+	if p := refunded(ctx, res.ID); p.Status != models.PaymentStatusCaptured || p.Refunded != 0 {
+		t.Errorf("expected the deposit to be kept, got %+v", p)
+	}
+
+	// room 1 cancelled in time is refunded in full
+	ctx, res = book(1, 20, 2)
+	id := strconv.Itoa(res.ID)
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/reservation-status/all/"+id+"/cancelled", nil)
+	req = withURLParams(req.WithContext(ctx), map[string]string{"src": "all", "id": id, "status": models.ReservationStatusCancelled})
+	http.HandlerFunc(repo.AdminReservationStatus).ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "flash"); msg != "Reservation marked as cancelled, 54.00 EUR refunded" {
+		t.Errorf("unexpected flash %q", msg)
+	}
+	if p := refunded(ctx, res.ID); p.Status != models.PaymentStatusRefunded || p.Refunded != p.Amount {
+		t.Errorf("expected the deposit to be refunded in full, got %+v", p)
+	}
+
+	// room 2: a late cancellation keeps 30% of the stay, the whole deposit
+	ctx, res = book(2, 3, 2)
+	id = strconv.Itoa(res.ID)
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/reservation-status/all/"+id+"/cancelled", nil)
+	req = withURLParams(req.WithContext(ctx), map[string]string{"src": "all", "id": id, "status": models.ReservationStatusCancelled})
+	http.HandlerFunc(repo.AdminReservationStatus).ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "flash"); msg != "Reservation marked as cancelled" {
+		t.Errorf("unexpected flash %q", msg)
+	}
+	if p := refunded(ctx, res.ID); p.Status != models.PaymentStatusCaptured || p.Refunded != 0 {
+		t.Errorf("expected the deposit to be kept, got %+v", p)
+	}
+
+	// a deleted reservation gives its deposit back like a cancellation, and can't be reached anymore
+	ctx, res = book(1, 20, 2)
+	id = strconv.Itoa(res.ID)
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/delete-reservation/all/"+id, nil)
+	req = withURLParams(req.WithContext(ctx), map[string]string{"src": "all", "id": id})
+	http.HandlerFunc(repo.AdminDeleteReservation).ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "flash"); msg != "Reservation deleted, 54.00 EUR refunded" {
+		t.Errorf("unexpected flash %q", msg)
+	}
+	if p := refunded(ctx, res.ID); p.Status != models.PaymentStatusRefunded || p.Refunded != p.Amount {
+		t.Errorf("expected the deposit of a deleted reservation to be refunded in full, got %+v", p)
+	}
+
+	for name, h := range map[string]http.HandlerFunc{
+		"show":   repo.AdminShowReservation,
+		"edit":   repo.AdminPostShowReservation,
+		"delete": repo.AdminDeleteReservation,
+		"status": repo.AdminReservationStatus,
+	} {
+		rr = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req.WithContext(ctx), map[string]string{"src": "all", "id": id, "status": models.ReservationStatusConfirmed})
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected a deleted reservation to be not found, got %d", name, rr.Code)
+		}
+	}
+}
+
+// TestRepository_PaymentWebhook checks that a failed payment notified by the gateway releases the room
+func TestRepository_PaymentWebhook(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	start := time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 4)
+	resId, err := repo.DB.InsertReservation(ctx, models.Reservation{FirstName: "John", Email: "john@smith.com", StartDate: start, EndDate: end, RoomId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DB.InsertRoomRestriction(ctx, models.RoomRestriction{StartDate: start, EndDate: end, RoomId: 1, ReservationId: resId, RestrictionId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.DB.InsertPayment(ctx, models.Payment{ReservationId: resId, Gateway: gateway.Name(), Reference: "fake_42",
+		Amount: 10800, Currency: "EUR", Status: models.PaymentStatusAuthorized})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var webhookTests = []struct {
+		name         string
+		payload      string
+		signature    string
+		expectedCode int
+	}{
+		{"bad-signature", `{"type": "payment.failed", "reference": "fake_42"}`, "bad", http.StatusBadRequest},
+		{"unknown-payment", `{"type": "payment.failed", "reference": "fake_1000"}`, "", http.StatusNotFound},
+		{"ignored-event", `{"type": "payment.created", "reference": "fake_42"}`, "", http.StatusOK},
+		{"failed", `{"type": "payment.failed", "reference": "fake_42", "amount": 10800}`, "", http.StatusOK},
+	}
+
+	for _, e := range webhookTests {
+		signature := e.signature
+		if signature == "" {
+			signature = gateway.Sign([]byte(e.payload))
+		}
+
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(e.payload))
+		req = req.WithContext(ctx)
+		req.Header.Set(payments.SignatureHeader, signature)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PaymentWebhook).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+
+	p, err := repo.DB.GetPaymentByReference(ctx, gateway.Name(), "fake_42")
+	if err != nil || p.Status != models.PaymentStatusFailed {
+		t.Errorf("expected the payment to be failed, got %+v, %v", p, err)
+	}
+
+	res, err := repo.DB.GetReservationById(ctx, resId)
+	if err != nil || res.Status != models.ReservationStatusCancelled {
+		t.Errorf("expected the reservation to be cancelled, got %+v, %v", res.Status, err)
+	}
+
+	available, err := repo.DB.SearchAvailabilityByDatesByRoomId(ctx, start, end, 1)
+	if err != nil || !available {
+		t.Errorf("expected the room to be released, got %t, %v", available, err)
+	}
+}
+
+// TestRepository_BackToBackStays checks that a room can be booked again on its check-out day,
+// and that the calendar shows each stay on its nights only
+func TestRepository_BackToBackStays(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getStaffCtx(req)
+
+	book := func(startDate, endDate string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("start_date", startDate)
+		postedData.Add("end_date", endDate)
+		postedData.Add("room_id", "1")
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/new", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(repo.AdminPostCreateReservation).ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	var stayTests = []struct {
+		name      string
+		startDate string
+		endDate   string
+		booked    bool
+	}{
+		{"first stay", "2040-01-01", "2040-01-05", true},
+		{"arriving on check-out day", "2040-01-05", "2040-01-07", true},
+		{"overlapping last night", "2040-01-06", "2040-01-08", false},
+		{"leaving on check-in day", "2039-12-30", "2040-01-01", true},
+	}
+
+	for _, e := range stayTests {
+		rr := book(e.startDate, e.endDate)
+		if booked := rr.Code == http.StatusSeeOther; booked != e.booked {
+			t.Errorf("%s: expected booked %t, got code %d", e.name, e.booked, rr.Code)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/reservations-cal?y=2040&m=1", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.AdminCalendarReservations).ServeHTTP(rr, req)
+
+	html := rr.Body.String()
+	for id, nights := range map[int]int{1: 4, 2: 2, 3: 0} {
+		link := fmt.Sprintf(`href="/admin/reservations/cal/%d"`, id)
+		if got := strings.Count(html, link); got != nights {
+			t.Errorf("expected reservation %d on %d days of January, got %d", id, nights, got)
+		}
+	}
+}
+
+func TestRepository_Italian(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/generals-quarters", nil)
+	req = req.WithContext(i18n.WithLang(getCtx(req), "it"))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.Generals).ServeHTTP(rr, req)
+
+	for _, text := range []string{`<html lang="it">`, "Verifica disponibilità", "Cerca disponibilità"} {
+		if !strings.Contains(rr.Body.String(), text) {
+			t.Errorf("expected the page to contain %q", text)
+		}
+	}
+
+	postedData := url.Values{}
+	postedData.Add("start_date", "2040-01-01")
+	postedData.Add("end_date", "2040-01-02")
+	postedData.Add("first_name", "J")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("room_id", "1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := i18n.WithLang(getCtx(req), "it")
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", models.Reservation{RoomId: 1})
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "Questo campo deve essere lungo almeno 3 caratteri") {
+		t.Error("expected the validation error in Italian")
+	}
+
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(`{"start": "2040-01-01", "end": "2040-01-02", "room_id": "abc"}`))
+	req = req.WithContext(i18n.WithLang(getCtx(req), "it"))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+	var jr jsonResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &jr)
+	if err != nil || jr.Message != "Camera non valida" {
+		t.Errorf("expected the JSON error in Italian, got %q (%v)", jr.Message, err)
+	}
+}
+
+func TestRepository_GuestConfirmationLanguage(t *testing.T) {
+	mailApp := app
+	mailApp.MailChan = make(chan models.MailData, 1)
+	pr := &Repository{App: &mailApp}
+
+	res := models.Reservation{
+		FirstName: "Mario",
+		Email:     "mario@rossi.it",
+		StartDate: time.Date(2040, time.March, 5, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, time.March, 7, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{FreeCancellationDays: 7, CancellationPercent: 20},
+		Language:  "it",
+	}
+
+	pr.sendGuestConfirmation(res, models.Payment{ID: 1, Amount: 6000, Currency: "EUR"})
+	msg := <-mailApp.MailChan
+
+	if msg.Subject != "Conferma della prenotazione" {
+		t.Errorf("expected the subject in Italian, got %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Content, "Gentile Mario,") || !strings.Contains(msg.Content, "dal 5 marzo 2040 al 7 marzo 2040") {
+		t.Errorf("expected the body in Italian, got %q", msg.Content)
+	}
+	if !strings.Contains(msg.Content, "60.00 EUR") {
+		t.Errorf("expected the deposit in the email, got %q", msg.Content)
+	}
+	if !strings.Contains(msg.Content, "Cancellazione gratuita fino al 27 febbraio 2040, poi viene addebitato il 20% del soggiorno") {
+		t.Errorf("expected the cancellation policy in the email, got %q", msg.Content)
+	}
+
+	res.Language = "en"
+	pr.sendGuestConfirmation(res, models.Payment{})
+	msg = <-mailApp.MailChan
+
+	if msg.Subject != "Reservation Confirmation" || !strings.Contains(msg.Content, "from March 5, 2040 to March 7, 2040") {
+		t.Errorf("expected the email in English, got %q: %q", msg.Subject, msg.Content)
+	}
+}
+
+// TestRepository_Invoices checks that an invoice is numbered once, downloaded as a PDF and emailed to the guest
+func TestRepository_Invoices(t *testing.T) {
+	mailApp := app
+	mailApp.MailChan = make(chan models.MailData, 1)
+	repo := &Repository{App: &mailApp, DB: dbrepo.NewMemoryRepo(&mailApp)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	id, err := repo.DB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Mario",
+		LastName:  "Rossi",
+		Email:     "mario@rossi.it",
+		StartDate: time.Date(2040, time.March, 5, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, time.March, 8, 0, 0, 0, 0, time.UTC),
+		RoomId:    1,
+		Language:  "it",
+		PromoCode: "SPRING",
+		Discount:  2700,
+		Status:    models.ReservationStatusConfirmed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []models.Payment{
+		{ReservationId: id, Amount: 7290, Currency: "EUR", Status: models.PaymentStatusCaptured},
+		{ReservationId: id, Amount: 1000, Currency: "EUR", Status: models.PaymentStatusAuthorized},
+	} {
+		if _, err := repo.DB.InsertPayment(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params := map[string]string{"src": "all", "id": strconv.Itoa(id)}
+	serve := func(h http.HandlerFunc, method string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/", nil)
+		req = withURLParams(req.WithContext(ctx), params)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	if rr := serve(repo.AdminReservationInvoice, "GET"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected no invoice before it is issued, got code %d", rr.Code)
+	}
+
+	rr := serve(repo.AdminIssueInvoice, "POST")
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != fmt.Sprintf("/admin/reservations/all/%d", id) {
+		t.Fatalf("AdminIssueInvoice returned code %d, location %v", rr.Code, loc)
+	}
+	if flash := session.PopString(ctx, "flash"); flash != "Invoice 1 issued" {
+		t.Errorf("expected invoice 1 to be issued, got %q", flash)
+	}
+
+	rr = serve(repo.AdminReservationInvoice, "GET")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pdf" {
+		t.Fatalf("expected a PDF, got code %d and %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != "attachment; filename=invoice-1.pdf" {
+		t.Errorf("expected the invoice to be downloaded as invoice-1.pdf, got %s", disposition)
+	}
+
+	// 3 nights at 90.00, 27.00 off and the captured deposit, in the language of the guest
+	pdf := rr.Body.String()
+	for _, s := range []string{"%PDF-", "(Fattura)", "(Mario Rossi)", "(Notti in General's Quarters)", "(270.00 EUR)",
+		"(Sconto \\(SPRING\\))", "(-27.00 EUR)", "(243.00 EUR)", "(72.90 EUR)", "(170.10 EUR)"} {
+		if !strings.Contains(pdf, s) {
+			t.Errorf("expected the invoice to contain %s", s)
+		}
+	}
+	if strings.Contains(pdf, "(10.00 EUR)") {
+		t.Error("expected an authorized payment not to be on the invoice")
+	}
+
+	rr = serve(repo.AdminEmailInvoice, "POST")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AdminEmailInvoice returned code %d", rr.Code)
+	}
+	msg := <-mailApp.MailChan
+	if msg.To != "mario@rossi.it" || msg.Subject != "Fattura 1" {
+		t.Errorf("expected invoice 1 to be sent to the guest in Italian, got %q to %s", msg.Subject, msg.To)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Name != "invoice-1.pdf" || !bytes.HasPrefix(msg.Attachments[0].Data, []byte("%PDF-")) {
+		t.Errorf("expected the invoice to be attached, got %+v", msg.Attachments)
+	}
+
+	logs, err := repo.DB.GetAuditLogsForEntity(ctx, auditEntityReservation, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Action != "invoice emailed" || logs[1].Action != "invoice issued" {
+		t.Errorf("expected the invoice to be audited, got %+v", logs)
+	}
+}
+
+// TestRepository_Taxes checks that the taxes of a stay are quoted, kept with the reservation and
+// reported on its invoice, in the CSV export and in the monthly report
+func TestRepository_Taxes(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	serve := func(h http.HandlerFunc, method, target string, form url.Values, params map[string]string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+
+		req, _ := http.NewRequest(method, target, body)
+		req = req.WithContext(ctx)
+		if params != nil {
+			req = withURLParams(req, params)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for _, form := range []url.Values{
+		{"name": {"VAT"}, "kind": {"percent"}, "percent": {"10"}},
+		{"name": {"City tax"}, "kind": {"per-guest"}, "amount": {"200"}, "exempt_children": {"on"}, "max_nights": {"3"}},
+	} {
+		rr := serve(repo.AdminPostTaxRule, "POST", "/admin/tax-rules/new", form, nil)
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("AdminPostTaxRule returned code %d", rr.Code)
+		}
+	}
+
+	// a percentage needs a percentage, the other kinds an amount
+	rr := serve(repo.AdminPostTaxRule, "POST", "/admin/tax-rules/new", url.Values{"name": {"Resort fee"}, "kind": {"per-night"}}, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Set the amount charged") {
+		t.Errorf("expected a tax rule without amount to be rejected, got code %d", rr.Code)
+	}
+	rr = serve(repo.AdminPostTaxRule, "POST", "/admin/tax-rules/new", url.Values{"name": {"Resort fee"}, "kind": {"weekly"}}, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Invalid kind") {
+		t.Errorf("expected a tax rule of an unknown kind to be rejected, got code %d", rr.Code)
+	}
+
+	dates := url.Values{"start": {"2040-01-01"}, "end": {"2040-01-05"}}
+	serve(repo.PostAvailability, "POST", "/search-availability", dates, nil)
+	serve(repo.ChooseRoom, "GET", "/choose-room/2", nil, map[string]string{"id": "2"})
+
+	// 4 nights at 150.00: 60.00 of VAT and 3 nights of city tax for 2 adults, the child is exempt
+	postedData := url.Values{
+		"start_date": {"2040-01-01"},
+		"end_date":   {"2040-01-05"},
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"room_id":    {"2"},
+		"adults":     {"2"},
+		"children":   {"1"},
+	}
+	rr = serve(repo.PostReservation, "POST", "/make-reservation", postedData, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation returned code %d", rr.Code)
+	}
+
+	rr = serve(repo.ReservationSummary, "GET", "/reservation-summary", nil, nil)
+	for _, s := range []string{"VAT", "60.00 EUR", "City tax", "12.00 EUR", "Total with taxes", "672.00 EUR"} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Errorf("expected the summary to contain %s", s)
+		}
+	}
+
+	reservations, err := repo.DB.AllReservations(ctx)
+	if err != nil || len(reservations) != 1 {
+		t.Fatalf("expected 1 reservation, got %d (%v)", len(reservations), err)
+	}
+	id := reservations[0].ID
+
+	res, err := repo.DB.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TaxTotal() != 7200 || len(res.Taxes) != 2 || res.Taxes[0].Quantity != 6 || res.Taxes[0].UnitPrice != 200 {
+		t.Fatalf("expected the taxes to be kept with the reservation, got %+v", res.Taxes)
+	}
+
+	// a rule changed afterwards does not change the taxes of the reservations already booked
+	vat := res.Taxes[1].TaxRuleId
+	rr = serve(repo.AdminPostTaxRule, "POST", "/admin/tax-rules/1", url.Values{"name": {"VAT"}, "kind": {"percent"}, "percent": {"22"}},
+		map[string]string{"id": strconv.Itoa(vat)})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AdminPostTaxRule returned code %d", rr.Code)
+	}
+
+	params := map[string]string{"src": "all", "id": strconv.Itoa(id)}
+	serve(repo.AdminIssueInvoice, "POST", "/", nil, params)
+	rr = serve(repo.AdminReservationInvoice, "GET", "/", nil, params)
+	pdf := rr.Body.String()
+	for _, s := range []string{"(VAT)", "(60.00 EUR)", "(City tax)", "(12.00 EUR)", "(672.00 EUR)"} {
+		if !strings.Contains(pdf, s) {
+			t.Errorf("expected the invoice to contain %s", s)
+		}
+	}
+
+	rr = serve(repo.AdminExportReservations, "GET", "/admin/reservations-all.csv", nil, map[string]string{"src": "all"})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("expected a CSV file, got code %d and %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected a header and 1 reservation, got %d rows", len(records))
+	}
+	header, row := strings.Join(records[0], ","), strings.Join(records[1], ",")
+	if !strings.HasSuffix(header, ",stay,City tax,VAT,taxes,total") || !strings.HasSuffix(row, ",600.00,12.00,60.00,72.00,672.00") {
+		t.Errorf("expected a column for each tax, got %s and %s", header, row)
+	}
+
+	lines, err := repo.DB.TaxReport(ctx, time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2040, time.February, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = serve(repo.AdminTaxReport, "GET", "/admin/tax-report?y=2040&m=1", nil, nil)
+	if len(lines) != 2 || !strings.Contains(rr.Body.String(), "72.00 EUR") {
+		t.Errorf("expected the report to total the taxes of January, got %+v", lines)
+	}
+
+	logs, err := repo.DB.GetAuditLogsForEntity(ctx, auditEntityTaxRule, vat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Details != "VAT, 22% of the stay" {
+		t.Errorf("expected the tax rule changes to be audited, got %+v", logs)
+	}
+}
+
+// TestRepository_FlexibleSearch checks the stays offered in each room by a flexible search and how they are ranked
+func TestRepository_FlexibleSearch(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	// room 1 is taken from the 9th to the 12th of January
+	start, end := time.Date(2040, time.January, 9, 0, 0, 0, 0, time.UTC), time.Date(2040, time.January, 12, 0, 0, 0, 0, time.UTC)
+	id, err := repo.DB.InsertReservation(ctx, models.Reservation{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com",
+		StartDate: start, EndDate: end, RoomId: 1, Adults: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DB.InsertRoomRestriction(ctx, models.RoomRestriction{StartDate: start, EndDate: end, RoomId: 1,
+		ReservationId: id, RestrictionId: models.RestrictionReservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string) (int, flexibleResponse) {
+		req, _ := http.NewRequest("GET", "/api/availability/flexible?"+query, nil)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		repo.FlexibleAvailabilityJSON(rr, req)
+
+		var resp flexibleResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("failed to parse the stays of %s: %v", query, err)
+		}
+
+		return rr.Code, resp
+	}
+
+	// stays maps each room to the arrival days of its stays, in the order they are offered
+	stays := func(resp flexibleResponse) []string {
+		var rooms []string
+		for _, room := range resp.Rooms {
+			days := []string{strconv.Itoa(room.RoomID) + ":"}
+			for _, s := range room.Stays {
+				days = append(days, s.StartDate[8:])
+			}
+			rooms = append(rooms, strings.Join(days, " "))
+		}
+		return rooms
+	}
+
+	var tests = []struct {
+		name   string
+		query  string
+		wanted []string
+	}{
+		{"closest", "mode=around&start=2040-01-10&flex=3&nights=2&sort=date", []string{"2: 10 09 11", "1: 12 07 13"}},
+		{"cheapest", "mode=around&start=2040-01-10&flex=3&nights=2&sort=price", []string{"1: 12 07 13", "2: 10 09 11"}},
+		{"exact", "mode=around&start=2040-01-10&nights=2", []string{"2: 10"}},
+		{"guests", "mode=around&start=2040-01-10&flex=3&nights=2&adults=3", []string{"2: 10 09 11"}},
+		{"weekend", "mode=weekend&month=2040-01&nights=2&sort=price", []string{"1: 06 13 20", "2: 06 13 20"}},
+	}
+
+	for _, e := range tests {
+		code, resp := search(e.query)
+		if code != http.StatusOK || !resp.OK || resp.Nights != 2 || resp.Currency != "EUR" {
+			t.Errorf("%s: expected stays, got code %d and %+v", e.name, code, resp)
+			continue
+		}
+		if got := stays(resp); !slices.Equal(got, e.wanted) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.wanted, got)
+		}
+	}
+
+	_, resp := search("mode=around&start=2040-01-10&nights=2")
+	if s := resp.Rooms[0].Stays[0]; s.EndDate != "2040-01-12" || s.Price != 30000 {
+		t.Errorf("expected two nights at 150.00, got %+v", s)
+	}
+
+	req, _ = http.NewRequest("GET", "/search-availability?mode=around&start=2040-01-10&flex=3&nights=2", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	repo.Availability(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/book-room?id=1&s=2040-01-12&e=2040-01-14") {
+		t.Errorf("expected the search page to link the stays to their booking, got code %d", rr.Code)
+	}
+}
+
+// TestRepository_AvailabilityCalendar checks the days and prices returned for a room
+func TestRepository_AvailabilityCalendar(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	start, end := time.Date(2040, time.January, 5, 0, 0, 0, 0, time.UTC), time.Date(2040, time.January, 8, 0, 0, 0, 0, time.UTC)
+	id, err := repo.DB.InsertReservation(ctx, models.Reservation{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com",
+		StartDate: start, EndDate: end, RoomId: 1, Adults: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DB.InsertRoomRestriction(ctx, models.RoomRestriction{StartDate: start, EndDate: end, RoomId: 1,
+		ReservationId: id, RestrictionId: models.RestrictionReservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calendar := func(target string) (int, calendarResponse) {
+		req, _ := http.NewRequest("GET", target, nil)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		repo.AvailabilityCalendar(rr, req)
+
+		var resp calendarResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("failed to parse the calendar of %s: %v", target, err)
+		}
+
+		return rr.Code, resp
+	}
+
+	code, resp := calendar("/api/availability/calendar?room_id=1&from=2040-01-01&to=2040-02-01")
+	if code != http.StatusOK || !resp.OK || len(resp.Days) != 31 || resp.Currency != "EUR" {
+		t.Fatalf("expected the 31 days of January, got code %d and %+v", code, resp)
+	}
+	for i, d := range resp.Days {
+		wanted := calendarDay{Date: fmt.Sprintf("2040-01-%02d", i+1), Available: i+1 < 5 || i+1 >= 8, Price: 9000}
+		if d != wanted {
+			t.Errorf("expected %+v, got %+v", wanted, d)
+		}
+	}
+
+	today := time.Now().UTC()
+	from := today.AddDate(0, 0, -1).Format("2006-01-02")
+	code, resp = calendar("/api/availability/calendar?room_id=1&from=" + from + "&to=" + today.AddDate(0, 0, 1).Format("2006-01-02"))
+	if code != http.StatusOK || len(resp.Days) != 2 || resp.Days[0].Available || !resp.Days[1].Available {
+		t.Errorf("expected yesterday not to be available and today to be, got code %d and %+v", code, resp)
+	}
+
+	code, resp = calendar("/api/availability/calendar?room_id=1&from=2040-01-01&to=2040-06-01")
+	if code != http.StatusBadRequest || resp.OK || resp.Message != "The calendar can show up to 93 days" {
+		t.Errorf("expected a period too long to be refused, got code %d and %+v", code, resp)
+	}
+}
+
+// TestRepository_Waitlist checks that guests waiting for a fully booked stay are emailed a booking link
+// once a cancellation frees their nights, and that the link holds the room for the first one to follow it
+func TestRepository_Waitlist(t *testing.T) {
+	mailApp := app
+	mailApp.MailChan = make(chan models.MailData, 5)
+	mailApp.BaseURL = "https://booking.example.com"
+	repo := &Repository{App: &mailApp, DB: dbrepo.NewMemoryRepo(&mailApp)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	serve := func(h http.HandlerFunc, method, target string, form url.Values, params map[string]string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+
+		req, _ := http.NewRequest(method, target, body)
+		req = req.WithContext(ctx)
+		if params != nil {
+			req = withURLParams(req, params)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// the links sent by email must not follow the Host of the request
+		req.Host = "attacker.example"
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	// both rooms are booked from the 1st to the 5th of January
+	var resIds []int
+	for _, roomId := range []int{1, 2} {
+		start, end := time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2040, time.January, 5, 0, 0, 0, 0, time.UTC)
+		id, err := repo.DB.InsertReservation(ctx, models.Reservation{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com",
+			StartDate: start, EndDate: end, RoomId: roomId, Adults: 1, Status: models.ReservationStatusConfirmed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.DB.InsertRoomRestriction(ctx, models.RoomRestriction{StartDate: start, EndDate: end, RoomId: roomId,
+			ReservationId: id, RestrictionId: models.RestrictionReservation})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resIds = append(resIds, id)
+	}
+
+	rr := serve(repo.PostAvailability, "POST", "/search-availability", url.Values{"start": {"2040-01-02"}, "end": {"2040-01-04"}, "adults": {"2"}}, nil)
+	loc, _ := rr.Result().Location()
+	if rr.Code != http.StatusSeeOther || loc == nil || loc.Path != "/waitlist" {
+		t.Fatalf("expected a search with no room to lead to the waitlist, got code %d, location %v", rr.Code, loc)
+	}
+
+	rr = serve(repo.Waitlist, "GET", loc.String(), nil, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `value="2040-01-02"`) {
+		t.Errorf("expected the waitlist form to be filled in with the search, got code %d", rr.Code)
+	}
+
+	join := func(firstName, roomId, start, end, adults string) *httptest.ResponseRecorder {
+		return serve(repo.PostWaitlist, "POST", "/waitlist", url.Values{
+			"first_name": {firstName},
+			"last_name":  {"Smith"},
+			"email":      {strings.ToLower(firstName) + "@smith.com"},
+			"room_id":    {roomId},
+			"start":      {start},
+			"end":        {end},
+			"adults":     {adults},
+		}, nil)
+	}
+
+	if rr := join("Ann", "1", "2040-01-02", "2040-01-04", "3"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "The room can host up to 2 guests") {
+		t.Errorf("expected a waitlist for too many guests to be rejected, got code %d", rr.Code)
+	}
+	for _, guest := range [][]string{
+		{"Ann", "1", "2040-01-02", "2040-01-04", "2"},
+		{"Bob", "0", "2040-01-01", "2040-01-03", "1"},
+		{"Cid", "2", "2040-01-02", "2040-01-04", "1"},
+	} {
+		rr := join(guest[0], guest[1], guest[2], guest[3], guest[4])
+		if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/" {
+			t.Fatalf("PostWaitlist returned code %d, location %v", rr.Code, loc)
+		}
+	}
+	if flash := session.PopString(ctx, "flash"); flash != "You are on the waitlist, we will email you if a room becomes available" {
+		t.Errorf("expected the guest to be told they are on the waitlist, got %q", flash)
+	}
+
+	// cancelling the reservation of room 1 frees the nights Ann and Bob wait for, Cid waits for room 2
+	rr = serve(repo.AdminReservationStatus, "POST", "/", nil,
+		map[string]string{"src": "all", "id": strconv.Itoa(resIds[0]), "status": models.ReservationStatusCancelled})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AdminReservationStatus returned code %d", rr.Code)
+	}
+
+	links := make(map[string]string)
+	tokenRegexp := regexp.MustCompile(`"https://booking\.example\.com/waitlist/([A-Z0-9]+)"`)
+	for len(mailApp.MailChan) > 0 {
+		msg := <-mailApp.MailChan
+		m := tokenRegexp.FindStringSubmatch(msg.Content)
+		if msg.Subject != "A room is available" || m == nil {
+			t.Fatalf("expected a booking link, got %q: %s", msg.Subject, msg.Content)
+		}
+		links[msg.To] = m[1]
+	}
+	if len(links) != 2 || links["ann@smith.com"] == "" || links["bob@smith.com"] == "" {
+		t.Fatalf("expected Ann and Bob to be sent a booking link, got %v", links)
+	}
+
+	// a guest is sent a link once
+	rr = serve(repo.AdminDeleteReservation, "POST", "/", nil, map[string]string{"src": "all", "id": strconv.Itoa(resIds[0])})
+	if rr.Code != http.StatusSeeOther || len(mailApp.MailChan) != 0 {
+		t.Errorf("expected no new link for Ann and Bob, got code %d and %d emails", rr.Code, len(mailApp.MailChan))
+	}
+
+	rr = serve(repo.WaitlistOffer, "GET", "/", nil, map[string]string{"token": links["ann@smith.com"]})
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/make-reservation" {
+		t.Fatalf("WaitlistOffer returned code %d, location %v", rr.Code, loc)
+	}
+	res, ok := session.Get(ctx, "reservation").(models.Reservation)
+	if !ok || res.RoomId != 1 || res.FirstName != "Ann" || res.Adults != 2 || res.StartDate.Format("2006-01-02") != "2040-01-02" {
+		t.Errorf("expected the reservation form to be filled in for Ann, got %+v", res)
+	}
+
+	// room 1 is held for Ann, Bob comes too late
+	rr = serve(repo.WaitlistOffer, "GET", "/", nil, map[string]string{"token": links["bob@smith.com"]})
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/search-availability" {
+		t.Errorf("expected Bob to find no room, got code %d, location %v", rr.Code, loc)
+	}
+
+	for token, flash := range map[string]string{"unknown": "This link is not valid"} {
+		serve(repo.WaitlistOffer, "GET", "/", nil, map[string]string{"token": token})
+		if got := session.PopString(ctx, "error"); got != flash {
+			t.Errorf("expected %q for token %s, got %q", flash, token, got)
+		}
+	}
+
+	// deleting the reservation of room 2 frees the nights Cid waits for, the link expires
+	mailApp.WaitlistOfferDuration = time.Nanosecond
+	rr = serve(repo.AdminDeleteReservation, "POST", "/", nil, map[string]string{"src": "all", "id": strconv.Itoa(resIds[1])})
+	if rr.Code != http.StatusSeeOther || len(mailApp.MailChan) != 1 {
+		t.Fatalf("expected Cid to be sent a booking link, got code %d and %d emails", rr.Code, len(mailApp.MailChan))
+	}
+	msg := <-mailApp.MailChan
+	m := tokenRegexp.FindStringSubmatch(msg.Content)
+	if msg.To != "cid@smith.com" || m == nil {
+		t.Fatalf("expected a booking link for Cid, got %s to %s", msg.Content, msg.To)
+	}
+
+	serve(repo.WaitlistOffer, "GET", "/", nil, map[string]string{"token": m[1]})
+	if got := session.PopString(ctx, "error"); got != "This link has expired" {
+		t.Errorf("expected the link to have expired, got %q", got)
+	}
+}
+
+// TestRepository_GroupBooking books both rooms for a party no room can host on its own,
+// with a single confirmation to the guest, and cancels them together
+func TestRepository_GroupBooking(t *testing.T) {
+	mailApp := app
+	mailApp.MailChan = make(chan models.MailData, 2)
+	repo := &Repository{App: &mailApp, DB: dbrepo.NewMemoryRepo(&mailApp)}
+	start := time.Date(2040, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	serve := func(h http.HandlerFunc, method string, form url.Values, params map[string]string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+
+		req, _ := http.NewRequest(method, "/", body)
+		req = req.WithContext(ctx)
+		if params != nil {
+			req = withURLParams(req, params)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	search := url.Values{"start": {"2040-03-01"}, "end": {"2040-03-03"}, "adults": {"4"}, "children": {"1"}}
+	rr := serve(repo.PostAvailability, "POST", search, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `action="/choose-rooms"`) {
+		t.Fatalf("expected the rooms to be offered together, got code %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), `href="/choose-room/1"`) {
+		t.Error("expected a room too small for the party not to be offered on its own")
+	}
+
+	rr = serve(repo.ChooseRooms, "POST", url.Values{"room_id": {"1"}}, nil)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/search-availability" {
+		t.Fatalf("expected a single room to be refused, got %d to %v", rr.Code, loc)
+	}
+	if msg := session.PopString(ctx, "error"); msg != "Please choose at least two rooms" {
+		t.Errorf("unexpected error %q", msg)
+	}
+
+	rr = serve(repo.ChooseRooms, "POST", url.Values{"room_id": {"1", "2"}}, nil)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/make-group-reservation" {
+		t.Fatalf("ChooseRooms returned code %d, location %v", rr.Code, loc)
+	}
+	for _, roomId := range []int{1, 2} {
+		if free, _ := repo.DB.SearchAvailabilityByDatesByRoomId(ctx, start, end, roomId); free {
+			t.Errorf("expected room %d to be held", roomId)
+		}
+	}
+
+	rr = serve(repo.GroupReservation, "GET", nil, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="adults_1" value="2"`) ||
+		!strings.Contains(rr.Body.String(), `name="adults_2" value="2"`) || !strings.Contains(rr.Body.String(), `name="children_2" value="1"`) {
+		t.Fatalf("expected the guests to be spread over the rooms, got code %d", rr.Code)
+	}
+
+	guest := url.Values{
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"adults_1":   {"3"},
+		"adults_2":   {"2"},
+	}
+	rr = serve(repo.PostGroupReservation, "POST", guest, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "The room can host up to 2 guests") {
+		t.Fatalf("expected the form back with the occupancy of room 1, got code %d", rr.Code)
+	}
+
+	guest.Set("adults_1", "2")
+	guest.Set("children_2", "1")
+	rr = serve(repo.PostGroupReservation, "POST", guest, nil)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/reservation-summary" {
+		t.Fatalf("PostGroupReservation returned code %d, location %v", rr.Code, loc)
+	}
+
+	if len(mailApp.MailChan) != 2 {
+		t.Fatalf("expected a mail to the guest and one to the owner, got %d", len(mailApp.MailChan))
+	}
+	msg := <-mailApp.MailChan
+	if msg.To != "john@smith.com" || !strings.Contains(msg.Content, "2 rooms") ||
+		!strings.Contains(msg.Content, "General") || !strings.Contains(msg.Content, "Major") {
+		t.Errorf("expected one confirmation listing both rooms, got %+v", msg)
+	}
+	<-mailApp.MailChan
+
+	reservations, err := repo.DB.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 2 || reservations[0].GroupId == 0 || reservations[0].GroupId != reservations[1].GroupId {
+		t.Fatalf("expected 2 reservations in a group, got %+v", reservations)
+	}
+	for _, res := range reservations {
+		if res.Status != models.ReservationStatusConfirmed {
+			t.Errorf("expected room %d to be confirmed, got %s", res.RoomId, res.Status)
+		}
+	}
+
+	rr = serve(repo.ReservationSummary, "GET", nil, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), html.EscapeString("General's Quarters")) ||
+		!strings.Contains(rr.Body.String(), html.EscapeString("Major's Suite")) {
+		t.Errorf("expected the summary to list both rooms, got code %d", rr.Code)
+	}
+
+	id := strconv.Itoa(reservations[0].ID)
+	rr = serve(repo.AdminShowReservation, "GET", nil, map[string]string{"src": "all", "id": id})
+	if other := fmt.Sprintf(`href="/admin/reservations/all/%d"`, reservations[1].ID); !strings.Contains(rr.Body.String(), other) {
+		t.Errorf("expected the reservation page to link the other room of the group")
+	}
+
+	rr = serve(repo.AdminCalendarReservations, "GET", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminCalendarReservations returned code %d", rr.Code)
+	}
+
+	rr = serve(repo.PostCancelReservation, "POST", nil, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostCancelReservation returned code %d", rr.Code)
+	}
+	for _, res := range reservations {
+		got, err := repo.DB.GetReservationById(ctx, res.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != models.ReservationStatusCancelled {
+			t.Errorf("expected room %d to be cancelled with its group, got %s", res.RoomId, got.Status)
+		}
+		if free, _ := repo.DB.SearchAvailabilityByDatesByRoomId(ctx, start, end, res.RoomId); !free {
+			t.Errorf("expected room %d to be freed", res.RoomId)
+		}
+	}
+}
+
+// TestRepository_GroupBookingDeclined checks that a declined deposit releases every room of the group
+func TestRepository_GroupBookingDeclined(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+	start := time.Date(2040, time.April, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{StartDate: start, EndDate: end, Adults: 2})
+
+	post := func(h http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	post(repo.ChooseRooms, url.Values{"room_id": {"1", "2"}})
+
+	rr := post(repo.PostGroupReservation, url.Values{
+		"first_name":    {"John"},
+		"last_name":     {"Smith"},
+		"email":         {"john@smith.com"},
+		"adults_1":      {"1"},
+		"adults_2":      {"1"},
+		"payment_token": {payments.FakeDeclineToken},
+	})
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/make-group-reservation" {
+		t.Fatalf("expected a redirect to the form, got %d to %v", rr.Code, loc)
+	}
+	if msg := session.PopString(ctx, "error"); msg != "Your payment was not authorized, please try again" {
+		t.Errorf("unexpected error %q", msg)
+	}
+
+	reservations, err := repo.DB.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 2 {
+		t.Fatalf("expected the 2 reservations of the group, got %d", len(reservations))
+	}
+	for _, res := range reservations {
+		if res.Status != models.ReservationStatusCancelled {
+			t.Errorf("expected room %d to be cancelled, got %s", res.RoomId, res.Status)
+		}
+		if free, _ := repo.DB.SearchAvailabilityByDatesByRoomId(ctx, start, end, res.RoomId); !free {
+			t.Errorf("expected room %d to be released", res.RoomId)
+		}
+	}
+}
+
+func getCtx(r *http.Request) context.Context {
+	ctx, err := session.Load(r.Context(), r.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+// getStaffCtx loads the session of a request with a staff member logged in
+func getStaffCtx(r *http.Request) context.Context {
+	ctx := getCtx(r)
+	session.Put(ctx, "user_id", 1)
+	return ctx
+}
+
+// withURLParams adds path parameters to the request, as the chi router would do
+func withURLParams(r *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+var guestsValidationTests = []struct {
+	name          string
+	adults        string
+	children      string
+	expectedError string
+}{
+	{"over-occupancy", "2", "1", "The room can host up to 2 guests"},
+	{"no-adults", "0", "0", "This field must be at least 1"},
+	{"negative-children", "1", "-1", "This field must be at least 0"},
+	{"not-a-number", "two", "0", "Invalid number of adults"},
+}
+
+func TestRepository_Guests(t *testing.T) {
+	for _, e := range guestsValidationTests {
+		postedData := url.Values{}
+		postedData.Add("start_date", "2040-01-01")
+		postedData.Add("end_date", "2040-01-02")
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("phone", "123456789")
+		postedData.Add("room_id", "1")
+		postedData.Add("adults", e.adults)
+		postedData.Add("children", e.children)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", models.Reservation{RoomId: 1})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("failed %s: expected to find %q but did not", e.name, e.expectedError)
+		}
+
+		// the availability check of the room pages answers with the same error
+		postedData = url.Values{}
+		postedData.Add("start", "2040-01-01")
+		postedData.Add("end", "2040-01-02")
+		postedData.Add("room_id", "1")
+		postedData.Add("adults", e.adults)
+		postedData.Add("children", e.children)
+
+		req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+
+		handler = http.HandlerFunc(Repo.AvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var jr jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &jr); err != nil {
+			t.Errorf("failed json %s: can't parse json", e.name)
+			continue
+		}
+
+		if jr.OK || jr.Message != e.expectedError {
+			t.Errorf("failed json %s: expected not ok with message %q, got ok %t with %q", e.name, e.expectedError, jr.OK, jr.Message)
+		}
+	}
+
+	// a valid reservation keeps its guests and special requests
+	postedData := url.Values{}
+	postedData.Add("start_date", "2040-01-01")
+	postedData.Add("end_date", "2040-01-02")
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "123456789")
+	postedData.Add("room_id", "1")
+	postedData.Add("adults", "1")
+	postedData.Add("children", "1")
+	postedData.Add("special_requests", "Late arrival")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", models.Reservation{RoomId: 1})
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	res, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if res.Adults != 1 || res.Children != 1 || res.SpecialRequests != "Late arrival" {
+		t.Errorf("guests not kept in the reservation: %d, %d, %q", res.Adults, res.Children, res.SpecialRequests)
+	}
+}
+
+// TestRepository_PromotionCodes checks the promotion codes entered on the reservation form
+func TestRepository_PromotionCodes(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	january := func(day int) time.Time { return time.Date(2040, time.January, day, 0, 0, 0, 0, time.UTC) }
+	for _, p := range []models.Promotion{
+		{Code: "SPRING", DiscountPercent: 10, ValidFrom: january(1), ValidTo: january(31), MinNights: 2, MaxUses: 1},
+		{Code: "SUITE", DiscountFixed: 5000, ValidFrom: january(1), ValidTo: january(31), RoomId: 2},
+	} {
+		if _, err := repo.DB.InsertPromotion(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := func(code, start string, nights, roomId int, token string) *httptest.ResponseRecorder {
+		session.Put(ctx, "reservation", models.Reservation{RoomId: roomId})
+
+		startDate, _ := time.Parse("2006-01-02", start)
+		postedData := url.Values{}
+		postedData.Add("start_date", start)
+		postedData.Add("end_date", startDate.AddDate(0, 0, nights).Format("2006-01-02"))
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("room_id", strconv.Itoa(roomId))
+		postedData.Add("promo_code", code)
+		postedData.Add("payment_token", token)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	uses := func(code string) int {
+		p, err := repo.DB.GetPromotionByCode(ctx, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Uses
+	}
+
+	var invalidCodes = []struct {
+		name          string
+		code          string
+		start         string
+		nights        int
+		roomId        int
+		expectedError string
+	}{
+		{"unknown code", "WINTER", "2040-01-05", 3, 1, "Invalid promotion code"},
+		{"arrival after the window", "SPRING", "2040-02-05", 3, 1, "This code is valid for arrivals from"},
+		{"another room", "SUITE", "2040-01-05", 3, 1, "This code is not valid for this room"},
+		{"too short", "SPRING", "2040-01-05", 1, 1, "This code requires a stay of at least 2 nights"},
+	}
+
+	for _, e := range invalidCodes {
+		rr := post(e.code, e.start, e.nights, e.roomId, "")
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected the form again, got code %d", e.name, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedError)
+		}
+	}
+
+	if uses("SPRING") != 0 || uses("SUITE") != 0 {
+		t.Fatal("expected invalid forms not to use a code")
+	}
+
+	// 4 nights at 90.00 cost 360.00, less 10% is 324.00 with a deposit of 97.20
+	rr := post(" spring ", "2040-01-05", 4, 1, "")
+	if loc, _ := rr.Result().Location(); loc.String() != "/reservation-summary" {
+		t.Fatalf("expected the reservation to be made, got %d to %v", rr.Code, loc)
+	}
+
+	res := session.Get(ctx, "reservation").(models.Reservation)
+	stored, err := repo.DB.GetReservationById(ctx, res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.PromoCode != "SPRING" || stored.Discount != 3600 {
+		t.Errorf("expected a discount of 3600 for SPRING, got %d for %q", stored.Discount, stored.PromoCode)
+	}
+	resPayments, err := repo.DB.GetPaymentsForReservation(ctx, res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resPayments) != 1 || resPayments[0].Amount != 9720 {
+		t.Errorf("expected a deposit of 9720 on the discounted stay, got %+v", resPayments)
+	}
+	if uses("SPRING") != 1 {
+		t.Errorf("expected SPRING to be used once, got %d", uses("SPRING"))
+	}
+
+	// SPRING can be used once only
+	rr = post("SPRING", "2040-01-20", 3, 1, "")
+	if !strings.Contains(rr.Body.String(), "This code has been used up") {
+		t.Errorf("expected SPRING to be used up, got code %d", rr.Code)
+	}
+
+	// a declined payment gives the use of the code back
+	rr = post("SUITE", "2040-01-05", 2, 2, payments.FakeDeclineToken)
+	if loc, _ := rr.Result().Location(); loc.String() != "/make-reservation" {
+		t.Fatalf("expected the payment to be declined, got %d to %v", rr.Code, loc)
+	}
+	if uses("SUITE") != 0 {
+		t.Errorf("expected the use of SUITE to be released, got %d", uses("SUITE"))
+	}
+}
+
+// TestRepository_AdminPostPromotion checks the creation and the changes of promotion codes by staff
+func TestRepository_AdminPostPromotion(t *testing.T) {
+	repo := &Repository{App: &app, DB: dbrepo.NewMemoryRepo(&app)}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx := getCtx(req)
+
+	post := func(target string, params map[string]string, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req = req.WithContext(ctx)
+		if params != nil {
+			req = withURLParams(req, params)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.AdminPostPromotion).ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	promotionData := func(code, percent, fixed, from, to, roomId string) url.Values {
+		form := url.Values{}
+		form.Add("code", code)
+		form.Add("description", "Easter 2040")
+		form.Add("discount_percent", percent)
+		form.Add("discount_fixed", fixed)
+		form.Add("valid_from", from)
+		form.Add("valid_to", to)
+		form.Add("room_id", roomId)
+		form.Add("min_nights", "2")
+		form.Add("max_uses", "10")
+		return form
+	}
+
+	rr := post("/admin/promotions/new", nil, promotionData("easter-40", "15", "0", "2040-03-20", "2040-04-10", "0"))
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc.String() != "/admin/promotions" {
+		t.Fatalf("expected the promotion to be created, got %d to %v", rr.Code, loc)
+	}
+
+	promo, err := repo.DB.GetPromotionByCode(ctx, "EASTER-40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promo.DiscountPercent != 15 || promo.MinNights != 2 || promo.MaxUses != 10 || promo.RoomId != 0 {
+		t.Errorf("unexpected promotion %+v", promo)
+	}
+
+	var invalidPromotions = []struct {
+		name          string
+		form          url.Values
+		expectedError string
+	}{
+		{"duplicate code", promotionData("Easter-40", "15", "0", "2040-03-20", "2040-04-10", "0"), "This code already exists"},
+		{"invalid code", promotionData("EASTER 40", "15", "0", "2040-03-20", "2040-04-10", "0"), "Use only letters, digits, dashes and underscores"},
+		{"both discounts", promotionData("EASTER", "15", "500", "2040-03-20", "2040-04-10", "0"), "Set either a percentage or a fixed discount"},
+		{"no discount", promotionData("EASTER", "0", "0", "2040-03-20", "2040-04-10", "0"), "Set either a percentage or a fixed discount"},
+		{"percentage above 100", promotionData("EASTER", "150", "0", "2040-03-20", "2040-04-10", "0"), "This field cannot be more than 100"},
+		{"window backwards", promotionData("EASTER", "15", "0", "2040-04-10", "2040-03-20", "0"), "The last arrival date can't be before the first"},
+		{"missing date", promotionData("EASTER", "15", "0", "", "2040-03-20", "0"), "Invalid date"},
+		{"unknown room", promotionData("EASTER", "15", "0", "2040-03-20", "2040-04-10", "7"), "Invalid room"},
+	}
+
+	for _, e := range invalidPromotions {
+		rr := post("/admin/promotions/new", nil, e.form)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected the form again, got code %d", e.name, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), html.EscapeString(e.expectedError)) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedError)
+		}
+	}
+
+	err = repo.DB.UsePromotion(ctx, promo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the code of a promotion can be kept, and changed, when it is edited
+	id := strconv.Itoa(promo.ID)
+	rr = post("/admin/promotions/"+id, map[string]string{"id": id}, promotionData("EASTER-40", "0", "2000", "2040-03-20", "2040-04-10", "2"))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the promotion to be saved, got code %d", rr.Code)
+	}
+
+	promo, err = repo.DB.GetPromotionById(ctx, promo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promo.DiscountFixed != 2000 || promo.DiscountPercent != 0 || promo.RoomId != 2 || promo.Uses != 1 {
+		t.Errorf("expected a fixed discount for room 2 and the use kept, got %+v", promo)
+	}
+
+	logs, err := repo.DB.GetAuditLogsForEntity(ctx, auditEntityPromotion, promo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Details != "EASTER-40, 20.00 EUR off" || logs[1].Details != "EASTER-40, 15% off" {
+		t.Errorf("expected the creation and the change to be audited, got %+v", logs)
+	}
+
+	req, _ = http.NewRequest("POST", "/admin/promotions/"+id+"/delete", nil)
+	req = withURLParams(req.WithContext(ctx), map[string]string{"id": id})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(repo.AdminDeletePromotion).ServeHTTP(rr, req)
+
+	if _, err := repo.DB.GetPromotionById(ctx, promo.ID); err == nil {
+		t.Error("expected the promotion to be deleted")
 	}
-	return ctx
 }
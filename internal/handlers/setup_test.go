@@ -12,7 +12,10 @@ import (
 	"time"
 
 	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/helpers"
+	"github.com/AlessioPani/go-booking/internal/i18n"
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/payments"
 	"github.com/AlessioPani/go-booking/internal/renders"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
@@ -21,11 +24,18 @@ import (
 )
 
 var functions = template.FuncMap{
-	"humanDate":  renders.HumanDate,
+	"T":          i18n.T,
+	"date":       i18n.FormatDate,
+	"month":      i18n.FormatMonth,
+	"languages":  renders.Languages,
 	"formatDate": renders.FormatDate,
 	"iterate":    renders.Iterate,
+	"money":      payments.FormatAmount,
 }
 
+// gateway takes the payments of the tests, its webhooks are signed with "secret"
+var gateway = payments.NewFake("secret")
+
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
@@ -33,6 +43,7 @@ var pathToTemplates = "./../../templates"
 func TestMain(m *testing.M) {
 	// data models I'm going to put to the session
 	gob.Register(models.Reservation{})
+	gob.Register([]models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
@@ -40,6 +51,8 @@ func TestMain(m *testing.M) {
 
 	// change this to true when in production
 	app.InProduction = false
+	app.Payments = gateway
+	app.Currency = "EUR"
 
 	// Set up the infoLog
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -76,6 +89,7 @@ func TestMain(m *testing.M) {
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 	renders.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
@@ -95,12 +109,23 @@ func getRoutes() http.Handler {
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", Repo.WaitlistOffer)
+	mux.Get("/api/availability/calendar", Repo.AvailabilityCalendar)
+	mux.Get("/api/availability/flexible", Repo.FlexibleAvailabilityJSON)
 
 	mux.Get("/contact", Repo.Contact)
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/choose-room/{id}", Repo.ChooseRoom)
+	mux.Post("/choose-rooms", Repo.ChooseRooms)
+	mux.Get("/make-group-reservation", Repo.GroupReservation)
+	mux.Post("/make-group-reservation", Repo.PostGroupReservation)
+	mux.Get("/book-room", Repo.BookRoom)
+
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
@@ -112,12 +137,32 @@ func getRoutes() http.Handler {
 		mux.Get("/dashboard", Repo.AdminDashboard)
 		mux.Get("/reservations-new", Repo.AdminNewReservations)
 		mux.Get("/reservations-all", Repo.AdminAllReservations)
+		mux.Get("/reservations-{src}.csv", Repo.AdminExportReservations)
+		mux.Get("/reservations/new", Repo.AdminCreateReservation)
+		mux.Post("/reservations/new", Repo.AdminPostCreateReservation)
 		mux.Get("/reservations-cal", Repo.AdminCalendarReservations)
 		mux.Post("/reservations-cal", Repo.AdminPostCalendarReservations)
-		mux.Get("/process-reservation/{src}/{id}", Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}", Repo.AdminDeleteReservation)
+		mux.Post("/process-reservation/{src}/{id}", Repo.AdminProcessReservation)
+		mux.Post("/delete-reservation/{src}/{id}", Repo.AdminDeleteReservation)
+		mux.Post("/reservation-status/{src}/{id}/{status}", Repo.AdminReservationStatus)
 		mux.Get("/reservations/{src}/{id}", Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+		mux.Get("/reservations/{src}/{id}/invoice", Repo.AdminReservationInvoice)
+		mux.Post("/issue-invoice/{src}/{id}", Repo.AdminIssueInvoice)
+		mux.Post("/email-invoice/{src}/{id}", Repo.AdminEmailInvoice)
+		mux.Get("/promotions", Repo.AdminPromotions)
+		mux.Get("/promotions/new", Repo.AdminNewPromotion)
+		mux.Post("/promotions/new", Repo.AdminPostPromotion)
+		mux.Get("/promotions/{id}", Repo.AdminShowPromotion)
+		mux.Post("/promotions/{id}", Repo.AdminPostPromotion)
+		mux.Post("/promotions/{id}/delete", Repo.AdminDeletePromotion)
+		mux.Get("/tax-rules", Repo.AdminTaxRules)
+		mux.Get("/tax-rules/new", Repo.AdminNewTaxRule)
+		mux.Post("/tax-rules/new", Repo.AdminPostTaxRule)
+		mux.Get("/tax-rules/{id}", Repo.AdminShowTaxRule)
+		mux.Post("/tax-rules/{id}", Repo.AdminPostTaxRule)
+		mux.Post("/tax-rules/{id}/delete", Repo.AdminDeleteTaxRule)
+		mux.Get("/tax-report", Repo.AdminTaxReport)
 
 	})
 
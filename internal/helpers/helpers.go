@@ -1,13 +1,28 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/go-chi/chi/v5"
 )
 
+// ErrInvalidParam is returned when a path or query parameter is missing or malformed
+var ErrInvalidParam = errors.New("invalid url parameter")
+
+// reservationSources are the admin pages a reservation can be opened from, used to redirect back
+var reservationSources = map[string]bool{
+	"new": true,
+	"all": true,
+	"cal": true,
+}
+
 var app *config.AppConfig
 
 // NewHelpers sets up app config for helpers
@@ -30,3 +45,67 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// IntURLParam returns the named path parameter as a positive integer
+func IntURLParam(r *http.Request, name string) (int, error) {
+	return positiveInt(chi.URLParam(r, name))
+}
+
+// IntQueryParam returns the named query string parameter as a positive integer
+func IntQueryParam(r *http.Request, name string) (int, error) {
+	return positiveInt(r.URL.Query().Get(name))
+}
+
+// IntFormValue returns the named field of a parsed form as a positive integer
+func IntFormValue(r *http.Request, name string) (int, error) {
+	return positiveInt(r.Form.Get(name))
+}
+
+// blockFieldLayout is the layout of the day in the name of a calendar block field
+const blockFieldLayout = "2006-01-2"
+
+// BlockField parses the name of a block field of the reservations calendar, as prefix_<room id>_<day>,
+// and returns its room id and day
+func BlockField(name, prefix string) (int, time.Time, error) {
+	rest, ok := strings.CutPrefix(name, prefix+"_")
+	if !ok {
+		return 0, time.Time{}, ErrInvalidParam
+	}
+
+	room, day, ok := strings.Cut(rest, "_")
+	if !ok {
+		return 0, time.Time{}, ErrInvalidParam
+	}
+
+	roomId, err := positiveInt(room)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	date, err := time.Parse(blockFieldLayout, day)
+	if err != nil {
+		return 0, time.Time{}, ErrInvalidParam
+	}
+
+	return roomId, date, nil
+}
+
+// SrcURLParam returns the {src} path parameter, only the known admin sources are allowed
+func SrcURLParam(r *http.Request) (string, error) {
+	src := chi.URLParam(r, "src")
+	if !reservationSources[src] {
+		return "", ErrInvalidParam
+	}
+
+	return src, nil
+}
+
+// positiveInt converts s to an integer greater than zero
+func positiveInt(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i <= 0 {
+		return 0, ErrInvalidParam
+	}
+
+	return i, nil
+}
@@ -0,0 +1,187 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var intURLParamTests = []struct {
+	name        string
+	value       string
+	expected    int
+	expectedErr bool
+}{
+	{"valid", "12", 12, false},
+	{"missing", "", 0, true},
+	{"non numeric", "abc", 0, true},
+	{"trailing characters", "1abc", 0, true},
+	{"zero", "0", 0, true},
+	{"negative", "-1", 0, true},
+	{"float", "1.5", 0, true},
+}
+
+func TestIntURLParam(t *testing.T) {
+	for _, e := range intURLParamTests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = withURLParams(req, map[string]string{"id": e.value})
+
+		id, err := IntURLParam(req, "id")
+		if e.expectedErr && err == nil {
+			t.Errorf("failed %s: expected an error but did not get one", e.name)
+		}
+		if !e.expectedErr && err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+		if id != e.expected {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expected, id)
+		}
+	}
+}
+
+var intQueryParamTests = []struct {
+	name        string
+	url         string
+	expected    int
+	expectedErr bool
+}{
+	{"valid", "/?y=2023", 2023, false},
+	{"missing", "/", 0, true},
+	{"empty", "/?y=", 0, true},
+	{"non numeric", "/?y=abc", 0, true},
+	{"negative", "/?y=-2023", 0, true},
+	{"first value wins", "/?y=2023&y=abc", 2023, false},
+}
+
+func TestIntQueryParam(t *testing.T) {
+	for _, e := range intQueryParamTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+
+		y, err := IntQueryParam(req, "y")
+		if e.expectedErr && err == nil {
+			t.Errorf("failed %s: expected an error but did not get one", e.name)
+		}
+		if !e.expectedErr && err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+		if y != e.expected {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expected, y)
+		}
+	}
+}
+
+var intFormValueTests = []struct {
+	name        string
+	form        url.Values
+	expected    int
+	expectedErr bool
+}{
+	{"valid", url.Values{"m": {"4"}}, 4, false},
+	{"missing", url.Values{}, 0, true},
+	{"non numeric", url.Values{"m": {"abc"}}, 0, true},
+	{"zero", url.Values{"m": {"0"}}, 0, true},
+}
+
+func TestIntFormValue(t *testing.T) {
+	for _, e := range intFormValueTests {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.Form = e.form
+
+		m, err := IntFormValue(req, "m")
+		if e.expectedErr && err == nil {
+			t.Errorf("failed %s: expected an error but did not get one", e.name)
+		}
+		if !e.expectedErr && err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+		if m != e.expected {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expected, m)
+		}
+	}
+}
+
+var blockFieldTests = []struct {
+	name        string
+	field       string
+	roomId      int
+	day         time.Time
+	expectedErr bool
+}{
+	{"valid", "add_block_2_2040-01-5", 2, time.Date(2040, time.January, 5, 0, 0, 0, 0, time.UTC), false},
+	{"two digits day", "add_block_1_2040-01-15", 1, time.Date(2040, time.January, 15, 0, 0, 0, 0, time.UTC), false},
+	{"other prefix", "remove_block_1_2040-01-5", 0, time.Time{}, true},
+	{"missing day", "add_block_1", 0, time.Time{}, true},
+	{"missing room", "add_block__2040-01-5", 0, time.Time{}, true},
+	{"non numeric room", "add_block_x_2040-01-5", 0, time.Time{}, true},
+	{"invalid day", "add_block_1_2040-13-5", 0, time.Time{}, true},
+	{"extra part", "add_block_1_2040-01-5_x", 0, time.Time{}, true},
+}
+
+func TestBlockField(t *testing.T) {
+	for _, e := range blockFieldTests {
+		roomId, day, err := BlockField(e.field, "add_block")
+		if e.expectedErr && err == nil {
+			t.Errorf("failed %s: expected an error but did not get one", e.name)
+		}
+		if !e.expectedErr && err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+		if roomId != e.roomId || !day.Equal(e.day) {
+			t.Errorf("failed %s: expected room %d on %s, but got room %d on %s", e.name, e.roomId, e.day, roomId, day)
+		}
+	}
+}
+
+var srcURLParamTests = []struct {
+	name        string
+	value       string
+	expectedErr bool
+}{
+	{"new", "new", false},
+	{"all", "all", false},
+	{"cal", "cal", false},
+	{"missing", "", true},
+	{"unknown", "bogus", true},
+	{"case sensitive", "NEW", true},
+	{"external host", "//evil.com", true},
+	{"path traversal", "../user/logout", true},
+}
+
+func TestSrcURLParam(t *testing.T) {
+	for _, e := range srcURLParamTests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = withURLParams(req, map[string]string{"src": e.value})
+
+		src, err := SrcURLParam(req)
+		if e.expectedErr {
+			if err == nil {
+				t.Errorf("failed %s: expected an error but did not get one", e.name)
+			}
+			if src != "" {
+				t.Errorf("failed %s: expected empty src, but got %s", e.name, src)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+		if src != e.value {
+			t.Errorf("failed %s: expected %s, but got %s", e.name, e.value, src)
+		}
+	}
+}
+
+// withURLParams adds path parameters to the request, as the chi router would do
+func withURLParams(r *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
@@ -0,0 +1,143 @@
+// Package i18n holds the message catalogs of the site and the language of a request.
+// Messages are looked up by their English text, so a message missing from a catalog
+// is shown in English.
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default is the language used when a request asks for none of the supported ones
+const Default = "en"
+
+// CookieName is the name of the cookie that remembers the language chosen by a visitor
+const CookieName = "lang"
+
+// Languages are the supported languages, with the name they are listed with
+var Languages = []Language{
+	{Code: "en", Name: "English"},
+	{Code: "it", Name: "Italiano"},
+}
+
+// Language is a supported language
+type Language struct {
+	Code string
+	Name string
+}
+
+// catalog maps English messages to their translation
+type catalog map[string]string
+
+var catalogs = map[string]catalog{
+	"it": italian,
+}
+
+// Supported returns true if lang is one of the supported languages
+func Supported(lang string) bool {
+	for _, l := range Languages {
+		if l.Code == lang {
+			return true
+		}
+	}
+
+	return false
+}
+
+// T translates a message into lang, formatting it with args if any
+func T(lang, message string, args ...any) string {
+	if translated, ok := catalogs[lang][message]; ok {
+		message = translated
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+
+	return message
+}
+
+// Match returns the supported language that fits an Accept-Language header best, or Default
+func Match(acceptLanguage string) string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		// only the primary subtag matters, it-CH is served in Italian
+		primary, _, _ := strings.Cut(tag, "-")
+		langs = append(langs, weighted{lang: strings.ToLower(primary), q: q})
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	for _, l := range langs {
+		if l.q > 0 && Supported(l.lang) {
+			return l.lang
+		}
+	}
+
+	return Default
+}
+
+type contextKey struct{}
+
+// WithLang returns a copy of ctx holding the language of a request
+func WithLang(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, contextKey{}, lang)
+}
+
+// FromContext returns the language of a request, or Default
+func FromContext(ctx context.Context) string {
+	lang, ok := ctx.Value(contextKey{}).(string)
+	if !ok || lang == "" {
+		return Default
+	}
+
+	return lang
+}
+
+// monthNames holds the month names of the languages that are not English
+var monthNames = map[string][12]string{
+	"it": {"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno",
+		"luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+}
+
+// FormatDate formats a date the way it is written in lang, e.g. January 2, 2006 or 2 gennaio 2006
+func FormatDate(lang string, t time.Time) string {
+	months, ok := monthNames[lang]
+	if !ok {
+		return t.Format("January 2, 2006")
+	}
+
+	return fmt.Sprintf("%d %s %d", t.Day(), months[t.Month()-1], t.Year())
+}
+
+// FormatMonth formats the month of a date the way it is written in lang, e.g. January 2006 or gennaio 2006
+func FormatMonth(lang string, t time.Time) string {
+	months, ok := monthNames[lang]
+	if !ok {
+		return t.Format("January 2006")
+	}
+
+	return fmt.Sprintf("%s %d", months[t.Month()-1], t.Year())
+}
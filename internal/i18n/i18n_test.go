@@ -0,0 +1,93 @@
+package i18n
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+var translateTests = []struct {
+	name     string
+	lang     string
+	message  string
+	args     []any
+	expected string
+}{
+	{"english", "en", "Invalid room", nil, "Invalid room"},
+	{"italian", "it", "Invalid room", nil, "Camera non valida"},
+	{"italian with args", "it", "Stays cannot be longer than %d nights", []any{30}, "Il soggiorno non può superare le 30 notti"},
+	{"english with args", "en", "Stays cannot be longer than %d nights", []any{30}, "Stays cannot be longer than 30 nights"},
+	{"missing translation", "it", "Reservation deleted", nil, "Reservation deleted"},
+	{"unsupported language", "fr", "Invalid room", nil, "Invalid room"},
+	{"empty message", "it", "", nil, ""},
+}
+
+func TestT(t *testing.T) {
+	for _, e := range translateTests {
+		if got := T(e.lang, e.message, e.args...); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestCatalogsKeepVerbs(t *testing.T) {
+	for lang, c := range catalogs {
+		for message, translated := range c {
+			if strings.Count(message, "%") != strings.Count(translated, "%") {
+				t.Errorf("%s: %q and %q have different format verbs", lang, message, translated)
+			}
+		}
+	}
+}
+
+var matchTests = []struct {
+	header   string
+	expected string
+}{
+	{"", "en"},
+	{"it", "it"},
+	{"it-IT,it;q=0.9,en;q=0.8", "it"},
+	{"en-US,en;q=0.9,it;q=0.8", "en"},
+	{"fr-FR,fr;q=0.9,it;q=0.5", "it"},
+	{"fr, en;q=0.2, it;q=0.7", "it"},
+	{"IT-ch", "it"},
+	{"it;q=0", "en"},
+	{"de, fr", "en"},
+	{"it;q=abc, en", "en"},
+}
+
+func TestMatch(t *testing.T) {
+	for _, e := range matchTests {
+		if got := Match(e.header); got != e.expected {
+			t.Errorf("%q: expected %s, got %s", e.header, e.expected, got)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != Default {
+		t.Errorf("expected the default language without one in the context, got %s", got)
+	}
+
+	if got := FromContext(WithLang(context.Background(), "it")); got != "it" {
+		t.Errorf("expected it, got %s", got)
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	d := time.Date(2040, time.March, 5, 0, 0, 0, 0, time.UTC)
+
+	if got := FormatDate("en", d); got != "March 5, 2040" {
+		t.Errorf("expected March 5, 2040, got %s", got)
+	}
+	if got := FormatDate("it", d); got != "5 marzo 2040" {
+		t.Errorf("expected 5 marzo 2040, got %s", got)
+	}
+	if got := FormatMonth("en", d); got != "March 2040" {
+		t.Errorf("expected March 2040, got %s", got)
+	}
+	if got := FormatMonth("it", d); got != "marzo 2040" {
+		t.Errorf("expected marzo 2040, got %s", got)
+	}
+}
@@ -0,0 +1,180 @@
+package i18n
+
+// italian is the Italian catalog
+var italian = catalog{
+	// pages
+	"Home":                     "Home",
+	"About":                    "Chi siamo",
+	"About Fort Smythe B&B":    "Chi siamo",
+	"Rooms":                    "Camere",
+	"Search Availability":      "Cerca disponibilità",
+	"Search for Availability":  "Cerca disponibilità",
+	"Contact":                  "Contatti",
+	"Contact us":               "Contattaci",
+	"Dashboard":                "Pannello",
+	"Login":                    "Accedi",
+	"Logout":                   "Esci",
+	"Your home away from home": "La tua casa lontano da casa",
+	"Welcome to Fort Smythe Bed and Breakfast": "Benvenuti al Fort Smythe Bed and Breakfast",
+	"Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.": "La tua casa lontano da casa, affacciata sulle maestose acque dell'Oceano Atlantico: sarà una vacanza da ricordare.",
+	"Make Reservation Now":  "Prenota ora",
+	"Check Availability":    "Verifica disponibilità",
+	"Choose your dates":     "Scegli le date",
+	"Room is available":     "La camera è disponibile",
+	"Room is not available": "La camera non è disponibile",
+	"Availability":          "Disponibilità",
+	"Choose your arrival and then your departure on the calendar.": "Scegli sul calendario l'arrivo e poi la partenza.",
+	"Book now":      "Prenota ora",
+	"Arrival":       "Arrivo",
+	"Departure":     "Partenza",
+	"Choose a room": "Scegli una camera",
+	"No room can host your party on its own, but these rooms can together.": "Nessuna camera può ospitare da sola il vostro gruppo, ma queste camere insieme sì.",
+	"Book several rooms together":                                           "Prenota più camere insieme",
+	"up to %d guests":                                                       "fino a %d ospiti",
+	"Book the selected rooms":                                               "Prenota le camere selezionate",
+	"Make a Reservation":                                                    "Prenota",
+	"Reservation details":                                                   "Dettagli della prenotazione",
+	"Room":                                                                  "Camera",
+	"Name":                                                                  "Nome",
+	"First Name":                                                            "Nome",
+	"Last Name":                                                             "Cognome",
+	"Email":                                                                 "Email",
+	"Phone":                                                                 "Telefono",
+	"Password":                                                              "Password",
+	"Make Reservation":                                                      "Prenota",
+	"please search again":                                                   "cerca di nuovo",
+	"Reservation Summary":                                                   "Riepilogo della prenotazione",
+	"Join the Waitlist":                                                     "Iscriviti alla lista d'attesa",
+	"Any room":                                                              "Qualsiasi camera",
+	"No room is available for these dates. Leave your details and we will email you a link to book as soon as a room becomes available.": "Nessuna camera è disponibile per queste date. Lascia i tuoi dati e ti invieremo via email un link per prenotare appena una camera si libera.",
+	"Adults":                 "Adulti",
+	"Children":               "Bambini",
+	"Guests":                 "Ospiti",
+	"Maximum guests":         "Numero massimo di ospiti",
+	"Special requests":       "Richieste particolari",
+	"%d adults, %d children": "%d adulti, %d bambini",
+	"Total":                  "Totale",
+	"Deposit due now":        "Caparra da versare ora",
+	"Total with taxes":       "Totale con tasse",
+	"Deposit authorized":     "Caparra autorizzata",
+	"Card":                   "Carta",
+	"Test card, approved":    "Carta di prova, approvata",
+	"Test card, declined":    "Carta di prova, rifiutata",
+	"The deposit is authorized now and taken when you check in.": "La caparra viene autorizzata ora e addebitata al check-in.",
+	"Cancellation policy": "Condizioni di cancellazione",
+	"Free cancellation":   "Cancellazione gratuita",
+	"Free cancellation until %s, then %d%% of the stay is charged": "Cancellazione gratuita fino al %s, poi viene addebitato il %d%% del soggiorno",
+	"Cancel reservation":       "Annulla la prenotazione",
+	"Cancel this reservation?": "Annullare questa prenotazione?",
+	"Discount":                 "Sconto",
+	"Promotion code":           "Codice promozionale",
+
+	"Flexible dates": "Date flessibili",
+	"Tell us how many nights you want to stay and when, we will find the best arrival dates for each room.": "Dicci quante notti vuoi restare e quando, troveremo le migliori date di arrivo per ogni camera.",
+	"Around a date":                         "Intorno a una data",
+	"Any weekend in a month":                "Un fine settimana del mese",
+	"Date":                                  "Data",
+	"Flexibility":                           "Flessibilità",
+	"± %d days":                             "± %d giorni",
+	"Month":                                 "Mese",
+	"Arrivals on friday.":                   "Arrivo di venerdì.",
+	"Nights":                                "Notti",
+	"Sort by":                               "Ordina per",
+	"Closest to the date":                   "Più vicine alla data",
+	"Lowest price":                          "Prezzo più basso",
+	"Find dates":                            "Trova le date",
+	"Available stays":                       "Soggiorni disponibili",
+	"No room is available for these dates.": "Nessuna camera è disponibile per queste date.",
+
+	// form errors
+	"This field cannot be blank":                      "Questo campo è obbligatorio",
+	"This field must be at least %d characters long":  "Questo campo deve essere lungo almeno %d caratteri",
+	"This field cannot be longer than %d characters":  "Questo campo non può superare i %d caratteri",
+	"Invalid email address":                           "Indirizzo email non valido",
+	"Invalid phone number":                            "Numero di telefono non valido",
+	"The calendar can show up to %d days":             "Il calendario può mostrare fino a %d giorni",
+	"Invalid date":                                    "Data non valida",
+	"Invalid value":                                   "Valore non valido",
+	"Invalid number":                                  "Numero non valido",
+	"Invalid arrival date":                            "Data di arrivo non valida",
+	"Invalid departure date":                          "Data di partenza non valida",
+	"Departure must be after arrival":                 "La partenza deve essere successiva all'arrivo",
+	"Date cannot be in the past":                      "La data non può essere nel passato",
+	"Stays cannot be longer than %d nights":           "Il soggiorno non può superare le %d notti",
+	"Invalid room":                                    "Camera non valida",
+	"This field must be at least %d":                  "Questo campo deve essere almeno %d",
+	"This field cannot be more than %d":               "Questo campo non può superare %d",
+	"Invalid number of adults":                        "Numero di adulti non valido",
+	"Invalid number of children":                      "Numero di bambini non valido",
+	"The room can host up to %d guests":               "La camera può ospitare al massimo %d persone",
+	"Invalid promotion code":                          "Codice promozionale non valido",
+	"This code is valid for arrivals from %s to %s":   "Questo codice è valido per arrivi dal %s al %s",
+	"This code is not valid for this room":            "Questo codice non è valido per questa camera",
+	"This code requires a stay of at least %d nights": "Questo codice richiede un soggiorno di almeno %d notti",
+	"This code has been used up":                      "Questo codice è esaurito",
+
+	"Invalid month":            "Mese non valido",
+	"Invalid search":           "Ricerca non valida",
+	"Invalid number of days":   "Numero di giorni non valido",
+	"Invalid number of nights": "Numero di notti non valido",
+
+	// flash messages
+	"No availability": "Nessuna disponibilità",
+	"Sorry, the room is no longer available for the selected dates":          "Spiacenti, la camera non è più disponibile per le date selezionate",
+	"Sorry, the rooms are no longer available for the selected dates":        "Spiacenti, le camere non sono più disponibili per le date selezionate",
+	"Please choose at least two rooms":                                       "Scegli almeno due camere",
+	"Can't get reservation from session":                                     "Impossibile recuperare la prenotazione",
+	"Invalid login credentials":                                              "Credenziali non valide",
+	"Logged in successfully":                                                 "Accesso effettuato",
+	"Your payment was not authorized, please try again":                      "Il pagamento non è stato autorizzato, riprova",
+	"Can't hold the room":                                                    "Impossibile riservare la camera",
+	"Your reservation has been cancelled":                                    "La prenotazione è stata annullata",
+	"Your reservation has been cancelled, %s will be refunded":               "La prenotazione è stata annullata, verranno rimborsati %s",
+	"This reservation can't be cancelled":                                    "Questa prenotazione non può essere annullata",
+	"Can't cancel the reservation, please contact us":                        "Impossibile annullare la prenotazione, contattaci",
+	"Can't check the promotion code":                                         "Impossibile verificare il codice promozionale",
+	"Can't compute the taxes":                                                "Impossibile calcolare le tasse",
+	"Can't get the rooms":                                                    "Impossibile recuperare le camere",
+	"Can't add you to the waitlist":                                          "Impossibile iscriverti alla lista d'attesa",
+	"You are on the waitlist, we will email you if a room becomes available": "Sei nella lista d'attesa, ti invieremo un'email se una camera si libera",
+	"This link is not valid":                                                 "Questo link non è valido",
+	"This link has expired":                                                  "Questo link è scaduto",
+	"Can't get the waitlist offer":                                           "Impossibile recuperare l'offerta della lista d'attesa",
+	"Log in first":                                                           "Accedi prima di continuare",
+
+	// invoices
+	"Invoice":                       "Fattura",
+	"Invoice number: %d":            "Fattura numero: %d",
+	"Date: %s":                      "Data: %s",
+	"Bill to":                       "Intestata a",
+	"Description":                   "Descrizione",
+	"Quantity":                      "Quantità",
+	"Unit price":                    "Prezzo unitario",
+	"Amount":                        "Importo",
+	"Subtotal":                      "Subtotale",
+	"Paid":                          "Pagato",
+	"Payments":                      "Pagamenti",
+	"Balance due":                   "Saldo dovuto",
+	"Reservation %d, from %s to %s": "Prenotazione %d, dal %s al %s",
+	"Nights in %s":                  "Notti in %s",
+	"Discount (%s)":                 "Sconto (%s)",
+	"Card payment":                  "Pagamento con carta",
+	"Invoice %d":                    "Fattura %d",
+	"please find attached the invoice of your stay from %s to %s.": "in allegato trova la fattura del suo soggiorno dal %s al %s.",
+
+	// emails
+	"Reservation Confirmation": "Conferma della prenotazione",
+	"Dear %s,":                 "Gentile %s,",
+	"this is a confirmation of your reservation from %s to %s.":             "le confermiamo la sua prenotazione dal %s al %s.",
+	"this is a confirmation of your reservation of %d rooms from %s to %s.": "le confermiamo la sua prenotazione di %d camere dal %s al %s.",
+	"Rooms booked:":                                        "Camere prenotate:",
+	"Guests: %d adults, %d children":                       "Ospiti: %d adulti, %d bambini",
+	"A deposit of %s has been authorized on your card.":    "Una caparra di %s è stata autorizzata sulla sua carta.",
+	"Promotion code %s: %s off the stay.":                  "Codice promozionale %s: %s di sconto sul soggiorno.",
+	"Cancellation policy: %s.":                             "Condizioni di cancellazione: %s.",
+	"Looking forward to see you soon":                      "La aspettiamo presto",
+	"Best regards,":                                        "Cordiali saluti,",
+	"A room is available":                                  "Una camera è disponibile",
+	"a room is now available for your stay from %s to %s.": "una camera è ora disponibile per il suo soggiorno dal %s al %s.",
+	"The link is valid until %s, the room goes to the first guest who books it.": "Il link è valido fino al %s, la camera va al primo ospite che la prenota.",
+}
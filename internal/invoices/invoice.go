@@ -0,0 +1,183 @@
+// Package invoices writes the invoices of reservations as PDF files. The PDF is written
+// by the package itself with the standard fonts of PDF readers, so nothing is embedded.
+package invoices
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/AlessioPani/go-booking/internal/i18n"
+	"github.com/AlessioPani/go-booking/internal/payments"
+)
+
+// Invoice is what is printed on an invoice, amounts are in the minor unit of Currency
+type Invoice struct {
+	Number   int
+	IssuedAt time.Time
+	// Lang is the language the invoice is written in
+	Lang     string
+	Currency string
+	// Seller and Customer are the lines of the addresses, the first one is the name
+	Seller   []string
+	Customer []string
+	// Reference tells what the invoice is for, e.g. the stay and the reservation number
+	Reference string
+	Lines     []Line
+	Taxes     []Line
+	Payments  []Payment
+}
+
+// Line is a line of an invoice, a negative amount is a discount
+type Line struct {
+	Description string
+	Quantity    int
+	UnitPrice   int
+	Amount      int
+}
+
+// Payment is an amount the customer paid
+type Payment struct {
+	Date        time.Time
+	Description string
+	Amount      int
+}
+
+// Total returns what the invoice costs, taxes included
+func (inv Invoice) Total() int {
+	total := 0
+	for _, l := range inv.Lines {
+		total += l.Amount
+	}
+	for _, l := range inv.Taxes {
+		total += l.Amount
+	}
+
+	return total
+}
+
+// Paid returns the sum of the payments
+func (inv Invoice) Paid() int {
+	paid := 0
+	for _, p := range inv.Payments {
+		paid += p.Amount
+	}
+
+	return paid
+}
+
+// layout of the page, in points
+const (
+	margin      = 50.0
+	right       = pageWidth - margin
+	bottom      = 70.0
+	lineHeight  = 16.0
+	columnQty   = 360.0
+	columnPrice = 450.0
+)
+
+// PDF returns the invoice as a PDF file
+func (inv Invoice) PDF() []byte {
+	d := &document{}
+	t := func(message string, args ...any) string {
+		return i18n.T(inv.Lang, message, args...)
+	}
+	money := func(amount int) string {
+		return payments.FormatAmount(amount, inv.Currency)
+	}
+
+	y := pageHeight - margin - 10
+	// next moves to the following line, on a new page when the current one is full
+	next := func() {
+		y -= lineHeight
+		if y < bottom {
+			d.addPage()
+			y = pageHeight - margin - 10
+		}
+	}
+
+	for i, s := range inv.Seller {
+		if i == 0 {
+			d.text(margin, y, 14, bold, s)
+		} else {
+			d.text(margin, y-4, 10, regular, s)
+		}
+		next()
+	}
+
+	top := pageHeight - margin - 10
+	d.textRight(right, top, 20, bold, t("Invoice"))
+	d.textRight(right, top-24, 10, regular, t("Invoice number: %d", inv.Number))
+	d.textRight(right, top-24-lineHeight, 10, regular, t("Date: %s", i18n.FormatDate(inv.Lang, inv.IssuedAt)))
+	y = min(y, top-24-2*lineHeight)
+
+	next()
+	d.text(margin, y, 10, bold, t("Bill to"))
+	next()
+	for _, s := range inv.Customer {
+		d.text(margin, y, 10, regular, s)
+		next()
+	}
+
+	if inv.Reference != "" {
+		next()
+		d.text(margin, y, 10, regular, inv.Reference)
+		next()
+	}
+
+	next()
+	d.text(margin, y, 10, bold, t("Description"))
+	d.textRight(columnQty, y, 10, bold, t("Quantity"))
+	d.textRight(columnPrice, y, 10, bold, t("Unit price"))
+	d.textRight(right, y, 10, bold, t("Amount"))
+	d.line(margin, y-5, right, y-5)
+	next()
+
+	row := func(l Line) {
+		d.text(margin, y, 10, regular, l.Description)
+		if l.Quantity > 0 {
+			d.textRight(columnQty, y, 10, regular, strconv.Itoa(l.Quantity))
+			d.textRight(columnPrice, y, 10, regular, money(l.UnitPrice))
+		}
+		d.textRight(right, y, 10, regular, money(l.Amount))
+		next()
+	}
+	total := func(label string, amount int, font string) {
+		d.textRight(columnPrice, y, 10, font, label)
+		d.textRight(right, y, 10, font, money(amount))
+		next()
+	}
+
+	for _, l := range inv.Lines {
+		row(l)
+	}
+	if len(inv.Taxes) > 0 {
+		d.line(margin, y+lineHeight-5, right, y+lineHeight-5)
+		subtotal := 0
+		for _, l := range inv.Lines {
+			subtotal += l.Amount
+		}
+		total(t("Subtotal"), subtotal, regular)
+		for _, l := range inv.Taxes {
+			row(l)
+		}
+	}
+	d.line(columnQty, y+lineHeight-5, right, y+lineHeight-5)
+	total(t("Total"), inv.Total(), bold)
+
+	if len(inv.Payments) > 0 {
+		next()
+		d.text(margin, y, 10, bold, t("Payments"))
+		next()
+		for _, p := range inv.Payments {
+			d.text(margin, y, 10, regular, i18n.FormatDate(inv.Lang, p.Date))
+			d.text(margin+120, y, 10, regular, p.Description)
+			d.textRight(right, y, 10, regular, money(p.Amount))
+			next()
+		}
+		d.line(columnQty, y+lineHeight-5, right, y+lineHeight-5)
+		total(t("Paid"), inv.Paid(), regular)
+		total(t("Balance due"), inv.Total()-inv.Paid(), bold)
+	}
+
+	return d.bytes()
+}
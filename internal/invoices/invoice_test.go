@@ -0,0 +1,133 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var invoice = Invoice{
+	Number:    7,
+	IssuedAt:  time.Date(2040, time.January, 12, 9, 0, 0, 0, time.UTC),
+	Lang:      "en",
+	Currency:  "EUR",
+	Seller:    []string{"Fort Smythe B&B"},
+	Customer:  []string{"John Smith (guest)", "john@smith.com"},
+	Reference: "Reservation 42",
+	Lines: []Line{
+		{Description: "General's Quarters", Quantity: 3, UnitPrice: 10000, Amount: 30000},
+		{Description: "Discount (SUMMER)", Amount: -3000},
+	},
+	Taxes:    []Line{{Description: "City tax", Quantity: 3, UnitPrice: 200, Amount: 600}},
+	Payments: []Payment{{Date: time.Date(2040, time.January, 2, 0, 0, 0, 0, time.UTC), Description: "Deposit", Amount: 8000}},
+}
+
+func TestInvoice_Totals(t *testing.T) {
+	if got := invoice.Total(); got != 27600 {
+		t.Errorf("expected a total of 27600, got %d", got)
+	}
+	if got := invoice.Paid(); got != 8000 {
+		t.Errorf("expected 8000 paid, got %d", got)
+	}
+}
+
+func TestInvoice_PDF(t *testing.T) {
+	pdf := invoice.PDF()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("expected a PDF file")
+	}
+
+	checkXref(t, pdf)
+
+	for _, s := range []string{"(Invoice)", "(Invoice number: 7)", "(Date: January 12, 2040)", "(John Smith \\(guest\\))",
+		"(Reservation 42)", "(300.00 EUR)", "(-30.00 EUR)", "(City tax)", "(276.00 EUR)", "(80.00 EUR)", "(196.00 EUR)"} {
+		if !bytes.Contains(pdf, []byte(s)) {
+			t.Errorf("expected the PDF to contain %s", s)
+		}
+	}
+}
+
+func TestInvoice_PDFPages(t *testing.T) {
+	long := invoice
+	long.Lines = nil
+	for i := range 100 {
+		long.Lines = append(long.Lines, Line{Description: fmt.Sprintf("Night %d", i+1), Quantity: 1, UnitPrice: 100, Amount: 100})
+	}
+
+	pdf := long.PDF()
+	checkXref(t, pdf)
+
+	if !bytes.Contains(pdf, []byte("/Count 3 ")) {
+		t.Error("expected the lines to fill 3 pages")
+	}
+	if !bytes.Contains(pdf, []byte("(Night 100)")) {
+		t.Error("expected the last line to be written")
+	}
+}
+
+func TestInvoice_PDFLanguage(t *testing.T) {
+	it := invoice
+	it.Lang = "it"
+
+	pdf := it.PDF()
+	if !bytes.Contains(pdf, []byte("(Fattura)")) || !bytes.Contains(pdf, []byte("(Data: 12 gennaio 2040)")) {
+		t.Error("expected the invoice to be written in Italian")
+	}
+}
+
+var encodeTests = []struct {
+	name     string
+	s        string
+	expected string
+}{
+	{"ascii", "Room 1", "Room 1"},
+	{"latin-1", "Città", "Citt\xe0"},
+	{"euro", "10 €", "10 \x80"},
+	{"outside the encoding", "Łódź", "?\xf3d?"},
+	{"parentheses", "(a)", "\\(a\\)"},
+	{"backslash", `a\b`, `a\\b`},
+}
+
+func TestEncode(t *testing.T) {
+	for _, e := range encodeTests {
+		if got := escape(encode(e.s)); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	// 5 digits, a dot and a space are 5*556 + 278 + 278
+	if got := textWidth("12345. ", 10); got != 33.36 {
+		t.Errorf("expected a width of 33.36, got %g", got)
+	}
+}
+
+// checkXref checks that every object listed in the cross-reference table starts at its offset
+func checkXref(t *testing.T, pdf []byte) {
+	t.Helper()
+
+	start := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if start == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(start[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point to the cross-reference table", xref)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	if len(entries) == 0 {
+		t.Fatal("expected objects in the cross-reference table")
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(pdf[offset:], fmt.Appendf(nil, "%d 0 obj\n", i+1)) {
+			t.Errorf("object %d is not at offset %d", i+1, offset)
+		}
+	}
+}
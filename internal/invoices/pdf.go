@@ -0,0 +1,150 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+)
+
+// fonts are the standard fonts every PDF reader has, so no font is embedded
+const (
+	regular = "F1"
+	bold    = "F2"
+)
+
+// helveticaWidths are the widths of the printable ASCII characters in Helvetica, in thousandths of the font size.
+// The digits, the space and the punctuation amounts are written with are as wide in Helvetica-Bold.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0 to 9
+	278, 278, 584, 584, 584, 556, 1015, // : to @
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A to M
+	722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N to Z
+	278, 278, 278, 469, 556, 333, // [ to `
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a to m
+	556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n to z
+	334, 260, 334, 584, // { to ~
+}
+
+// document is a minimal PDF writer: pages of text in Helvetica and straight lines
+type document struct {
+	pages []*bytes.Buffer
+}
+
+// addPage starts a new page, the following drawing goes to it
+func (d *document) addPage() {
+	d.pages = append(d.pages, new(bytes.Buffer))
+}
+
+func (d *document) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.addPage()
+	}
+
+	return d.pages[len(d.pages)-1]
+}
+
+// text writes s with its baseline starting at x, y; the origin is the bottom left corner of the page
+func (d *document) text(x, y, size float64, font, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(encode(s)))
+}
+
+// textRight writes s so that it ends at x
+func (d *document) textRight(x, y, size float64, font, s string) {
+	d.text(x-textWidth(s, size), y, size, font, s)
+}
+
+// line draws a thin line from x1, y1 to x2, y2
+func (d *document) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// bytes returns the PDF file
+func (d *document) bytes() []byte {
+	d.page()
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// the catalog, the page tree and the fonts come first, then each page followed by its content
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] "+
+			"/Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, regular, bold, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// encode converts s to the WinAnsi encoding of the fonts. Latin-1 letters keep their code, the euro sign
+// has its own and anything else is written as a question mark.
+func encode(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '€':
+			b = append(b, 0x80)
+		case r < 0x80 || (r > 0x9f && r <= 0xff):
+			b = append(b, byte(r))
+		default:
+			b = append(b, '?')
+		}
+	}
+
+	return b
+}
+
+// escape escapes the characters that end or break a PDF string
+func escape(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c == '\\' || c == '(' || c == ')' {
+			s.WriteByte('\\')
+		}
+		s.WriteByte(c)
+	}
+
+	return s.String()
+}
+
+// textWidth returns the width of s in points, characters outside ASCII are counted as wide as a digit
+func textWidth(s string, size float64) float64 {
+	width := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			width += helveticaWidths[r-' ']
+		} else {
+			width += 556
+		}
+	}
+
+	return float64(width) * size / 1000
+}
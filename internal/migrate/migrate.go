@@ -0,0 +1,273 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// versionTable is the table holding the applied migration versions, it is the same table used by soda
+// so that databases migrated with the soda CLI are picked up as they are
+const versionTable = "schema_migration"
+
+// lockID is the key of the postgres advisory lock taken while migrating
+const lockID = 8675309
+
+// fileName matches <version>_<name>[.<dialect>].<up|down>.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+?)(?:\.(\w+))?\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	DryRun     bool
+	Out        io.Writer
+}
+
+// Load reads the migrations from fsys, sorted by version. Files for a dialect other than postgres are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		parts := fileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			continue
+		}
+
+		version, name, dialect, direction := parts[1], parts[2], parts[3], parts[4]
+		if dialect != "" && dialect != "postgres" {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(".", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %s has two names: %s and %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// New creates a migrator for the migrations found in fsys
+func New(db *sql.DB, fsys fs.FS, out io.Writer) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+		Out:        out,
+	}, nil
+}
+
+// Up applies all pending migrations and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			if done[migration.Version] {
+				continue
+			}
+
+			fmt.Fprintf(m.Out, "applying %s_%s\n", migration.Version, migration.Name)
+			if m.DryRun {
+				applied++
+				continue
+			}
+
+			err := m.exec(ctx, conn, migration.Up,
+				fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", versionTable), migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %s_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations and returns how many were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	rolledBack := 0
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0 && rolledBack < steps; i-- {
+			migration := m.Migrations[i]
+			if !done[migration.Version] {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %s_%s has no down file", migration.Version, migration.Name)
+			}
+
+			fmt.Fprintf(m.Out, "rolling back %s_%s\n", migration.Version, migration.Name)
+			if m.DryRun {
+				rolledBack++
+				continue
+			}
+
+			err := m.exec(ctx, conn, migration.Down,
+				fmt.Sprintf("DELETE FROM %s WHERE version = $1", versionTable), migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %s_%s: %w", migration.Version, migration.Name, err)
+			}
+			rolledBack++
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// exec runs a migration script and records its version in a single transaction
+func (m *Migrator) exec(ctx context.Context, conn *sql.Conn, script, versionStmt, version string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, versionStmt, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// locked runs f while holding the migration lock, so that instances started at the same time
+// don't apply the same migrations twice
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn) error) error {
+	if m.DB == nil {
+		return errors.New("no database connection")
+	}
+
+	// advisory locks belong to a session, so every statement must run on the same connection
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return err
+	}
+	defer func() {
+		// use a fresh context, the lock must be released even if ctx is done
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = conn.ExecContext(unlockCtx, "SELECT pg_advisory_unlock($1)", lockID)
+	}()
+
+	// a dry run must leave the database untouched
+	if !m.DryRun {
+		stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version VARCHAR(14) NOT NULL PRIMARY KEY)", versionTable)
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return f(conn)
+}
+
+// appliedVersions returns the set of migration versions already applied
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	versions := make(map[string]bool)
+
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", versionTable).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return versions, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", versionTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = true
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/AlessioPani/go-booking/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20230102000000_second.up.sql":          {Data: []byte("up 2")},
+		"20230102000000_second.down.sql":        {Data: []byte("down 2")},
+		"20230101000000_first.up.sql":           {Data: []byte("up 1")},
+		"20230101000000_first.down.sql":         {Data: []byte("down 1")},
+		"20230103000000_seed.postgres.up.sql":   {Data: []byte("up 3")},
+		"20230103000000_seed.postgres.down.sql": {Data: []byte("down 3")},
+		"20230104000000_seed.mysql.up.sql":      {Data: []byte("mysql")},
+		"20230105000000_no_down.up.sql":         {Data: []byte("up 5")},
+		"schema.sql":                            {Data: []byte("")},
+		"20230106000000_old.up.fizz":            {Data: []byte("fizz")},
+	}
+
+	m, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Migration{
+		{"20230101000000", "first", "up 1", "down 1"},
+		{"20230102000000", "second", "up 2", "down 2"},
+		{"20230103000000", "seed", "up 3", "down 3"},
+		{"20230105000000", "no_down", "up 5", ""},
+	}
+
+	if len(m) != len(expected) {
+		t.Fatalf("expected %d migrations, but got %d", len(expected), len(m))
+	}
+
+	for i, e := range expected {
+		if m[i] != e {
+			t.Errorf("migration %d: expected %+v, but got %+v", i, e, m[i])
+		}
+	}
+}
+
+var loadErrorTests = []struct {
+	name string
+	fsys fstest.MapFS
+}{
+	{
+		"missing up file",
+		fstest.MapFS{
+			"20230101000000_first.down.sql": {Data: []byte("down")},
+		},
+	},
+	{
+		"two names for one version",
+		fstest.MapFS{
+			"20230101000000_first.up.sql":   {Data: []byte("up")},
+			"20230101000000_other.down.sql": {Data: []byte("down")},
+		},
+	},
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for _, e := range loadErrorTests {
+		_, err := Load(e.fsys)
+		if err == nil {
+			t.Errorf("failed %s: expected an error but did not get one", e.name)
+		}
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	m, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m) == 0 {
+		t.Fatal("no embedded migrations found")
+	}
+
+	for _, x := range m {
+		if x.Down == "" {
+			t.Errorf("migration %s_%s has no down file", x.Version, x.Name)
+		}
+	}
+}
+
+func TestMigrator_NoDatabase(t *testing.T) {
+	m := &Migrator{}
+
+	if _, err := m.Up(t.Context()); err == nil {
+		t.Error("Up without a database should fail")
+	}
+
+	if _, err := m.Down(t.Context(), 1); err == nil {
+		t.Error("Down without a database should fail")
+	}
+}
@@ -18,10 +18,20 @@ type User struct {
 
 // Room is the Room model
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID           int
+	RoomName     string
+	MaxOccupancy int // the most guests, adults and children, the room can host
+	NightlyRate  int // the price of a night, in cents
+	// DepositPercent and DepositFixed are the deposit paid when booking online,
+	// a percentage of the stay or a fixed amount in cents
+	DepositPercent int
+	DepositFixed   int
+	// a cancellation is free until FreeCancellationDays before arrival,
+	// then CancellationPercent of the stay is kept from what was paid
+	FreeCancellationDays int
+	CancellationPercent  int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
 }
 
 // Restriction is the Restriction model
@@ -32,6 +42,34 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+// Restrictions, as seeded in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	// RestrictionHold keeps a room for a guest who is filling in the reservation form
+	RestrictionHold = 3
+)
+
+// Reservation statuses
+const (
+	ReservationStatusPending    = "pending"
+	ReservationStatusConfirmed  = "confirmed"
+	ReservationStatusCheckedIn  = "checked-in"
+	ReservationStatusCheckedOut = "checked-out"
+	ReservationStatusCancelled  = "cancelled"
+	ReservationStatusNoShow     = "no-show"
+)
+
+// reservationStatusTransitions lists the statuses a reservation can move to from its current status
+var reservationStatusTransitions = map[string][]string{
+	ReservationStatusPending:    {ReservationStatusConfirmed, ReservationStatusCancelled},
+	ReservationStatusConfirmed:  {ReservationStatusCheckedIn, ReservationStatusCancelled, ReservationStatusNoShow},
+	ReservationStatusCheckedIn:  {ReservationStatusCheckedOut},
+	ReservationStatusCheckedOut: {},
+	ReservationStatusCancelled:  {},
+	ReservationStatusNoShow:     {},
+}
+
 // Reservation is the Reservation model
 type Reservation struct {
 	ID        int
@@ -45,7 +83,51 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Processed int
-	Room      Room
+	CreatedBy int
+	Status    string
+	Language  string // the language of the guest, emails are written in it
+	Adults    int
+	Children  int
+	// SpecialRequests is free text from the guest, e.g. a late arrival or a cot
+	SpecialRequests string
+	// PromoCode is the promotion applied when booking, Discount what it took off the stay, in cents
+	PromoCode string
+	Discount  int
+	// Taxes are the taxes charged on the stay, computed when it is booked or changed
+	Taxes []ReservationTax
+	// GroupId is the group of the rooms booked together with this one, 0 for a room booked alone
+	GroupId int
+	Room    Room
+}
+
+// TaxTotal returns the sum of the taxes charged on the stay
+func (r Reservation) TaxTotal() int {
+	total := 0
+	for _, t := range r.Taxes {
+		total += t.Amount
+	}
+
+	return total
+}
+
+// Nights returns the number of nights of the stay
+func (r Reservation) Nights() int {
+	return int(r.EndDate.Sub(r.StartDate).Round(time.Hour).Hours()) / 24
+}
+
+// NextStatuses returns the statuses the reservation can move to
+func (r Reservation) NextStatuses() []string {
+	return reservationStatusTransitions[r.Status]
+}
+
+// CanTransitionTo returns true if the reservation can move to the given status
+func (r Reservation) CanTransitionTo(status string) bool {
+	for _, s := range r.NextStatuses() {
+		if s == status {
+			return true
+		}
+	}
+	return false
 }
 
 // RoomRestriction is the Room Restriction model
@@ -61,13 +143,166 @@ type RoomRestriction struct {
 	Reservation   Reservation
 	RestrictionId int
 	Restriction   Restriction
+	// ExpiresAt is when a hold releases the room, it is zero for reservations and blocks
+	ExpiresAt time.Time
+}
+
+// Payment statuses
+const (
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusCaptured   = "captured"
+	PaymentStatusRefunded   = "refunded"
+	PaymentStatusFailed     = "failed"
+)
+
+// Payment is the Payment model, an amount taken through a payment gateway for a reservation
+type Payment struct {
+	ID            int
+	ReservationId int
+	Gateway       string
+	Reference     string // the id of the payment at the gateway
+	Amount        int    // in cents
+	Refunded      int    // the part of Amount given back, in cents
+	Currency      string
+	Status        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Promotion is the Promotion model, a code giving a discount on stays. The discount is a percentage
+// of the stay or a fixed amount in cents.
+type Promotion struct {
+	ID              int
+	Code            string // unique, stored in upper case
+	Description     string
+	DiscountPercent int
+	DiscountFixed   int
+	// ValidFrom and ValidTo are the first and the last arrival date the code can be used for
+	ValidFrom time.Time
+	ValidTo   time.Time
+	RoomId    int // the room the code is restricted to, 0 for any room
+	MinNights int
+	MaxUses   int // how many reservations can use the code, 0 for no limit
+	Uses      int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UsedUp returns true if the promotion can't be used by another reservation
+func (p Promotion) UsedUp() bool {
+	return p.MaxUses > 0 && p.Uses >= p.MaxUses
+}
+
+// TaxRule is the Tax Rule model, a tax charged on top of the price of stays. Kind is one of the kinds
+// of the payments package: a Percent of the stay, or an Amount in cents for each night or guest night.
+type TaxRule struct {
+	ID             int
+	Name           string
+	Kind           string
+	Percent        int
+	Amount         int
+	ExemptChildren bool
+	MaxNights      int // nights charged at most, 0 for every night
+	Cap            int // the most charged for a stay in cents, 0 for no cap
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// ReservationTax is a tax charged on a reservation. The name and the amounts are kept as they
+// were computed, TaxRuleId is 0 once the rule is deleted.
+type ReservationTax struct {
+	ID            int
+	ReservationId int
+	TaxRuleId     int
+	Name          string
+	Quantity      int // nights or guest nights charged, 0 for a percentage
+	UnitPrice     int
+	Amount        int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TaxReportLine is what a tax rule charged on the stays of a period
+type TaxReportLine struct {
+	TaxRuleId    int
+	Name         string
+	Reservations int
+	Quantity     int
+	Amount       int
+}
+
+// StayOption is a stay that can be booked in a room, found by a flexible search
+type StayOption struct {
+	Room      Room
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// CalendarDay is a day on the availability calendar of a room: whether the night starting on Date
+// can be booked, and its price in cents, 0 when the room has no rate
+type CalendarDay struct {
+	Date      time.Time
+	Available bool
+	Price     int
+}
+
+// WaitlistEntry is a guest waiting for a room to become available for a stay. Once nights are freed
+// and the stay can be booked, the guest is sent a booking link with Token, valid until ExpiresAt.
+type WaitlistEntry struct {
+	ID         int
+	FirstName  string
+	LastName   string
+	Email      string
+	StartDate  time.Time
+	EndDate    time.Time
+	RoomId     int // the room wanted, 0 for any room
+	Adults     int
+	Children   int
+	Language   string
+	Token      string
+	NotifiedAt time.Time // zero while the guest is waiting
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// AuditLog is the Audit Log model, it records who changed what and when
+type AuditLog struct {
+	ID        int
+	UserId    int
+	User      User
+	Entity    string
+	EntityId  int
+	Action    string
+	Details   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Invoice is the Invoice model, invoices are numbered in sequence as they are issued
+// and a reservation has at most one
+type Invoice struct {
+	ID            int
+	ReservationId int
+	Number        int
+	IssuedAt      time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Template    string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
 }
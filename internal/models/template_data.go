@@ -14,4 +14,5 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated bool
+	Lang            string // the language of the request, see package i18n
 }
@@ -0,0 +1,46 @@
+package payments
+
+import "time"
+
+// CancellationPolicy is what a guest pays for cancelling a stay: nothing until FreeDays days before arrival,
+// then PenaltyPercent of the stay. A policy without a penalty always cancels for free.
+type CancellationPolicy struct {
+	FreeDays       int
+	PenaltyPercent int
+}
+
+// calendarDay drops the time of day, keeping the date as seen in the location of t
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FreeUntil returns the last day a stay starting on arrival can be cancelled for free
+func (p CancellationPolicy) FreeUntil(arrival time.Time) time.Time {
+	return calendarDay(arrival).AddDate(0, 0, -max(p.FreeDays, 0))
+}
+
+// IsFree reports whether cancelling at the given time costs nothing. Days are calendar days: with 7 free days
+// and an arrival on the 10th, a cancellation is free until the end of the 3rd.
+func (p CancellationPolicy) IsFree(arrival, at time.Time) bool {
+	return p.PenaltyPercent <= 0 || !calendarDay(at).After(p.FreeUntil(arrival))
+}
+
+// Penalty returns the part of a stay that costs total kept when it is cancelled at the given time
+func (p CancellationPolicy) Penalty(total int, arrival, at time.Time) int {
+	if total <= 0 || p.IsFree(arrival, at) {
+		return 0
+	}
+
+	// rounded down, in favour of the guest
+	return total * min(p.PenaltyPercent, 100) / 100
+}
+
+// Refund returns the part of paid given back when the stay is cancelled at the given time,
+// the penalty is taken from what was paid and never more than that
+func (p CancellationPolicy) Refund(paid, total int, arrival, at time.Time) int {
+	if paid <= 0 {
+		return 0
+	}
+
+	return paid - min(p.Penalty(total, arrival, at), paid)
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"testing"
+	"time"
+)
+
+// arrival is the check-in day of the stays below, a 7 days policy is free until the end of January 3rd
+var arrival = time.Date(2040, time.January, 10, 0, 0, 0, 0, time.UTC)
+
+var cancellationTests = []struct {
+	name     string
+	policy   CancellationPolicy
+	at       time.Time
+	expected int // the penalty of a stay of 20000
+}{
+	{"well before", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, time.Date(2039, time.December, 1, 12, 0, 0, 0, time.UTC), 0},
+	{"first minute of the last free day", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, time.Date(2040, time.January, 3, 0, 0, 0, 0, time.UTC), 0},
+	{"last second of the last free day", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, time.Date(2040, time.January, 3, 23, 59, 59, 0, time.UTC), 0},
+	{"first day with a penalty", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, time.Date(2040, time.January, 4, 0, 0, 0, 0, time.UTC), 10000},
+	{"day before arrival", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, time.Date(2040, time.January, 9, 18, 0, 0, 0, time.UTC), 10000},
+	{"day of arrival", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, arrival, 10000},
+	{"after arrival", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, arrival.AddDate(0, 0, 2), 10000},
+	{"free until arrival, on arrival", CancellationPolicy{PenaltyPercent: 50}, time.Date(2040, time.January, 10, 20, 0, 0, 0, time.UTC), 0},
+	{"free until arrival, the day after", CancellationPolicy{PenaltyPercent: 50}, time.Date(2040, time.January, 11, 0, 0, 0, 0, time.UTC), 10000},
+	{"local date counts", CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}, time.Date(2040, time.January, 3, 23, 30, 0, 0, time.FixedZone("CET", 3600)), 0},
+	{"no penalty", CancellationPolicy{FreeDays: 7}, arrival, 0},
+	{"penalty above 100", CancellationPolicy{FreeDays: 7, PenaltyPercent: 150}, arrival, 20000},
+	{"penalty rounded down", CancellationPolicy{FreeDays: 7, PenaltyPercent: 33}, arrival, 6600},
+}
+
+func TestCancellationPolicy_Penalty(t *testing.T) {
+	for _, e := range cancellationTests {
+		if got := e.policy.Penalty(20000, arrival, e.at); got != e.expected {
+			t.Errorf("%s: expected a penalty of %d, got %d", e.name, e.expected, got)
+		}
+		if free := e.policy.IsFree(arrival, e.at); free != (e.expected == 0) {
+			t.Errorf("%s: expected free to be %t, got %t", e.name, e.expected == 0, free)
+		}
+	}
+}
+
+func TestCancellationPolicy_FreeUntil(t *testing.T) {
+	policy := CancellationPolicy{FreeDays: 7, PenaltyPercent: 50}
+	expected := time.Date(2040, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+	if got := policy.FreeUntil(arrival); !got.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	// across a month and a year
+	if got := policy.FreeUntil(time.Date(2040, time.January, 2, 0, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2039, time.December, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected December 26th, got %s", got)
+	}
+}
+
+func TestCancellationPolicy_Refund(t *testing.T) {
+	policy := CancellationPolicy{FreeDays: 7, PenaltyPercent: 20}
+	late := arrival.AddDate(0, 0, -1)
+
+	var refundTests = []struct {
+		name     string
+		paid     int
+		at       time.Time
+		expected int
+	}{
+		{"free cancellation", 6000, arrival.AddDate(0, 0, -7), 6000},
+		{"penalty taken from the deposit", 6000, late, 2000},
+		{"penalty above the deposit", 3000, late, 0},
+		{"nothing paid", 0, late, 0},
+	}
+
+	for _, e := range refundTests {
+		if got := policy.Refund(e.paid, 20000, arrival, e.at); got != e.expected {
+			t.Errorf("%s: expected a refund of %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// FakeName is the name of the fake gateway
+const FakeName = "fake"
+
+// Cards of the fake gateway: every token is approved except FakeDeclineToken
+const (
+	FakeApproveToken = "tok_approve"
+	FakeDeclineToken = "tok_decline"
+)
+
+// Fake is an in process gateway for development and tests, no money is moved.
+// Its webhooks are signed with an HMAC-SHA256 of the payload, hex encoded.
+type Fake struct {
+	secret []byte
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+	lastId   int
+}
+
+// fakePayment is the state of a payment held by the fake gateway
+type fakePayment struct {
+	authorized int
+	captured   int
+	refunded   int
+}
+
+// NewFake returns a fake gateway, a blank secret rejects every webhook
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   []byte(secret),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Name identifies the gateway in the payments table
+func (f *Fake) Name() string {
+	return FakeName
+}
+
+// Authorize holds an amount, unless the card is FakeDeclineToken
+func (f *Fake) Authorize(ctx context.Context, req Request) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if req.Amount <= 0 {
+		return "", ErrInvalidAmount
+	}
+
+	if req.Token == FakeDeclineToken {
+		return "", ErrDeclined
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastId++
+	reference := fmt.Sprintf("fake_%d", f.lastId)
+	f.payments[reference] = &fakePayment{authorized: req.Amount}
+
+	return reference, nil
+}
+
+// Capture takes up to the authorized amount that was not captured yet
+func (f *Fake) Capture(ctx context.Context, reference string, amount int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+
+	if amount <= 0 || amount > p.authorized-p.captured-p.refunded {
+		return ErrInvalidAmount
+	}
+
+	p.captured += amount
+
+	return nil
+}
+
+// Refund gives back a captured amount, or releases an authorization that was not captured
+func (f *Fake) Refund(ctx context.Context, reference string, amount int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+
+	refundable := p.authorized
+	if p.captured > 0 {
+		refundable = p.captured
+	}
+
+	if amount <= 0 || amount > refundable-p.refunded {
+		return ErrInvalidAmount
+	}
+
+	p.refunded += amount
+
+	return nil
+}
+
+// Sign returns the signature the fake gateway sends with a webhook payload
+func (f *Fake) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a payload and returns its event
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	var event Event
+
+	if len(f.secret) == 0 || !hmac.Equal([]byte(f.Sign(payload)), []byte(signature)) {
+		return event, ErrInvalidSignature
+	}
+
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return event, err
+	}
+
+	return event, nil
+}
@@ -0,0 +1,125 @@
+// Package payments takes the deposits of reservations through a payment gateway.
+// Amounts are integers in the minor unit of the currency, e.g. cents.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDeclined is returned when the gateway refuses to authorize a payment
+	ErrDeclined = errors.New("payment declined")
+	// ErrInvalidAmount is returned for amounts that are not positive or exceed what can be captured or refunded
+	ErrInvalidAmount = errors.New("invalid payment amount")
+	// ErrUnknownPayment is returned when the gateway has no payment with the given reference
+	ErrUnknownPayment = errors.New("unknown payment")
+	// ErrInvalidSignature is returned when a webhook is not signed by the gateway
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// SignatureHeader is the request header holding the signature of a webhook
+const SignatureHeader = "X-Payment-Signature"
+
+// Webhook event types
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventFailed   = "payment.failed"
+)
+
+// Gateway is a payment provider. A payment is first authorized, which holds the amount on the
+// guest's card, then captured when the money is actually taken, or refunded.
+type Gateway interface {
+	// Name identifies the gateway in the payments table
+	Name() string
+	// Authorize holds an amount and returns the gateway reference of the payment
+	Authorize(ctx context.Context, req Request) (string, error)
+	// Capture takes up to the authorized amount of a payment
+	Capture(ctx context.Context, reference string, amount int) error
+	// Refund gives back a captured amount, or releases an authorization that was not captured
+	Refund(ctx context.Context, reference string, amount int) error
+	// VerifyWebhook checks the signature of a webhook sent by the gateway and returns its event
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// Request is an authorization request
+type Request struct {
+	Amount   int
+	Currency string
+	// Description tells the guest what the payment is for, e.g. the reservation number
+	Description string
+	// Token identifies the card of the guest, as returned by the gateway to the checkout page
+	Token string
+}
+
+// Event is a notification sent by the gateway about a payment
+type Event struct {
+	Type      string `json:"type"`
+	Reference string `json:"reference"`
+	Amount    int    `json:"amount"`
+}
+
+// New returns the gateway with the given name, secret signs its webhooks
+func New(name, secret string) (Gateway, error) {
+	switch name {
+	case FakeName:
+		return NewFake(secret), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", name)
+	}
+}
+
+// DepositPolicy is the part of a stay paid when booking, either a fixed amount or a percentage of the stay.
+// A fixed amount takes precedence, it is never more than the stay itself.
+type DepositPolicy struct {
+	Percent int
+	Fixed   int
+}
+
+// Amount returns the deposit due for a stay that costs total
+func (p DepositPolicy) Amount(total int) int {
+	if total <= 0 {
+		return 0
+	}
+
+	if p.Fixed > 0 {
+		return min(p.Fixed, total)
+	}
+
+	// rounded up, so that a deposit is never zero when a percentage is set
+	return (total*min(max(p.Percent, 0), 100) + 99) / 100
+}
+
+// Discount is what a promotion takes off a stay, either a fixed amount or a percentage of the stay.
+// A fixed amount takes precedence, a discount never makes a stay cost less than nothing.
+type Discount struct {
+	Percent int
+	Fixed   int
+}
+
+// Amount returns the discount on a stay that costs total
+func (d Discount) Amount(total int) int {
+	if total <= 0 {
+		return 0
+	}
+
+	if d.Fixed > 0 {
+		return min(d.Fixed, total)
+	}
+
+	// rounded down, in favour of the property
+	return total * min(max(d.Percent, 0), 100) / 100
+}
+
+// FormatAmount formats an amount in the minor unit of a currency, e.g. 12050 EUR is 120.50 EUR
+func FormatAmount(amount int, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, currency)
+}
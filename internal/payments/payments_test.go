@@ -0,0 +1,153 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var depositTests = []struct {
+	name     string
+	policy   DepositPolicy
+	total    int
+	expected int
+}{
+	{"no deposit", DepositPolicy{}, 20000, 0},
+	{"percentage", DepositPolicy{Percent: 30}, 20000, 6000},
+	{"percentage rounded up", DepositPolicy{Percent: 30}, 1001, 301},
+	{"whole stay", DepositPolicy{Percent: 100}, 20000, 20000},
+	{"percentage above 100", DepositPolicy{Percent: 150}, 20000, 20000},
+	{"negative percentage", DepositPolicy{Percent: -10}, 20000, 0},
+	{"fixed", DepositPolicy{Fixed: 5000}, 20000, 5000},
+	{"fixed wins over percentage", DepositPolicy{Percent: 30, Fixed: 5000}, 20000, 5000},
+	{"fixed capped at the stay", DepositPolicy{Fixed: 5000}, 3000, 3000},
+	{"free stay", DepositPolicy{Percent: 30, Fixed: 5000}, 0, 0},
+}
+
+func TestDepositPolicy_Amount(t *testing.T) {
+	for _, e := range depositTests {
+		if got := e.policy.Amount(e.total); got != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+var discountTests = []struct {
+	name     string
+	discount Discount
+	total    int
+	expected int
+}{
+	{"no discount", Discount{}, 20000, 0},
+	{"percentage", Discount{Percent: 10}, 20000, 2000},
+	{"percentage rounded down", Discount{Percent: 10}, 1009, 100},
+	{"percentage above 100", Discount{Percent: 150}, 20000, 20000},
+	{"negative percentage", Discount{Percent: -10}, 20000, 0},
+	{"fixed", Discount{Fixed: 5000}, 20000, 5000},
+	{"fixed wins over percentage", Discount{Percent: 10, Fixed: 5000}, 20000, 5000},
+	{"fixed capped at the stay", Discount{Fixed: 5000}, 3000, 3000},
+	{"free stay", Discount{Percent: 10, Fixed: 5000}, 0, 0},
+}
+
+func TestDiscount_Amount(t *testing.T) {
+	for _, e := range discountTests {
+		if got := e.discount.Amount(e.total); got != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	for amount, expected := range map[int]string{0: "0.00 EUR", 5: "0.05 EUR", 12050: "120.50 EUR", -250: "-2.50 EUR"} {
+		if got := FormatAmount(amount, "EUR"); got != expected {
+			t.Errorf("%d: expected %s, got %s", amount, expected, got)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	g, err := New(FakeName, "secret")
+	if err != nil || g.Name() != FakeName {
+		t.Errorf("expected the fake gateway, got %v, %v", g, err)
+	}
+
+	if _, err := New("acme", "secret"); err == nil {
+		t.Error("expected an error for an unknown gateway")
+	}
+}
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake("secret")
+
+	if _, err := f.Authorize(ctx, Request{Amount: 5000, Token: FakeDeclineToken}); !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected the decline token to be declined, got %v", err)
+	}
+
+	if _, err := f.Authorize(ctx, Request{Amount: 0, Token: FakeApproveToken}); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected a zero amount to be refused, got %v", err)
+	}
+
+	ref, err := f.Authorize(ctx, Request{Amount: 5000, Currency: "EUR", Token: FakeApproveToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Capture(ctx, ref, 6000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected capturing more than authorized to fail, got %v", err)
+	}
+	if err := f.Capture(ctx, "fake_999", 1000); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected an unknown payment, got %v", err)
+	}
+	if err := f.Capture(ctx, ref, 3000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Refund(ctx, ref, 4000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected refunding more than captured to fail, got %v", err)
+	}
+	if err := f.Refund(ctx, ref, 3000); err != nil {
+		t.Error(err)
+	}
+	if err := f.Refund(ctx, ref, 1); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected a second refund to fail, got %v", err)
+	}
+
+	// an authorization that was not captured is released by a refund
+	ref, err = f.Authorize(ctx, Request{Amount: 5000, Token: FakeApproveToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Refund(ctx, ref, 5000); err != nil {
+		t.Error(err)
+	}
+	if err := f.Capture(ctx, ref, 1000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected a released authorization not to be captured, got %v", err)
+	}
+}
+
+func TestFake_VerifyWebhook(t *testing.T) {
+	f := NewFake("secret")
+	payload := []byte(`{"type": "payment.captured", "reference": "fake_1", "amount": 5000}`)
+
+	event, err := f.VerifyWebhook(payload, f.Sign(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventCaptured || event.Reference != "fake_1" || event.Amount != 5000 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if _, err := f.VerifyWebhook(payload, NewFake("other").Sign(payload)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected a signature with another secret to be refused, got %v", err)
+	}
+
+	if _, err := f.VerifyWebhook([]byte(`not json`), f.Sign([]byte(`not json`))); err == nil {
+		t.Error("expected an error for a payload that is not json")
+	}
+
+	unsigned := NewFake("")
+	if _, err := unsigned.VerifyWebhook(payload, unsigned.Sign(payload)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected a gateway without a secret to refuse webhooks, got %v", err)
+	}
+}
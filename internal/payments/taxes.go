@@ -0,0 +1,77 @@
+package payments
+
+// Kinds of tax rules
+const (
+	// TaxPercent is a percentage of the price of the stay, e.g. VAT
+	TaxPercent = "percent"
+	// TaxPerNight is an amount for each night of the stay
+	TaxPerNight = "per-night"
+	// TaxPerGuest is an amount for each guest and night of the stay, e.g. a city tax
+	TaxPerGuest = "per-guest"
+)
+
+// TaxKinds are the kinds of tax rules, in the order they are offered
+var TaxKinds = []string{TaxPercent, TaxPerNight, TaxPerGuest}
+
+// TaxRule is a tax charged on top of the price of a stay
+type TaxRule struct {
+	Name    string
+	Kind    string
+	Percent int // for TaxPercent
+	Amount  int // for each night or guest night, for the other kinds
+	// ExemptChildren leaves children out of the guests a TaxPerGuest is charged for
+	ExemptChildren bool
+	// MaxNights is the number of nights charged at most, 0 to charge every night
+	MaxNights int
+	// Cap is the most charged for a stay, 0 for no cap
+	Cap int
+}
+
+// Stay is what taxes are computed on, Price is what the stay costs after its discount
+type Stay struct {
+	Price    int
+	Nights   int
+	Adults   int
+	Children int
+}
+
+// TaxLine is a tax charged on a stay, Quantity is the number of nights or guest nights charged
+// at UnitPrice; both are 0 for a percentage.
+type TaxLine struct {
+	Name      string
+	Quantity  int
+	UnitPrice int
+	Amount    int
+}
+
+// Apply returns the tax charged on a stay by the rule
+func (r TaxRule) Apply(s Stay) TaxLine {
+	line := TaxLine{Name: r.Name}
+
+	nights := max(s.Nights, 0)
+	if r.MaxNights > 0 {
+		nights = min(nights, r.MaxNights)
+	}
+
+	switch r.Kind {
+	case TaxPercent:
+		// rounded to the nearest cent
+		line.Amount = (max(s.Price, 0)*max(r.Percent, 0) + 50) / 100
+	case TaxPerNight:
+		line.Quantity, line.UnitPrice = nights, max(r.Amount, 0)
+		line.Amount = line.Quantity * line.UnitPrice
+	case TaxPerGuest:
+		guests := max(s.Adults, 0)
+		if !r.ExemptChildren {
+			guests += max(s.Children, 0)
+		}
+		line.Quantity, line.UnitPrice = nights*guests, max(r.Amount, 0)
+		line.Amount = line.Quantity * line.UnitPrice
+	}
+
+	if r.Cap > 0 {
+		line.Amount = min(line.Amount, r.Cap)
+	}
+
+	return line
+}
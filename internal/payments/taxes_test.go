@@ -0,0 +1,47 @@
+package payments
+
+import "testing"
+
+// stay is 3 nights for 2 adults and a child at 300.00, after its discount
+var stay = Stay{Price: 30000, Nights: 3, Adults: 2, Children: 1}
+
+var taxTests = []struct {
+	name             string
+	rule             TaxRule
+	expectedQuantity int
+	expectedAmount   int
+}{
+	{"percent", TaxRule{Kind: TaxPercent, Percent: 10}, 0, 3000},
+	{"percent rounded to the nearest cent", TaxRule{Kind: TaxPercent, Percent: 22}, 0, 6600},
+	{"percent capped", TaxRule{Kind: TaxPercent, Percent: 10, Cap: 2500}, 0, 2500},
+	{"per night", TaxRule{Kind: TaxPerNight, Amount: 150}, 3, 450},
+	{"per night up to 2 nights", TaxRule{Kind: TaxPerNight, Amount: 150, MaxNights: 2}, 2, 300},
+	{"per guest", TaxRule{Kind: TaxPerGuest, Amount: 200}, 9, 1800},
+	{"per guest, children exempt", TaxRule{Kind: TaxPerGuest, Amount: 200, ExemptChildren: true}, 6, 1200},
+	{"per guest up to 2 nights", TaxRule{Kind: TaxPerGuest, Amount: 200, ExemptChildren: true, MaxNights: 2}, 4, 800},
+	{"per guest capped", TaxRule{Kind: TaxPerGuest, Amount: 200, Cap: 1000}, 9, 1000},
+	{"no amount", TaxRule{Kind: TaxPerGuest}, 9, 0},
+	{"unknown kind", TaxRule{Kind: "bogus", Percent: 10, Amount: 100}, 0, 0},
+}
+
+func TestTaxRule_Apply(t *testing.T) {
+	for _, e := range taxTests {
+		line := e.rule.Apply(stay)
+		if line.Quantity != e.expectedQuantity || line.Amount != e.expectedAmount {
+			t.Errorf("%s: expected %d for %d, got %d for %d", e.name, e.expectedAmount, e.expectedQuantity, line.Amount, line.Quantity)
+		}
+	}
+
+	if line := (TaxRule{Name: "City tax", Kind: TaxPerGuest, Amount: 200}).Apply(stay); line.Name != "City tax" || line.UnitPrice != 200 {
+		t.Errorf("expected the line to carry the name and the amount of the rule, got %+v", line)
+	}
+
+	// 33% of 10.01 is 3.3033
+	if got := (TaxRule{Kind: TaxPercent, Percent: 33}).Apply(Stay{Price: 1001}).Amount; got != 330 {
+		t.Errorf("expected a tax of 330, got %d", got)
+	}
+	// 50% of 0.01 is 0.005
+	if got := (TaxRule{Kind: TaxPercent, Percent: 50}).Apply(Stay{Price: 1}).Amount; got != 1 {
+		t.Errorf("expected half a cent to be rounded up, got %d", got)
+	}
+}
@@ -10,15 +10,21 @@ import (
 	"time"
 
 	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/i18n"
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/payments"
 
 	"github.com/justinas/nosurf"
 )
 
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
+	"T":          i18n.T,
+	"date":       i18n.FormatDate,
+	"month":      i18n.FormatMonth,
+	"languages":  Languages,
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
+	"money":      payments.FormatAmount,
 }
 var app *config.AppConfig
 var pathToTemplates = "./templates"
@@ -28,9 +34,9 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
-// HumanDate converts a time.Time into a string with a YYYY-MM-DD format
-func HumanDate(t time.Time) string {
-	return t.Format("2006-01-02")
+// Languages returns the languages the site can be switched to
+func Languages() []i18n.Language {
+	return i18n.Languages
 }
 
 // FormatDate converts a time.Time into a string with a f format string
@@ -52,9 +58,10 @@ func Iterate(count int) []int {
 
 // AddDefaultData adds default data to templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
-	td.Flash = app.Session.PopString(r.Context(), "flash")
-	td.Error = app.Session.PopString(r.Context(), "error")
-	td.Warning = app.Session.PopString(r.Context(), "warning")
+	td.Lang = i18n.FromContext(r.Context())
+	td.Flash = i18n.T(td.Lang, app.Session.PopString(r.Context(), "flash"))
+	td.Error = i18n.T(td.Lang, app.Session.PopString(r.Context(), "error"))
+	td.Warning = i18n.T(td.Lang, app.Session.PopString(r.Context(), "warning"))
 	td.CSRFToken = nosurf.Token(r)
 	td.IsAuthenticated = app.Session.Exists(r.Context(), "user_id")
 	return td
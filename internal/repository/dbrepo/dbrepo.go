@@ -1,16 +1,51 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/models"
 	"github.com/AlessioPani/go-booking/internal/repository"
 )
 
+// defaultTimeout is used when no database timeout has been configured
+const defaultTimeout = 3 * time.Second
+
 type postgresDbRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
 }
 
+type sqliteDbRepo struct {
+	App *config.AppConfig
+	DB  *sql.DB
+}
+
+// memoryDbRepo keeps every table in memory, the lock guards all of them
+type memoryDbRepo struct {
+	App *config.AppConfig
+
+	mu               sync.RWMutex
+	users            map[int]models.User
+	rooms            map[int]models.Room
+	restrictions     map[int]models.Restriction
+	reservations     map[int]models.Reservation
+	deleted          map[int]time.Time
+	roomRestrictions map[int]models.RoomRestriction
+	auditLogs        []models.AuditLog
+	payments         map[int]models.Payment
+	promotions       map[int]models.Promotion
+	invoices         map[int]models.Invoice // by reservation id
+	taxRules         map[int]models.TaxRule
+	waitlist         map[int]models.WaitlistEntry
+	lastId           map[string]int
+}
+
 type testDbRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
@@ -23,8 +58,244 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 	}
 }
 
+func NewSqliteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqliteDbRepo{
+		App: a,
+		DB:  conn,
+	}
+}
+
+// NewMemoryRepo returns an empty in memory repository, holding only the seeded rooms and restrictions
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	return newMemoryRepo(a)
+}
+
 func NewTestRepo(a *config.AppConfig) repository.DatabaseRepo {
 	return &testDbRepo{
 		App: a,
 	}
 }
+
+// withTimeout derives a context from the request context, bounded by the configured database timeout.
+// The timeout is a ceiling: the query is cancelled earlier if the request context is done.
+func withTimeout(ctx context.Context, a *config.AppConfig) (context.Context, context.CancelFunc) {
+	timeout := defaultTimeout
+	if a != nil && a.DBTimeout > 0 {
+		timeout = a.DBTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// overlapPolicy returns the configured overlap policy, half-open when there is no configuration
+func overlapPolicy(a *config.AppConfig) repository.OverlapPolicy {
+	if a == nil {
+		return repository.HalfOpen
+	}
+
+	return a.Overlap
+}
+
+// activeRestriction is the SQL condition matching the restrictions that take a room at the time bound to now:
+// reservations and blocks never expire, holds do
+func activeRestriction(expiresCol, now string) string {
+	return fmt.Sprintf("(%s is null or %s > %s)", expiresCol, expiresCol, now)
+}
+
+// calendarOrNoRoom returns the days of an availability calendar, sql.ErrNoRows when the query found
+// no room for a period that has days
+func calendarOrNoRoom(days []models.CalendarDay, from, to time.Time) ([]models.CalendarDay, error) {
+	if len(days) == 0 && dateOnly(from).Before(dateOnly(to)) {
+		return nil, sql.ErrNoRows
+	}
+
+	return days, nil
+}
+
+// promotionCode normalizes a promotion code, codes are matched ignoring case and surrounding spaces
+func promotionCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// promotionColumns are the columns read by scanPromotion, a promotion for any room has no room_id
+const promotionColumns = `id, code, description, discount_percent, discount_fixed, valid_from, valid_to,
+	coalesce(room_id, 0), min_nights, max_uses, uses, created_at, updated_at`
+
+// scanPromotion scans a row selected with promotionColumns
+func scanPromotion(row interface{ Scan(dest ...any) error }) (models.Promotion, error) {
+	var p models.Promotion
+	err := row.Scan(&p.ID, &p.Code, &p.Description, &p.DiscountPercent, &p.DiscountFixed, &p.ValidFrom, &p.ValidTo,
+		&p.RoomId, &p.MinNights, &p.MaxUses, &p.Uses, &p.CreatedAt, &p.UpdatedAt)
+
+	return p, err
+}
+
+// promotionRoom is the room_id of a promotion, null when the promotion is for any room
+func promotionRoom(p models.Promotion) sql.NullInt64 {
+	if p.RoomId > 0 {
+		return sql.NullInt64{Int64: int64(p.RoomId), Valid: true}
+	}
+
+	return sql.NullInt64{}
+}
+
+// taxRuleColumns are the columns read by scanTaxRule
+const taxRuleColumns = `id, name, kind, percent, amount, exempt_children, max_nights, cap, created_at, updated_at`
+
+// scanTaxRule scans a row selected with taxRuleColumns
+func scanTaxRule(row interface{ Scan(dest ...any) error }) (models.TaxRule, error) {
+	var t models.TaxRule
+	err := row.Scan(&t.ID, &t.Name, &t.Kind, &t.Percent, &t.Amount, &t.ExemptChildren, &t.MaxNights, &t.Cap,
+		&t.CreatedAt, &t.UpdatedAt)
+
+	return t, err
+}
+
+// reservationTaxColumns are the columns of the reservation_taxes table t read by queryReservationTaxes,
+// the taxes of a deleted rule have no tax_rule_id
+const reservationTaxColumns = `t.id, t.reservation_id, coalesce(t.tax_rule_id, 0), t.name, t.quantity, t.unit_price, t.amount,
+	t.created_at, t.updated_at`
+
+// queryReservationTaxes runs a query selecting reservationTaxColumns
+func queryReservationTaxes(ctx context.Context, db *sql.DB, query string, args ...any) ([]models.ReservationTax, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var taxes []models.ReservationTax
+	for rows.Next() {
+		var t models.ReservationTax
+		err := rows.Scan(&t.ID, &t.ReservationId, &t.TaxRuleId, &t.Name, &t.Quantity, &t.UnitPrice, &t.Amount,
+			&t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		taxes = append(taxes, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxes, nil
+}
+
+// replaceReservationTaxes replaces the taxes of a reservation within tx
+func replaceReservationTaxes(ctx context.Context, tx *sql.Tx, reservationId int, taxes []models.ReservationTax) error {
+	_, err := tx.ExecContext(ctx, `delete from reservation_taxes where reservation_id = $1`, reservationId)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into reservation_taxes (reservation_id, tax_rule_id, name, quantity, unit_price, amount, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	for _, t := range taxes {
+		var ruleId sql.NullInt64
+		if t.TaxRuleId > 0 {
+			ruleId = sql.NullInt64{Int64: int64(t.TaxRuleId), Valid: true}
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, reservationId, ruleId, t.Name, t.Quantity, t.UnitPrice, t.Amount, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// taxReportQuery sums the reservation taxes of the stays starting between $1 and $2, excluded,
+// leaving out the statuses $3 and $4. Taxes are grouped by rule and by the name they were charged with.
+const taxReportQuery = `select coalesce(t.tax_rule_id, 0), t.name, count(distinct t.reservation_id), sum(t.quantity), sum(t.amount)
+	from reservation_taxes t
+	join reservations r on (r.id = t.reservation_id)
+	where r.deleted_at is null and r.status not in ($3, $4) and r.start_date >= $1 and r.start_date < $2
+	group by coalesce(t.tax_rule_id, 0), t.name
+	order by t.name, coalesce(t.tax_rule_id, 0)`
+
+// scanTaxReport scans the lines of a tax report
+func scanTaxReport(rows *sql.Rows) ([]models.TaxReportLine, error) {
+	defer rows.Close()
+
+	var report []models.TaxReportLine
+	for rows.Next() {
+		var l models.TaxReportLine
+		err := rows.Scan(&l.TaxRuleId, &l.Name, &l.Reservations, &l.Quantity, &l.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		report = append(report, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// waitlistColumns are the columns read by scanWaitlistEntry, an entry for any room has no room_id
+// and an entry still waiting has no token
+const waitlistColumns = `id, first_name, last_name, email, start_date, end_date, coalesce(room_id, 0), adults, children,
+	language, coalesce(token, ''), notified_at, expires_at, created_at, updated_at`
+
+// scanWaitlistEntry scans a row selected with waitlistColumns
+func scanWaitlistEntry(row interface{ Scan(dest ...any) error }) (models.WaitlistEntry, error) {
+	var e models.WaitlistEntry
+	var notifiedAt, expiresAt sql.NullTime
+	err := row.Scan(&e.ID, &e.FirstName, &e.LastName, &e.Email, &e.StartDate, &e.EndDate, &e.RoomId, &e.Adults, &e.Children,
+		&e.Language, &e.Token, &notifiedAt, &expiresAt, &e.CreatedAt, &e.UpdatedAt)
+	e.NotifiedAt, e.ExpiresAt = notifiedAt.Time, expiresAt.Time
+
+	return e, err
+}
+
+// scanWaitlist scans the rows of a query selecting waitlistColumns
+func scanWaitlist(rows *sql.Rows) ([]models.WaitlistEntry, error) {
+	defer rows.Close()
+
+	var entries []models.WaitlistEntry
+	for rows.Next() {
+		e, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// waitlistRoom is the room_id of a waitlist entry, null when the guest waits for any room
+func waitlistRoom(e models.WaitlistEntry) sql.NullInt64 {
+	if e.RoomId > 0 {
+		return sql.NullInt64{Int64: int64(e.RoomId), Valid: true}
+	}
+
+	return sql.NullInt64{}
+}
+
+// waitingQuery selects the entries still waiting for a stay with nights between $1 and $2, excluded,
+// in the room $3 or in any room, in the order guests joined the waitlist
+const waitingQuery = `select ` + waitlistColumns + `
+	from waitlist
+	where notified_at is null and start_date < $2 and end_date > $1 and (room_id is null or room_id = $3)
+	order by created_at, id`
+
+func (m *postgresDbRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.App)
+}
+
+func (m *sqliteDbRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.App)
+}
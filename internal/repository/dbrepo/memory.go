@@ -0,0 +1,1629 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/i18n"
+	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials of the administrator created by NewDemoRepo
+const (
+	DemoEmail    = "admin@admin.com"
+	DemoPassword = "password"
+)
+
+// newMemoryRepo creates the tables and seeds them as the migrations do
+func newMemoryRepo(a *config.AppConfig) *memoryDbRepo {
+	m := &memoryDbRepo{
+		App:              a,
+		users:            make(map[int]models.User),
+		rooms:            make(map[int]models.Room),
+		restrictions:     make(map[int]models.Restriction),
+		reservations:     make(map[int]models.Reservation),
+		deleted:          make(map[int]time.Time),
+		roomRestrictions: make(map[int]models.RoomRestriction),
+		payments:         make(map[int]models.Payment),
+		promotions:       make(map[int]models.Promotion),
+		invoices:         make(map[int]models.Invoice),
+		taxRules:         make(map[int]models.TaxRule),
+		waitlist:         make(map[int]models.WaitlistEntry),
+		lastId:           make(map[string]int),
+	}
+
+	rooms := []models.Room{
+		{RoomName: "General's Quarters", MaxOccupancy: 2, NightlyRate: 9000, DepositPercent: 30, FreeCancellationDays: 7, CancellationPercent: 20},
+		{RoomName: "Major's Suite", MaxOccupancy: 4, NightlyRate: 15000, DepositPercent: 30, FreeCancellationDays: 14, CancellationPercent: 30},
+	}
+	for _, room := range rooms {
+		room.ID = m.nextId("rooms")
+		room.CreatedAt = time.Now()
+		room.UpdatedAt = time.Now()
+		m.rooms[room.ID] = room
+	}
+
+	for _, name := range []string{"Reservation", "Owner Block", "Hold"} {
+		id := m.nextId("restrictions")
+		m.restrictions[id] = models.Restriction{ID: id, RestrictionName: name, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	}
+
+	return m
+}
+
+// NewDemoRepo returns an in memory repository with an administrator and a few reservations around today
+func NewDemoRepo(a *config.AppConfig) (repository.DatabaseRepo, error) {
+	m := newMemoryRepo(a)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(DemoPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	id := m.nextId("users")
+	m.users[id] = models.User{
+		ID:          id,
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       DemoEmail,
+		Password:    string(hashedPassword),
+		AccessLevel: 3,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	today := dateOnly(time.Now())
+	guests := []struct {
+		firstName, lastName string
+		roomId              int
+		from, nights        int
+		processed           int
+	}{
+		{"John", "Smith", 1, -2, 3, 1},
+		{"Jane", "Doe", 2, 1, 2, 0},
+		{"Mario", "Rossi", 1, 7, 4, 0},
+		{"Anna", "Bianchi", 2, 10, 5, 1},
+	}
+
+	ctx := context.Background()
+	for _, g := range guests {
+		start := today.AddDate(0, 0, g.from)
+		end := start.AddDate(0, 0, g.nights)
+
+		resId, err := m.InsertReservation(ctx, models.Reservation{
+			FirstName: g.firstName,
+			LastName:  g.lastName,
+			Email:     fmt.Sprintf("%s@%s.com", g.firstName, g.lastName),
+			Phone:     "555-0100",
+			StartDate: start,
+			EndDate:   end,
+			RoomId:    g.roomId,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.InsertRoomRestriction(ctx, models.RoomRestriction{
+			StartDate:     start,
+			EndDate:       end,
+			RoomId:        g.roomId,
+			ReservationId: resId,
+			RestrictionId: models.RestrictionReservation,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.UpdatedProcessedForReservation(ctx, resId, g.processed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.AddBlockForRoom(ctx, 2, today.AddDate(0, 0, 5))
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// nextId returns the next value of the table's id sequence, the caller holds the lock
+func (m *memoryDbRepo) nextId(table string) int {
+	m.lastId[table]++
+	return m.lastId[table]
+}
+
+// dateOnly drops the time of day, as a DATE column does
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// reservationTaxes returns a copy of the taxes of a reservation as they are stored, the caller holds the lock
+func (m *memoryDbRepo) reservationTaxes(reservationId int, taxes []models.ReservationTax) []models.ReservationTax {
+	var stored []models.ReservationTax
+	for _, t := range taxes {
+		t.ID = m.nextId("reservation_taxes")
+		t.ReservationId = reservationId
+		t.CreatedAt = time.Now()
+		t.UpdatedAt = time.Now()
+		stored = append(stored, t)
+	}
+
+	return stored
+}
+
+// overlaps reports whether a stay from start to end overlaps a restriction under the configured policy
+func (m *memoryDbRepo) overlaps(start, end time.Time, r models.RoomRestriction) bool {
+	return overlapPolicy(m.App).OverlapsRestriction(dateOnly(start), dateOnly(end), r)
+}
+
+// takes reports whether a restriction takes its room for a stay from start to end at the time now,
+// as the overlap and activeRestriction conditions do
+func (m *memoryDbRepo) takes(start, end time.Time, r models.RoomRestriction, now time.Time) bool {
+	return (r.ExpiresAt.IsZero() || r.ExpiresAt.After(now)) && m.overlaps(start, end, r)
+}
+
+// withRoom joins the room of a reservation, as the list queries do
+func (m *memoryDbRepo) withRoom(res models.Reservation) models.Reservation {
+	room := m.rooms[res.RoomId]
+	res.Room = models.Room{ID: room.ID, RoomName: room.RoomName, MaxOccupancy: room.MaxOccupancy,
+		NightlyRate: room.NightlyRate, DepositPercent: room.DepositPercent, DepositFixed: room.DepositFixed,
+		FreeCancellationDays: room.FreeCancellationDays, CancellationPercent: room.CancellationPercent}
+	return res
+}
+
+// sortedReservations returns the reservations that are not deleted and match keep,
+// by start date with the rooms of a group next to each other
+func (m *memoryDbRepo) sortedReservations(keep func(models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for id, res := range m.reservations {
+		if _, deleted := m.deleted[id]; deleted || !keep(res) {
+			continue
+		}
+		reservations = append(reservations, m.withRoom(res))
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		a, b := reservations[i], reservations[j]
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.Before(b.StartDate)
+		}
+		if a.GroupId != b.GroupId {
+			return a.GroupId < b.GroupId
+		}
+		return a.ID < b.ID
+	})
+
+	return reservations
+}
+
+func (m *memoryDbRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+// InsertReservation inserts a reservation into the database, with its taxes
+func (m *memoryDbRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertReservation(res)
+}
+
+// insertReservation inserts a reservation and its taxes, the caller holds the lock
+func (m *memoryDbRepo) insertReservation(res models.Reservation) (int, error) {
+	if _, ok := m.rooms[res.RoomId]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", res.RoomId)
+	}
+
+	if res.Status == "" {
+		res.Status = models.ReservationStatusPending
+	}
+
+	if res.Language == "" {
+		res.Language = i18n.Default
+	}
+
+	if res.Adults < 1 {
+		res.Adults = 1
+	}
+
+	res.ID = m.nextId("reservations")
+	res.StartDate = dateOnly(res.StartDate)
+	res.EndDate = dateOnly(res.EndDate)
+	res.Room = models.Room{}
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	res.Taxes = m.reservationTaxes(res.ID, res.Taxes)
+	m.reservations[res.ID] = res
+
+	return res.ID, nil
+}
+
+// InsertRoomRestriction inserts a room restriction into the databases,
+// it returns repository.ErrRoomNotAvailable if the room is already taken for those dates
+func (m *memoryDbRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	_, err := m.insertRestriction(ctx, r)
+	return err
+}
+
+// insertRestriction inserts a room restriction, or a hold when it expires, and returns its id
+func (m *memoryDbRepo) insertRestriction(ctx context.Context, r models.RoomRestriction) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertRestrictionLocked(r)
+}
+
+// insertRestrictionLocked inserts a room restriction if the room is free, the caller holds the lock
+func (m *memoryDbRepo) insertRestrictionLocked(r models.RoomRestriction) (int, error) {
+	if _, ok := m.rooms[r.RoomId]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", r.RoomId)
+	}
+	if _, ok := m.restrictions[r.RestrictionId]; !ok {
+		return 0, fmt.Errorf("restriction %d does not exist", r.RestrictionId)
+	}
+	if _, ok := m.reservations[r.ReservationId]; r.ReservationId != 0 && !ok {
+		return 0, fmt.Errorf("reservation %d does not exist", r.ReservationId)
+	}
+
+	// a block is checked as the day it blocks
+	start, end := overlapPolicy(m.App).Span(r)
+
+	now := time.Now()
+	for _, other := range m.roomRestrictions {
+		if other.RoomId == r.RoomId && m.takes(start, end, other, now) {
+			return 0, repository.ErrRoomNotAvailable
+		}
+	}
+
+	r.ID = m.nextId("room_restrictions")
+	r.StartDate = dateOnly(r.StartDate)
+	r.EndDate = dateOnly(r.EndDate)
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.roomRestrictions[r.ID] = r
+
+	return r.ID, nil
+}
+
+// InsertReservationGroup books several rooms together: it creates a group and inserts each line as a reservation
+// of that group, with its room restriction, in place of the holds of the guest. Nothing is booked unless every
+// room is free, it returns repository.ErrRoomNotAvailable otherwise. It returns the group id and the reservation ids.
+func (m *memoryDbRepo) InsertReservationGroup(ctx context.Context, lines []models.Reservation, holds []int) (int, []int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	released := make(map[int]bool)
+	for _, id := range holds {
+		released[id] = m.roomRestrictions[id].RestrictionId == models.RestrictionHold
+	}
+
+	// every line is checked before anything is written, against the rooms taken and the lines before it
+	now := time.Now()
+	var booked []models.RoomRestriction
+	for _, res := range lines {
+		if _, ok := m.rooms[res.RoomId]; !ok {
+			return 0, nil, fmt.Errorf("room %d does not exist", res.RoomId)
+		}
+
+		for _, other := range m.roomRestrictions {
+			if other.RoomId == res.RoomId && !released[other.ID] && m.takes(res.StartDate, res.EndDate, other, now) {
+				return 0, nil, repository.ErrRoomNotAvailable
+			}
+		}
+		for _, other := range booked {
+			if other.RoomId == res.RoomId && m.takes(res.StartDate, res.EndDate, other, now) {
+				return 0, nil, repository.ErrRoomNotAvailable
+			}
+		}
+
+		booked = append(booked, models.RoomRestriction{RoomId: res.RoomId,
+			StartDate: dateOnly(res.StartDate), EndDate: dateOnly(res.EndDate)})
+	}
+
+	for id, ok := range released {
+		if ok {
+			delete(m.roomRestrictions, id)
+		}
+	}
+
+	groupId := m.nextId("reservation_groups")
+	ids := make([]int, 0, len(lines))
+	for _, res := range lines {
+		res.GroupId = groupId
+		id, err := m.insertReservation(res)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, err = m.insertRestrictionLocked(models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomId:        res.RoomId,
+			ReservationId: id,
+			RestrictionId: models.RestrictionReservation,
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return groupId, ids, nil
+}
+
+// InsertBooking inserts a reservation and its room restriction together, releasing the hold holdId of the guest.
+// If the room is taken, nothing is changed and repository.ErrRoomNotAvailable is returned.
+func (m *memoryDbRepo) InsertBooking(ctx context.Context, res models.Reservation, holdId int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[res.RoomId]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", res.RoomId)
+	}
+
+	released := holdId > 0 && m.roomRestrictions[holdId].RestrictionId == models.RestrictionHold
+
+	now := time.Now()
+	for _, other := range m.roomRestrictions {
+		if other.RoomId == res.RoomId && !(released && other.ID == holdId) && m.takes(res.StartDate, res.EndDate, other, now) {
+			return 0, repository.ErrRoomNotAvailable
+		}
+	}
+
+	if released {
+		delete(m.roomRestrictions, holdId)
+	}
+
+	newId, err := m.insertReservation(res)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = m.insertRestrictionLocked(models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomId:        res.RoomId,
+		ReservationId: newId,
+		RestrictionId: models.RestrictionReservation,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// GetReservationsByGroup returns the reservations of a group that are not deleted, in the order they were booked
+func (m *memoryDbRepo) GetReservationsByGroup(ctx context.Context, groupId int) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reservations []models.Reservation
+	for id, res := range m.reservations {
+		if _, deleted := m.deleted[id]; deleted || groupId == 0 || res.GroupId != groupId {
+			continue
+		}
+		res.Taxes = slices.Clone(res.Taxes)
+		reservations = append(reservations, m.withRoom(res))
+	}
+
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].ID < reservations[j].ID })
+
+	return reservations, nil
+}
+
+// SearchAvailabilityByDatesByRoomId returns true if availability exists for a room Id and false if no availability exists
+func (m *memoryDbRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start time.Time, end time.Time, roomId int) (bool, error) {
+	return m.SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx, start, end, roomId, 0)
+}
+
+// SearchAvailabilityByDatesByRoomIdExcludingReservation returns true if availability exists for a room Id,
+// ignoring the restriction that belongs to the given reservation
+func (m *memoryDbRepo) SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx context.Context, start time.Time, end time.Time, roomId int, reservationId int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, r := range m.roomRestrictions {
+		if r.RoomId != roomId || (reservationId != 0 && r.ReservationId == reservationId) {
+			continue
+		}
+		if m.takes(start, end, r, now) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// SearchAvailabilityForAllRooms returns a list of available rooms for the given start and end date that can host the given number of guests
+func (m *memoryDbRepo) SearchAvailabilityForAllRooms(ctx context.Context, start time.Time, end time.Time, guests int) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	taken := make(map[int]bool)
+	for _, r := range m.roomRestrictions {
+		if m.takes(start, end, r, now) {
+			taken[r.RoomId] = true
+		}
+	}
+
+	var rooms []models.Room
+	for id, room := range m.rooms {
+		if !taken[id] && room.MaxOccupancy >= guests {
+			rooms = append(rooms, models.Room{ID: room.ID, RoomName: room.RoomName, MaxOccupancy: room.MaxOccupancy,
+				NightlyRate: room.NightlyRate, DepositPercent: room.DepositPercent, DepositFixed: room.DepositFixed,
+				FreeCancellationDays: room.FreeCancellationDays, CancellationPercent: room.CancellationPercent})
+		}
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+// AvailabilityCalendar returns the days of a room from from until the day before to, with the price of their night,
+// sql.ErrNoRows if the room does not exist
+func (m *memoryDbRepo) AvailabilityCalendar(ctx context.Context, roomId int, from, to time.Time) ([]models.CalendarDay, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room, ok := m.rooms[roomId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	var days []models.CalendarDay
+	for d := dateOnly(from); d.Before(dateOnly(to)); d = d.AddDate(0, 0, 1) {
+		day := models.CalendarDay{Date: d, Available: true, Price: room.NightlyRate}
+		for _, r := range m.roomRestrictions {
+			if r.RoomId == roomId && m.takes(d, d.AddDate(0, 0, 1), r, now) {
+				day.Available = false
+				break
+			}
+		}
+		days = append(days, day)
+	}
+
+	return days, nil
+}
+
+// SearchFlexibleAvailability returns the stays of the given nights that can be booked, arriving from from until to
+// included, in the rooms that can host the guests, by arrival and room
+func (m *memoryDbRepo) SearchFlexibleAvailability(ctx context.Context, from, to time.Time, nights, guests int) ([]models.StayOption, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rooms := make([]models.Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		if room.MaxOccupancy >= guests {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	now := time.Now()
+	var stays []models.StayOption
+	for d := dateOnly(from); !d.After(dateOnly(to)); d = d.AddDate(0, 0, 1) {
+		end := d.AddDate(0, 0, nights)
+	next:
+		for _, room := range rooms {
+			for _, r := range m.roomRestrictions {
+				if r.RoomId == room.ID && m.takes(d, end, r, now) {
+					continue next
+				}
+			}
+			stays = append(stays, models.StayOption{Room: room, StartDate: d, EndDate: end})
+		}
+	}
+
+	return stays, nil
+}
+
+// GetRoomById gets a room by id
+func (m *memoryDbRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+
+	return room, nil
+}
+
+// GetUserById returns a user by id
+func (m *memoryDbRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// UpdateUserById updates an user in the database
+func (m *memoryDbRepo) UpdateUserById(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[u.ID]
+	if !ok {
+		return nil
+	}
+
+	stored.FirstName = u.FirstName
+	stored.LastName = u.LastName
+	stored.Email = u.Email
+	stored.AccessLevel = u.AccessLevel
+	stored.UpdatedAt = time.Now()
+	m.users[u.ID] = stored
+
+	return nil
+}
+
+// Authenticate authenticates a user
+func (m *memoryDbRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Email != email {
+			continue
+		}
+
+		err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(testPassword))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return 0, "", errors.New("incorrect password")
+		} else if err != nil {
+			return 0, "", err
+		}
+
+		return u.ID, u.Password, nil
+	}
+
+	return 0, "", sql.ErrNoRows
+}
+
+// AllRooms returns a slice of all rooms
+func (m *memoryDbRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomName < rooms[j].RoomName })
+
+	return rooms, nil
+}
+
+// AllReservations returns a slice of all reservations
+func (m *memoryDbRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedReservations(func(models.Reservation) bool { return true }), nil
+}
+
+// AllNewReservations returns a slice of new reservations
+func (m *memoryDbRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedReservations(func(res models.Reservation) bool { return res.Processed == 0 }), nil
+}
+
+// GetReservationById retrieve from the database a reservation by its id, a deleted reservation is not found
+func (m *memoryDbRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res, ok := m.reservations[id]
+	if _, deleted := m.deleted[id]; !ok || deleted {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	res.Taxes = slices.Clone(res.Taxes)
+
+	return m.withRoom(res), nil
+}
+
+// UpdateReservation updates a reservation and keeps its room restriction in sync, its taxes are replaced
+func (m *memoryDbRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// a cancelled or deleted reservation can't be changed
+	res, ok := m.reservations[r.ID]
+	if _, deleted := m.deleted[r.ID]; !ok || deleted {
+		return sql.ErrNoRows
+	}
+	if res.Status == models.ReservationStatusCancelled {
+		return repository.ErrReservationCancelled
+	}
+	if _, ok := m.rooms[r.RoomId]; !ok {
+		return fmt.Errorf("room %d does not exist", r.RoomId)
+	}
+
+	// the reservation itself is not a conflict for its new dates
+	now := time.Now()
+	for _, other := range m.roomRestrictions {
+		if other.RoomId == r.RoomId && other.ReservationId != r.ID && m.takes(r.StartDate, r.EndDate, other, now) {
+			return repository.ErrRoomNotAvailable
+		}
+	}
+
+	res.FirstName = r.FirstName
+	res.LastName = r.LastName
+	res.Email = r.Email
+	res.Phone = r.Phone
+	res.StartDate = dateOnly(r.StartDate)
+	res.EndDate = dateOnly(r.EndDate)
+	res.RoomId = r.RoomId
+	res.Adults = r.Adults
+	res.Children = r.Children
+	res.SpecialRequests = r.SpecialRequests
+	res.Taxes = m.reservationTaxes(r.ID, r.Taxes)
+	res.UpdatedAt = time.Now()
+	m.reservations[r.ID] = res
+
+	for id, rr := range m.roomRestrictions {
+		if rr.ReservationId != r.ID {
+			continue
+		}
+		rr.StartDate = res.StartDate
+		rr.EndDate = res.EndDate
+		rr.RoomId = res.RoomId
+		rr.UpdatedAt = time.Now()
+		m.roomRestrictions[id] = rr
+	}
+
+	return nil
+}
+
+// DeleteReservation soft deletes a reservation by ID and frees its room
+func (m *memoryDbRepo) DeleteReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+
+	m.deleted[id] = time.Now()
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+	m.freeRoom(id)
+
+	return nil
+}
+
+// freeRoom deletes the room restrictions of a reservation, the caller holds the lock
+func (m *memoryDbRepo) freeRoom(reservationId int) {
+	for id, rr := range m.roomRestrictions {
+		if rr.ReservationId == reservationId {
+			delete(m.roomRestrictions, id)
+		}
+	}
+}
+
+// UpdatedProcessedForReservation updates processed value for a reservation,
+// a pending reservation marked as processed becomes confirmed
+func (m *memoryDbRepo) UpdatedProcessedForReservation(ctx context.Context, id int, processed int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+
+	res.Processed = processed
+	if processed == 1 && res.Status == models.ReservationStatusPending {
+		res.Status = models.ReservationStatusConfirmed
+	}
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	return nil
+}
+
+// UpdateReservationStatus sets the status of a reservation, cancelled and no-show reservations free their room
+func (m *memoryDbRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+
+	res.Status = status
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	if status == models.ReservationStatusCancelled || status == models.ReservationStatusNoShow {
+		m.freeRoom(id)
+	}
+
+	return nil
+}
+
+// GetRestrictionsForRoomByDate gets a slice of room restriction given a room id,
+// that overlap the period from startDate to endDate. Holds are left out, they are not managed by staff.
+func (m *memoryDbRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var restrictions []models.RoomRestriction
+	for _, r := range m.roomRestrictions {
+		if r.RoomId == roomId && r.ExpiresAt.IsZero() && m.overlaps(startDate, endDate, r) {
+			restrictions = append(restrictions, models.RoomRestriction{
+				ID:            r.ID,
+				ReservationId: r.ReservationId,
+				RestrictionId: r.RestrictionId,
+				RoomId:        r.RoomId,
+				StartDate:     r.StartDate,
+				EndDate:       r.EndDate,
+				Reservation:   models.Reservation{GroupId: m.reservations[r.ReservationId].GroupId},
+			})
+		}
+	}
+
+	sort.Slice(restrictions, func(i, j int) bool {
+		if restrictions[i].StartDate.Equal(restrictions[j].StartDate) {
+			return restrictions[i].ID < restrictions[j].ID
+		}
+		return restrictions[i].StartDate.Before(restrictions[j].StartDate)
+	})
+
+	return restrictions, nil
+}
+
+// AddBlock inserts a room restriction (block) into the database, a block holds the room for the night of date
+func (m *memoryDbRepo) AddBlockForRoom(ctx context.Context, roomId int, date time.Time) error {
+	return m.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     date,
+		EndDate:       date.AddDate(0, 0, 1),
+		RoomId:        roomId,
+		RestrictionId: models.RestrictionOwnerBlock,
+	})
+}
+
+// DeleteBlockById deletes a room restriction (block) into the database
+func (m *memoryDbRepo) DeleteBlockById(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.roomRestrictions, id)
+
+	return nil
+}
+
+// InsertHold holds a room for a guest until r.ExpiresAt and returns the id of the hold,
+// it returns repository.ErrRoomNotAvailable if the room is already taken for those dates
+func (m *memoryDbRepo) InsertHold(ctx context.Context, r models.RoomRestriction) (int, error) {
+	if r.ExpiresAt.IsZero() {
+		return 0, errors.New("a hold needs an expiry")
+	}
+
+	r.ReservationId = 0
+	r.RestrictionId = models.RestrictionHold
+
+	return m.insertRestriction(ctx, r)
+}
+
+// ConvertHold turns a hold into the restriction of the reservation r.ReservationId. The hold must not have expired
+// and must be for the room and dates of r, otherwise repository.ErrHoldExpired is returned.
+func (m *memoryDbRepo) ConvertHold(ctx context.Context, holdId int, r models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold, ok := m.roomRestrictions[holdId]
+	if !ok || hold.RestrictionId != models.RestrictionHold || hold.RoomId != r.RoomId ||
+		!hold.StartDate.Equal(dateOnly(r.StartDate)) || !hold.EndDate.Equal(dateOnly(r.EndDate)) || !hold.ExpiresAt.After(time.Now()) {
+		return repository.ErrHoldExpired
+	}
+
+	hold.ReservationId = r.ReservationId
+	hold.RestrictionId = models.RestrictionReservation
+	hold.ExpiresAt = time.Time{}
+	hold.UpdatedAt = time.Now()
+	m.roomRestrictions[holdId] = hold
+
+	return nil
+}
+
+// DeleteHold releases a hold, it does nothing if the hold was already converted or removed
+func (m *memoryDbRepo) DeleteHold(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.roomRestrictions[id].RestrictionId == models.RestrictionHold {
+		delete(m.roomRestrictions, id)
+	}
+
+	return nil
+}
+
+// DeleteExpiredHolds removes the holds expired at now and returns how many were removed
+func (m *memoryDbRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, r := range m.roomRestrictions {
+		if r.RestrictionId == models.RestrictionHold && !r.ExpiresAt.After(now) {
+			delete(m.roomRestrictions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// InsertAuditLog records a change made to an entity
+func (m *memoryDbRepo) InsertAuditLog(ctx context.Context, a models.AuditLog) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a.ID = m.nextId("audit_log")
+	a.User = models.User{}
+	a.CreatedAt = time.Now()
+	a.UpdatedAt = time.Now()
+	m.auditLogs = append(m.auditLogs, a)
+
+	return nil
+}
+
+// GetAuditLogsForEntity returns the audit trail of an entity, newest first
+func (m *memoryDbRepo) GetAuditLogsForEntity(ctx context.Context, entity string, entityId int) ([]models.AuditLog, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var logs []models.AuditLog
+	for i := len(m.auditLogs) - 1; i >= 0; i-- {
+		a := m.auditLogs[i]
+		if a.Entity != entity || a.EntityId != entityId {
+			continue
+		}
+
+		u := m.users[a.UserId]
+		a.User = models.User{ID: a.UserId, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email}
+		logs = append(logs, a)
+	}
+
+	return logs, nil
+}
+
+// InsertPayment records a payment of a reservation
+func (m *memoryDbRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.reservations[p.ReservationId]; !ok {
+		return 0, fmt.Errorf("reservation %d does not exist", p.ReservationId)
+	}
+
+	p.ID = m.nextId("payments")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.payments[p.ID] = p
+
+	return p.ID, nil
+}
+
+// UpdatePaymentStatus sets the status of a payment
+func (m *memoryDbRepo) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.payments[id]
+	if !ok {
+		return nil
+	}
+
+	p.Status = status
+	p.UpdatedAt = time.Now()
+	m.payments[id] = p
+
+	return nil
+}
+
+// RefundPayment records that amount of a payment was given back, the payment becomes refunded
+func (m *memoryDbRepo) RefundPayment(ctx context.Context, id int, amount int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.payments[id]
+	if !ok {
+		return nil
+	}
+
+	p.Status = models.PaymentStatusRefunded
+	p.Refunded += amount
+	p.UpdatedAt = time.Now()
+	m.payments[id] = p
+
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments of a reservation, oldest first
+func (m *memoryDbRepo) GetPaymentsForReservation(ctx context.Context, reservationId int) ([]models.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var payments []models.Payment
+	for _, p := range m.payments {
+		if p.ReservationId == reservationId {
+			payments = append(payments, p)
+		}
+	}
+
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].ID < payments[j].ID
+	})
+
+	return payments, nil
+}
+
+// GetPaymentByReference returns a payment by the reference given to it by a gateway
+func (m *memoryDbRepo) GetPaymentByReference(ctx context.Context, gateway, reference string) (models.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Payment{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, p := range m.payments {
+		if p.Gateway == gateway && p.Reference == reference {
+			return p, nil
+		}
+	}
+
+	return models.Payment{}, sql.ErrNoRows
+}
+
+// AllPromotions returns every promotion, ordered by code
+func (m *memoryDbRepo) AllPromotions(ctx context.Context) ([]models.Promotion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var promotions []models.Promotion
+	for _, p := range m.promotions {
+		promotions = append(promotions, p)
+	}
+
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].Code < promotions[j].Code })
+
+	return promotions, nil
+}
+
+// GetPromotionById returns a promotion by id
+func (m *memoryDbRepo) GetPromotionById(ctx context.Context, id int) (models.Promotion, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Promotion{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.promotions[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+// GetPromotionByCode returns a promotion by its code, ignoring case
+func (m *memoryDbRepo) GetPromotionByCode(ctx context.Context, code string) (models.Promotion, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Promotion{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	code = promotionCode(code)
+	for _, p := range m.promotions {
+		if p.Code == code {
+			return p, nil
+		}
+	}
+
+	return models.Promotion{}, sql.ErrNoRows
+}
+
+// codeTaken reports whether a promotion other than id has the code, as the unique index of the table does
+func (m *memoryDbRepo) codeTaken(code string, id int) bool {
+	for _, p := range m.promotions {
+		if p.Code == code && p.ID != id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InsertPromotion inserts a promotion, its code is stored in upper case
+func (m *memoryDbRepo) InsertPromotion(ctx context.Context, p models.Promotion) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.Code = promotionCode(p.Code)
+	if m.codeTaken(p.Code, 0) {
+		return 0, fmt.Errorf("promotion code %s already exists", p.Code)
+	}
+
+	p.ID = m.nextId("promotions")
+	p.ValidFrom = dateOnly(p.ValidFrom)
+	p.ValidTo = dateOnly(p.ValidTo)
+	p.Uses = 0
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.promotions[p.ID] = p
+
+	return p.ID, nil
+}
+
+// UpdatePromotion updates a promotion, the number of times it was used is left as it is
+func (m *memoryDbRepo) UpdatePromotion(ctx context.Context, p models.Promotion) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.promotions[p.ID]
+	if !ok {
+		return nil
+	}
+
+	p.Code = promotionCode(p.Code)
+	if m.codeTaken(p.Code, p.ID) {
+		return fmt.Errorf("promotion code %s already exists", p.Code)
+	}
+
+	p.ValidFrom = dateOnly(p.ValidFrom)
+	p.ValidTo = dateOnly(p.ValidTo)
+	p.Uses = existing.Uses
+	p.CreatedAt = existing.CreatedAt
+	p.UpdatedAt = time.Now()
+	m.promotions[p.ID] = p
+
+	return nil
+}
+
+// DeletePromotion deletes a promotion, reservations keep the code they were booked with
+func (m *memoryDbRepo) DeletePromotion(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.promotions, id)
+
+	return nil
+}
+
+// UsePromotion counts a use of a promotion, it returns repository.ErrPromotionUsedUp if the promotion
+// has reached its maximum number of uses
+func (m *memoryDbRepo) UsePromotion(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.promotions[id]
+	if !ok || p.UsedUp() {
+		return repository.ErrPromotionUsedUp
+	}
+
+	p.Uses++
+	p.UpdatedAt = time.Now()
+	m.promotions[id] = p
+
+	return nil
+}
+
+// ReleasePromotion gives back a use of a promotion, for a reservation that could not be completed
+func (m *memoryDbRepo) ReleasePromotion(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.promotions[id]
+	if !ok || p.Uses == 0 {
+		return nil
+	}
+
+	p.Uses--
+	p.UpdatedAt = time.Now()
+	m.promotions[id] = p
+
+	return nil
+}
+
+// AllTaxRules returns every tax rule, ordered by name
+func (m *memoryDbRepo) AllTaxRules(ctx context.Context) ([]models.TaxRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rules []models.TaxRule
+	for _, t := range m.taxRules {
+		rules = append(rules, t)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Name == rules[j].Name {
+			return rules[i].ID < rules[j].ID
+		}
+		return rules[i].Name < rules[j].Name
+	})
+
+	return rules, nil
+}
+
+// GetTaxRuleById returns a tax rule by id
+func (m *memoryDbRepo) GetTaxRuleById(ctx context.Context, id int) (models.TaxRule, error) {
+	if err := ctx.Err(); err != nil {
+		return models.TaxRule{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.taxRules[id]
+	if !ok {
+		return t, sql.ErrNoRows
+	}
+
+	return t, nil
+}
+
+// InsertTaxRule inserts a tax rule
+func (m *memoryDbRepo) InsertTaxRule(ctx context.Context, t models.TaxRule) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = m.nextId("tax_rules")
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = time.Now()
+	m.taxRules[t.ID] = t
+
+	return t.ID, nil
+}
+
+// UpdateTaxRule updates a tax rule, the taxes already charged on reservations are left as they are
+func (m *memoryDbRepo) UpdateTaxRule(ctx context.Context, t models.TaxRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.taxRules[t.ID]
+	if !ok {
+		return nil
+	}
+
+	t.CreatedAt = existing.CreatedAt
+	t.UpdatedAt = time.Now()
+	m.taxRules[t.ID] = t
+
+	return nil
+}
+
+// DeleteTaxRule deletes a tax rule, reservations keep the taxes they were charged
+func (m *memoryDbRepo) DeleteTaxRule(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.taxRules, id)
+
+	// the taxes charged lose their rule, as the foreign key of the table does
+	for resId, res := range m.reservations {
+		taxes := slices.Clone(res.Taxes)
+		for i := range taxes {
+			if taxes[i].TaxRuleId == id {
+				taxes[i].TaxRuleId = 0
+			}
+		}
+		res.Taxes = taxes
+		m.reservations[resId] = res
+	}
+
+	return nil
+}
+
+// AllReservationTaxes returns the taxes of the reservations that are not deleted, by reservation
+func (m *memoryDbRepo) AllReservationTaxes(ctx context.Context) ([]models.ReservationTax, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var taxes []models.ReservationTax
+	for id, res := range m.reservations {
+		if _, deleted := m.deleted[id]; !deleted {
+			taxes = append(taxes, res.Taxes...)
+		}
+	}
+
+	sort.Slice(taxes, func(i, j int) bool {
+		if taxes[i].ReservationId == taxes[j].ReservationId {
+			return taxes[i].ID < taxes[j].ID
+		}
+		return taxes[i].ReservationId < taxes[j].ReservationId
+	})
+
+	return taxes, nil
+}
+
+// TaxReport returns what each tax rule charged on the stays starting from start until the day before end,
+// cancelled stays and no-shows are left out
+func (m *memoryDbRepo) TaxReport(ctx context.Context, start, end time.Time) ([]models.TaxReportLine, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type key struct {
+		ruleId int
+		name   string
+	}
+	lines := make(map[key]*models.TaxReportLine)
+	counted := make(map[key]map[int]bool)
+
+	start, end = dateOnly(start), dateOnly(end)
+	for id, res := range m.reservations {
+		if _, deleted := m.deleted[id]; deleted || res.Status == models.ReservationStatusCancelled ||
+			res.Status == models.ReservationStatusNoShow || res.StartDate.Before(start) || !res.StartDate.Before(end) {
+			continue
+		}
+
+		for _, t := range res.Taxes {
+			k := key{t.TaxRuleId, t.Name}
+			if lines[k] == nil {
+				lines[k] = &models.TaxReportLine{TaxRuleId: t.TaxRuleId, Name: t.Name}
+				counted[k] = make(map[int]bool)
+			}
+			if !counted[k][id] {
+				counted[k][id] = true
+				lines[k].Reservations++
+			}
+			lines[k].Quantity += t.Quantity
+			lines[k].Amount += t.Amount
+		}
+	}
+
+	var report []models.TaxReportLine
+	for _, l := range lines {
+		report = append(report, *l)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Name == report[j].Name {
+			return report[i].TaxRuleId < report[j].TaxRuleId
+		}
+		return report[i].Name < report[j].Name
+	})
+
+	return report, nil
+}
+
+// IssueInvoice returns the invoice of a reservation, issuing it with the next number if the reservation has none
+func (m *memoryDbRepo) IssueInvoice(ctx context.Context, reservationId int, issuedAt time.Time) (models.Invoice, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Invoice{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if inv, ok := m.invoices[reservationId]; ok {
+		return inv, nil
+	}
+
+	if _, ok := m.reservations[reservationId]; !ok {
+		return models.Invoice{}, fmt.Errorf("reservation %d does not exist", reservationId)
+	}
+
+	inv := models.Invoice{
+		ID:            m.nextId("invoices"),
+		ReservationId: reservationId,
+		Number:        len(m.invoices) + 1,
+		IssuedAt:      issuedAt,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	m.invoices[reservationId] = inv
+
+	return inv, nil
+}
+
+// GetInvoiceForReservation returns the invoice of a reservation, sql.ErrNoRows if none was issued
+func (m *memoryDbRepo) GetInvoiceForReservation(ctx context.Context, reservationId int) (models.Invoice, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Invoice{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	inv, ok := m.invoices[reservationId]
+	if !ok {
+		return inv, sql.ErrNoRows
+	}
+
+	return inv, nil
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist
+func (m *memoryDbRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = m.nextId("waitlist")
+	e.StartDate = dateOnly(e.StartDate)
+	e.EndDate = dateOnly(e.EndDate)
+	e.Token, e.NotifiedAt, e.ExpiresAt = "", time.Time{}, time.Time{}
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = time.Now()
+	m.waitlist[e.ID] = e
+
+	return e.ID, nil
+}
+
+// WaitingForDates returns the guests still waiting for a stay with nights between start and end, excluded,
+// in the given room or in any room. The guest who joined first comes first.
+func (m *memoryDbRepo) WaitingForDates(ctx context.Context, start, end time.Time, roomId int) ([]models.WaitlistEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	start, end = dateOnly(start), dateOnly(end)
+
+	var entries []models.WaitlistEntry
+	for _, e := range m.waitlist {
+		if e.NotifiedAt.IsZero() && e.StartDate.Before(end) && e.EndDate.After(start) && (e.RoomId == 0 || e.RoomId == roomId) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	return entries, nil
+}
+
+// OfferWaitlistEntry records that a guest has been sent a booking link with token, valid until expiresAt.
+// A guest is offered a stay once, it fails with sql.ErrNoRows if the guest was offered one already.
+func (m *memoryDbRepo) OfferWaitlistEntry(ctx context.Context, id int, token string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.waitlist[id]
+	if !ok || !e.NotifiedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	e.Token = token
+	e.NotifiedAt = time.Now()
+	e.ExpiresAt = expiresAt
+	e.UpdatedAt = time.Now()
+	m.waitlist[id] = e
+
+	return nil
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry a booking link was sent for
+func (m *memoryDbRepo) GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return models.WaitlistEntry{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, e := range m.waitlist {
+		if token != "" && e.Token == token {
+			return e, nil
+		}
+	}
+
+	return models.WaitlistEntry{}, sql.ErrNoRows
+}
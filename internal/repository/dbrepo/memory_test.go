@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/repository"
+	"github.com/AlessioPani/go-booking/internal/repository/repotest"
+)
+
+func TestMemory_Contract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryRepo(&config.AppConfig{})
+	})
+	repotest.RunPolicies(t, func(t *testing.T, policy repository.OverlapPolicy) repository.DatabaseRepo {
+		return NewMemoryRepo(&config.AppConfig{Overlap: policy})
+	})
+}
+
+func TestMemory_DemoRepo(t *testing.T) {
+	repo, err := NewDemoRepo(&config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	id, _, err := repo.Authenticate(ctx, DemoEmail, DemoPassword)
+	if err != nil {
+		t.Fatalf("expected the demo administrator to log in: %v", err)
+	}
+
+	u, err := repo.GetUserById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.AccessLevel != 3 {
+		t.Errorf("expected an administrator, got access level %d", u.AccessLevel)
+	}
+
+	_, _, err = repo.Authenticate(ctx, DemoEmail, "wrong")
+	if err == nil {
+		t.Error("expected a wrong password to be refused")
+	}
+
+	reservations, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) == 0 {
+		t.Error("expected seeded reservations")
+	}
+
+	newReservations, err := repo.AllNewReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newReservations) == 0 || len(newReservations) == len(reservations) {
+		t.Errorf("expected some of the %d reservations to be new, got %d", len(reservations), len(newReservations))
+	}
+}
+
+func TestMemory_CancelledContext(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.AllReservations(ctx)
+	if err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
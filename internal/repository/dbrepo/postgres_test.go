@@ -0,0 +1,56 @@
+package dbrepo
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/driver"
+	"github.com/AlessioPani/go-booking/internal/migrate"
+	"github.com/AlessioPani/go-booking/internal/repository"
+	"github.com/AlessioPani/go-booking/internal/repository/repotest"
+	"github.com/AlessioPani/go-booking/migrations"
+)
+
+// postgresTestDSN names the environment variable with the connection string of a throwaway database,
+// the tests truncate its tables
+const postgresTestDSN = "BOOKINGS_TEST_POSTGRES_DSN"
+
+func TestPostgres_Contract(t *testing.T) {
+	dsn := os.Getenv(postgresTestDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresTestDSN)
+	}
+
+	conn, err := driver.NewDatabase(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m, err := migrate.New(conn, migrations.FS, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRepo := func(t *testing.T, policy repository.OverlapPolicy) repository.DatabaseRepo {
+		_, err := conn.Exec(`truncate audit_log, payments, room_restrictions, reservations, promotions, tax_rules, waitlist, reservation_groups restart identity cascade`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return NewPostgresRepo(conn, &config.AppConfig{Overlap: policy})
+	}
+
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return newRepo(t, repository.HalfOpen)
+	})
+	repotest.RunPolicies(t, newRepo)
+}
@@ -0,0 +1,1717 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlessioPani/go-booking/internal/i18n"
+	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed sqlite_schema.sql
+var sqliteSchema string
+
+// sqliteDateLayout is the layout used to store DATE columns, so that range comparisons work on the stored text
+const sqliteDateLayout = "2006-01-02"
+
+// sqliteTimeLayout is the layout used to store the TIMESTAMP columns that are compared, always in UTC
+const sqliteTimeLayout = "2006-01-02 15:04:05.000"
+
+// sqliteColumns are the columns added to tables after they were first created,
+// InitSqliteSchema adds them to databases created before
+var sqliteColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"reservations", "language", "TEXT NOT NULL DEFAULT 'en'"},
+	{"rooms", "max_occupancy", "INTEGER NOT NULL DEFAULT 2"},
+	{"reservations", "adults", "INTEGER NOT NULL DEFAULT 1"},
+	{"reservations", "children", "INTEGER NOT NULL DEFAULT 0"},
+	{"reservations", "special_requests", "TEXT NOT NULL DEFAULT ''"},
+	{"rooms", "nightly_rate", "INTEGER NOT NULL DEFAULT 0"},
+	{"rooms", "deposit_percent", "INTEGER NOT NULL DEFAULT 0"},
+	{"rooms", "deposit_fixed", "INTEGER NOT NULL DEFAULT 0"},
+	{"room_restrictions", "expires_at", "TIMESTAMP"},
+	{"rooms", "free_cancellation_days", "INTEGER NOT NULL DEFAULT 0"},
+	{"rooms", "cancellation_percent", "INTEGER NOT NULL DEFAULT 0"},
+	{"payments", "refunded", "INTEGER NOT NULL DEFAULT 0"},
+	{"reservations", "promo_code", "TEXT NOT NULL DEFAULT ''"},
+	{"reservations", "discount", "INTEGER NOT NULL DEFAULT 0"},
+	{"reservations", "group_id", "INTEGER"},
+}
+
+// InitSqliteSchema creates the tables and the seed rows of a SQLite database, it is safe to run on every start
+func InitSqliteSchema(ctx context.Context, conn *sql.DB) error {
+	// existing tables get their new columns first, the seed rows may need them
+	for _, c := range sqliteColumns {
+		var columns, found int
+		err := conn.QueryRowContext(ctx, `select count(*), coalesce(sum(name = $2), 0) from pragma_table_info($1)`, c.table, c.column).Scan(&columns, &found)
+		if err != nil {
+			return err
+		}
+
+		if columns == 0 || found > 0 {
+			continue
+		}
+
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.ExecContext(ctx, sqliteSchema)
+	return err
+}
+
+// sqliteDate formats a date for a DATE column
+func sqliteDate(t time.Time) string {
+	return t.Format(sqliteDateLayout)
+}
+
+// sqliteTime formats an instant for a TIMESTAMP column that is compared
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func (m *sqliteDbRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+// InsertReservation inserts a reservation into the database, with its taxes
+func (m *sqliteDbRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newId, err := insertSqliteReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, tx.Commit()
+}
+
+// insertSqliteReservation inserts a reservation and its taxes within tx
+func insertSqliteReservation(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var newId int
+
+	// created_by is only set for reservations made by staff
+	var createdBy sql.NullInt64
+	if res.CreatedBy > 0 {
+		createdBy = sql.NullInt64{Int64: int64(res.CreatedBy), Valid: true}
+	}
+
+	status := res.Status
+	if status == "" {
+		status = models.ReservationStatusPending
+	}
+
+	language := res.Language
+	if language == "" {
+		language = i18n.Default
+	}
+
+	adults := res.Adults
+	if adults < 1 {
+		adults = 1
+	}
+
+	// group_id is only set for rooms booked together
+	var groupId sql.NullInt64
+	if res.GroupId > 0 {
+		groupId = sql.NullInt64{Int64: int64(res.GroupId), Valid: true}
+	}
+
+	stmt := `INSERT INTO reservations (first_name, last_name, email, phone, start_date, end_date, room_id, processed, created_by, status, language, adults, children, special_requests, promo_code, discount, group_id, created_at, updated_at) 
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19) returning id`
+
+	err := tx.QueryRowContext(ctx,
+		stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		sqliteDate(res.StartDate),
+		sqliteDate(res.EndDate),
+		res.RoomId,
+		res.Processed,
+		createdBy,
+		status,
+		language,
+		adults,
+		res.Children,
+		res.SpecialRequests,
+		res.PromoCode,
+		res.Discount,
+		groupId,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = replaceReservationTaxes(ctx, tx, newId, res.Taxes)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// InsertRoomRestriction inserts a room restriction into the databases,
+// it returns repository.ErrRoomNotAvailable if the room is already taken for those dates
+func (m *sqliteDbRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	_, err := m.insertRestriction(ctx, r)
+	return err
+}
+
+// insertRestriction inserts a room restriction, or a hold when it expires, and returns its id
+func (m *sqliteDbRepo) insertRestriction(ctx context.Context, r models.RoomRestriction) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newId, err := m.insertRestrictionTx(ctx, tx, r)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, tx.Commit()
+}
+
+// insertRestrictionTx inserts a room restriction within tx, if the room is free
+func (m *sqliteDbRepo) insertRestrictionTx(ctx context.Context, tx *sql.Tx, r models.RoomRestriction) (int, error) {
+	query := `select count(id) from room_restrictions where room_id = $1 and ` +
+		overlapPolicy(m.App).Condition("$2", "$3", "start_date", "end_date", "restriction_id") + ` and ` + activeRestriction("expires_at", "$4")
+
+	// a block is checked as the day it blocks
+	start, end := overlapPolicy(m.App).Span(r)
+
+	var numRows int
+	err := tx.QueryRowContext(ctx, query, r.RoomId, sqliteDate(start), sqliteDate(end), sqliteTime(time.Now())).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if numRows > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	// blocks and holds do not belong to a reservation
+	var reservationId sql.NullInt64
+	if r.ReservationId > 0 {
+		reservationId = sql.NullInt64{Int64: int64(r.ReservationId), Valid: true}
+	}
+
+	var expiresAt sql.NullString
+	if !r.ExpiresAt.IsZero() {
+		expiresAt = sql.NullString{String: sqliteTime(r.ExpiresAt), Valid: true}
+	}
+
+	stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, expires_at, created_at, updated_at) 
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var newId int
+	err = tx.QueryRowContext(ctx, stmt,
+		sqliteDate(r.StartDate),
+		sqliteDate(r.EndDate),
+		r.RoomId,
+		reservationId,
+		r.RestrictionId,
+		expiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// InsertReservationGroup books several rooms together: it creates a group and inserts each line as a reservation
+// of that group, with its room restriction, in place of the holds of the guest. Nothing is booked unless every
+// room is free, it returns repository.ErrRoomNotAvailable otherwise. It returns the group id and the reservation ids.
+func (m *sqliteDbRepo) InsertReservationGroup(ctx context.Context, lines []models.Reservation, holds []int) (int, []int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, holdId := range holds {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`, holdId, models.RestrictionHold)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var groupId int
+	err = tx.QueryRowContext(ctx, `insert into reservation_groups (created_at, updated_at) values ($1, $2) returning id`,
+		time.Now(), time.Now()).Scan(&groupId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ids := make([]int, 0, len(lines))
+	for _, res := range lines {
+		res.GroupId = groupId
+		id, err := insertSqliteReservation(ctx, tx, res)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, err = m.insertRestrictionTx(ctx, tx, models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomId:        res.RoomId,
+			ReservationId: id,
+			RestrictionId: models.RestrictionReservation,
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return groupId, ids, tx.Commit()
+}
+
+// InsertBooking inserts a reservation and its room restriction in one transaction, releasing the hold holdId of
+// the guest first. If the room was taken meanwhile, nothing is inserted and repository.ErrRoomNotAvailable is returned.
+func (m *sqliteDbRepo) InsertBooking(ctx context.Context, res models.Reservation, holdId int) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if holdId > 0 {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`, holdId, models.RestrictionHold)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	newId, err := insertSqliteReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = m.insertRestrictionTx(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomId:        res.RoomId,
+		ReservationId: newId,
+		RestrictionId: models.RestrictionReservation,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, tx.Commit()
+}
+
+// GetReservationsByGroup returns the reservations of a group that are not deleted, in the order they were booked
+func (m *sqliteDbRepo) GetReservationsByGroup(ctx context.Context, groupId int) ([]models.Reservation, error) {
+	ids, err := m.groupReservationIds(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservations []models.Reservation
+	for _, id := range ids {
+		res, err := m.GetReservationById(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, res)
+	}
+
+	return reservations, nil
+}
+
+// groupReservationIds returns the ids of the reservations of a group that are not deleted
+func (m *sqliteDbRepo) groupReservationIds(ctx context.Context, groupId int) ([]int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select id from reservations where group_id = $1 and deleted_at is null order by id`, groupId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// SearchAvailabilityByDatesByRoomId returns true if availability exists for a room Id and false if no availability exists
+func (m *sqliteDbRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start time.Time, end time.Time, roomId int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT count(id)
+			  FROM room_restrictions
+		      WHERE room_id = $1 and ` + overlapPolicy(m.App).Condition("$2", "$3", "start_date", "end_date", "restriction_id") +
+		` and ` + activeRestriction("expires_at", "$4")
+	var numRows int
+
+	row := m.DB.QueryRowContext(ctx, query, roomId, sqliteDate(start), sqliteDate(end), sqliteTime(time.Now()))
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	if numRows == 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// SearchAvailabilityByDatesByRoomIdExcludingReservation returns true if availability exists for a room Id,
+// ignoring the restriction that belongs to the given reservation
+func (m *sqliteDbRepo) SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx context.Context, start time.Time, end time.Time, roomId int, reservationId int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT count(id)
+			  FROM room_restrictions
+		      WHERE (reservation_id is null or reservation_id <> $4) and room_id = $1 and ` +
+		overlapPolicy(m.App).Condition("$2", "$3", "start_date", "end_date", "restriction_id") + ` and ` + activeRestriction("expires_at", "$5")
+	var numRows int
+
+	row := m.DB.QueryRowContext(ctx, query, roomId, sqliteDate(start), sqliteDate(end), reservationId, sqliteTime(time.Now()))
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	if numRows == 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// SearchAvailabilityForAllRooms returns a list of available rooms for the given start and end date that can host the given number of guests
+func (m *sqliteDbRepo) SearchAvailabilityForAllRooms(ctx context.Context, start time.Time, end time.Time, guests int) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT r.id, r.room_name, r.max_occupancy, r.nightly_rate, r.deposit_percent, r.deposit_fixed, r.free_cancellation_days, r.cancellation_percent
+			  FROM rooms r
+			  WHERE r.max_occupancy >= $3 AND r.id NOT IN (SELECT rr.room_id 
+			                     FROM room_restrictions rr 
+			                     WHERE ` + overlapPolicy(m.App).Condition("$1", "$2", "rr.start_date", "rr.end_date", "rr.restriction_id") +
+		` AND ` + activeRestriction("rr.expires_at", "$4") + `)
+			  ORDER BY r.id`
+
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, sqliteDate(start), sqliteDate(end), guests, sqliteTime(time.Now()))
+	if err != nil {
+		return rooms, err
+	}
+
+	for rows.Next() {
+		var room models.Room
+		err = rows.Scan(&room.ID, &room.RoomName, &room.MaxOccupancy, &room.NightlyRate, &room.DepositPercent, &room.DepositFixed, &room.FreeCancellationDays, &room.CancellationPercent)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err := rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// AvailabilityCalendar returns the days of a room from from until the day before to, with the price of their night,
+// sql.ErrNoRows if the room does not exist
+func (m *sqliteDbRepo) AvailabilityCalendar(ctx context.Context, roomId int, from, to time.Time) ([]models.CalendarDay, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `with recursive days(day) as (
+			      select $2 where $2 < $3
+			      union all
+			      select date(day, '+1 day') from days where date(day, '+1 day') < $3
+			  )
+			  select days.day, not exists (select 1
+			                               from room_restrictions rr
+			                               where rr.room_id = r.id and ` +
+		overlapPolicy(m.App).Condition("days.day", "date(days.day, '+1 day')", "rr.start_date", "rr.end_date", "rr.restriction_id") +
+		` and ` + activeRestriction("rr.expires_at", "$4") + `), r.nightly_rate
+			  from rooms r
+			  cross join days
+			  where r.id = $1
+			  order by days.day`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomId, sqliteDate(from), sqliteDate(to), sqliteTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []models.CalendarDay
+	for rows.Next() {
+		var d models.CalendarDay
+		var day string
+		err = rows.Scan(&day, &d.Available, &d.Price)
+		if err != nil {
+			return nil, err
+		}
+		d.Date, err = time.Parse(sqliteDateLayout, day)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return calendarOrNoRoom(days, from, to)
+}
+
+// SearchFlexibleAvailability returns the stays of the given nights that can be booked, arriving from from until to
+// included, in the rooms that can host the guests, by arrival and room
+func (m *sqliteDbRepo) SearchFlexibleAvailability(ctx context.Context, from, to time.Time, nights, guests int) ([]models.StayOption, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `with recursive days(day) as (
+			      select $1 where $1 <= $2
+			      union all
+			      select date(day, '+1 day') from days where date(day, '+1 day') <= $2
+			  )
+			  select days.day, r.id, r.room_name, r.max_occupancy, r.nightly_rate, r.deposit_percent, r.deposit_fixed,
+			         r.free_cancellation_days, r.cancellation_percent
+			  from rooms r
+			  cross join days
+			  where r.max_occupancy >= $4 and not exists (select 1
+			                                              from room_restrictions rr
+			                                              where rr.room_id = r.id and ` +
+		overlapPolicy(m.App).Condition("days.day", "date(days.day, '+' || $3 || ' days')", "rr.start_date", "rr.end_date", "rr.restriction_id") +
+		` and ` + activeRestriction("rr.expires_at", "$5") + `)
+			  order by days.day, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, sqliteDate(from), sqliteDate(to), nights, guests, sqliteTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stays []models.StayOption
+	for rows.Next() {
+		var s models.StayOption
+		var day string
+		err = rows.Scan(&day, &s.Room.ID, &s.Room.RoomName, &s.Room.MaxOccupancy, &s.Room.NightlyRate,
+			&s.Room.DepositPercent, &s.Room.DepositFixed, &s.Room.FreeCancellationDays, &s.Room.CancellationPercent)
+		if err != nil {
+			return nil, err
+		}
+		s.StartDate, err = time.Parse(sqliteDateLayout, day)
+		if err != nil {
+			return nil, err
+		}
+		s.EndDate = s.StartDate.AddDate(0, 0, nights)
+		stays = append(stays, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stays, nil
+}
+
+// GetRoomById gets a room by id
+func (m *sqliteDbRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, room_name, max_occupancy, nightly_rate, deposit_percent, deposit_fixed, free_cancellation_days, cancellation_percent, created_at, updated_at
+			  FROM rooms 
+			  WHERE id = $1
+	`
+
+	var room models.Room
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(&room.ID, &room.RoomName, &room.MaxOccupancy, &room.NightlyRate, &room.DepositPercent, &room.DepositFixed, &room.FreeCancellationDays, &room.CancellationPercent, &room.CreatedAt, &room.UpdatedAt)
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// GetUserById returns a user by id
+func (m *sqliteDbRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, first_name, last_name, email, password, access_level, created_at, updated_at
+			  FROM Users
+			  WHERE id = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	var u models.User
+
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// UpdateUserById updates an user in the database
+func (m *sqliteDbRepo) UpdateUserById(ctx context.Context, u models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, updated_at=$5 where id=$6`
+
+	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Authenticate authenticates a user
+func (m *sqliteDbRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password from users where email=$1", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+
+}
+
+// AllRooms returns a slice of all rooms
+func (m *sqliteDbRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `SELECT id, room_name, max_occupancy, nightly_rate, deposit_percent, deposit_fixed, free_cancellation_days, cancellation_percent, created_at, updated_at
+			  FROM rooms
+			  ORDER BY room_name asc
+			`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.Room
+		err := rows.Scan(
+			&item.ID,
+			&item.RoomName,
+			&item.MaxOccupancy,
+			&item.NightlyRate,
+			&item.DepositPercent,
+			&item.DepositFixed,
+			&item.FreeCancellationDays,
+			&item.CancellationPercent,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, item)
+
+		if err := rows.Err(); err != nil {
+			return rooms, err
+		}
+	}
+
+	return rooms, nil
+
+}
+
+// AllReservations returns a slice of all reservations
+func (m *sqliteDbRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+	                 r.created_at, r.updated_at, r.status, r.adults, r.children, r.promo_code, r.discount, coalesce(r.group_id, 0),
+	                 rm.id, rm.room_name, rm.nightly_rate
+			  FROM reservations r
+			  LEFT JOIN rooms rm on (r.room_id = rm.id)
+			  WHERE r.deleted_at is null
+			  ORDER BY r.start_date asc, coalesce(r.group_id, 0), r.id
+			`
+
+	row, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer row.Close()
+
+	for row.Next() {
+		var item models.Reservation
+		err := row.Scan(
+			&item.ID,
+			&item.FirstName,
+			&item.LastName,
+			&item.Email,
+			&item.Phone,
+			&item.StartDate,
+			&item.EndDate,
+			&item.RoomId,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.Status,
+			&item.Adults,
+			&item.Children,
+			&item.PromoCode,
+			&item.Discount,
+			&item.GroupId,
+			&item.Room.ID,
+			&item.Room.RoomName,
+			&item.Room.NightlyRate,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, item)
+
+		if err := row.Err(); err != nil {
+			return reservations, err
+		}
+	}
+
+	return reservations, nil
+
+}
+
+// AllNewReservations returns a slice of new reservations
+func (m *sqliteDbRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+	                 r.created_at, r.updated_at, r.processed, r.status, r.adults, r.children, r.promo_code, r.discount, coalesce(r.group_id, 0),
+	                 rm.id, rm.room_name, rm.nightly_rate
+			  FROM reservations r
+			  LEFT JOIN rooms rm on (r.room_id = rm.id)
+			  WHERE r.processed = 0 and r.deleted_at is null
+			  ORDER BY r.start_date asc, coalesce(r.group_id, 0), r.id
+			`
+
+	row, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer row.Close()
+
+	for row.Next() {
+		var item models.Reservation
+		err := row.Scan(
+			&item.ID,
+			&item.FirstName,
+			&item.LastName,
+			&item.Email,
+			&item.Phone,
+			&item.StartDate,
+			&item.EndDate,
+			&item.RoomId,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.Processed,
+			&item.Status,
+			&item.Adults,
+			&item.Children,
+			&item.PromoCode,
+			&item.Discount,
+			&item.GroupId,
+			&item.Room.ID,
+			&item.Room.RoomName,
+			&item.Room.NightlyRate,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, item)
+
+		if err := row.Err(); err != nil {
+			return reservations, err
+		}
+	}
+
+	return reservations, nil
+
+}
+
+// GetReservationById retrieve from the database a reservation by its id, a deleted reservation is not found
+func (m *sqliteDbRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
+	                 r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, 
+					 coalesce(r.created_by, 0), r.status, r.language, r.adults, r.children,
+	                 r.special_requests, r.promo_code, r.discount, coalesce(r.group_id, 0), rm.id, rm.room_name, rm.max_occupancy,
+	                 rm.nightly_rate, rm.deposit_percent, rm.deposit_fixed,
+	                 rm.free_cancellation_days, rm.cancellation_percent
+			  from reservations r
+			  left join rooms rm on (r.room_id = rm.id)
+			  where r.id = $1 and r.deleted_at is null`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomId,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.CreatedBy,
+		&res.Status,
+		&res.Language,
+		&res.Adults,
+		&res.Children,
+		&res.SpecialRequests,
+		&res.PromoCode,
+		&res.Discount,
+		&res.GroupId,
+		&res.Room.ID,
+		&res.Room.RoomName,
+		&res.Room.MaxOccupancy,
+		&res.Room.NightlyRate,
+		&res.Room.DepositPercent,
+		&res.Room.DepositFixed,
+		&res.Room.FreeCancellationDays,
+		&res.Room.CancellationPercent,
+	)
+	if err != nil {
+		return res, err
+	}
+
+	res.Taxes, err = queryReservationTaxes(ctx, m.DB, `select `+reservationTaxColumns+`
+	                                                   from reservation_taxes t
+	                                                   where t.reservation_id = $1 order by t.id`, res.ID)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// UpdateReservation updates a reservation and keeps its room restriction in sync, its taxes are replaced
+func (m *sqliteDbRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a cancelled or deleted reservation can't be changed
+	var status string
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = $1 and deleted_at is null`, r.ID).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status == models.ReservationStatusCancelled {
+		return repository.ErrReservationCancelled
+	}
+
+	// the reservation itself is not a conflict for its new dates
+	query := `select count(id) from room_restrictions
+	          where (reservation_id is null or reservation_id <> $4) and room_id = $1 and ` +
+		overlapPolicy(m.App).Condition("$2", "$3", "start_date", "end_date", "restriction_id") + ` and ` + activeRestriction("expires_at", "$5")
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, query, r.RoomId, sqliteDate(r.StartDate), sqliteDate(r.EndDate), r.ID, sqliteTime(time.Now())).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	query = `update reservations 
+	          set first_name=$1, last_name=$2, email=$3, phone=$4, start_date=$5, end_date=$6, room_id=$7,
+			      adults=$8, children=$9, special_requests=$10, updated_at=$11
+			  where id=$12`
+
+	_, err = tx.ExecContext(ctx, query,
+		r.FirstName,
+		r.LastName,
+		r.Email,
+		r.Phone,
+		sqliteDate(r.StartDate),
+		sqliteDate(r.EndDate),
+		r.RoomId,
+		r.Adults,
+		r.Children,
+		r.SpecialRequests,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	query = `update room_restrictions set start_date=$1, end_date=$2, room_id=$3, updated_at=$4 where reservation_id=$5`
+
+	_, err = tx.ExecContext(ctx, query, sqliteDate(r.StartDate), sqliteDate(r.EndDate), r.RoomId, time.Now(), r.ID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceReservationTaxes(ctx, tx, r.ID, r.Taxes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReservation soft deletes a reservation by ID and frees its room
+func (m *sqliteDbRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set deleted_at=$1, updated_at=$1 where id=$2`
+
+	_, err = tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdatedProcessedForReservation updates processed value for a reservation,
+// a pending reservation marked as processed becomes confirmed
+func (m *sqliteDbRepo) UpdatedProcessedForReservation(ctx context.Context, id int, processed int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update reservations 
+	          set processed=$1, 
+			      status = case when $1 = 1 and status = $3 then $4 else status end,
+				  updated_at=$5
+			  where id=$2`
+
+	_, err := m.DB.ExecContext(ctx, query, processed, id,
+		models.ReservationStatusPending, models.ReservationStatusConfirmed, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateReservationStatus sets the status of a reservation, cancelled and no-show reservations free their room
+func (m *sqliteDbRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update reservations set status=$1, updated_at=$2 where id=$3`, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if status == models.ReservationStatusCancelled || status == models.ReservationStatusNoShow {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=$1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRestrictionsForRoomByDate gets a slice of room restriction given a room id,
+// that overlap the period from startDate to endDate. Holds are left out, they are not managed by staff.
+func (m *sqliteDbRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select rr.id, coalesce(rr.reservation_id, 0), coalesce(r.group_id, 0), rr.restriction_id, rr.room_id,
+			         rr.start_date, rr.end_date
+			  from room_restrictions rr
+			  left join reservations r on (r.id = rr.reservation_id)
+			  where rr.room_id = $3 and rr.expires_at is null and ` +
+		overlapPolicy(m.App).Condition("$1", "$2", "rr.start_date", "rr.end_date", "rr.restriction_id") + `
+			  order by rr.start_date, rr.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, sqliteDate(startDate), sqliteDate(endDate), roomId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationId,
+			&r.Reservation.GroupId,
+			&r.RestrictionId,
+			&r.RoomId,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return restrictions, nil
+
+}
+
+// AddBlock inserts a room restriction (block) into the database, a block holds the room for the night of date
+func (m *sqliteDbRepo) AddBlockForRoom(ctx context.Context, roomId int, date time.Time) error {
+	return m.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     date,
+		EndDate:       date.AddDate(0, 0, 1),
+		RoomId:        roomId,
+		RestrictionId: models.RestrictionOwnerBlock,
+	})
+}
+
+// DeleteBlockById deletes a room restriction (block) into the database
+func (m *sqliteDbRepo) DeleteBlockById(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertHold holds a room for a guest until r.ExpiresAt and returns the id of the hold,
+// it returns repository.ErrRoomNotAvailable if the room is already taken for those dates
+func (m *sqliteDbRepo) InsertHold(ctx context.Context, r models.RoomRestriction) (int, error) {
+	if r.ExpiresAt.IsZero() {
+		return 0, errors.New("a hold needs an expiry")
+	}
+
+	r.ReservationId = 0
+	r.RestrictionId = models.RestrictionHold
+
+	return m.insertRestriction(ctx, r)
+}
+
+// ConvertHold turns a hold into the restriction of the reservation r.ReservationId. The hold must not have expired
+// and must be for the room and dates of r, otherwise repository.ErrHoldExpired is returned.
+func (m *sqliteDbRepo) ConvertHold(ctx context.Context, holdId int, r models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update room_restrictions set reservation_id = $1, restriction_id = $2, expires_at = null, updated_at = $3
+			 where id = $4 and restriction_id = $5 and room_id = $6 and start_date = $7 and end_date = $8 and expires_at > $9`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		r.ReservationId,
+		models.RestrictionReservation,
+		time.Now(),
+		holdId,
+		models.RestrictionHold,
+		r.RoomId,
+		sqliteDate(r.StartDate),
+		sqliteDate(r.EndDate),
+		sqliteTime(time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+
+	converted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if converted == 0 {
+		return repository.ErrHoldExpired
+	}
+
+	return nil
+}
+
+// DeleteHold releases a hold, it does nothing if the hold was already converted or removed
+func (m *sqliteDbRepo) DeleteHold(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`, id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredHolds removes the holds expired at now and returns how many were removed
+func (m *sqliteDbRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`,
+		models.RestrictionHold, sqliteTime(now))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(deleted), nil
+}
+
+// InsertAuditLog records a change made to an entity
+func (m *sqliteDbRepo) InsertAuditLog(ctx context.Context, a models.AuditLog) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var userId sql.NullInt64
+	if a.UserId > 0 {
+		userId = sql.NullInt64{Int64: int64(a.UserId), Valid: true}
+	}
+
+	query := `insert into audit_log (user_id, entity, entity_id, action, details, created_at, updated_at)
+			  values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, query, userId, a.Entity, a.EntityId, a.Action, a.Details, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditLogsForEntity returns the audit trail of an entity, newest first
+func (m *sqliteDbRepo) GetAuditLogsForEntity(ctx context.Context, entity string, entityId int) ([]models.AuditLog, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var logs []models.AuditLog
+
+	query := `select a.id, coalesce(a.user_id, 0), a.entity, a.entity_id, a.action, a.details, a.created_at, a.updated_at,
+	                 coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+			  from audit_log a
+			  left join users u on (a.user_id = u.id)
+			  where a.entity = $1 and a.entity_id = $2
+			  order by a.created_at desc, a.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, entity, entityId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.AuditLog
+		err := rows.Scan(
+			&a.ID,
+			&a.UserId,
+			&a.Entity,
+			&a.EntityId,
+			&a.Action,
+			&a.Details,
+			&a.CreatedAt,
+			&a.UpdatedAt,
+			&a.User.FirstName,
+			&a.User.LastName,
+			&a.User.Email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.User.ID = a.UserId
+
+		logs = append(logs, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// InsertPayment records a payment of a reservation
+func (m *sqliteDbRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into payments (reservation_id, gateway, reference, amount, currency, status, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, p.ReservationId, p.Gateway, p.Reference, p.Amount, p.Currency, p.Status,
+		time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// UpdatePaymentStatus sets the status of a payment
+func (m *sqliteDbRepo) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update payments set status=$1, updated_at=$2 where id=$3`, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RefundPayment records that amount of a payment was given back, the payment becomes refunded
+func (m *sqliteDbRepo) RefundPayment(ctx context.Context, id int, amount int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update payments set status=$1, refunded=refunded+$2, updated_at=$3 where id=$4`,
+		models.PaymentStatusRefunded, amount, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments of a reservation, oldest first
+func (m *sqliteDbRepo) GetPaymentsForReservation(ctx context.Context, reservationId int) ([]models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `select id, reservation_id, gateway, reference, amount, refunded, currency, status, created_at, updated_at
+			  from payments
+			  where reservation_id = $1
+			  order by created_at asc, id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(&p.ID, &p.ReservationId, &p.Gateway, &p.Reference, &p.Amount, &p.Refunded, &p.Currency, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// GetPaymentByReference returns a payment by the reference given to it by a gateway
+func (m *sqliteDbRepo) GetPaymentByReference(ctx context.Context, gateway, reference string) (models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var p models.Payment
+
+	query := `select id, reservation_id, gateway, reference, amount, refunded, currency, status, created_at, updated_at
+			  from payments
+			  where gateway = $1 and reference = $2`
+
+	err := m.DB.QueryRowContext(ctx, query, gateway, reference).Scan(
+		&p.ID, &p.ReservationId, &p.Gateway, &p.Reference, &p.Amount, &p.Refunded, &p.Currency, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// AllPromotions returns every promotion, ordered by code
+func (m *sqliteDbRepo) AllPromotions(ctx context.Context) ([]models.Promotion, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var promotions []models.Promotion
+
+	rows, err := m.DB.QueryContext(ctx, `select `+promotionColumns+` from promotions order by code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		promotions = append(promotions, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+// GetPromotionById returns a promotion by id
+func (m *sqliteDbRepo) GetPromotionById(ctx context.Context, id int) (models.Promotion, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return scanPromotion(m.DB.QueryRowContext(ctx, `select `+promotionColumns+` from promotions where id = $1`, id))
+}
+
+// GetPromotionByCode returns a promotion by its code, ignoring case
+func (m *sqliteDbRepo) GetPromotionByCode(ctx context.Context, code string) (models.Promotion, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return scanPromotion(m.DB.QueryRowContext(ctx, `select `+promotionColumns+` from promotions where code = $1`, promotionCode(code)))
+}
+
+// InsertPromotion inserts a promotion, its code is stored in upper case
+func (m *sqliteDbRepo) InsertPromotion(ctx context.Context, p models.Promotion) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into promotions (code, description, discount_percent, discount_fixed, valid_from, valid_to, room_id,
+	                                 min_nights, max_uses, uses, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, promotionCode(p.Code), p.Description, p.DiscountPercent, p.DiscountFixed,
+		sqliteDate(p.ValidFrom), sqliteDate(p.ValidTo), promotionRoom(p), p.MinNights, p.MaxUses, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// UpdatePromotion updates a promotion, the number of times it was used is left as it is
+func (m *sqliteDbRepo) UpdatePromotion(ctx context.Context, p models.Promotion) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update promotions
+	          set code=$1, description=$2, discount_percent=$3, discount_fixed=$4, valid_from=$5, valid_to=$6,
+	              room_id=$7, min_nights=$8, max_uses=$9, updated_at=$10
+	          where id=$11`
+
+	_, err := m.DB.ExecContext(ctx, query, promotionCode(p.Code), p.Description, p.DiscountPercent, p.DiscountFixed,
+		sqliteDate(p.ValidFrom), sqliteDate(p.ValidTo), promotionRoom(p), p.MinNights, p.MaxUses, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeletePromotion deletes a promotion, reservations keep the code they were booked with
+func (m *sqliteDbRepo) DeletePromotion(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from promotions where id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UsePromotion counts a use of a promotion, it returns repository.ErrPromotionUsedUp if the promotion
+// has reached its maximum number of uses. The check and the count are a single statement, so that two
+// guests can't take the last use of a code.
+func (m *sqliteDbRepo) UsePromotion(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update promotions set uses=uses+1, updated_at=$1
+	                                       where id=$2 and (max_uses = 0 or uses < max_uses)`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return repository.ErrPromotionUsedUp
+	}
+
+	return nil
+}
+
+// ReleasePromotion gives back a use of a promotion, for a reservation that could not be completed
+func (m *sqliteDbRepo) ReleasePromotion(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update promotions set uses=uses-1, updated_at=$1 where id=$2 and uses > 0`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllTaxRules returns every tax rule, ordered by name
+func (m *sqliteDbRepo) AllTaxRules(ctx context.Context) ([]models.TaxRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rules []models.TaxRule
+
+	rows, err := m.DB.QueryContext(ctx, `select `+taxRuleColumns+` from tax_rules order by name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTaxRule(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// GetTaxRuleById returns a tax rule by id
+func (m *sqliteDbRepo) GetTaxRuleById(ctx context.Context, id int) (models.TaxRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return scanTaxRule(m.DB.QueryRowContext(ctx, `select `+taxRuleColumns+` from tax_rules where id = $1`, id))
+}
+
+// InsertTaxRule inserts a tax rule
+func (m *sqliteDbRepo) InsertTaxRule(ctx context.Context, t models.TaxRule) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into tax_rules (name, kind, percent, amount, exempt_children, max_nights, cap, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, t.Name, t.Kind, t.Percent, t.Amount, t.ExemptChildren, t.MaxNights, t.Cap,
+		time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// UpdateTaxRule updates a tax rule, the taxes already charged on reservations are left as they are
+func (m *sqliteDbRepo) UpdateTaxRule(ctx context.Context, t models.TaxRule) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update tax_rules
+	          set name=$1, kind=$2, percent=$3, amount=$4, exempt_children=$5, max_nights=$6, cap=$7, updated_at=$8
+	          where id=$9`
+
+	_, err := m.DB.ExecContext(ctx, query, t.Name, t.Kind, t.Percent, t.Amount, t.ExemptChildren, t.MaxNights, t.Cap,
+		time.Now(), t.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteTaxRule deletes a tax rule, reservations keep the taxes they were charged
+func (m *sqliteDbRepo) DeleteTaxRule(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from tax_rules where id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllReservationTaxes returns the taxes of the reservations that are not deleted, by reservation
+func (m *sqliteDbRepo) AllReservationTaxes(ctx context.Context) ([]models.ReservationTax, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationTaxColumns + `
+	          from reservation_taxes t
+	          join reservations r on (r.id = t.reservation_id)
+	          where r.deleted_at is null
+	          order by t.reservation_id, t.id`
+
+	return queryReservationTaxes(ctx, m.DB, query)
+}
+
+// TaxReport returns what each tax rule charged on the stays starting from start until the day before end,
+// cancelled stays and no-shows are left out
+func (m *sqliteDbRepo) TaxReport(ctx context.Context, start, end time.Time) ([]models.TaxReportLine, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, taxReportQuery, sqliteDate(start), sqliteDate(end),
+		models.ReservationStatusCancelled, models.ReservationStatusNoShow)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanTaxReport(rows)
+}
+
+// IssueInvoice returns the invoice of a reservation, issuing it with the next number if the reservation has none.
+// The number is taken by the insert itself, SQLite runs one write at a time so numbers have no gaps and no duplicates.
+func (m *sqliteDbRepo) IssueInvoice(ctx context.Context, reservationId int, issuedAt time.Time) (models.Invoice, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into invoices (reservation_id, number, issued_at, created_at, updated_at)
+	         select $1, (select coalesce(max(number), 0) + 1 from invoices), $2, $3, $3
+	         where not exists (select 1 from invoices where reservation_id = $1)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, reservationId, issuedAt, time.Now())
+	if err != nil {
+		return models.Invoice{}, err
+	}
+
+	return m.GetInvoiceForReservation(ctx, reservationId)
+}
+
+// GetInvoiceForReservation returns the invoice of a reservation, sql.ErrNoRows if none was issued
+func (m *sqliteDbRepo) GetInvoiceForReservation(ctx context.Context, reservationId int) (models.Invoice, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var inv models.Invoice
+
+	query := `select id, reservation_id, number, issued_at, created_at, updated_at
+			  from invoices
+			  where reservation_id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, reservationId).Scan(
+		&inv.ID, &inv.ReservationId, &inv.Number, &inv.IssuedAt, &inv.CreatedAt, &inv.UpdatedAt)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist
+func (m *sqliteDbRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into waitlist (first_name, last_name, email, start_date, end_date, room_id, adults, children, language,
+	                               created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, e.FirstName, e.LastName, e.Email, sqliteDate(e.StartDate), sqliteDate(e.EndDate), waitlistRoom(e),
+		e.Adults, e.Children, e.Language, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// WaitingForDates returns the guests still waiting for a stay with nights between start and end, excluded,
+// in the given room or in any room. The guest who joined first comes first.
+func (m *sqliteDbRepo) WaitingForDates(ctx context.Context, start, end time.Time, roomId int) ([]models.WaitlistEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, waitingQuery, sqliteDate(start), sqliteDate(end), roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanWaitlist(rows)
+}
+
+// OfferWaitlistEntry records that a guest has been sent a booking link with token, valid until expiresAt.
+// A guest is offered a stay once, it fails with sql.ErrNoRows if the guest was offered one already.
+func (m *sqliteDbRepo) OfferWaitlistEntry(ctx context.Context, id int, token string, expiresAt time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update waitlist set token = $1, notified_at = $2, expires_at = $3, updated_at = $2
+	                                      where id = $4 and notified_at is null`, token, time.Now(), expiresAt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry a booking link was sent for
+func (m *sqliteDbRepo) GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return scanWaitlistEntry(m.DB.QueryRowContext(ctx, `select `+waitlistColumns+` from waitlist where token = $1`, token))
+}
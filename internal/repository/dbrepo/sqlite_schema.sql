@@ -0,0 +1,193 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name TEXT NOT NULL DEFAULT '',
+    last_name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    access_level INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_name TEXT NOT NULL DEFAULT '',
+    max_occupancy INTEGER NOT NULL DEFAULT 2,
+    nightly_rate INTEGER NOT NULL DEFAULT 0,
+    deposit_percent INTEGER NOT NULL DEFAULT 0,
+    deposit_fixed INTEGER NOT NULL DEFAULT 0,
+    free_cancellation_days INTEGER NOT NULL DEFAULT 0,
+    cancellation_percent INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS restrictions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    restriction_name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reservation_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reservations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name TEXT NOT NULL DEFAULT '',
+    last_name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL,
+    phone TEXT NOT NULL DEFAULT '',
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    room_id INTEGER NOT NULL REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    processed INTEGER NOT NULL DEFAULT 0,
+    created_by INTEGER REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending',
+    language TEXT NOT NULL DEFAULT 'en',
+    adults INTEGER NOT NULL DEFAULT 1,
+    children INTEGER NOT NULL DEFAULT 0,
+    special_requests TEXT NOT NULL DEFAULT '',
+    promo_code TEXT NOT NULL DEFAULT '',
+    discount INTEGER NOT NULL DEFAULT 0,
+    group_id INTEGER,
+    deleted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS reservations_last_name_idx ON reservations (last_name);
+CREATE INDEX IF NOT EXISTS reservations_email_idx ON reservations (email);
+CREATE INDEX IF NOT EXISTS reservations_status_idx ON reservations (status);
+
+CREATE TABLE IF NOT EXISTS room_restrictions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    room_id INTEGER NOT NULL REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    reservation_id INTEGER REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    restriction_id INTEGER NOT NULL REFERENCES restrictions (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS room_restrictions_start_date_end_date_idx ON room_restrictions (start_date, end_date);
+CREATE INDEX IF NOT EXISTS room_restrictions_room_id_idx ON room_restrictions (room_id);
+CREATE INDEX IF NOT EXISTS room_restrictions_reservation_id_idx ON room_restrictions (reservation_id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    entity TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_entity_id_idx ON audit_log (entity, entity_id);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reservation_id INTEGER NOT NULL REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    gateway TEXT NOT NULL,
+    reference TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    refunded INTEGER NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS payments_reservation_id_idx ON payments (reservation_id);
+CREATE INDEX IF NOT EXISTS payments_gateway_reference_idx ON payments (gateway, reference);
+
+CREATE TABLE IF NOT EXISTS promotions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    discount_percent INTEGER NOT NULL DEFAULT 0,
+    discount_fixed INTEGER NOT NULL DEFAULT 0,
+    valid_from DATE NOT NULL,
+    valid_to DATE NOT NULL,
+    room_id INTEGER REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    min_nights INTEGER NOT NULL DEFAULT 0,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reservation_id INTEGER NOT NULL UNIQUE REFERENCES reservations (id) ON DELETE RESTRICT ON UPDATE CASCADE,
+    number INTEGER NOT NULL UNIQUE,
+    issued_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tax_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    percent INTEGER NOT NULL DEFAULT 0,
+    amount INTEGER NOT NULL DEFAULT 0,
+    exempt_children INTEGER NOT NULL DEFAULT 0,
+    max_nights INTEGER NOT NULL DEFAULT 0,
+    cap INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reservation_taxes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reservation_id INTEGER NOT NULL REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    tax_rule_id INTEGER REFERENCES tax_rules (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    name TEXT NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 0,
+    unit_price INTEGER NOT NULL DEFAULT 0,
+    amount INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS reservation_taxes_reservation_id_idx ON reservation_taxes (reservation_id);
+
+CREATE TABLE IF NOT EXISTS waitlist (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name TEXT NOT NULL DEFAULT '',
+    last_name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    room_id INTEGER REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    adults INTEGER NOT NULL DEFAULT 1,
+    children INTEGER NOT NULL DEFAULT 0,
+    language TEXT NOT NULL DEFAULT 'en',
+    token TEXT UNIQUE,
+    notified_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS waitlist_start_date_end_date_idx ON waitlist (start_date, end_date);
+
+INSERT OR IGNORE INTO rooms (id, room_name, max_occupancy, nightly_rate, deposit_percent, free_cancellation_days, cancellation_percent, created_at, updated_at) VALUES
+    (1, 'General''s Quarters', 2, 9000, 30, 7, 20, '2023-04-14 00:00:00', '2023-04-14 00:00:00'),
+    (2, 'Major''s Suite', 4, 15000, 30, 14, 30, '2023-04-16 00:00:00', '2023-04-16 00:00:00');
+
+INSERT OR IGNORE INTO restrictions (id, restriction_name, created_at, updated_at) VALUES
+    (1, 'Reservation', '2023-04-15 00:00:00', '2023-04-15 00:00:00'),
+    (2, 'Owner Block', '2023-04-16 00:00:00', '2023-04-16 00:00:00'),
+    (3, 'Hold', '2026-10-19 00:00:00', '2026-10-19 00:00:00');
+
+-- blocks used to end on the blocked day, they now hold the room until the next one
+UPDATE room_restrictions SET end_date = date(end_date, '+1 day') WHERE reservation_id IS NULL AND restriction_id = 2 AND end_date = start_date;
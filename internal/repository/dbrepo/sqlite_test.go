@@ -0,0 +1,112 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/AlessioPani/go-booking/internal/config"
+	"github.com/AlessioPani/go-booking/internal/driver"
+	"github.com/AlessioPani/go-booking/internal/repository"
+	"github.com/AlessioPani/go-booking/internal/repository/repotest"
+)
+
+// newSqliteTestRepo returns a repository backed by a fresh in memory SQLite database
+func newSqliteTestRepo(t *testing.T) repository.DatabaseRepo {
+	t.Helper()
+
+	return newSqlitePolicyRepo(t, repository.HalfOpen)
+}
+
+// newSqlitePolicyRepo returns a repository backed by a fresh in memory SQLite database, with the given overlap policy
+func newSqlitePolicyRepo(t *testing.T, policy repository.OverlapPolicy) repository.DatabaseRepo {
+	t.Helper()
+
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	err = InitSqliteSchema(context.Background(), db.SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSqliteRepo(db.SQL, &config.AppConfig{Overlap: policy})
+}
+
+func TestSqlite_InitSchemaTwice(t *testing.T) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := InitSqliteSchema(context.Background(), db.SQL); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	repo := NewSqliteRepo(db.SQL, &config.AppConfig{})
+	rooms, err := repo.AllRooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 {
+		t.Errorf("expected 2 seeded rooms, got %d", len(rooms))
+	}
+}
+
+func TestSqlite_InitSchemaAddsColumns(t *testing.T) {
+	db, err := driver.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	ctx := context.Background()
+	if err := InitSqliteSchema(ctx, db.SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	// a database created before the columns were added
+	for _, c := range sqliteColumns {
+		if _, err := db.SQL.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", c.table, c.column)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := InitSqliteSchema(ctx, db.SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range sqliteColumns {
+		var found int
+		err := db.SQL.QueryRowContext(ctx, `select count(*) from pragma_table_info($1) where name = $2`, c.table, c.column).Scan(&found)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != 1 {
+			t.Errorf("column %s.%s was not added", c.table, c.column)
+		}
+	}
+}
+
+func TestSqlite_Contract(t *testing.T) {
+	repotest.Run(t, newSqliteTestRepo)
+	repotest.RunPolicies(t, newSqlitePolicyRepo)
+}
+
+func TestSqlite_CancelledContext(t *testing.T) {
+	repo := newSqliteTestRepo(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.AllReservations(ctx)
+	if err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
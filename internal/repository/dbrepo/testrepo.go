@@ -1,19 +1,22 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"log"
 	"time"
 
 	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/repository"
 )
 
-func (m *testDbRepo) AllUsers() bool {
+func (m *testDbRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
 // InsertReservation inserts a reservation into the database
-func (m *testDbRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDbRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	if res.RoomId == 2 {
 		return 1, errors.New("Error")
 	}
@@ -21,15 +24,49 @@ func (m *testDbRepo) InsertReservation(res models.Reservation) (int, error) {
 }
 
 // InsertRoomRestriction inserts a room restriction into the databases
-func (m *testDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+func (m *testDbRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
 	if r.RoomId == 1000 {
 		return errors.New("Error")
 	}
 	return nil
 }
 
+// InsertReservationGroup books several rooms together, it fails when one of the rooms is room 2
+func (m *testDbRepo) InsertReservationGroup(ctx context.Context, lines []models.Reservation, holds []int) (int, []int, error) {
+	ids := make([]int, 0, len(lines))
+	for i, res := range lines {
+		if res.RoomId == 2 {
+			return 0, nil, errors.New("Error")
+		}
+		ids = append(ids, i+1)
+	}
+	return 1, ids, nil
+}
+
+// InsertBooking inserts a reservation with its room restriction, it fails for room 2 and room 1000
+func (m *testDbRepo) InsertBooking(ctx context.Context, res models.Reservation, holdId int) (int, error) {
+	if res.RoomId == 2 || res.RoomId == 1000 {
+		return 0, errors.New("Error")
+	}
+	return 1, nil
+}
+
+// GetReservationsByGroup returns the reservations of a group, group 1 has two rooms
+func (m *testDbRepo) GetReservationsByGroup(ctx context.Context, groupId int) ([]models.Reservation, error) {
+	if groupId == 1000 {
+		return nil, errors.New("Error")
+	}
+	if groupId != 1 {
+		return nil, nil
+	}
+	return []models.Reservation{
+		{ID: 1, GroupId: 1, RoomId: 1, Status: models.ReservationStatusPending},
+		{ID: 2, GroupId: 1, RoomId: 2, Status: models.ReservationStatusPending},
+	}, nil
+}
+
 // SearchAvailabilityByDatesByRoomId returns true if availability exists for a room Id and false if no availability exists
-func (m *testDbRepo) SearchAvailabilityByDatesByRoomId(start time.Time, end time.Time, roomId int) (bool, error) {
+func (m *testDbRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start time.Time, end time.Time, roomId int) (bool, error) {
 	// set up a test time
 	layout := "2006-01-02"
 	str := "2049-12-31"
@@ -58,8 +95,14 @@ func (m *testDbRepo) SearchAvailabilityByDatesByRoomId(start time.Time, end time
 	return true, nil
 }
 
-// SearchAvailabilityForAllRooms returns a list of available rooms for the given start and end date
-func (m *testDbRepo) SearchAvailabilityForAllRooms(start time.Time, end time.Time) ([]models.Room, error) {
+// SearchAvailabilityByDatesByRoomIdExcludingReservation returns true if availability exists for a room Id,
+// ignoring the restriction that belongs to the given reservation
+func (m *testDbRepo) SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx context.Context, start time.Time, end time.Time, roomId int, reservationId int) (bool, error) {
+	return m.SearchAvailabilityByDatesByRoomId(ctx, start, end, roomId)
+}
+
+// SearchAvailabilityForAllRooms returns a list of available rooms for the given start and end date that can host the given number of guests
+func (m *testDbRepo) SearchAvailabilityForAllRooms(ctx context.Context, start time.Time, end time.Time, guests int) ([]models.Room, error) {
 	var rooms []models.Room
 
 	// if the start date is after 2049-12-31, then return empty slice,
@@ -94,9 +137,46 @@ func (m *testDbRepo) SearchAvailabilityForAllRooms(start time.Time, end time.Tim
 	return rooms, nil
 }
 
+// AvailabilityCalendar returns every day available at 100.00 a night, except the 1st of each month;
+// room 1000 fails and rooms other than 1 and 2 do not exist
+func (m *testDbRepo) AvailabilityCalendar(ctx context.Context, roomId int, from, to time.Time) ([]models.CalendarDay, error) {
+	if roomId == 1000 {
+		return nil, errors.New("some error")
+	}
+	if roomId != 1 && roomId != 2 {
+		return nil, sql.ErrNoRows
+	}
+
+	var days []models.CalendarDay
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, models.CalendarDay{Date: d, Available: d.Day() != 1, Price: 10000})
+	}
+
+	return days, nil
+}
+
+// SearchFlexibleAvailability returns room 1 for every arrival, nothing from 2050 and fails for arrivals from 2060
+func (m *testDbRepo) SearchFlexibleAvailability(ctx context.Context, from, to time.Time, nights, guests int) ([]models.StayOption, error) {
+	if from.Year() >= 2060 {
+		return nil, errors.New("some error")
+	}
+	if from.Year() >= 2050 {
+		return nil, nil
+	}
+
+	var stays []models.StayOption
+	room := models.Room{ID: 1, RoomName: "General's Quarters", MaxOccupancy: 2, NightlyRate: 10000}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		stays = append(stays, models.StayOption{Room: room, StartDate: d, EndDate: d.AddDate(0, 0, nights)})
+	}
+
+	return stays, nil
+}
+
 // GetRoomById gets a room by id
-func (m *testDbRepo) GetRoomById(id int) (models.Room, error) {
-	var room models.Room
+func (m *testDbRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	// every test room hosts up to 2 guests, at 100.00 a night with a 30% deposit
+	room := models.Room{MaxOccupancy: 2, NightlyRate: 10000, DepositPercent: 30}
 
 	if id > 2 {
 		return room, errors.New("some error")
@@ -105,16 +185,16 @@ func (m *testDbRepo) GetRoomById(id int) (models.Room, error) {
 	return room, nil
 }
 
-func (m *testDbRepo) GetUserById(id int) (models.User, error) {
+func (m *testDbRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
 	var u models.User
 	return u, nil
 }
 
-func (m *testDbRepo) UpdateUserById(u models.User) error {
+func (m *testDbRepo) UpdateUserById(ctx context.Context, u models.User) error {
 	return nil
 }
 
-func (m *testDbRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (m *testDbRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
 	if email == "invalid@invalid.com" {
 		return 0, "", errors.New("invalid email")
 	}
@@ -123,43 +203,87 @@ func (m *testDbRepo) Authenticate(email, testPassword string) (int, string, erro
 }
 
 // AllReservations returns a slice of all reservations
-func (m *testDbRepo) AllReservations() ([]models.Reservation, error) {
+func (m *testDbRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 
+	// like database/sql, fail if the request has been cancelled
+	if err := ctx.Err(); err != nil {
+		return reservations, err
+	}
+
 	return reservations, nil
 }
 
 // AllNewReservations returns a slice of new reservations
-func (m *testDbRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDbRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 
+	// like database/sql, fail if the request has been cancelled
+	if err := ctx.Err(); err != nil {
+		return reservations, err
+	}
+
 	return reservations, nil
 }
 
-// GetReservationById retrieve from the database a reservation by its id
-func (m *testDbRepo) GetReservationById(id int) (models.Reservation, error) {
+// GetReservationById retrieve from the database a reservation by its id, a deleted reservation is not found
+func (m *testDbRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
 	var reservations models.Reservation
+	// reservation 4 has been deleted
+	if id > 1000 || id == 4 {
+		return reservations, sql.ErrNoRows
+	}
+	reservations.ID = id
+	reservations.Status = models.ReservationStatusPending
+	// reservation 3 has been cancelled
+	if id == 3 {
+		reservations.Status = models.ReservationStatusCancelled
+	}
 
 	return reservations, nil
 }
 
-// UpdateReservation updates a reservation
-func (m *testDbRepo) UpdateReservation(r models.Reservation) error {
+// UpdateReservation updates a reservation and keeps its room restriction in sync. Like the availability search,
+// the room is not available from 2050 and the query fails for a stay starting on 2060-01-01.
+func (m *testDbRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	if r.ID == 1000 || r.StartDate.Equal(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return errors.New("Error")
+	}
+	if r.Status == models.ReservationStatusCancelled {
+		return repository.ErrReservationCancelled
+	}
+	if r.StartDate.After(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		return repository.ErrRoomNotAvailable
+	}
 	return nil
 }
 
-// DeleteReservation deletes a reservation by ID
-func (m *testDbRepo) DeleteReservation(id int) error {
+// DeleteReservation soft deletes a reservation by ID and frees its room
+func (m *testDbRepo) DeleteReservation(ctx context.Context, id int) error {
+	if id == 1000 {
+		return errors.New("Error")
+	}
 	return nil
 }
 
 // UpdatedProcessedForReservation updates processed value for a reservation
-func (m *testDbRepo) UpdatedProcessedForReservation(id int, processed int) error {
+func (m *testDbRepo) UpdatedProcessedForReservation(ctx context.Context, id int, processed int) error {
+	if id == 1000 {
+		return errors.New("Error")
+	}
+	return nil
+}
+
+// UpdateReservationStatus sets the status of a reservation, cancelled and no-show reservations free their room
+func (m *testDbRepo) UpdateReservationStatus(ctx context.Context, id int, status string) error {
+	if id == 1000 {
+		return errors.New("Error")
+	}
 	return nil
 }
 
 // AllRooms returns a slice of all rooms
-func (m *testDbRepo) AllRooms() ([]models.Room, error) {
+func (m *testDbRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
 
 	var rooms []models.Room
 
@@ -168,20 +292,265 @@ func (m *testDbRepo) AllRooms() ([]models.Room, error) {
 }
 
 // GetRestrictionsForRoomByDate gets a slice of room restriction given a room id, start and end dates
-func (m *testDbRepo) GetRestrictionsForRoomByDate(roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+func (m *testDbRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 
 	return restrictions, nil
 }
 
 // AddBlock inserts a room restriction (block) into the database
-func (m *testDbRepo) AddBlockForRoom(roomId int, date time.Time) error {
+func (m *testDbRepo) AddBlockForRoom(ctx context.Context, roomId int, date time.Time) error {
 
 	return nil
 }
 
 // DeleteBlockById deletes a room restriction (block) into the database
-func (m *testDbRepo) DeleteBlockById(id int) error {
+func (m *testDbRepo) DeleteBlockById(ctx context.Context, id int) error {
+
+	return nil
+}
+
+// InsertHold holds a room for a guest, rooms are taken after 2049-12-31 and holds starting on 2060-01-01 fail
+func (m *testDbRepo) InsertHold(ctx context.Context, r models.RoomRestriction) (int, error) {
+	if r.StartDate.Equal(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, errors.New("some error")
+	}
+
+	if r.StartDate.After(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	return 1, nil
+}
+
+// ConvertHold turns a hold into the restriction of a reservation, only hold 1 exists
+func (m *testDbRepo) ConvertHold(ctx context.Context, holdId int, r models.RoomRestriction) error {
+	if holdId != 1 {
+		return repository.ErrHoldExpired
+	}
+
+	return nil
+}
+
+// DeleteHold releases a hold
+func (m *testDbRepo) DeleteHold(ctx context.Context, id int) error {
+	return nil
+}
+
+// DeleteExpiredHolds removes the holds expired at now
+func (m *testDbRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
+// InsertAuditLog records a change made to an entity
+func (m *testDbRepo) InsertAuditLog(ctx context.Context, a models.AuditLog) error {
+	return nil
+}
+
+// GetAuditLogsForEntity returns the audit trail of an entity, newest first
+func (m *testDbRepo) GetAuditLogsForEntity(ctx context.Context, entity string, entityId int) ([]models.AuditLog, error) {
+	var logs []models.AuditLog
+
+	return logs, nil
+}
+
+// InsertPayment records a payment of a reservation
+func (m *testDbRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	return 1, nil
+}
+
+// UpdatePaymentStatus sets the status of a payment
+func (m *testDbRepo) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
+	return nil
+}
 
+// RefundPayment records that amount of a payment was given back
+func (m *testDbRepo) RefundPayment(ctx context.Context, id int, amount int) error {
 	return nil
 }
+
+// GetPaymentsForReservation returns the payments of a reservation, oldest first
+func (m *testDbRepo) GetPaymentsForReservation(ctx context.Context, reservationId int) ([]models.Payment, error) {
+	var payments []models.Payment
+
+	return payments, nil
+}
+
+// GetPaymentByReference returns a payment by the reference given to it by a gateway
+func (m *testDbRepo) GetPaymentByReference(ctx context.Context, gateway, reference string) (models.Payment, error) {
+	return models.Payment{}, sql.ErrNoRows
+}
+
+// AllPromotions returns every promotion
+func (m *testDbRepo) AllPromotions(ctx context.Context) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+
+	return promotions, nil
+}
+
+// GetPromotionById returns a promotion by id, only promotion 1 exists and 1000 fails
+func (m *testDbRepo) GetPromotionById(ctx context.Context, id int) (models.Promotion, error) {
+	switch id {
+	case 1:
+		return models.Promotion{
+			ID:              1,
+			Code:            "SUMMER",
+			DiscountPercent: 10,
+			ValidFrom:       time.Date(2040, time.June, 1, 0, 0, 0, 0, time.UTC),
+			ValidTo:         time.Date(2040, time.August, 31, 0, 0, 0, 0, time.UTC),
+		}, nil
+	case 1000:
+		return models.Promotion{}, errors.New("some error")
+	}
+
+	return models.Promotion{}, sql.ErrNoRows
+}
+
+// GetPromotionByCode returns a promotion by its code, there are none
+func (m *testDbRepo) GetPromotionByCode(ctx context.Context, code string) (models.Promotion, error) {
+	return models.Promotion{}, sql.ErrNoRows
+}
+
+// InsertPromotion inserts a promotion
+func (m *testDbRepo) InsertPromotion(ctx context.Context, p models.Promotion) (int, error) {
+	return 1, nil
+}
+
+// UpdatePromotion updates a promotion
+func (m *testDbRepo) UpdatePromotion(ctx context.Context, p models.Promotion) error {
+	return nil
+}
+
+// DeletePromotion deletes a promotion, deleting promotion 1000 fails
+func (m *testDbRepo) DeletePromotion(ctx context.Context, id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+// UsePromotion counts a use of a promotion
+func (m *testDbRepo) UsePromotion(ctx context.Context, id int) error {
+	return nil
+}
+
+// ReleasePromotion gives back a use of a promotion
+func (m *testDbRepo) ReleasePromotion(ctx context.Context, id int) error {
+	return nil
+}
+
+// AllTaxRules returns every tax rule, there are none so that stays are not taxed
+func (m *testDbRepo) AllTaxRules(ctx context.Context) ([]models.TaxRule, error) {
+	var rules []models.TaxRule
+
+	return rules, nil
+}
+
+// GetTaxRuleById returns a tax rule by id, only tax rule 1 exists and 1000 fails
+func (m *testDbRepo) GetTaxRuleById(ctx context.Context, id int) (models.TaxRule, error) {
+	switch id {
+	case 1:
+		return models.TaxRule{ID: 1, Name: "City tax", Kind: "per-guest", Amount: 200, ExemptChildren: true}, nil
+	case 1000:
+		return models.TaxRule{}, errors.New("some error")
+	}
+
+	return models.TaxRule{}, sql.ErrNoRows
+}
+
+// InsertTaxRule inserts a tax rule
+func (m *testDbRepo) InsertTaxRule(ctx context.Context, t models.TaxRule) (int, error) {
+	return 1, nil
+}
+
+// UpdateTaxRule updates a tax rule
+func (m *testDbRepo) UpdateTaxRule(ctx context.Context, t models.TaxRule) error {
+	return nil
+}
+
+// DeleteTaxRule deletes a tax rule, deleting tax rule 1000 fails
+func (m *testDbRepo) DeleteTaxRule(ctx context.Context, id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+// AllReservationTaxes returns the taxes of the reservations, reservation 1 is charged a city tax
+func (m *testDbRepo) AllReservationTaxes(ctx context.Context) ([]models.ReservationTax, error) {
+	return []models.ReservationTax{
+		{ID: 1, ReservationId: 1, TaxRuleId: 1, Name: "City tax", Quantity: 4, UnitPrice: 200, Amount: 800},
+	}, nil
+}
+
+// TaxReport returns what each tax rule charged on the stays of a period, the year 1000 fails
+func (m *testDbRepo) TaxReport(ctx context.Context, start, end time.Time) ([]models.TaxReportLine, error) {
+	if start.Year() == 1000 {
+		return nil, errors.New("some error")
+	}
+
+	return []models.TaxReportLine{{TaxRuleId: 1, Name: "City tax", Reservations: 1, Quantity: 4, Amount: 800}}, nil
+}
+
+// IssueInvoice returns the invoice of a reservation, every reservation gets invoice 1 but reservation 1000 fails
+func (m *testDbRepo) IssueInvoice(ctx context.Context, reservationId int, issuedAt time.Time) (models.Invoice, error) {
+	if reservationId == 1000 {
+		return models.Invoice{}, errors.New("Error")
+	}
+	return models.Invoice{ID: 1, ReservationId: reservationId, Number: 1, IssuedAt: issuedAt}, nil
+}
+
+// GetInvoiceForReservation returns the invoice of a reservation, only reservation 1 has one
+func (m *testDbRepo) GetInvoiceForReservation(ctx context.Context, reservationId int) (models.Invoice, error) {
+	if reservationId != 1 {
+		return models.Invoice{}, sql.ErrNoRows
+	}
+
+	return models.Invoice{ID: 1, ReservationId: 1, Number: 1, IssuedAt: time.Date(2040, time.January, 5, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist
+func (m *testDbRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	return 1, nil
+}
+
+// WaitingForDates returns the guests waiting for a stay, there are none
+func (m *testDbRepo) WaitingForDates(ctx context.Context, start, end time.Time, roomId int) ([]models.WaitlistEntry, error) {
+	return nil, nil
+}
+
+// OfferWaitlistEntry records that a guest has been sent a booking link
+func (m *testDbRepo) OfferWaitlistEntry(ctx context.Context, id int, token string, expiresAt time.Time) error {
+	return nil
+}
+
+// GetWaitlistEntryByToken returns the entry of a booking link: "valid" is an offer for room 1 in January 2040,
+// "expired" an offer that has expired and "fail" fails
+func (m *testDbRepo) GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error) {
+	entry := models.WaitlistEntry{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, time.January, 3, 0, 0, 0, 0, time.UTC),
+		RoomId:    1,
+		Adults:    1,
+		Token:     token,
+	}
+
+	switch token {
+	case "valid":
+		entry.ExpiresAt = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return entry, nil
+	case "expired":
+		entry.ExpiresAt = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return entry, nil
+	case "fail":
+		return models.WaitlistEntry{}, errors.New("some error")
+	}
+
+	return models.WaitlistEntry{}, sql.ErrNoRows
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlessioPani/go-booking/internal/models"
+)
+
+var (
+	// ErrRoomNotAvailable is returned when a room restriction would overlap an existing one
+	ErrRoomNotAvailable = errors.New("room is not available for the selected dates")
+	// ErrHoldExpired is returned when a hold cannot become a reservation, because it expired or was released
+	ErrHoldExpired = errors.New("room hold expired")
+	// ErrPromotionUsedUp is returned when a promotion has reached its maximum number of uses
+	ErrPromotionUsedUp = errors.New("promotion used up")
+	// ErrReservationCancelled is returned when a cancelled reservation would be changed
+	ErrReservationCancelled = errors.New("reservation is cancelled")
+)
+
+// OverlapPolicy decides when two stays, given as check-in and check-out dates, compete for the same room.
+// Restrictions are always stored as they are booked: a reservation from its check-in to its check-out date,
+// a block from the blocked day to the next one. A block only ever holds the days it blocks, the extra
+// check-out day of the closed policy is kept for turning a room over after a stay.
+type OverlapPolicy int
+
+const (
+	// HalfOpen treats a stay as the nights in [check-in, check-out): a room can be booked again
+	// on the day it is left. It is the default.
+	HalfOpen OverlapPolicy = iota
+	// Closed treats a stay as [check-in, check-out]: the check-out day stays occupied,
+	// for properties that need a day to turn a room over.
+	Closed
+)
+
+// ParseOverlapPolicy returns the policy with the given name
+func ParseOverlapPolicy(name string) (OverlapPolicy, error) {
+	switch name {
+	case "half-open":
+		return HalfOpen, nil
+	case "closed":
+		return Closed, nil
+	}
+
+	return HalfOpen, fmt.Errorf("unknown overlap policy %q", name)
+}
+
+// String returns the name of the policy
+func (p OverlapPolicy) String() string {
+	if p == Closed {
+		return "closed"
+	}
+
+	return "half-open"
+}
+
+// Overlaps reports whether a stay from start to end competes with one from otherStart to otherEnd
+func (p OverlapPolicy) Overlaps(start, end, otherStart, otherEnd time.Time) bool {
+	if p == Closed {
+		return !start.After(otherEnd) && !end.Before(otherStart)
+	}
+
+	return start.Before(otherEnd) && end.After(otherStart)
+}
+
+// OverlapsRestriction reports whether a stay from start to end competes with a room restriction
+func (p OverlapPolicy) OverlapsRestriction(start, end time.Time, r models.RoomRestriction) bool {
+	otherStart, otherEnd := p.Span(r)
+	return p.Overlaps(start, end, otherStart, otherEnd)
+}
+
+// Span returns the check-in and check-out dates a room restriction competes for its room with:
+// under the closed policy a block ends on the day it blocks rather than on the next one
+func (p OverlapPolicy) Span(r models.RoomRestriction) (time.Time, time.Time) {
+	if p == Closed && r.RestrictionId == models.RestrictionOwnerBlock {
+		return r.StartDate, r.EndDate.AddDate(0, 0, -1)
+	}
+
+	return r.StartDate, r.EndDate
+}
+
+// Condition returns the SQL condition matching the room restrictions in startColumn, endColumn and restrictionColumn
+// that overlap a stay bound to the start and end placeholders,
+// e.g. Condition("$1", "$2", "start_date", "end_date", "restriction_id")
+func (p OverlapPolicy) Condition(start, end, startColumn, endColumn, restrictionColumn string) string {
+	if p == Closed {
+		return fmt.Sprintf("%s <= %s and %s >= %s and (%s < %s or %s <> %d)",
+			start, endColumn, end, startColumn, start, endColumn, restrictionColumn, models.RestrictionOwnerBlock)
+	}
+
+	return fmt.Sprintf("%s < %s and %s > %s", start, endColumn, end, startColumn)
+}
+
+// Days returns the days a stay holds the room for, as shown on the calendar
+func (p OverlapPolicy) Days(start, end time.Time) []time.Time {
+	last := end.AddDate(0, 0, -1)
+	if p == Closed {
+		last = end
+	}
+
+	var days []time.Time
+	for d := start; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+
+	return days
+}
+
+// RestrictionDays returns the days a room restriction holds its room for, as shown on the calendar
+func (p OverlapPolicy) RestrictionDays(r models.RoomRestriction) []time.Time {
+	if r.RestrictionId == models.RestrictionOwnerBlock {
+		return HalfOpen.Days(r.StartDate, r.EndDate)
+	}
+
+	return p.Days(r.StartDate, r.EndDate)
+}
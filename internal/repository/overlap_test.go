@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlessioPani/go-booking/internal/models"
+)
+
+func date(day int) time.Time {
+	return time.Date(2040, time.January, day, 0, 0, 0, 0, time.UTC)
+}
+
+var overlapTests = []struct {
+	name     string
+	start    int
+	end      int
+	halfOpen bool
+	closed   bool
+}{
+	{"same dates", 5, 8, true, true},
+	{"inside", 6, 7, true, true},
+	{"covering", 3, 10, true, true},
+	{"overlapping arrival", 3, 6, true, true},
+	{"overlapping departure", 7, 10, true, true},
+	{"back to back after", 8, 10, false, true},
+	{"back to back before", 3, 5, false, true},
+	{"well after", 9, 12, false, false},
+	{"well before", 1, 4, false, false},
+}
+
+func TestOverlapPolicy_Overlaps(t *testing.T) {
+	// the existing stay is from the 5th to the 8th
+	for _, e := range overlapTests {
+		if got := HalfOpen.Overlaps(date(e.start), date(e.end), date(5), date(8)); got != e.halfOpen {
+			t.Errorf("half-open %s: expected %t, got %t", e.name, e.halfOpen, got)
+		}
+		if got := Closed.Overlaps(date(e.start), date(e.end), date(5), date(8)); got != e.closed {
+			t.Errorf("closed %s: expected %t, got %t", e.name, e.closed, got)
+		}
+	}
+}
+
+func TestOverlapPolicy_OverlapsRestriction(t *testing.T) {
+	// a block on the 15th
+	block := models.RoomRestriction{StartDate: date(15), EndDate: date(16), RestrictionId: models.RestrictionOwnerBlock}
+
+	var blockTests = []struct {
+		name     string
+		start    int
+		end      int
+		halfOpen bool
+		closed   bool
+	}{
+		{"the blocked night", 15, 16, true, true},
+		{"across the block", 14, 17, true, true},
+		{"leaving on the blocked day", 13, 15, false, true},
+		{"arriving the day after", 16, 18, false, false},
+	}
+
+	for _, e := range blockTests {
+		if got := HalfOpen.OverlapsRestriction(date(e.start), date(e.end), block); got != e.halfOpen {
+			t.Errorf("half-open %s: expected %t, got %t", e.name, e.halfOpen, got)
+		}
+		if got := Closed.OverlapsRestriction(date(e.start), date(e.end), block); got != e.closed {
+			t.Errorf("closed %s: expected %t, got %t", e.name, e.closed, got)
+		}
+	}
+
+	// a reservation keeps its check-out day under the closed policy
+	reservation := models.RoomRestriction{StartDate: date(15), EndDate: date(16), RestrictionId: models.RestrictionReservation}
+	if !Closed.OverlapsRestriction(date(16), date(18), reservation) {
+		t.Error("expected the check-out day of a reservation to be taken under the closed policy")
+	}
+}
+
+func TestOverlapPolicy_Condition(t *testing.T) {
+	var conditionTests = []struct {
+		policy   OverlapPolicy
+		expected string
+	}{
+		{HalfOpen, "$1 < rr.end_date and $2 > rr.start_date"},
+		{Closed, "$1 <= rr.end_date and $2 >= rr.start_date and ($1 < rr.end_date or rr.restriction_id <> 2)"},
+	}
+
+	for _, e := range conditionTests {
+		if got := e.policy.Condition("$1", "$2", "rr.start_date", "rr.end_date", "rr.restriction_id"); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.policy, e.expected, got)
+		}
+	}
+}
+
+func TestOverlapPolicy_Days(t *testing.T) {
+	var daysTests = []struct {
+		policy   OverlapPolicy
+		expected []int
+	}{
+		{HalfOpen, []int{5, 6, 7}},
+		{Closed, []int{5, 6, 7, 8}},
+	}
+
+	for _, e := range daysTests {
+		days := e.policy.Days(date(5), date(8))
+		if len(days) != len(e.expected) {
+			t.Fatalf("%s: expected %d days, got %d", e.policy, len(e.expected), len(days))
+		}
+		for i, d := range days {
+			if !d.Equal(date(e.expected[i])) {
+				t.Errorf("%s: expected day %d to be the %d, got %s", e.policy, i, e.expected[i], d)
+			}
+		}
+	}
+}
+
+func TestOverlapPolicy_RestrictionDays(t *testing.T) {
+	block := models.RoomRestriction{StartDate: date(15), EndDate: date(16), RestrictionId: models.RestrictionOwnerBlock}
+
+	for _, p := range []OverlapPolicy{HalfOpen, Closed} {
+		days := p.RestrictionDays(block)
+		if len(days) != 1 || !days[0].Equal(date(15)) {
+			t.Errorf("%s: expected a block to hold the 15th only, got %v", p, days)
+		}
+	}
+}
+
+func TestParseOverlapPolicy(t *testing.T) {
+	for _, p := range []OverlapPolicy{HalfOpen, Closed} {
+		parsed, err := ParseOverlapPolicy(p.String())
+		if err != nil || parsed != p {
+			t.Errorf("%s: expected to parse back, got %s, %v", p, parsed, err)
+		}
+	}
+
+	_, err := ParseOverlapPolicy("open")
+	if err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -1,30 +1,77 @@
 package repository
 
 import (
+	"context"
 	"time"
 
 	"github.com/AlessioPani/go-booking/internal/models"
 )
 
+// DatabaseRepo is the storage used by the handlers. Every method takes the context of the
+// request it serves, so that a client disconnect or a server shutdown cancels the query.
 type DatabaseRepo interface {
-	AllUsers() bool
-
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomId(start time.Time, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start time.Time, end time.Time) ([]models.Room, error)
-	GetRoomById(id int) (models.Room, error)
-	GetUserById(id int) (models.User, error)
-	UpdateUserById(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationById(id int) (models.Reservation, error)
-	UpdateReservation(r models.Reservation) error
-	DeleteReservation(id int) error
-	UpdatedProcessedForReservation(id int, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error)
-	AddBlockForRoom(roomId int, date time.Time) error
-	DeleteBlockById(id int) error
+	AllUsers(ctx context.Context) bool
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	InsertReservationGroup(ctx context.Context, lines []models.Reservation, holds []int) (int, []int, error)
+	InsertBooking(ctx context.Context, res models.Reservation, holdId int) (int, error)
+	GetReservationsByGroup(ctx context.Context, groupId int) ([]models.Reservation, error)
+	SearchAvailabilityByDatesByRoomId(ctx context.Context, start time.Time, end time.Time, roomId int) (bool, error)
+	SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx context.Context, start time.Time, end time.Time, roomId int, reservationId int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start time.Time, end time.Time, guests int) ([]models.Room, error)
+	AvailabilityCalendar(ctx context.Context, roomId int, from, to time.Time) ([]models.CalendarDay, error)
+	SearchFlexibleAvailability(ctx context.Context, from, to time.Time, nights, guests int) ([]models.StayOption, error)
+	GetRoomById(ctx context.Context, id int) (models.Room, error)
+	GetUserById(ctx context.Context, id int) (models.User, error)
+	UpdateUserById(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationById(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, r models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdatedProcessedForReservation(ctx context.Context, id int, processed int) error
+	UpdateReservationStatus(ctx context.Context, id int, status string) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error)
+	AddBlockForRoom(ctx context.Context, roomId int, date time.Time) error
+	DeleteBlockById(ctx context.Context, id int) error
+	InsertHold(ctx context.Context, r models.RoomRestriction) (int, error)
+	ConvertHold(ctx context.Context, holdId int, r models.RoomRestriction) error
+	DeleteHold(ctx context.Context, id int) error
+	DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error)
+	InsertAuditLog(ctx context.Context, a models.AuditLog) error
+	GetAuditLogsForEntity(ctx context.Context, entity string, entityId int) ([]models.AuditLog, error)
+
+	InsertPayment(ctx context.Context, p models.Payment) (int, error)
+	UpdatePaymentStatus(ctx context.Context, id int, status string) error
+	RefundPayment(ctx context.Context, id int, amount int) error
+	GetPaymentsForReservation(ctx context.Context, reservationId int) ([]models.Payment, error)
+	GetPaymentByReference(ctx context.Context, gateway, reference string) (models.Payment, error)
+
+	AllPromotions(ctx context.Context) ([]models.Promotion, error)
+	GetPromotionById(ctx context.Context, id int) (models.Promotion, error)
+	GetPromotionByCode(ctx context.Context, code string) (models.Promotion, error)
+	InsertPromotion(ctx context.Context, p models.Promotion) (int, error)
+	UpdatePromotion(ctx context.Context, p models.Promotion) error
+	DeletePromotion(ctx context.Context, id int) error
+	UsePromotion(ctx context.Context, id int) error
+	ReleasePromotion(ctx context.Context, id int) error
+
+	AllTaxRules(ctx context.Context) ([]models.TaxRule, error)
+	GetTaxRuleById(ctx context.Context, id int) (models.TaxRule, error)
+	InsertTaxRule(ctx context.Context, t models.TaxRule) (int, error)
+	UpdateTaxRule(ctx context.Context, t models.TaxRule) error
+	DeleteTaxRule(ctx context.Context, id int) error
+	AllReservationTaxes(ctx context.Context) ([]models.ReservationTax, error)
+	TaxReport(ctx context.Context, start, end time.Time) ([]models.TaxReportLine, error)
+
+	InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error)
+	WaitingForDates(ctx context.Context, start, end time.Time, roomId int) ([]models.WaitlistEntry, error)
+	OfferWaitlistEntry(ctx context.Context, id int, token string, expiresAt time.Time) error
+	GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error)
+
+	IssueInvoice(ctx context.Context, reservationId int, issuedAt time.Time) (models.Invoice, error)
+	GetInvoiceForReservation(ctx context.Context, reservationId int) (models.Invoice, error)
 }
@@ -0,0 +1,1815 @@
+// Package repotest holds behavioural tests that every repository.DatabaseRepo implementation must pass.
+//
+// An implementation runs the suite from its own tests:
+//
+//	func TestContract(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repository.DatabaseRepo { ... })
+//	}
+//
+// The factory is called once per test and must return an empty store that only holds
+// the seeded rooms (1 for up to 2 guests at 90.00 a night, free to cancel until 7 days before arrival then 20% of the stay,
+// 2 for up to 4 at 150.00, free to cancel until 14 days before arrival then 30%, both with a 30% deposit) and restrictions (1 reservation, 2 owner block, 3 hold),
+// configured with the default half-open overlap policy.
+//
+// The behaviour that depends on the overlap policy is checked under every policy by RunPolicies.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AlessioPani/go-booking/internal/models"
+	"github.com/AlessioPani/go-booking/internal/repository"
+)
+
+// Factory returns a fresh repository for a single test
+type Factory func(t *testing.T) repository.DatabaseRepo
+
+// PolicyFactory returns a fresh repository for a single test, configured with the given overlap policy
+type PolicyFactory func(t *testing.T, policy repository.OverlapPolicy) repository.DatabaseRepo
+
+// Ids of the seeded rows
+const (
+	roomOne                = 1
+	roomTwo                = 2
+	roomOneOccupancy       = 2
+	roomTwoOccupancy       = 4
+	roomOneRate            = 9000
+	roomTwoRate            = 15000
+	depositPercent         = 30
+	restrictionReservation = 1
+	restrictionOwnerBlock  = 2
+)
+
+// cancellation policies of the seeded rooms, free days and penalty percent
+var cancellationPolicies = map[int][2]int{roomOne: {7, 20}, roomTwo: {14, 30}}
+
+// date returns midnight UTC of the given day in January 2040
+func date(day int) time.Time {
+	return time.Date(2040, time.January, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Run runs the whole contract suite against the repositories returned by newRepo
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"Rooms", testRooms},
+		{"Overlap", testOverlap},
+		{"SameDayTurnover", testSameDayTurnover},
+		{"ExcludingReservation", testExcludingReservation},
+		{"InsertOverlapping", testInsertOverlapping},
+		{"RestrictionsByDate", testRestrictionsByDate},
+		{"Blocks", testBlocks},
+		{"UpdateReservation", testUpdateReservation},
+		{"Language", testLanguage},
+		{"Occupancy", testOccupancy},
+		{"Guests", testGuests},
+		{"Status", testStatus},
+		{"DeleteReservation", testDeleteReservation},
+		{"MissingRows", testMissingRows},
+		{"AuditLog", testAuditLog},
+		{"Rates", testRates},
+		{"Payments", testPayments},
+		{"Holds", testHolds},
+		{"ConvertHold", testConvertHold},
+		{"InsertBooking", testInsertBooking},
+		{"Promotions", testPromotions},
+		{"PromotionUses", testPromotionUses},
+		{"Invoices", testInvoices},
+		{"TaxRules", testTaxRules},
+		{"ReservationTaxes", testReservationTaxes},
+		{"Waitlist", testWaitlist},
+		{"AvailabilityCalendar", testAvailabilityCalendar},
+		{"FlexibleAvailability", testFlexibleAvailability},
+		{"ReservationGroups", testReservationGroups},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			e.test(t, newRepo(t))
+		})
+	}
+}
+
+// RunPolicies runs the tests that depend on the overlap policy once for each policy
+func RunPolicies(t *testing.T, newRepo PolicyFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.DatabaseRepo, policy repository.OverlapPolicy)
+	}{
+		{"OneDayBlock", testOneDayBlock},
+	}
+
+	for _, policy := range []repository.OverlapPolicy{repository.HalfOpen, repository.Closed} {
+		for _, e := range tests {
+			t.Run(policy.String()+"/"+e.name, func(t *testing.T) {
+				e.test(t, newRepo(t, policy), policy)
+			})
+		}
+	}
+}
+
+// book inserts a reservation and its room restriction, as the booking handlers do
+func book(t *testing.T, repo repository.DatabaseRepo, roomId int, start, end time.Time) int {
+	t.Helper()
+	ctx := context.Background()
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "123456789",
+		StartDate: start,
+		EndDate:   end,
+		RoomId:    roomId,
+	})
+	if err != nil {
+		t.Fatalf("InsertReservation: %v", err)
+	}
+
+	err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomId:        roomId,
+		ReservationId: id,
+		RestrictionId: restrictionReservation,
+	})
+	if err != nil {
+		t.Fatalf("InsertRoomRestriction: %v", err)
+	}
+
+	return id
+}
+
+// available reports whether the room is free between start and end
+func available(t *testing.T, repo repository.DatabaseRepo, roomId int, start, end time.Time) bool {
+	t.Helper()
+
+	ok, err := repo.SearchAvailabilityByDatesByRoomId(context.Background(), start, end, roomId)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityByDatesByRoomId: %v", err)
+	}
+
+	return ok
+}
+
+// availableRooms returns the ids of the rooms free between start and end for a single guest
+func availableRooms(t *testing.T, repo repository.DatabaseRepo, start, end time.Time) map[int]bool {
+	t.Helper()
+
+	return roomsForGuests(t, repo, start, end, 1)
+}
+
+// roomsForGuests returns the ids of the rooms free between start and end that can host the guests
+func roomsForGuests(t *testing.T, repo repository.DatabaseRepo, start, end time.Time, guests int) map[int]bool {
+	t.Helper()
+
+	rooms, err := repo.SearchAvailabilityForAllRooms(context.Background(), start, end, guests)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityForAllRooms: %v", err)
+	}
+
+	ids := make(map[int]bool)
+	for _, room := range rooms {
+		ids[room.ID] = true
+	}
+
+	return ids
+}
+
+func testRooms(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 {
+		t.Fatalf("expected 2 rooms, got %d", len(rooms))
+	}
+
+	room, err := repo.GetRoomById(ctx, roomTwo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.ID != roomTwo || room.RoomName == "" {
+		t.Errorf("unexpected room %+v", room)
+	}
+
+	ids := availableRooms(t, repo, date(5), date(8))
+	if !ids[roomOne] || !ids[roomTwo] {
+		t.Errorf("expected every room to be available in an empty store, got %v", ids)
+	}
+}
+
+func testOverlap(t *testing.T, repo repository.DatabaseRepo) {
+	// room one is taken for the nights of the 5th, 6th and 7th
+	book(t, repo, roomOne, date(5), date(8))
+
+	var overlapTests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"same dates", date(5), date(8), false},
+		{"inside", date(6), date(7), false},
+		{"covering", date(3), date(10), false},
+		{"overlapping arrival", date(3), date(6), false},
+		{"overlapping departure", date(7), date(10), false},
+		{"last night", date(7), date(8), false},
+		{"first night", date(5), date(6), false},
+		{"ending on arrival day", date(3), date(5), true},
+		{"starting on departure day", date(8), date(10), true},
+		{"well before", date(1), date(3), true},
+		{"well after", date(20), date(25), true},
+	}
+
+	for _, e := range overlapTests {
+		if got := available(t, repo, roomOne, e.start, e.end); got != e.available {
+			t.Errorf("%s: expected room availability %t, got %t", e.name, e.available, got)
+		}
+
+		ids := availableRooms(t, repo, e.start, e.end)
+		if ids[roomOne] != e.available {
+			t.Errorf("%s: expected room in search results %t, got %t", e.name, e.available, ids[roomOne])
+		}
+		if !ids[roomTwo] {
+			t.Errorf("%s: expected the other room to be available", e.name)
+		}
+	}
+}
+
+func testSameDayTurnover(t *testing.T, repo repository.DatabaseRepo) {
+	first := book(t, repo, roomOne, date(5), date(8))
+
+	if !available(t, repo, roomOne, date(8), date(10)) {
+		t.Fatal("expected the room to be bookable on the departure day")
+	}
+	second := book(t, repo, roomOne, date(8), date(10))
+
+	if first == second {
+		t.Fatalf("expected distinct reservation ids, got %d twice", first)
+	}
+
+	if available(t, repo, roomOne, date(7), date(9)) {
+		t.Error("expected a stay across both reservations to be refused")
+	}
+	if !available(t, repo, roomOne, date(10), date(12)) {
+		t.Error("expected the room to be bookable after the second departure")
+	}
+
+	reservations, err := repo.AllReservations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reservations) != 2 {
+		t.Errorf("expected 2 reservations, got %d", len(reservations))
+	}
+}
+
+func testExcludingReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, roomOne, date(5), date(8))
+	other := book(t, repo, roomOne, date(10), date(12))
+
+	ok, err := repo.SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx, date(4), date(9), roomOne, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("expected a reservation not to conflict with itself")
+	}
+
+	ok, err = repo.SearchAvailabilityByDatesByRoomIdExcludingReservation(ctx, date(4), date(11), roomOne, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expected a conflict with reservation %d", other)
+	}
+}
+
+func testInsertOverlapping(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	book(t, repo, roomOne, date(5), date(8))
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: date(7),
+		EndDate:   date(9),
+		RoomId:    roomOne,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     date(7),
+		EndDate:       date(9),
+		RoomId:        roomOne,
+		ReservationId: id,
+		RestrictionId: restrictionReservation,
+	})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Fatalf("expected repository.ErrRoomNotAvailable for an overlapping stay, got %v", err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 {
+		t.Errorf("expected the refused restriction not to be stored, got %d restrictions", len(restrictions))
+	}
+}
+
+func testRestrictionsByDate(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	book(t, repo, roomOne, date(5), date(8))
+
+	var restrictionTests = []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		expected int
+	}{
+		{"covering", date(1), date(31), 1},
+		{"ending on arrival day", date(1), date(5), 0},
+		{"starting on departure day", date(8), date(31), 0},
+		{"including the last night", date(7), date(31), 1},
+	}
+
+	for _, e := range restrictionTests {
+		restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, e.start, e.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(restrictions) != e.expected {
+			t.Errorf("%s: expected %d restrictions, got %d", e.name, e.expected, len(restrictions))
+		}
+	}
+}
+
+func testBlocks(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	reservationId := book(t, repo, roomOne, date(5), date(8))
+
+	err := repo.AddBlockForRoom(ctx, roomOne, date(15))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 {
+		t.Fatalf("expected a reservation and a block, got %d restrictions", len(restrictions))
+	}
+
+	var block, reservation models.RoomRestriction
+	for _, r := range restrictions {
+		if r.ReservationId == 0 {
+			block = r
+		} else {
+			reservation = r
+		}
+	}
+
+	if block.RestrictionId != restrictionOwnerBlock || !block.StartDate.Equal(date(15)) || !block.EndDate.Equal(date(16)) {
+		t.Errorf("unexpected block %+v", block)
+	}
+	if reservation.ReservationId != reservationId || reservation.RestrictionId != restrictionReservation {
+		t.Errorf("unexpected reservation restriction %+v", reservation)
+	}
+
+	var blockTests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"across the block", date(14), date(16), false},
+		{"the blocked night", date(15), date(16), false},
+		{"leaving on the blocked day", date(13), date(15), true},
+		{"arriving the day after", date(16), date(18), true},
+	}
+
+	for _, e := range blockTests {
+		if got := available(t, repo, roomOne, e.start, e.end); got != e.available {
+			t.Errorf("%s: expected room availability %t, got %t", e.name, e.available, got)
+		}
+	}
+
+	err = repo.AddBlockForRoom(ctx, roomOne, date(6))
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected a block on a booked night to be refused, got %v", err)
+	}
+	if !available(t, repo, roomTwo, date(14), date(16)) {
+		t.Error("expected a block to only affect its room")
+	}
+
+	other, err := repo.GetRestrictionsForRoomByDate(ctx, roomTwo, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other) != 0 {
+		t.Errorf("expected no restrictions for the other room, got %d", len(other))
+	}
+
+	err = repo.DeleteBlockById(ctx, block.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !available(t, repo, roomOne, date(14), date(16)) {
+		t.Error("expected the room to be free once the block is removed")
+	}
+	if available(t, repo, roomOne, date(5), date(8)) {
+		t.Error("expected removing a block to leave reservations alone")
+	}
+}
+
+func testUpdateReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, roomOne, date(5), date(8))
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.FirstName = "Jane"
+	res.StartDate = date(10)
+	res.EndDate = date(12)
+	res.RoomId = roomTwo
+
+	err = repo.UpdateReservation(ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FirstName != "Jane" || res.RoomId != roomTwo || !res.StartDate.Equal(date(10)) || !res.EndDate.Equal(date(12)) {
+		t.Errorf("reservation was not updated: %+v", res)
+	}
+
+	if !available(t, repo, roomOne, date(5), date(8)) {
+		t.Error("expected the old room and dates to be freed")
+	}
+	if available(t, repo, roomTwo, date(10), date(12)) {
+		t.Error("expected the new room and dates to be taken")
+	}
+
+	// a stay can move within its own dates, but not onto another booking
+	res.StartDate = date(11)
+	res.EndDate = date(13)
+	if err := repo.UpdateReservation(ctx, res); err != nil {
+		t.Errorf("expected a stay to overlap its own dates, got %v", err)
+	}
+
+	other := book(t, repo, roomOne, date(20), date(22))
+	res.RoomId = roomOne
+	res.StartDate = date(21)
+	res.EndDate = date(23)
+	if err := repo.UpdateReservation(ctx, res); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected ErrRoomNotAvailable, got %v", err)
+	}
+	if res, err = repo.GetReservationById(ctx, id); err != nil || res.RoomId != roomTwo || !res.StartDate.Equal(date(11)) {
+		t.Errorf("expected a refused change to keep the reservation, got %+v, %v", res, err)
+	}
+
+	// cancelled and deleted reservations can't be changed
+	if err := repo.UpdateReservationStatus(ctx, id, models.ReservationStatusCancelled); err != nil {
+		t.Fatal(err)
+	}
+	res.StartDate = date(25)
+	res.EndDate = date(26)
+	if err := repo.UpdateReservation(ctx, res); !errors.Is(err, repository.ErrReservationCancelled) {
+		t.Errorf("expected ErrReservationCancelled, got %v", err)
+	}
+
+	if err := repo.DeleteReservation(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	deleted := models.Reservation{ID: other, RoomId: roomOne, StartDate: date(20), EndDate: date(22)}
+	if err := repo.UpdateReservation(ctx, deleted); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted reservation, got %v", err)
+	}
+	if !available(t, repo, roomOne, date(20), date(22)) {
+		t.Error("expected a deleted reservation to stay without its room")
+	}
+}
+
+func testLanguage(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, roomOne, date(5), date(8))
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Language != "en" {
+		t.Errorf("expected reservations without a language to be in English, got %q", res.Language)
+	}
+
+	id, err = repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "Mario",
+		LastName:  "Rossi",
+		Email:     "mario@rossi.it",
+		StartDate: date(10),
+		EndDate:   date(12),
+		RoomId:    roomOne,
+		Language:  "it",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Language != "it" {
+		t.Errorf("expected the stored language to be it, got %q", res.Language)
+	}
+}
+
+func testOccupancy(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	for id, expected := range map[int]int{roomOne: roomOneOccupancy, roomTwo: roomTwoOccupancy} {
+		room, err := repo.GetRoomById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if room.MaxOccupancy != expected {
+			t.Errorf("room %d: expected max occupancy %d, got %d", id, expected, room.MaxOccupancy)
+		}
+	}
+
+	var occupancyTests = []struct {
+		guests   int
+		expected map[int]bool
+	}{
+		{1, map[int]bool{roomOne: true, roomTwo: true}},
+		{roomOneOccupancy, map[int]bool{roomOne: true, roomTwo: true}},
+		{roomOneOccupancy + 1, map[int]bool{roomTwo: true}},
+		{roomTwoOccupancy, map[int]bool{roomTwo: true}},
+		{roomTwoOccupancy + 1, map[int]bool{}},
+	}
+
+	for _, e := range occupancyTests {
+		got := roomsForGuests(t, repo, date(5), date(8), e.guests)
+		if len(got) != len(e.expected) || got[roomOne] != e.expected[roomOne] || got[roomTwo] != e.expected[roomTwo] {
+			t.Errorf("%d guests: expected rooms %v, got %v", e.guests, e.expected, got)
+		}
+	}
+}
+
+func testGuests(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName:       "John",
+		LastName:        "Smith",
+		Email:           "john@smith.com",
+		StartDate:       date(5),
+		EndDate:         date(8),
+		RoomId:          roomTwo,
+		Adults:          2,
+		Children:        1,
+		SpecialRequests: "A cot, please",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Adults != 2 || res.Children != 1 || res.SpecialRequests != "A cot, please" {
+		t.Errorf("guests were not stored: %d adults, %d children, %q", res.Adults, res.Children, res.SpecialRequests)
+	}
+	if res.Room.MaxOccupancy != roomTwoOccupancy {
+		t.Errorf("expected the room of the reservation to hold %d guests, got %d", roomTwoOccupancy, res.Room.MaxOccupancy)
+	}
+
+	res.Adults = 1
+	res.Children = 0
+	res.SpecialRequests = ""
+	err = repo.UpdateReservation(ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Adults != 1 || all[0].Children != 0 {
+		t.Errorf("guests were not updated: %+v", all)
+	}
+
+	// a reservation without guests is for one adult
+	id = book(t, repo, roomOne, date(10), date(12))
+	res, err = repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Adults != 1 || res.Children != 0 {
+		t.Errorf("expected one adult by default, got %d adults and %d children", res.Adults, res.Children)
+	}
+}
+
+func testStatus(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, roomOne, date(5), date(8))
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.ReservationStatusPending {
+		t.Errorf("expected a new reservation to be %q, got %q", models.ReservationStatusPending, res.Status)
+	}
+
+	newReservations, err := repo.AllNewReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newReservations) != 1 {
+		t.Errorf("expected 1 new reservation, got %d", len(newReservations))
+	}
+
+	err = repo.UpdatedProcessedForReservation(ctx, id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, _ = repo.GetReservationById(ctx, id)
+	if res.Processed != 1 || res.Status != models.ReservationStatusConfirmed {
+		t.Errorf("expected a processed reservation to be confirmed, got processed %d status %q", res.Processed, res.Status)
+	}
+
+	newReservations, _ = repo.AllNewReservations(ctx)
+	if len(newReservations) != 0 {
+		t.Errorf("expected no new reservations, got %d", len(newReservations))
+	}
+
+	err = repo.UpdateReservationStatus(ctx, id, models.ReservationStatusCheckedIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available(t, repo, roomOne, date(5), date(8)) {
+		t.Error("expected a checked in reservation to keep its room")
+	}
+
+	cancelled := book(t, repo, roomTwo, date(5), date(8))
+	err = repo.UpdateReservationStatus(ctx, cancelled, models.ReservationStatusCancelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available(t, repo, roomTwo, date(5), date(8)) {
+		t.Error("expected a cancelled reservation to free its room")
+	}
+
+	res, _ = repo.GetReservationById(ctx, cancelled)
+	if res.Status != models.ReservationStatusCancelled {
+		t.Errorf("expected status %q, got %q", models.ReservationStatusCancelled, res.Status)
+	}
+}
+
+func testDeleteReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, roomOne, date(5), date(8))
+	kept := book(t, repo, roomTwo, date(5), date(8))
+
+	err := repo.DeleteReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !available(t, repo, roomOne, date(5), date(8)) {
+		t.Error("expected deleting a reservation to free its room")
+	}
+
+	if _, err := repo.GetReservationById(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a deleted reservation to be not found, got %v", err)
+	}
+	if available(t, repo, roomTwo, date(5), date(8)) {
+		t.Error("expected other reservations to keep their room")
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 0 {
+		t.Errorf("expected the restrictions of a deleted reservation to be removed, got %d", len(restrictions))
+	}
+
+	for name, list := range map[string]func(context.Context) ([]models.Reservation, error){
+		"AllReservations":    repo.AllReservations,
+		"AllNewReservations": repo.AllNewReservations,
+	} {
+		reservations, err := list(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reservations) != 1 || reservations[0].ID != kept {
+			t.Errorf("%s: expected only reservation %d, got %+v", name, kept, reservations)
+		}
+	}
+}
+
+func testMissingRows(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	_, err := repo.GetReservationById(ctx, 1000)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetReservationById: expected sql.ErrNoRows, got %v", err)
+	}
+
+	_, err = repo.GetRoomById(ctx, 1000)
+	if err == nil {
+		t.Error("GetRoomById: expected an error for a missing room")
+	}
+
+	_, err = repo.GetUserById(ctx, 1000)
+	if err == nil {
+		t.Error("GetUserById: expected an error for a missing user")
+	}
+}
+
+func testAuditLog(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	for _, action := range []string{"created", "updated", "deleted"} {
+		err := repo.InsertAuditLog(ctx, models.AuditLog{Entity: "reservation", EntityId: 7, Action: action, Details: action})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := repo.InsertAuditLog(ctx, models.AuditLog{Entity: "room", EntityId: 7, Action: "block added"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := repo.GetAuditLogsForEntity(ctx, "reservation", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(logs))
+	}
+	if logs[0].Action != "deleted" || logs[2].Action != "created" {
+		t.Errorf("expected newest entries first, got %q ... %q", logs[0].Action, logs[2].Action)
+	}
+	if logs[0].UserId != 0 {
+		t.Errorf("expected no user for an anonymous entry, got %d", logs[0].UserId)
+	}
+}
+
+func testRates(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	for id, expected := range map[int]int{roomOne: roomOneRate, roomTwo: roomTwoRate} {
+		room, err := repo.GetRoomById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if room.NightlyRate != expected || room.DepositPercent != depositPercent || room.DepositFixed != 0 {
+			t.Errorf("room %d: expected rate %d with a %d%% deposit, got %d with %d%% and %d fixed",
+				id, expected, depositPercent, room.NightlyRate, room.DepositPercent, room.DepositFixed)
+		}
+		if policy := cancellationPolicies[id]; room.FreeCancellationDays != policy[0] || room.CancellationPercent != policy[1] {
+			t.Errorf("room %d: expected the cancellation policy %v, got %d days and %d%%",
+				id, policy, room.FreeCancellationDays, room.CancellationPercent)
+		}
+	}
+
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, date(5), date(8), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range rooms {
+		if room.NightlyRate == 0 {
+			t.Errorf("room %d: expected the search to return its rate", room.ID)
+		}
+	}
+
+	res, err := repo.GetReservationById(ctx, book(t, repo, roomTwo, date(5), date(8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Room.NightlyRate != roomTwoRate || res.Room.DepositPercent != depositPercent {
+		t.Errorf("expected the room of the reservation to cost %d, got %d", roomTwoRate, res.Room.NightlyRate)
+	}
+	if policy := cancellationPolicies[roomTwo]; res.Room.FreeCancellationDays != policy[0] || res.Room.CancellationPercent != policy[1] {
+		t.Errorf("expected the room of the reservation to have the cancellation policy %v, got %+v", policy, res.Room)
+	}
+
+	// the lists carry what is needed to price the stays
+	for name, list := range map[string]func(context.Context) ([]models.Reservation, error){
+		"AllReservations":    repo.AllReservations,
+		"AllNewReservations": repo.AllNewReservations,
+	} {
+		reservations, err := list(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reservations) != 1 || reservations[0].Room.NightlyRate != roomTwoRate {
+			t.Errorf("%s: expected the reservation with the rate of its room, got %+v", name, reservations)
+		}
+	}
+}
+
+func testPayments(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	resId := book(t, repo, roomOne, date(5), date(8))
+
+	for _, ref := range []string{"fake_1", "fake_2"} {
+		_, err := repo.InsertPayment(ctx, models.Payment{
+			ReservationId: resId,
+			Gateway:       "fake",
+			Reference:     ref,
+			Amount:        8100,
+			Currency:      "EUR",
+			Status:        models.PaymentStatusAuthorized,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := repo.GetPaymentByReference(ctx, "fake", "fake_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ReservationId != resId || p.Amount != 8100 || p.Currency != "EUR" || p.Status != models.PaymentStatusAuthorized {
+		t.Errorf("unexpected payment %+v", p)
+	}
+
+	err = repo.UpdatePaymentStatus(ctx, p.ID, models.PaymentStatusCaptured)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payments, err := repo.GetPaymentsForReservation(ctx, resId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 {
+		t.Fatalf("expected 2 payments, got %d", len(payments))
+	}
+	if payments[0].Reference != "fake_1" || payments[1].Status != models.PaymentStatusCaptured {
+		t.Errorf("expected the oldest payment first and the second captured, got %+v", payments)
+	}
+
+	err = repo.RefundPayment(ctx, payments[0].ID, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = repo.GetPaymentByReference(ctx, "fake", "fake_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != models.PaymentStatusRefunded || p.Refunded != 3000 {
+		t.Errorf("expected 3000 of the payment to be refunded, got %+v", p)
+	}
+
+	_, err = repo.GetPaymentByReference(ctx, "other", "fake_1")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a reference of another gateway, got %v", err)
+	}
+
+	payments, err = repo.GetPaymentsForReservation(ctx, resId+1)
+	if err != nil || len(payments) != 0 {
+		t.Errorf("expected no payments for another reservation, got %v, %v", payments, err)
+	}
+}
+
+// hold holds a room between start and end until expiresAt
+func hold(t *testing.T, repo repository.DatabaseRepo, roomId int, start, end, expiresAt time.Time) int {
+	t.Helper()
+
+	id, err := repo.InsertHold(context.Background(), models.RoomRestriction{
+		StartDate: start,
+		EndDate:   end,
+		RoomId:    roomId,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("InsertHold: %v", err)
+	}
+
+	return id
+}
+
+func testHolds(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := repo.InsertHold(ctx, models.RoomRestriction{StartDate: date(5), EndDate: date(8), RoomId: roomOne})
+	if err == nil {
+		t.Error("expected an error for a hold without an expiry")
+	}
+
+	held := hold(t, repo, roomOne, date(5), date(8), now.Add(time.Hour))
+
+	if available(t, repo, roomOne, date(6), date(7)) {
+		t.Error("expected a held room not to be available")
+	}
+
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, date(6), date(7), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != roomTwo {
+		t.Errorf("expected only the room that is not held to be offered, got %+v", rooms)
+	}
+
+	_, err = repo.InsertHold(ctx, models.RoomRestriction{StartDate: date(7), EndDate: date(9), RoomId: roomOne, ExpiresAt: now.Add(time.Hour)})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected a second hold on the same nights to be refused, got %v", err)
+	}
+
+	err = repo.InsertRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     date(7),
+		EndDate:       date(9),
+		RoomId:        roomOne,
+		RestrictionId: restrictionOwnerBlock,
+	})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected a block on held nights to be refused, got %v", err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 0 {
+		t.Errorf("expected holds not to be listed with the restrictions, got %+v", restrictions)
+	}
+
+	// an expired hold leaves the room free, even before it is removed
+	hold(t, repo, roomTwo, date(5), date(8), now.Add(-time.Minute))
+	if !available(t, repo, roomTwo, date(5), date(8)) {
+		t.Error("expected an expired hold not to take the room")
+	}
+	hold(t, repo, roomTwo, date(5), date(8), now.Add(time.Hour))
+
+	deleted, err := repo.DeleteExpiredHolds(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 expired hold to be removed, got %d", deleted)
+	}
+	if available(t, repo, roomTwo, date(5), date(8)) {
+		t.Error("expected the hold that did not expire to be kept")
+	}
+
+	err = repo.DeleteHold(ctx, held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available(t, repo, roomOne, date(5), date(8)) {
+		t.Error("expected a released hold to free the room")
+	}
+}
+
+func testConvertHold(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	now := time.Now()
+
+	held := hold(t, repo, roomOne, date(5), date(8), now.Add(time.Hour))
+
+	resId, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: date(5),
+		EndDate:   date(8),
+		RoomId:    roomOne,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restriction := models.RoomRestriction{
+		StartDate:     date(5),
+		EndDate:       date(8),
+		RoomId:        roomOne,
+		ReservationId: resId,
+		RestrictionId: restrictionReservation,
+	}
+
+	other := restriction
+	other.EndDate = date(9)
+	if err := repo.ConvertHold(ctx, held, other); !errors.Is(err, repository.ErrHoldExpired) {
+		t.Errorf("expected a hold for other dates not to be converted, got %v", err)
+	}
+
+	if err := repo.ConvertHold(ctx, held, restriction); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.ConvertHold(ctx, held, restriction); !errors.Is(err, repository.ErrHoldExpired) {
+		t.Errorf("expected a hold to be converted once, got %v", err)
+	}
+
+	// the converted hold is the restriction of the reservation, it never expires
+	if err := repo.DeleteHold(ctx, held); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DeleteExpiredHolds(ctx, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 {
+		t.Fatalf("expected the restriction of the reservation, got %d restrictions", len(restrictions))
+	}
+	if restrictions[0].ID != held || restrictions[0].ReservationId != resId || restrictions[0].RestrictionId != restrictionReservation {
+		t.Errorf("unexpected restriction %+v", restrictions[0])
+	}
+
+	expired := hold(t, repo, roomTwo, date(5), date(8), now.Add(-time.Minute))
+	restriction.RoomId = roomTwo
+	if err := repo.ConvertHold(ctx, expired, restriction); !errors.Is(err, repository.ErrHoldExpired) {
+		t.Errorf("expected an expired hold not to be converted, got %v", err)
+	}
+}
+
+func testInsertBooking(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	now := time.Now()
+
+	taken := book(t, repo, roomOne, date(10), date(12))
+	held := hold(t, repo, roomTwo, date(5), date(8), now.Add(time.Hour))
+
+	res := models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "123456789",
+		StartDate: date(11), EndDate: date(13), RoomId: roomOne, Adults: 2}
+
+	// a room taken meanwhile leaves no reservation behind
+	if _, err := repo.InsertBooking(ctx, res, 0); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Fatalf("expected ErrRoomNotAvailable, got %v", err)
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ID != taken {
+		t.Errorf("expected a failed booking to insert no reservation, got %+v", all)
+	}
+
+	// the hold of another guest is not released
+	res.RoomId, res.StartDate, res.EndDate = roomTwo, date(6), date(9)
+	if _, err := repo.InsertBooking(ctx, res, 0); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Fatalf("expected the hold to keep the room, got %v", err)
+	}
+
+	// the guest's own hold is released in favour of the reservation
+	id, err := repo.InsertBooking(ctx, res, held)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomTwo, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 || restrictions[0].ReservationId != id || restrictions[0].RestrictionId != models.RestrictionReservation {
+		t.Errorf("expected only the restriction of reservation %d, got %+v", id, restrictions)
+	}
+	if available(t, repo, roomTwo, date(8), date(9)) {
+		t.Error("expected the booked room to be taken")
+	}
+}
+
+func testPromotions(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertPromotion(ctx, models.Promotion{
+		Code:            " summer40 ",
+		Description:     "Summer 2040",
+		DiscountPercent: 10,
+		ValidFrom:       date(1),
+		ValidTo:         date(31),
+		RoomId:          roomTwo,
+		MinNights:       3,
+		MaxUses:         5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.InsertPromotion(ctx, models.Promotion{Code: "FIXED", DiscountFixed: 2000, ValidFrom: date(1), ValidTo: date(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := repo.GetPromotionByCode(ctx, "Summer40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != id || p.Code != "SUMMER40" || p.Description != "Summer 2040" || p.DiscountPercent != 10 || p.RoomId != roomTwo ||
+		p.MinNights != 3 || p.MaxUses != 5 || p.Uses != 0 {
+		t.Errorf("unexpected promotion %+v", p)
+	}
+	if !p.ValidFrom.Equal(date(1)) || !p.ValidTo.Equal(date(31)) {
+		t.Errorf("expected the promotion to be valid from %s to %s, got %s to %s", date(1), date(31), p.ValidFrom, p.ValidTo)
+	}
+
+	_, err = repo.InsertPromotion(ctx, models.Promotion{Code: "summer40", ValidFrom: date(1), ValidTo: date(2)})
+	if err == nil {
+		t.Error("expected an error for a duplicate code")
+	}
+
+	promotions, err := repo.AllPromotions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promotions) != 2 || promotions[0].Code != "FIXED" || promotions[0].RoomId != 0 || promotions[0].DiscountFixed != 2000 {
+		t.Errorf("expected 2 promotions ordered by code, the first for any room, got %+v", promotions)
+	}
+
+	err = repo.UsePromotion(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Code = "winter40"
+	p.RoomId = 0
+	p.MaxUses = 0
+	p.ValidTo = date(20)
+	p.Uses = 0
+	err = repo.UpdatePromotion(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = repo.GetPromotionById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "WINTER40" || p.RoomId != 0 || p.MaxUses != 0 || !p.ValidTo.Equal(date(20)) {
+		t.Errorf("expected the promotion to be updated, got %+v", p)
+	}
+	if p.Uses != 1 {
+		t.Errorf("expected an update to keep the uses, got %d", p.Uses)
+	}
+
+	err = repo.DeletePromotion(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.GetPromotionById(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted promotion, got %v", err)
+	}
+
+	_, err = repo.GetPromotionByCode(ctx, "WINTER40")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted code, got %v", err)
+	}
+
+	resId, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: date(5),
+		EndDate:   date(8),
+		RoomId:    roomOne,
+		PromoCode: "FIXED",
+		Discount:  2000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationById(ctx, resId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromoCode != "FIXED" || res.Discount != 2000 {
+		t.Errorf("expected the reservation to keep its discount, got %q and %d", res.PromoCode, res.Discount)
+	}
+}
+
+func testPromotionUses(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertPromotion(ctx, models.Promotion{Code: "TWICE", DiscountPercent: 5, ValidFrom: date(1), ValidTo: date(31), MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := repo.UsePromotion(ctx, id); err != nil {
+			t.Fatalf("use %d: %v", i+1, err)
+		}
+	}
+
+	err = repo.UsePromotion(ctx, id)
+	if !errors.Is(err, repository.ErrPromotionUsedUp) {
+		t.Errorf("expected ErrPromotionUsedUp past the maximum uses, got %v", err)
+	}
+
+	err = repo.ReleasePromotion(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.UsePromotion(ctx, id)
+	if err != nil {
+		t.Errorf("expected a released use to be available again, got %v", err)
+	}
+
+	p, err := repo.GetPromotionById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Uses != 2 || !p.UsedUp() {
+		t.Errorf("expected the promotion to be used up with 2 uses, got %d", p.Uses)
+	}
+
+	unlimited, err := repo.InsertPromotion(ctx, models.Promotion{Code: "ALWAYS", DiscountPercent: 5, ValidFrom: date(1), ValidTo: date(31)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := repo.UsePromotion(ctx, unlimited); err != nil {
+			t.Fatalf("expected a promotion without a maximum to be used again, got %v", err)
+		}
+	}
+
+	// a release never takes the uses below zero
+	id, err = repo.InsertPromotion(ctx, models.Promotion{Code: "UNUSED", DiscountPercent: 5, ValidFrom: date(1), ValidTo: date(31)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ReleasePromotion(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := repo.GetPromotionById(ctx, id); err != nil || p.Uses != 0 {
+		t.Errorf("expected no uses, got %d, %v", p.Uses, err)
+	}
+}
+
+func testInvoices(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	first := book(t, repo, roomOne, date(5), date(8))
+	second := book(t, repo, roomTwo, date(5), date(8))
+	issuedAt := time.Date(2040, time.January, 8, 10, 30, 0, 0, time.UTC)
+
+	_, err := repo.GetInvoiceForReservation(ctx, first)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows before the invoice is issued, got %v", err)
+	}
+
+	inv, err := repo.IssueInvoice(ctx, first, issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Number != 1 || inv.ReservationId != first || !inv.IssuedAt.Equal(issuedAt) {
+		t.Errorf("expected invoice 1 of reservation %d issued at %s, got %+v", first, issuedAt, inv)
+	}
+
+	// a reservation keeps its invoice and its number
+	again, err := repo.IssueInvoice(ctx, first, issuedAt.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != inv.ID || again.Number != 1 || !again.IssuedAt.Equal(issuedAt) {
+		t.Errorf("expected the invoice issued before, got %+v", again)
+	}
+
+	next, err := repo.IssueInvoice(ctx, second, issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Number != 2 {
+		t.Errorf("expected the next invoice to be number 2, got %d", next.Number)
+	}
+
+	got, err := repo.GetInvoiceForReservation(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != next.ID || got.Number != 2 {
+		t.Errorf("expected invoice 2, got %+v", got)
+	}
+}
+
+func testTaxRules(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertTaxRule(ctx, models.TaxRule{Name: "VAT", Kind: "percent", Percent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cityTax := models.TaxRule{Name: "City tax", Kind: "per-guest", Amount: 200, ExemptChildren: true, MaxNights: 7, Cap: 5000}
+	cityTaxId, err := repo.InsertTaxRule(ctx, cityTax)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetTaxRuleById(ctx, cityTaxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cityTax.ID = cityTaxId
+	got.CreatedAt, got.UpdatedAt = time.Time{}, time.Time{}
+	if got != cityTax {
+		t.Errorf("expected %+v, got %+v", cityTax, got)
+	}
+
+	rules, err := repo.AllTaxRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Name != "City tax" || rules[1].Name != "VAT" {
+		t.Errorf("expected 2 tax rules ordered by name, got %+v", rules)
+	}
+
+	err = repo.UpdateTaxRule(ctx, models.TaxRule{ID: id, Name: "VAT 22%", Kind: "percent", Percent: 22})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = repo.GetTaxRuleById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "VAT 22%" || got.Percent != 22 {
+		t.Errorf("expected the tax rule to be updated, got %+v", got)
+	}
+
+	err = repo.DeleteTaxRule(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.GetTaxRuleById(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted tax rule, got %v", err)
+	}
+}
+
+func testReservationTaxes(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	ruleId, err := repo.InsertTaxRule(ctx, models.TaxRule{Name: "City tax", Kind: "per-guest", Amount: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := models.Reservation{
+		FirstName: "John",
+		Email:     "john@smith.com",
+		StartDate: date(5),
+		EndDate:   date(8),
+		RoomId:    roomOne,
+		Taxes: []models.ReservationTax{
+			{TaxRuleId: ruleId, Name: "City tax", Quantity: 6, UnitPrice: 200, Amount: 1200},
+			{Name: "VAT", Amount: 2700},
+		},
+	}
+	id, err := repo.InsertReservation(ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Taxes) != 2 || got.Taxes[0].ReservationId != id || got.Taxes[0].TaxRuleId != ruleId || got.Taxes[0].Quantity != 6 ||
+		got.Taxes[0].UnitPrice != 200 || got.Taxes[0].Amount != 1200 || got.Taxes[1].TaxRuleId != 0 || got.Taxes[1].Name != "VAT" {
+		t.Fatalf("expected the taxes to be stored with the reservation, got %+v", got.Taxes)
+	}
+	if got.TaxTotal() != 3900 {
+		t.Errorf("expected taxes of 3900, got %d", got.TaxTotal())
+	}
+
+	// a change of the stay replaces its taxes
+	got.EndDate = date(9)
+	got.Taxes = []models.ReservationTax{{TaxRuleId: ruleId, Name: "City tax", Quantity: 8, UnitPrice: 200, Amount: 1600}}
+	err = repo.UpdateReservation(ctx, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Taxes) != 1 || got.Taxes[0].Amount != 1600 {
+		t.Fatalf("expected the taxes to be replaced, got %+v", got.Taxes)
+	}
+
+	// the report counts the stays starting in the period, cancelled and deleted ones left out
+	for _, r := range []struct {
+		start  time.Time
+		status string
+		delete bool
+	}{
+		{date(31), "", false},
+		{date(10), models.ReservationStatusCancelled, false},
+		{date(12), "", true},
+		{time.Date(2040, time.February, 1, 0, 0, 0, 0, time.UTC), "", false},
+	} {
+		otherId, err := repo.InsertReservation(ctx, models.Reservation{
+			Email: "jane@doe.com", StartDate: r.start, EndDate: r.start.AddDate(0, 0, 1), RoomId: roomTwo, Status: r.status,
+			Taxes: []models.ReservationTax{{TaxRuleId: ruleId, Name: "City tax", Quantity: 2, UnitPrice: 200, Amount: 400}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.delete {
+			if err := repo.DeleteReservation(ctx, otherId); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	report, err := repo.TaxReport(ctx, date(1), time.Date(2040, time.February, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := models.TaxReportLine{TaxRuleId: ruleId, Name: "City tax", Reservations: 2, Quantity: 10, Amount: 2000}
+	if len(report) != 1 || report[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+
+	taxes, err := repo.AllReservationTaxes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taxes) != 4 || taxes[0].ReservationId != id {
+		t.Errorf("expected the taxes of the 4 reservations that are not deleted, by reservation, got %+v", taxes)
+	}
+
+	// deleting the rule keeps what was charged
+	err = repo.DeleteTaxRule(ctx, ruleId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Taxes) != 1 || got.Taxes[0].TaxRuleId != 0 || got.Taxes[0].Amount != 1600 {
+		t.Errorf("expected the taxes to be kept without their rule, got %+v", got.Taxes)
+	}
+}
+
+// testWaitlist checks that guests waiting for nights that are freed are found, in the order they joined,
+// and that a booking link is sent to each of them once
+func testWaitlist(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	entries := []models.WaitlistEntry{
+		{FirstName: "Ann", Email: "ann@example.com", StartDate: date(5), EndDate: date(8), RoomId: roomOne, Adults: 2, Language: "en"},
+		{FirstName: "Bob", Email: "bob@example.com", StartDate: date(6), EndDate: date(7), Adults: 1, Children: 1, Language: "it"},
+		{FirstName: "Cid", Email: "cid@example.com", StartDate: date(5), EndDate: date(8), RoomId: roomTwo, Adults: 1, Language: "en"},
+		{FirstName: "Dee", Email: "dee@example.com", StartDate: date(8), EndDate: date(10), RoomId: roomOne, Adults: 1, Language: "en"},
+	}
+	var ids []int
+	for _, e := range entries {
+		id, err := repo.InsertWaitlistEntry(ctx, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// the nights from the 6th to the 8th of room one: Dee arrives when they end, Cid waits for another room
+	waiting, err := repo.WaitingForDates(ctx, date(6), date(8), roomOne)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(waiting) != 2 || waiting[0].ID != ids[0] || waiting[1].ID != ids[1] {
+		t.Fatalf("expected Ann and Bob to be waiting, got %+v", waiting)
+	}
+	if got := waiting[1]; got.RoomId != 0 || got.Children != 1 || got.Language != "it" || got.Token != "" || !got.NotifiedAt.IsZero() ||
+		!got.StartDate.Equal(date(6)) || !got.EndDate.Equal(date(7)) {
+		t.Errorf("expected Bob to wait for any room, got %+v", got)
+	}
+
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	err = repo.OfferWaitlistEntry(ctx, ids[0], "ann-token", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.OfferWaitlistEntry(ctx, ids[0], "other-token", expiresAt); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a guest to be offered a stay once, got %v", err)
+	}
+
+	got, err := repo.GetWaitlistEntryByToken(ctx, "ann-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != ids[0] || got.RoomId != roomOne || got.NotifiedAt.IsZero() || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected the offer made to Ann, got %+v", got)
+	}
+	if _, err := repo.GetWaitlistEntryByToken(ctx, "unknown"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown token, got %v", err)
+	}
+
+	waiting, err = repo.WaitingForDates(ctx, date(1), date(31), roomOne)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(waiting) != 2 || waiting[0].ID != ids[1] || waiting[1].ID != ids[3] {
+		t.Errorf("expected a guest offered a stay to stop waiting, got %+v", waiting)
+	}
+}
+
+func testAvailabilityCalendar(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	now := time.Now()
+
+	book(t, repo, roomOne, date(5), date(8))
+	err := repo.AddBlockForRoom(ctx, roomOne, date(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold(t, repo, roomOne, date(12), date(13), now.Add(time.Hour))
+	hold(t, repo, roomOne, date(14), date(15), now.Add(-time.Minute))
+	book(t, repo, roomTwo, date(3), date(16))
+
+	days, err := repo.AvailabilityCalendar(ctx, roomOne, date(3), date(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 13 {
+		t.Fatalf("expected a day for each night from the 3rd to the 15th, got %d", len(days))
+	}
+
+	taken := map[int]bool{5: true, 6: true, 7: true, 10: true, 12: true}
+	for i, d := range days {
+		day := 3 + i
+		if !d.Date.Equal(date(day)) {
+			t.Errorf("expected day %d to be %v, got %v", i, date(day), d.Date)
+		}
+		if d.Available == taken[day] {
+			t.Errorf("expected the %d to be available %v, got %v", day, !taken[day], d.Available)
+		}
+		if d.Price != roomOneRate {
+			t.Errorf("expected the night of the %d to cost %d, got %d", day, roomOneRate, d.Price)
+		}
+	}
+
+	days, err = repo.AvailabilityCalendar(ctx, roomOne, date(3), date(3))
+	if err != nil || len(days) != 0 {
+		t.Errorf("expected no days for an empty period, got %+v, %v", days, err)
+	}
+
+	_, err = repo.AvailabilityCalendar(ctx, 99, date(3), date(16))
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing room, got %v", err)
+	}
+}
+
+func testFlexibleAvailability(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	book(t, repo, roomOne, date(5), date(8))
+	err := repo.AddBlockForRoom(ctx, roomTwo, date(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stays, err := repo.SearchFlexibleAvailability(ctx, date(3), date(9), 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two nights arriving from the 3rd to the 9th, room one is taken from the 5th to the 8th
+	// and room two on the night of the 10th
+	wanted := [][2]int{{3, roomOne}, {3, roomTwo}, {4, roomTwo}, {5, roomTwo}, {6, roomTwo}, {7, roomTwo},
+		{8, roomOne}, {8, roomTwo}, {9, roomOne}}
+	if len(stays) != len(wanted) {
+		t.Fatalf("expected %d stays, got %+v", len(wanted), stays)
+	}
+	for i, s := range stays {
+		if !s.StartDate.Equal(date(wanted[i][0])) || !s.EndDate.Equal(date(wanted[i][0]+2)) || s.Room.ID != wanted[i][1] {
+			t.Errorf("expected room %d from the %d, got room %d from %v to %v", wanted[i][1], wanted[i][0], s.Room.ID, s.StartDate, s.EndDate)
+		}
+	}
+	if stays[0].Room.NightlyRate != roomOneRate || stays[1].Room.NightlyRate != roomTwoRate {
+		t.Errorf("expected the stays to hold the rates of their rooms, got %+v", stays[:2])
+	}
+
+	stays, err = repo.SearchFlexibleAvailability(ctx, date(3), date(9), 2, roomOneOccupancy+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stays {
+		if s.Room.ID != roomTwo {
+			t.Errorf("expected only the room that can host the guests, got %+v", s)
+		}
+	}
+	if len(stays) != 6 {
+		t.Errorf("expected 6 stays in room two, got %d", len(stays))
+	}
+
+	stays, err = repo.SearchFlexibleAvailability(ctx, date(9), date(3), 2, 1)
+	if err != nil || len(stays) != 0 {
+		t.Errorf("expected no stays for an empty window, got %+v, %v", stays, err)
+	}
+}
+
+func testReservationGroups(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	now := time.Now()
+
+	single := book(t, repo, roomTwo, date(20), date(22))
+	holds := []int{
+		hold(t, repo, roomOne, date(5), date(8), now.Add(time.Hour)),
+		hold(t, repo, roomTwo, date(5), date(8), now.Add(time.Hour)),
+	}
+
+	line := func(roomId int, start, end time.Time) models.Reservation {
+		return models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "123456789",
+			StartDate: start, EndDate: end, RoomId: roomId, Adults: 2}
+	}
+
+	// nothing is booked when one of the rooms is taken
+	_, _, err := repo.InsertReservationGroup(ctx, []models.Reservation{
+		line(roomOne, date(5), date(8)),
+		line(roomTwo, date(21), date(23)),
+	}, holds)
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Fatalf("expected ErrRoomNotAvailable, got %v", err)
+	}
+
+	all, err := repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Errorf("expected a failed group to book nothing, got %d reservations", len(all))
+	}
+	if available(t, repo, roomOne, date(5), date(8)) {
+		t.Error("expected a failed group to keep the holds of the guest")
+	}
+
+	groupId, ids, err := repo.InsertReservationGroup(ctx, []models.Reservation{
+		line(roomOne, date(5), date(8)),
+		line(roomTwo, date(5), date(8)),
+	}, holds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groupId == 0 || len(ids) != 2 {
+		t.Fatalf("expected a group of 2 reservations, got group %d with %v", groupId, ids)
+	}
+
+	for _, id := range ids {
+		res, err := repo.GetReservationById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GroupId != groupId {
+			t.Errorf("expected reservation %d in group %d, got %d", id, groupId, res.GroupId)
+		}
+	}
+
+	res, err := repo.GetReservationById(ctx, single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GroupId != 0 {
+		t.Errorf("expected a reservation booked alone to have no group, got %d", res.GroupId)
+	}
+
+	for _, roomId := range []int{roomOne, roomTwo} {
+		if available(t, repo, roomId, date(6), date(7)) {
+			t.Errorf("expected room %d to be booked by the group", roomId)
+		}
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, roomOne, date(1), date(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 || restrictions[0].ReservationId != ids[0] || restrictions[0].Reservation.GroupId != groupId {
+		t.Errorf("expected the restriction of the group to be listed with its group, got %+v", restrictions)
+	}
+
+	all, err = repo.AllReservations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].GroupId != groupId || all[1].GroupId != groupId || all[2].GroupId != 0 {
+		t.Errorf("expected the rooms of the group to be listed together, got %+v", all)
+	}
+
+	lines, err := repo.GetReservationsByGroup(ctx, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].ID != ids[0] || lines[1].ID != ids[1] || lines[1].Room.ID != roomTwo {
+		t.Errorf("expected the 2 reservations of the group, got %+v", lines)
+	}
+
+	err = repo.DeleteReservation(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err = repo.GetReservationsByGroup(ctx, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0].ID != ids[0] {
+		t.Errorf("expected a deleted reservation to leave the group, got %+v", lines)
+	}
+
+	lines, err = repo.GetReservationsByGroup(ctx, groupId+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 0 {
+		t.Errorf("expected an unknown group to have no reservations, got %+v", lines)
+	}
+}
+
+func testOneDayBlock(t *testing.T, repo repository.DatabaseRepo, policy repository.OverlapPolicy) {
+	ctx := context.Background()
+
+	// room one is blocked on the 15th only
+	err := repo.AddBlockForRoom(ctx, roomOne, date(15))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if available(t, repo, roomOne, date(15), date(16)) {
+		t.Error("expected the blocked day not to be bookable")
+	}
+	if availableRooms(t, repo, date(15), date(16))[roomOne] {
+		t.Error("expected the blocked day not to be in the search results")
+	}
+	if available(t, repo, roomOne, date(13), date(15)) != (policy == repository.HalfOpen) {
+		t.Errorf("expected leaving on the blocked day to be bookable %t", policy == repository.HalfOpen)
+	}
+
+	if !available(t, repo, roomOne, date(16), date(18)) {
+		t.Error("expected the day after the block to be bookable")
+	}
+	if !availableRooms(t, repo, date(16), date(18))[roomOne] {
+		t.Error("expected the day after the block to be in the search results")
+	}
+
+	days, err := repo.AvailabilityCalendar(ctx, roomOne, date(14), date(18))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// under the closed policy a night on the 14th leaves on the blocked day
+	taken := map[int]bool{14: policy == repository.Closed, 15: true}
+	for i, d := range days {
+		if day := 14 + i; d.Available == taken[day] {
+			t.Errorf("expected the %d to be available %t, got %t", day, !taken[day], d.Available)
+		}
+	}
+
+	book(t, repo, roomOne, date(16), date(18))
+
+	err = repo.AddBlockForRoom(ctx, roomOne, date(14))
+	if err != nil {
+		t.Errorf("expected a block on the day before the other block, got %v", err)
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS reservations_rooms_id_fk;
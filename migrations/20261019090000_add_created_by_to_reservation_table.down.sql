@@ -0,0 +1,2 @@
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS reservations_users_id_fk;
+ALTER TABLE reservations DROP COLUMN IF EXISTS created_by;
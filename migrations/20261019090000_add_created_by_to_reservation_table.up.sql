@@ -0,0 +1,4 @@
+ALTER TABLE reservations ADD COLUMN created_by INTEGER;
+
+ALTER TABLE reservations ADD CONSTRAINT reservations_users_id_fk
+    FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS reservations_status_idx;
+
+ALTER TABLE reservations DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE reservations DROP COLUMN IF EXISTS status;
@@ -0,0 +1,6 @@
+ALTER TABLE reservations ADD COLUMN status VARCHAR(255) NOT NULL DEFAULT 'pending';
+ALTER TABLE reservations ADD COLUMN deleted_at TIMESTAMP;
+
+UPDATE reservations SET status = 'confirmed' WHERE processed = 1;
+
+CREATE INDEX reservations_status_idx ON reservations (status);
@@ -0,0 +1,15 @@
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER,
+    entity VARCHAR(255) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_entity_entity_id_idx ON audit_log (entity, entity_id);
+
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_users_id_fk
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1 @@
+UPDATE room_restrictions SET end_date = end_date + 1 WHERE reservation_id IS NULL AND end_date = start_date;
@@ -0,0 +1 @@
+ALTER TABLE reservations ADD COLUMN language VARCHAR(10) NOT NULL DEFAULT 'en';
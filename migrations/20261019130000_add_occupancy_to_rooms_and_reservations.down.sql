@@ -0,0 +1,5 @@
+ALTER TABLE reservations DROP COLUMN IF EXISTS special_requests;
+ALTER TABLE reservations DROP COLUMN IF EXISTS children;
+ALTER TABLE reservations DROP COLUMN IF EXISTS adults;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS max_occupancy;
@@ -0,0 +1,7 @@
+ALTER TABLE rooms ADD COLUMN max_occupancy INTEGER NOT NULL DEFAULT 2;
+
+UPDATE rooms SET max_occupancy = 4 WHERE room_name = 'Major''s Suite';
+
+ALTER TABLE reservations ADD COLUMN adults INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE reservations ADD COLUMN children INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE reservations ADD COLUMN special_requests TEXT NOT NULL DEFAULT '';
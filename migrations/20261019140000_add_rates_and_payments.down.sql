@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS payments;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS deposit_fixed;
+ALTER TABLE rooms DROP COLUMN IF EXISTS deposit_percent;
+ALTER TABLE rooms DROP COLUMN IF EXISTS nightly_rate;
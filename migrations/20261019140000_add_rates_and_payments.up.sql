@@ -0,0 +1,24 @@
+ALTER TABLE rooms ADD COLUMN nightly_rate INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rooms ADD COLUMN deposit_percent INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rooms ADD COLUMN deposit_fixed INTEGER NOT NULL DEFAULT 0;
+
+UPDATE rooms SET nightly_rate = 9000, deposit_percent = 30 WHERE room_name = 'General''s Quarters';
+UPDATE rooms SET nightly_rate = 15000, deposit_percent = 30 WHERE room_name = 'Major''s Suite';
+
+CREATE TABLE payments (
+    id SERIAL PRIMARY KEY,
+    reservation_id INTEGER NOT NULL,
+    gateway VARCHAR(255) NOT NULL,
+    reference VARCHAR(255) NOT NULL,
+    amount INTEGER NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX payments_reservation_id_idx ON payments (reservation_id);
+CREATE INDEX payments_gateway_reference_idx ON payments (gateway, reference);
+
+ALTER TABLE payments ADD CONSTRAINT payments_reservations_id_fk
+    FOREIGN KEY (reservation_id) REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE;
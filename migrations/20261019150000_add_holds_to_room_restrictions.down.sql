@@ -0,0 +1,4 @@
+DELETE FROM room_restrictions WHERE restriction_id = 3;
+DELETE FROM restrictions WHERE id = 3;
+
+ALTER TABLE room_restrictions DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,4 @@
+ALTER TABLE room_restrictions ADD COLUMN expires_at TIMESTAMP;
+
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at) VALUES (3, 'Hold', '2026-10-19 00:00:00', '2026-10-19 00:00:00');
+SELECT setval(pg_get_serial_sequence('restrictions', 'id'), (SELECT max(id) FROM restrictions));
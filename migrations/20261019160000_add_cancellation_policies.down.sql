@@ -0,0 +1,4 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS refunded;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS cancellation_percent;
+ALTER TABLE rooms DROP COLUMN IF EXISTS free_cancellation_days;
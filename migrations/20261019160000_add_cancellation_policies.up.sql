@@ -0,0 +1,7 @@
+ALTER TABLE rooms ADD COLUMN free_cancellation_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rooms ADD COLUMN cancellation_percent INTEGER NOT NULL DEFAULT 0;
+
+UPDATE rooms SET free_cancellation_days = 7, cancellation_percent = 20 WHERE room_name = 'General''s Quarters';
+UPDATE rooms SET free_cancellation_days = 14, cancellation_percent = 30 WHERE room_name = 'Major''s Suite';
+
+ALTER TABLE payments ADD COLUMN refunded INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE reservations DROP COLUMN IF EXISTS discount;
+ALTER TABLE reservations DROP COLUMN IF EXISTS promo_code;
+
+DROP TABLE IF EXISTS promotions;
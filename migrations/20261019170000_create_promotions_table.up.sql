@@ -0,0 +1,23 @@
+CREATE TABLE promotions (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(255) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    discount_percent INTEGER NOT NULL DEFAULT 0,
+    discount_fixed INTEGER NOT NULL DEFAULT 0,
+    valid_from DATE NOT NULL,
+    valid_to DATE NOT NULL,
+    room_id INTEGER,
+    min_nights INTEGER NOT NULL DEFAULT 0,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX promotions_code_idx ON promotions (code);
+
+ALTER TABLE promotions ADD CONSTRAINT promotions_rooms_id_fk
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE reservations ADD COLUMN promo_code VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE reservations ADD COLUMN discount INTEGER NOT NULL DEFAULT 0;
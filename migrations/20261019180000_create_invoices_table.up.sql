@@ -0,0 +1,14 @@
+CREATE TABLE invoices (
+    id SERIAL PRIMARY KEY,
+    reservation_id INTEGER NOT NULL,
+    number INTEGER NOT NULL,
+    issued_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX invoices_number_idx ON invoices (number);
+CREATE UNIQUE INDEX invoices_reservation_id_idx ON invoices (reservation_id);
+
+ALTER TABLE invoices ADD CONSTRAINT invoices_reservations_id_fk
+    FOREIGN KEY (reservation_id) REFERENCES reservations (id) ON DELETE RESTRICT ON UPDATE CASCADE;
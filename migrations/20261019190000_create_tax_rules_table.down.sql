@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reservation_taxes;
+DROP TABLE IF EXISTS tax_rules;
@@ -0,0 +1,32 @@
+CREATE TABLE tax_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    percent INTEGER NOT NULL DEFAULT 0,
+    amount INTEGER NOT NULL DEFAULT 0,
+    exempt_children BOOLEAN NOT NULL DEFAULT FALSE,
+    max_nights INTEGER NOT NULL DEFAULT 0,
+    cap INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE reservation_taxes (
+    id SERIAL PRIMARY KEY,
+    reservation_id INTEGER NOT NULL,
+    tax_rule_id INTEGER,
+    name VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 0,
+    unit_price INTEGER NOT NULL DEFAULT 0,
+    amount INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX reservation_taxes_reservation_id_idx ON reservation_taxes (reservation_id);
+
+ALTER TABLE reservation_taxes ADD CONSTRAINT reservation_taxes_reservations_id_fk
+    FOREIGN KEY (reservation_id) REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE reservation_taxes ADD CONSTRAINT reservation_taxes_tax_rules_id_fk
+    FOREIGN KEY (tax_rule_id) REFERENCES tax_rules (id) ON DELETE SET NULL ON UPDATE CASCADE;
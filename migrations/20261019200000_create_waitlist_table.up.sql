@@ -0,0 +1,23 @@
+CREATE TABLE waitlist (
+    id SERIAL PRIMARY KEY,
+    first_name VARCHAR(255) NOT NULL DEFAULT '',
+    last_name VARCHAR(255) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    room_id INTEGER,
+    adults INTEGER NOT NULL DEFAULT 1,
+    children INTEGER NOT NULL DEFAULT 0,
+    language VARCHAR(10) NOT NULL DEFAULT 'en',
+    token VARCHAR(255),
+    notified_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX waitlist_start_date_end_date_idx ON waitlist (start_date, end_date);
+CREATE UNIQUE INDEX waitlist_token_idx ON waitlist (token);
+
+ALTER TABLE waitlist ADD CONSTRAINT waitlist_rooms_id_fk
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE;
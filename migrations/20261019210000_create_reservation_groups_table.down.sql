@@ -0,0 +1,3 @@
+ALTER TABLE reservations DROP COLUMN IF EXISTS group_id;
+
+DROP TABLE IF EXISTS reservation_groups;
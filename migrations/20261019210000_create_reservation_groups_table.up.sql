@@ -0,0 +1,12 @@
+CREATE TABLE reservation_groups (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE reservations ADD COLUMN group_id INTEGER;
+
+CREATE INDEX reservations_group_id_idx ON reservations (group_id);
+
+ALTER TABLE reservations ADD CONSTRAINT reservations_reservation_groups_id_fk
+    FOREIGN KEY (group_id) REFERENCES reservation_groups (id) ON DELETE SET NULL ON UPDATE CASCADE;
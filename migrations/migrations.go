@@ -0,0 +1,9 @@
+// Package migrations holds the SQL migrations of the database, embedded into the binary
+package migrations
+
+import "embed"
+
+// FS holds the up and down SQL migration files
+//
+//go:embed *.up.sql *.down.sql
+var FS embed.FS
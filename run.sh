@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
 
-go build -o bookings cmd/web/*.go && ./bookings -production=false -cache=false -dbuser=postgres -dbname=bookings -dbpassword=postgres
+go build -o bookings cmd/web/*.go && ./bookings -production=false -cache=false -dbuser=postgres -dbname=bookings -dbpassword=postgres -migrate=up
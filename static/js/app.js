@@ -106,4 +106,134 @@ function Prompt() {
         error: error,
         custom: custom,
     }
-}
\ No newline at end of file
+}
+// RoomCalendar draws a month of the availability calendar of a room in elem, fetched from
+// /api/availability/calendar. Days that can't be booked are greyed out; clicking an arrival and then
+// a departure selects a stay, passed to onSelect with the dates as yyyy-mm-dd.
+function RoomCalendar(elem, c) {
+    const {
+        roomId = 0,
+        lang = 'en',
+        onSelect = function () {},
+    } = c;
+
+    const today = new Date();
+    let month = new Date(Date.UTC(today.getFullYear(), today.getMonth(), 1));
+    let days = {};
+    let currency = '';
+    let start = '', end = '';
+
+    function iso(d) {
+        return d.toISOString().slice(0, 10);
+    }
+
+    function addDays(s, n) {
+        const d = new Date(s + 'T00:00:00Z');
+        d.setUTCDate(d.getUTCDate() + n);
+        return iso(d);
+    }
+
+    // free reports whether every night from s until the day before e can be booked,
+    // nights not fetched yet are left to the booking to check
+    function free(s, e) {
+        for (let d = s; d < e; d = addDays(d, 1)) {
+            if (days[d] !== undefined && !days[d].available) {
+                return false;
+            }
+        }
+        return true;
+    }
+
+    function price(cents) {
+        if (!cents || !currency) {
+            return '';
+        }
+        return new Intl.NumberFormat(lang, {style: 'currency', currency: currency, maximumFractionDigits: 0})
+            .format(cents / 100);
+    }
+
+    function pick(d) {
+        if (start !== '' && end === '' && d > start && free(start, d)) {
+            end = d;
+            onSelect(start, end);
+        } else if (days[d] !== undefined && days[d].available) {
+            start = d;
+            end = '';
+            onSelect('', '');
+        }
+        draw();
+    }
+
+    function draw() {
+        const first = iso(month);
+        const title = month.toLocaleDateString(lang, {month: 'long', year: 'numeric', timeZone: 'UTC'});
+        let html = '<div class="d-flex justify-content-between align-items-center mb-2">'
+            + '<button type="button" class="btn btn-sm btn-outline-dark" data-move="-1">&lsaquo;</button>'
+            + '<strong class="text-capitalize">' + title + '</strong>'
+            + '<button type="button" class="btn btn-sm btn-outline-dark" data-move="1">&rsaquo;</button>'
+            + '</div><table class="table table-sm table-bordered text-center room-calendar mb-0"><thead><tr>';
+        // the 1st of January 2024 is a monday
+        for (let i = 0; i < 7; i++) {
+            html += '<th>' + new Date(Date.UTC(2024, 0, 1 + i))
+                .toLocaleDateString(lang, {weekday: 'short', timeZone: 'UTC'}) + '</th>';
+        }
+        html += '</tr></thead><tbody><tr>';
+
+        // weeks start on monday
+        const blank = (month.getUTCDay() + 6) % 7;
+        for (let i = 0; i < blank; i++) {
+            html += '<td></td>';
+        }
+
+        let d = first, cell = blank;
+        while (d.slice(0, 7) === first.slice(0, 7)) {
+            if (cell > 0 && cell % 7 === 0) {
+                html += '</tr><tr>';
+            }
+            const day = days[d];
+            let cls = day !== undefined && day.available ? 'available' : 'unavailable';
+            if (d === start || d === end || (start !== '' && d > start && d < end)) {
+                cls += ' selected';
+            }
+            html += '<td class="' + cls + '" data-date="' + d + '">' + Number(d.slice(8))
+                + '<small class="d-block">' + (day !== undefined && day.available ? price(day.price) : '&nbsp;')
+                + '</small></td>';
+            d = addDays(d, 1);
+            cell++;
+        }
+        html += '</tr></tbody></table>';
+
+        elem.innerHTML = html;
+        elem.querySelectorAll('[data-move]').forEach(function (b) {
+            b.addEventListener('click', function () {
+                month.setUTCMonth(month.getUTCMonth() + Number(b.dataset.move));
+                load();
+            });
+        });
+        elem.querySelectorAll('td[data-date]').forEach(function (td) {
+            td.addEventListener('click', function () {
+                pick(td.dataset.date);
+            });
+        });
+    }
+
+    function load() {
+        const from = iso(month);
+        const next = new Date(month);
+        next.setUTCMonth(next.getUTCMonth() + 1);
+
+        fetch('/api/availability/calendar?room_id=' + roomId + '&from=' + from + '&to=' + iso(next))
+            .then(response => response.json())
+            .then(data => {
+                if (data.ok) {
+                    currency = data.currency || '';
+                    data.days.forEach(function (day) {
+                        days[day.date] = day;
+                    });
+                }
+                draw();
+            });
+    }
+
+    load();
+}